@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/hawkerd/privateinstruction/internal/db"
+	"github.com/hawkerd/privateinstruction/internal/migrations"
+)
+
+const usage = `usage: migrate <command>
+
+commands:
+  up              apply every pending migration
+  down [n]        roll back the last n migrations (default 1)
+  to <version>    migrate up or down to the given version (0 rolls back everything)
+  status          list the migrations and whether they are applied`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// load the migrations and connect to the database
+	all, err := migrations.All()
+	if err != nil {
+		log.Fatalf("failed to load migrations: %v", err)
+	}
+	dbConn, err := db.ConnectDB()
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+	migrator := migrations.NewMigrator(dbConn, all)
+
+	// run the command
+	switch os.Args[1] {
+	case "up":
+		err = migrator.Up()
+	case "down":
+		n := 1
+		if len(os.Args) > 2 {
+			n, err = strconv.Atoi(os.Args[2])
+			if err != nil || n < 1 {
+				log.Fatalf("invalid number of migrations: %s", os.Args[2])
+			}
+		}
+		err = migrator.Down(n)
+	case "to":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		version, convErr := strconv.Atoi(os.Args[2])
+		if convErr != nil || version < 0 {
+			log.Fatalf("invalid version: %s", os.Args[2])
+		}
+		err = migrator.To(version)
+	case "status":
+		err = printStatus(migrator)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("migrate %s failed: %v", os.Args[1], err)
+	}
+}
+
+// print one line per migration
+func printStatus(migrator *migrations.Migrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.Applied {
+			appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%04d  %-30s  %s\n", status.Version, status.Name, appliedAt)
+	}
+	return nil
+}
@@ -3,62 +3,170 @@ package main
 import (
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	_ "github.com/hawkerd/privateinstruction/docs"
+	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/config"
 	"github.com/hawkerd/privateinstruction/internal/db"
 	"github.com/hawkerd/privateinstruction/internal/handlers"
+	"github.com/hawkerd/privateinstruction/internal/mail"
 	"github.com/hawkerd/privateinstruction/internal/middleware"
 	"github.com/hawkerd/privateinstruction/internal/migrations"
+	"github.com/hawkerd/privateinstruction/internal/oidc"
+	"github.com/hawkerd/privateinstruction/internal/ratelimit"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
 	"github.com/hawkerd/privateinstruction/internal/services"
 	"github.com/rs/cors"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
 func main() {
-	// establish db connection and run the migrations
+	// establish db connection and make sure the schema is up to date
 	dbConn, err := db.ConnectDB()
 	if err != nil {
 		log.Fatalf("failed to connect to database: %v", err)
 	}
-	if err := migrations.Migrate(dbConn); err != nil {
-		log.Fatalf("failed to migrate database: %v", err)
+	allMigrations, err := migrations.All()
+	if err != nil {
+		log.Fatalf("failed to load migrations: %v", err)
+	}
+	if err := migrations.NewMigrator(dbConn, allMigrations).EnsureCurrent(); err != nil {
+		log.Fatalf("%v (run `go run ./cmd/migrate up`)", err)
+	}
+
+	// load the keys used to sign and hash tokens
+	if err := auth.ConfigureKeys(); err != nil {
+		log.Fatalf("failed to load JWT keys: %v", err)
 	}
 
-	authService := services.NewAuthService(dbConn)
-	userService := services.NewUserService(dbConn)
-	classService := services.NewClassService(dbConn)
+	// pick how email is delivered
+	mailer, err := mail.NewMailerFromConfig()
+	if err != nil {
+		log.Fatalf("failed to configure mail: %v", err)
+	}
+
+	store := repositories.NewGormStore(dbConn)
+	authService := services.NewAuthService(store, mailer)
+	authService.PasswordPolicy = auth.PasswordPolicyFromConfig()
+	if authService.OIDCProviders, err = oidc.ProvidersFromConfig(); err != nil {
+		log.Fatalf("failed to configure OIDC providers: %v", err)
+	}
+	userService := services.NewUserService(store, mailer)
+	classService := services.NewClassService(store)
+	classService.RequireVerifiedEmail = config.GetRequireVerifiedEmail()
+	adminService := services.NewAdminService(store, mailer)
+	if err := adminService.PromotePlatformAdmins(config.GetPlatformAdminEmails()); err != nil {
+		log.Fatalf("failed to set up platform admins: %v", err)
+	}
+
+	// keep the audit log from growing forever
+	auditRetention := services.DefaultAuditRetention
+	if days := config.GetAuditRetentionDays(); days >= 0 {
+		auditRetention = time.Duration(days) * 24 * time.Hour
+	}
+	go pruneAuditEvents(adminService, auditRetention)
+	go pruneRefreshTokens(authService)
 
-	// create a router
+	// create a router, limiting how fast each client can call it
 	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	limits := ratelimit.NewMemoryStore()
+	r.Use(middleware.RateLimit(limits, ratelimit.Default))
 
 	r.Get("/swagger/*", httpSwagger.WrapHandler)
+	r.Get("/.well-known/jwks.json", handlers.JWKS())
 
-	r.Post("/signup", handlers.SignUp(authService))
-	r.Post("/signin", handlers.SignIn(authService))
+	r.With(middleware.RateLimit(limits, ratelimit.SignUp)).Post("/signup", handlers.SignUp(authService))
+	r.With(middleware.RateLimit(limits, ratelimit.SignIn)).Post("/signin", handlers.SignIn(authService))
+	r.With(middleware.RateLimit(limits, ratelimit.SignIn)).Post("/signin/mfa", handlers.VerifyMFA(authService))
 	r.Post("/auth/refresh", handlers.RefreshToken(authService))
+	r.Post("/auth/logout", handlers.Logout(authService))
+	r.Post("/auth/verify-email", handlers.VerifyEmail(authService))
+	r.With(middleware.RateLimit(limits, ratelimit.SendEmail)).Post("/auth/password/forgot", handlers.ForgotPassword(authService))
+	r.Post("/auth/password/reset", handlers.ResetPassword(authService))
+	r.Get("/auth/oidc/{provider}/login", handlers.OIDCLogin(authService))
+	r.Get("/auth/oidc/{provider}/callback", handlers.OIDCCallback(authService))
 
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.TokenAuthMiddleware)
-		r.Get("/me", handlers.ReadUser(userService))
-		r.Delete("/me", handlers.DeleteUser(userService))
-		r.Put("/me", handlers.UpdateUser(userService))
-		r.Put("/me/password", handlers.UpdatePassword(authService))
-
-		r.Post("/class", handlers.CreateClass(classService))
-		r.Delete("/class/{id}", handlers.DeleteClass(classService))
-		r.Get("/class/{id}", handlers.ReadClass(classService))
-		r.Put("/class/{id}", handlers.UpdateClass(classService))
-		r.Post("/class/{id}/joincode", handlers.GenerateJoinCode(classService))
-		r.Post("/class/join", handlers.JoinClass(classService))
-		//r.Post("/class", handlers.CreateClass)
-		//r.Get("/classes", handlers.GetClasses)
+		r.Use(middleware.TokenAuthMiddleware(authService))
+		r.Use(middleware.RecordImpersonation(adminService))
+		r.Use(middleware.RateLimit(limits, ratelimit.Authenticated))
+
+		// account routes; signed in sessions have every scope, personal access tokens only those they were minted with
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireScope(auth.ScopeUserRead))
+			r.Get("/me", handlers.ReadUser(userService))
+			r.Get("/me/sessions", handlers.ListSessions(authService))
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireScope(auth.ScopeUserWrite))
+			r.Delete("/me", handlers.DeleteUser(userService))
+			r.Put("/me", handlers.UpdateUser(userService))
+			r.Put("/me/password", handlers.UpdatePassword(authService))
+			r.Post("/auth/logout-all", handlers.LogoutAll(authService))
+			r.With(middleware.RateLimit(limits, ratelimit.SendEmail)).Post("/auth/verify-email/resend", handlers.ResendVerification(authService))
+			r.Delete("/me/sessions/{id}", handlers.RevokeSession(authService))
+			r.Post("/me/2fa/setup", handlers.SetupTwoFactor(authService))
+			r.Post("/me/2fa/confirm", handlers.ConfirmTwoFactor(authService))
+			r.Delete("/me/2fa", handlers.DisableTwoFactor(authService))
+		})
+
+		// token management is refused to anything but a signed in session in the handlers
+		r.Get("/me/tokens", handlers.ListPersonalAccessTokens(authService))
+		r.Post("/me/tokens", handlers.CreatePersonalAccessToken(authService))
+		r.Delete("/me/tokens/{id}", handlers.RevokePersonalAccessToken(authService))
+
+		// class routes
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireScope(auth.ScopeClassesRead))
+			r.Get("/class/{id}", handlers.ReadClass(classService))
+			r.Get("/classes", handlers.GetClasses(classService))
+			r.Get("/class/{id}/members", handlers.ListMembers(classService))
+			r.Get("/class/{id}/joincode", handlers.ListJoinCodes(classService))
+			r.Get("/class/{id}/audit-events", handlers.ListClassAuditEvents(classService))
+			r.Get("/class/{id}/join-requests", handlers.ListJoinRequests(classService))
+			r.Get("/class/join-requests", handlers.ListMyJoinRequests(classService))
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireScope(auth.ScopeClassesWrite))
+			r.Post("/class", handlers.CreateClass(classService))
+			r.Delete("/class/{id}", handlers.DeleteClass(classService))
+			r.Put("/class/{id}", handlers.UpdateClass(classService))
+			r.Post("/class/{id}/joincode", handlers.GenerateJoinCode(classService))
+			r.Delete("/class/{id}/joincode/{codeID}", handlers.RevokeJoinCode(classService))
+			r.With(middleware.RateLimit(limits, ratelimit.JoinClass)).Post("/class/join", handlers.JoinClass(classService))
+			r.Delete("/class/join-requests/{requestID}", handlers.CancelJoinRequest(classService))
+			r.Post("/class/{id}/join-requests/{requestID}/approve", handlers.ApproveJoinRequest(classService))
+			r.Post("/class/{id}/join-requests/{requestID}/reject", handlers.RejectJoinRequest(classService))
+			r.Delete("/class/{id}/members/{memberID}", handlers.RemoveMember(classService))
+			r.Put("/class/{id}/members/{memberID}", handlers.UpdateMemberRole(classService))
+			r.Post("/class/{id}/leave", handlers.LeaveClass(classService))
+			r.Post("/class/{id}/transfer", handlers.TransferOwnership(classService))
+		})
+
+		// platform admin routes
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(middleware.RequirePlatformAdmin(adminService))
+			r.Get("/users", handlers.AdminListUsers(adminService))
+			r.Post("/users/{id}/disable", handlers.AdminDisableUser(adminService))
+			r.Post("/users/{id}/enable", handlers.AdminEnableUser(adminService))
+			r.Post("/users/{id}/password-reset", handlers.AdminForcePasswordReset(adminService))
+			r.Post("/users/{id}/impersonate", handlers.AdminImpersonateUser(adminService))
+			r.Delete("/impersonations/{id}", handlers.AdminEndImpersonation(adminService))
+			r.Get("/classes", handlers.AdminListClasses(adminService))
+			r.Delete("/classes/{id}", handlers.AdminDeleteClass(adminService))
+			r.Get("/actions", handlers.AdminListActions(adminService))
+			r.Get("/audit-events", handlers.AdminListAuditEvents(adminService))
+		})
 	})
 
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:3000"}, // Frontend URL
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-Request-ID"},
+		ExposedHeaders:   []string{"RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", "WWW-Authenticate", "X-Request-ID"},
 		AllowCredentials: true,
 	})
 	handler := c.Handler(r)
@@ -66,3 +174,30 @@ func main() {
 	log.Println("Starting server on :8080")
 	http.ListenAndServe(":8080", handler)
 }
+
+// delete expired refresh tokens, now and then once a day
+func pruneRefreshTokens(authService *services.AuthService) {
+	for {
+		if deleted, err := authService.PruneRefreshTokens(); err != nil {
+			log.Printf("failed to prune refresh tokens: %v", err)
+		} else if deleted > 0 {
+			log.Printf("pruned %d expired refresh tokens", deleted)
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
+
+// delete audit events past retention, now and then once a day; a zero retention keeps them forever
+func pruneAuditEvents(adminService *services.AdminService, retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	for {
+		if deleted, err := adminService.PruneAuditEvents(retention); err != nil {
+			log.Printf("failed to prune audit events: %v", err)
+		} else if deleted > 0 {
+			log.Printf("pruned %d audit events older than %s", deleted, retention)
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
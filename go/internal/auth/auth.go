@@ -1,12 +1,16 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,8 +18,6 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-var secretKey = []byte("12345")
-
 // hash a password
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -28,26 +30,167 @@ func CheckPassword(hashedPassword string, password string) bool {
 	return err == nil
 }
 
-// generate a JWT token
-func GenerateJWT(userID uint, username string) (string, error) {
+// the kinds of JWT the server issues, stored in the typ claim
+const (
+	TokenTypeAccess    = "access"
+	TokenTypeMFA       = "mfa"
+	TokenTypeOIDCState = "oidc_state"
+	// not a JWT; set on the principal of requests made with a personal access token
+	TokenTypePersonalAccess = "personal_access_token"
+)
+
+// generate a JWT token for a session
+func GenerateJWT(userID uint, username string, sessionID uint) (string, error) {
 	claims := jwt.MapClaims{
 		"exp":      JWTExpiration().Unix(),
 		"iat":      time.Now().Unix(),
 		"username": username,
 		"user_id":  userID,
+		"sid":      sessionID,
+		"typ":      TokenTypeAccess,
+		"scope":    strings.Join(AllScopes, " "),
+	}
+	return CurrentKeySet().Sign(claims)
+}
+
+// generate an access token letting an admin act as another user until expiresAt; it has no session, so it can't be refreshed
+func GenerateImpersonationJWT(userID uint, username string, actor Actor, expiresAt time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		"exp":      expiresAt.Unix(),
+		"iat":      time.Now().Unix(),
+		"username": username,
+		"user_id":  userID,
+		"typ":      TokenTypeAccess,
+		"scope":    strings.Join(ImpersonationScopes, " "),
+		// the actor claim of RFC 8693, naming who is really making the requests
+		"act": map[string]interface{}{
+			"sub":      strconv.FormatUint(uint64(actor.UserID), 10),
+			"username": actor.Username,
+		},
+		"imp": actor.ImpersonationID,
+	}
+	return CurrentKeySet().Sign(claims)
+}
+
+// parse an access token into the principal it was issued to
+func ParseAccessToken(tokenString string) (*Principal, error) {
+	claims, err := ParseJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	// tokens issued before typ existed have none
+	if typ, ok := claims["typ"].(string); ok && typ != TokenTypeAccess {
+		return nil, fmt.Errorf("not an access token")
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("user_id not found or invalid type")
+	}
+
+	principal := &Principal{
+		UserID:    uint(userID),
+		TokenType: TokenTypeAccess,
+		Scopes:    AllScopes,
+	}
+	principal.Username, _ = claims["username"].(string)
+	if sessionID, ok := claims["sid"].(float64); ok {
+		principal.SessionID = uint(sessionID)
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		principal.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+	if act, ok := claims["act"].(map[string]interface{}); ok {
+		subject, _ := act["sub"].(string)
+		actorID, err := strconv.ParseUint(subject, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid act claim")
+		}
+		principal.Actor = &Actor{UserID: uint(actorID)}
+		principal.Actor.Username, _ = act["username"].(string)
+		if impersonationID, ok := claims["imp"].(float64); ok {
+			principal.Actor.ImpersonationID = uint(impersonationID)
+		}
+	}
+	// tokens issued before scopes existed carry all of them
+	if scope, ok := claims["scope"].(string); ok {
+		principal.Scopes = strings.Fields(scope)
+	}
+	return principal, nil
+}
+
+// generate a short lived token proving the password was checked, exchanged for an access token once the second factor is
+func GenerateMFAToken(userID uint) (string, error) {
+	claims := jwt.MapClaims{
+		"exp":     MFATokenExpiration().Unix(),
+		"iat":     time.Now().Unix(),
+		"user_id": userID,
+		"typ":     TokenTypeMFA,
+	}
+	return CurrentKeySet().Sign(claims)
+}
+
+// parse an MFA token and return the user ID
+func ParseMFAToken(tokenString string) (uint, error) {
+	claims, err := ParseJWT(tokenString)
+	if err != nil {
+		return 0, err
+	}
+	if claims["typ"] != TokenTypeMFA {
+		return 0, fmt.Errorf("not an MFA token")
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, fmt.Errorf("user_id not found or invalid type")
+	}
+	return uint(userID), nil
+}
+
+// what a login with an OIDC provider needs to remember between the redirect and the callback
+type OIDCState struct {
+	Provider     string
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// generate a short lived token holding an OIDC login's state, kept in a cookie on the user's browser
+func GenerateOIDCStateToken(state OIDCState) (string, error) {
+	claims := jwt.MapClaims{
+		"exp":           OIDCStateTokenExpiration().Unix(),
+		"iat":           time.Now().Unix(),
+		"provider":      state.Provider,
+		"state":         state.State,
+		"nonce":         state.Nonce,
+		"code_verifier": state.CodeVerifier,
+		"typ":           TokenTypeOIDCState,
+	}
+	return CurrentKeySet().Sign(claims)
+}
+
+// parse an OIDC state token
+func ParseOIDCStateToken(tokenString string) (OIDCState, error) {
+	claims, err := ParseJWT(tokenString)
+	if err != nil {
+		return OIDCState{}, err
+	}
+	if claims["typ"] != TokenTypeOIDCState {
+		return OIDCState{}, fmt.Errorf("not an OIDC state token")
+	}
+	var state OIDCState
+	state.Provider, _ = claims["provider"].(string)
+	state.State, _ = claims["state"].(string)
+	state.Nonce, _ = claims["nonce"].(string)
+	state.CodeVerifier, _ = claims["code_verifier"].(string)
+	if state.State == "" || state.Nonce == "" || state.CodeVerifier == "" {
+		return OIDCState{}, fmt.Errorf("incomplete OIDC state token")
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secretKey)
+	return state, nil
 }
 
 // parse a JWT token
 func ParseJWT(tokenString string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return secretKey, nil
-	})
+	token, err := jwt.Parse(tokenString, CurrentKeySet().verificationKey, jwt.WithValidMethods([]string{AlgHS256, AlgRS256, AlgEdDSA}))
 
 	if err != nil {
 		log.Printf("Error parsing token: %v", err)
@@ -61,13 +204,45 @@ func ParseJWT(tokenString string) (jwt.MapClaims, error) {
 	return nil, fmt.Errorf("invalid token")
 }
 
-// genearate a refresh token
-func GenerateRefreshToken() (string, error) {
-	bytes := make([]byte, 32)
+// generate a token of the form <selector>.<verifier>; the selector finds the stored token and the verifier proves possession
+func GenerateToken() (token string, selector string, verifier string, err error) {
+	if selector, err = randomString(16); err != nil {
+		return "", "", "", err
+	}
+	if verifier, err = randomString(32); err != nil {
+		return "", "", "", err
+	}
+	return selector + "." + verifier, selector, verifier, nil
+}
+
+// split a token into its selector and verifier
+func SplitToken(token string) (selector string, verifier string, err error) {
+	selector, verifier, ok := strings.Cut(token, ".")
+	if !ok || selector == "" || verifier == "" {
+		return "", "", fmt.Errorf("malformed token")
+	}
+	return selector, verifier, nil
+}
+
+// hash a token verifier with the server's token hash key
+func HashVerifier(verifier string) string {
+	mac := hmac.New(sha256.New, currentTokenHashKey())
+	mac.Write([]byte(verifier))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// check a token verifier against its stored hash in constant time
+func CheckVerifier(hashedVerifier string, verifier string) bool {
+	return hmac.Equal([]byte(hashedVerifier), []byte(HashVerifier(verifier)))
+}
+
+// helper function to generate a random url-safe string from n random bytes
+func randomString(n int) (string, error) {
+	bytes := make([]byte, n)
 	if _, err := io.ReadFull(rand.Reader, bytes); err != nil {
 		return "", err
 	}
-	return base64.URLEncoding.EncodeToString(bytes), nil
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
 }
 
 // helper functions to set expiration times
@@ -77,24 +252,20 @@ func RefreshTokenExpiration() time.Time {
 func JWTExpiration() time.Time {
 	return time.Now().Add(time.Minute * 15)
 }
-
-// parse the user id from an expired JWT token
-func ParseID(tokenStr string) (uint, error) {
-	claims := jwt.MapClaims{}
-
-	// parse the token without verifying
-	_, _, err := new(jwt.Parser).ParseUnverified(tokenStr, claims)
-	if err != nil {
-		return 0, err
-	}
-
-	// Safely extract the user ID
-	userIDFloat, ok := claims["user_id"].(float64)
-	if !ok {
-		return 0, fmt.Errorf("user_id not found or invalid type")
-	}
-
-	return uint(userIDFloat), nil
+func MFATokenExpiration() time.Time {
+	return time.Now().Add(time.Minute * 5)
+}
+func ImpersonationTokenExpiration() time.Time {
+	return time.Now().Add(time.Minute * 30)
+}
+func OIDCStateTokenExpiration() time.Time {
+	return time.Now().Add(time.Minute * 10)
+}
+func PasswordResetTokenExpiration() time.Time {
+	return time.Now().Add(time.Hour)
+}
+func EmailVerificationTokenExpiration() time.Time {
+	return time.Now().Add(24 * time.Hour)
 }
 
 // extract the JWT from the request header
@@ -0,0 +1,20 @@
+package auth
+
+import "testing"
+
+func TestDeviceLabel(t *testing.T) {
+	cases := map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36 Edg/120.0": "Edge on Windows",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15": "Safari on macOS",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0":                                                "Firefox on Linux",
+		"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Mobile Safari/537.36":              "Chrome on Android",
+		"curl/8.4.0": "Unknown device",
+		"":           "Unknown device",
+	}
+
+	for userAgent, want := range cases {
+		if got := DeviceLabel(userAgent); got != want {
+			t.Errorf("DeviceLabel(%q) = %q, want %q", userAgent, got, want)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hawkerd/privateinstruction/internal/config"
+)
+
+// supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// a key used to sign or verify tokens
+type Key struct {
+	ID        string
+	Algorithm string
+	// signs tokens; nil for keys that are only kept to verify older tokens
+	Private interface{}
+	// verifies tokens
+	Public interface{}
+}
+
+// the keys used to sign and verify tokens
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// create a key set that signs with the key with the given ID
+func NewKeySet(signingKeyID string, keys ...*Key) (*KeySet, error) {
+	ks := &KeySet{keys: map[string]*Key{}}
+	for _, key := range keys {
+		if _, ok := ks.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+
+	signing, ok := ks.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found", signingKeyID)
+	}
+	if signing.Private == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", signingKeyID)
+	}
+	ks.signing = signing
+
+	return ks, nil
+}
+
+// create an HS256 key from a shared secret
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Algorithm: AlgHS256, Private: secret, Public: secret}
+}
+
+// load every key in a directory; <kid>.pem files hold RSA or Ed25519 keys and <kid>.secret files hold HS256 secrets
+func LoadKeys(dir string) ([]*Key, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*Key
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		id := strings.TrimSuffix(entry.Name(), ext)
+		if ext != ".pem" && ext != ".secret" {
+			continue
+		}
+
+		contents, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var key *Key
+		if ext == ".secret" {
+			key = NewHMACKey(id, []byte(strings.TrimSpace(string(contents))))
+		} else if key, err = ParsePEMKey(id, contents); err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// parse a PEM encoded private or public RSA or Ed25519 key
+func ParsePEMKey(id string, contents []byte) (*Key, error) {
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &Key{ID: id, Algorithm: AlgRS256, Private: k, Public: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &Key{ID: id, Algorithm: AlgRS256, Public: k}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: id, Algorithm: AlgEdDSA, Private: k, Public: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: id, Algorithm: AlgEdDSA, Public: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
+
+// the signing method matching the key's algorithm
+func (k *Key) method() jwt.SigningMethod {
+	switch k.Algorithm {
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// sign claims with the signing key, setting the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.method(), claims)
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signing.Private)
+}
+
+// find the key that verifies a token, making sure the algorithms match
+func (ks *KeySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	key := ks.signing
+	if kid, ok := token.Header["kid"].(string); ok {
+		if key, ok = ks.keys[kid]; !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Public, nil
+}
+
+// a JSON Web Key, as published in the JWKS document
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// the public keys other services can use to verify tokens; shared secrets are never published
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: AlgRS256,
+				N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: AlgEdDSA,
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID })
+	return jwks
+}
+
+// the key set used by GenerateJWT and ParseJWT
+var (
+	keySetMu sync.RWMutex
+	keySet   = newEphemeralKeySet()
+)
+
+// a random HS256 key, so tokens work out of the box but don't survive a restart
+func newEphemeralKeySet() *KeySet {
+	ks, _ := NewKeySet("ephemeral", NewHMACKey("ephemeral", newEphemeralSecret()))
+	return ks
+}
+
+// a random 32 byte secret
+func newEphemeralSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+// replace the key set used by GenerateJWT and ParseJWT
+func SetKeySet(ks *KeySet) {
+	keySetMu.Lock()
+	defer keySetMu.Unlock()
+	keySet = ks
+}
+
+// return the key set used by GenerateJWT and ParseJWT
+func CurrentKeySet() *KeySet {
+	keySetMu.RLock()
+	defer keySetMu.RUnlock()
+	return keySet
+}
+
+// the key used to hash token verifiers
+var (
+	tokenHashKeyMu sync.RWMutex
+	tokenHashKey   = newEphemeralSecret()
+)
+
+// replace the key used to hash token verifiers
+func SetTokenHashKey(key []byte) {
+	tokenHashKeyMu.Lock()
+	defer tokenHashKeyMu.Unlock()
+	tokenHashKey = key
+}
+
+func currentTokenHashKey() []byte {
+	tokenHashKeyMu.RLock()
+	defer tokenHashKeyMu.RUnlock()
+	return tokenHashKey
+}
+
+// load the keys described by the config and start using them
+func ConfigureKeys() error {
+	if key := config.GetTokenHashKey(); key != "" {
+		SetTokenHashKey([]byte(key))
+	} else {
+		log.Println("No token hash key configured, using a random key; sessions and reset links will not survive a restart")
+	}
+
+	var ks *KeySet
+	var err error
+	if dir := config.GetJWTKeysDir(); dir != "" {
+		keys, loadErr := LoadKeys(dir)
+		if loadErr != nil {
+			return loadErr
+		}
+		ks, err = NewKeySet(config.GetJWTSigningKeyID(), keys...)
+	} else if secret := config.GetJWTSecret(); secret != "" {
+		ks, err = NewKeySet("default", NewHMACKey("default", []byte(secret)))
+	} else {
+		log.Println("No JWT keys configured, using a random key; tokens will not survive a restart")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	SetKeySet(ks)
+	return nil
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// use a key set for the duration of a test
+func useKeySet(t *testing.T, ks *KeySet) {
+	t.Helper()
+
+	previous := CurrentKeySet()
+	SetKeySet(ks)
+	t.Cleanup(func() { SetKeySet(previous) })
+}
+
+func newRSAKey(t *testing.T, id string) *Key {
+	t.Helper()
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	return &Key{ID: id, Algorithm: AlgRS256, Private: private, Public: &private.PublicKey}
+}
+
+func TestGenerateAndParseWithEachAlgorithm(t *testing.T) {
+	_, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	keys := []*Key{
+		NewHMACKey("hmac", []byte("secret")),
+		newRSAKey(t, "rsa"),
+		{ID: "ed", Algorithm: AlgEdDSA, Private: edPrivate, Public: edPrivate.Public()},
+	}
+
+	for _, key := range keys {
+		t.Run(key.Algorithm, func(t *testing.T) {
+			ks, err := NewKeySet(key.ID, key)
+			if err != nil {
+				t.Fatalf("NewKeySet returned %v", err)
+			}
+			useKeySet(t, ks)
+
+			tokenString, err := GenerateJWT(7, "alice", 1)
+			if err != nil {
+				t.Fatalf("GenerateJWT returned %v", err)
+			}
+			token, _, _ := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+			if token.Header["kid"] != key.ID || token.Header["alg"] != key.Algorithm {
+				t.Fatalf("unexpected header %v", token.Header)
+			}
+
+			claims, err := ParseJWT(tokenString)
+			if err != nil {
+				t.Fatalf("ParseJWT returned %v", err)
+			}
+			if claims["username"] != "alice" {
+				t.Fatalf("unexpected claims %v", claims)
+			}
+		})
+	}
+}
+
+func TestRotatedKeyStillVerifies(t *testing.T) {
+	old := newRSAKey(t, "2024")
+	current := newRSAKey(t, "2025")
+
+	// sign with the old key
+	ks, _ := NewKeySet("2024", old)
+	useKeySet(t, ks)
+	tokenString, err := GenerateJWT(7, "alice", 1)
+	if err != nil {
+		t.Fatalf("GenerateJWT returned %v", err)
+	}
+
+	// rotate, keeping only the public half of the old key
+	ks, err = NewKeySet("2025", current, &Key{ID: "2024", Algorithm: AlgRS256, Public: old.Public})
+	if err != nil {
+		t.Fatalf("NewKeySet returned %v", err)
+	}
+	SetKeySet(ks)
+
+	if _, err := ParseJWT(tokenString); err != nil {
+		t.Fatalf("expected the old token to verify, got %v", err)
+	}
+
+	// once the old key is dropped its tokens are rejected
+	ks, _ = NewKeySet("2025", current)
+	SetKeySet(ks)
+	if _, err := ParseJWT(tokenString); err == nil {
+		t.Fatal("expected the old token to be rejected")
+	}
+}
+
+func TestParseRejectsAlgorithmMismatch(t *testing.T) {
+	rsaKey := newRSAKey(t, "rsa")
+	ks, _ := NewKeySet("rsa", rsaKey)
+	useKeySet(t, ks)
+
+	// an HS256 token signed with the public key must not be accepted for an RSA key id
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": 1})
+	token.Header["kid"] = "rsa"
+	publicDER, _ := x509.MarshalPKIXPublicKey(rsaKey.Public)
+	tokenString, _ := token.SignedString(publicDER)
+
+	if _, err := ParseJWT(tokenString); err == nil {
+		t.Fatal("expected the forged token to be rejected")
+	}
+}
+
+func TestLoadKeysAndJWKS(t *testing.T) {
+	dir := t.TempDir()
+
+	// an RSA private key, a retired Ed25519 public key and a shared secret
+	rsaKey := newRSAKey(t, "rsa")
+	rsaDER, _ := x509.MarshalPKCS8PrivateKey(rsaKey.Private)
+	os.WriteFile(filepath.Join(dir, "rsa.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rsaDER}), 0o600)
+	edPublic, _, _ := ed25519.GenerateKey(rand.Reader)
+	edDER, _ := x509.MarshalPKIXPublicKey(edPublic)
+	os.WriteFile(filepath.Join(dir, "ed.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: edDER}), 0o600)
+	os.WriteFile(filepath.Join(dir, "hmac.secret"), []byte("secret\n"), 0o600)
+
+	keys, err := LoadKeys(dir)
+	if err != nil {
+		t.Fatalf("LoadKeys returned %v", err)
+	}
+	if _, err := NewKeySet("ed", keys...); err == nil {
+		t.Fatal("expected a public-only key to be rejected for signing")
+	}
+	ks, err := NewKeySet("rsa", keys...)
+	if err != nil {
+		t.Fatalf("NewKeySet returned %v", err)
+	}
+
+	jwks := ks.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("expected the two asymmetric keys to be published, got %+v", jwks.Keys)
+	}
+	if jwks.Keys[0].KeyID != "ed" || jwks.Keys[0].KeyType != "OKP" || jwks.Keys[1].KeyID != "rsa" || jwks.Keys[1].E != "AQAB" {
+		t.Fatalf("unexpected keys %+v", jwks.Keys)
+	}
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hawkerd/privateinstruction/internal/config"
+)
+
+// bcrypt ignores everything after the first 72 bytes, so longer passwords are refused rather than silently truncated
+const MaxPasswordBytes = 72
+
+// codes of the password policy violations
+const (
+	PasswordTooShort         = "too_short"
+	PasswordTooLong          = "too_long"
+	PasswordContainsUsername = "contains_username"
+	PasswordContainsEmail    = "contains_email"
+	PasswordBreached         = "breached"
+)
+
+// a rule a password broke
+type PasswordViolation struct {
+	Code    string
+	Message string
+}
+
+// reports whether a password appears in a list of breached passwords
+type BreachChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+// the rules new passwords must follow
+type PasswordPolicy struct {
+	// minimum length in characters
+	MinLength int
+	// refuse passwords containing the username or the local part of the email
+	DisallowPersonalInfo bool
+	// refuse breached passwords when set
+	Breaches BreachChecker
+}
+
+// the policy used when none is configured
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:            8,
+	DisallowPersonalInfo: true,
+}
+
+// check a password for a user, returning every rule it breaks
+func (p PasswordPolicy) Check(password string, username string, email string) ([]PasswordViolation, error) {
+	var violations []PasswordViolation
+
+	// length, counted in characters for the minimum and in bytes for bcrypt's limit
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordTooShort,
+			Message: fmt.Sprintf("Password must be at least %d characters", p.MinLength),
+		})
+	}
+	if len(password) > MaxPasswordBytes {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordTooLong,
+			Message: fmt.Sprintf("Password must be at most %d bytes", MaxPasswordBytes),
+		})
+	}
+
+	// personal info is the first thing an attacker tries; very short names would match too many passwords to be useful
+	if p.DisallowPersonalInfo {
+		lower := strings.ToLower(password)
+		if name := strings.ToLower(strings.TrimSpace(username)); len(name) >= 3 && strings.Contains(lower, name) {
+			violations = append(violations, PasswordViolation{
+				Code:    PasswordContainsUsername,
+				Message: "Password must not contain your username",
+			})
+		}
+		local, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@")
+		if len(local) >= 3 && strings.Contains(lower, local) {
+			violations = append(violations, PasswordViolation{
+				Code:    PasswordContainsEmail,
+				Message: "Password must not contain your email address",
+			})
+		}
+	}
+
+	// known breached passwords
+	if p.Breaches != nil {
+		breached, err := p.Breaches.IsBreached(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, PasswordViolation{
+				Code:    PasswordBreached,
+				Message: "This password has appeared in a data breach, please choose another",
+			})
+		}
+	}
+
+	return violations, nil
+}
+
+// a local copy of a breached password list in k-anonymity range format: one file per 5 character
+// SHA-1 prefix, named <PREFIX> or <PREFIX>.txt, holding <SUFFIX>:<COUNT> lines
+type BreachedPasswordDir struct {
+	Dir string
+}
+
+// check the file for the password's hash prefix; only that file is read
+func (d BreachedPasswordDir) IsBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(d.Dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		file, err = os.Open(filepath.Join(d.Dir, prefix))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		// padded lists add fake entries with a count of 0
+		if strings.EqualFold(lineSuffix, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// the password policy described by the config
+func PasswordPolicyFromConfig() PasswordPolicy {
+	policy := DefaultPasswordPolicy
+	if minLength := config.GetPasswordMinLength(); minLength > 0 {
+		policy.MinLength = minLength
+	}
+	if dir := config.GetBreachedPasswordsDir(); dir != "" {
+		policy.Breaches = BreachedPasswordDir{Dir: dir}
+	}
+	return policy
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// the codes of a list of violations
+func violationCodes(violations []PasswordViolation) []string {
+	codes := []string{}
+	for _, violation := range violations {
+		codes = append(codes, violation.Code)
+	}
+	return codes
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := DefaultPasswordPolicy
+	cases := []struct {
+		password string
+		expected string
+	}{
+		{"correct horse", ""},
+		{"short", PasswordTooShort},
+		{strings.Repeat("é", 40), PasswordTooLong},
+		{"my-Alice-password", PasswordContainsUsername},
+		{"teacher.alice99", PasswordContainsEmail},
+	}
+	for _, c := range cases {
+		violations, err := policy.Check(c.password, "alice", "teacher.alice99@example.com")
+		if err != nil {
+			t.Fatalf("Check returned %v", err)
+		}
+		codes := strings.Join(violationCodes(violations), ",")
+		if c.expected == "" && codes != "" || !strings.Contains(codes, c.expected) {
+			t.Errorf("Check(%q) = %q, expected %q", c.password, codes, c.expected)
+		}
+	}
+
+	// very short usernames aren't matched
+	if violations, _ := policy.Check("bo-and-more", "bo", "x@example.com"); len(violations) != 0 {
+		t.Fatalf("expected no violations, got %v", violationCodes(violations))
+	}
+}
+
+func TestBreachedPasswordDir(t *testing.T) {
+	dir := t.TempDir()
+	sum := sha1.Sum([]byte("hunter22"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	contents := "0000000000000000000000000000000000A:0\n" + hash[5:] + ":42\n"
+	if err := os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	checker := BreachedPasswordDir{Dir: dir}
+
+	if breached, err := checker.IsBreached("hunter22"); err != nil || !breached {
+		t.Fatalf("expected hunter22 to be breached, got %v %v", breached, err)
+	}
+	if breached, err := checker.IsBreached("something else entirely"); err != nil || breached {
+		t.Fatalf("expected an unlisted password to pass, got %v %v", breached, err)
+	}
+
+	policy := PasswordPolicy{MinLength: 8, Breaches: checker}
+	violations, _ := policy.Check("hunter22", "", "")
+	if len(violations) != 1 || violations[0].Code != PasswordBreached {
+		t.Fatalf("expected a breached violation, got %v", violationCodes(violations))
+	}
+}
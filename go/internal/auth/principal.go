@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+// who a request is made by, as established by TokenAuthMiddleware
+type Principal struct {
+	UserID   uint
+	Username string
+	// the refresh token family of a signed in session; zero for personal access tokens
+	SessionID uint
+	Scopes    []string
+	// TokenTypeAccess or TokenTypePersonalAccess
+	TokenType string
+	// when the token stops working; zero for personal access tokens
+	ExpiresAt time.Time
+	// the admin really making the request while impersonating the user; nil otherwise
+	Actor *Actor
+}
+
+// the real user behind an impersonated request
+type Actor struct {
+	UserID   uint
+	Username string
+	// the impersonation the token was issued for, so it can be ended early
+	ImpersonationID uint
+}
+
+// whether the principal was granted a scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// whether the request was made with the user's own signed in session, rather than a personal access token or by an admin impersonating them
+func (p *Principal) IsSession() bool {
+	return p.TokenType == TokenTypeAccess && p.Actor == nil
+}
+
+// whether an admin is making the request as the user
+func (p *Principal) IsImpersonated() bool {
+	return p.Actor != nil
+}
+
+// unexported, so only this package can set or replace the principal
+type principalKey struct{}
+
+// add the principal to a context
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// the principal a context was authenticated as, if any
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
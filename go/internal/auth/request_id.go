@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+)
+
+// header a request ID is read from and echoed back in
+const RequestIDHeader = "X-Request-ID"
+
+// IDs passed in by a proxy are only kept when they look like one, so they can't be used to write arbitrary text into logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// whether a request ID passed in by a client or proxy can be used as is
+func ValidRequestID(id string) bool {
+	return validRequestID.MatchString(id)
+}
+
+// generate a new random request ID
+func GenerateRequestID() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(bytes)
+}
+
+type requestIDKey struct{}
+
+// add a request ID to a context
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// the ID of the request a context belongs to; empty when none was set
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
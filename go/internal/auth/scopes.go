@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"strings"
+)
+
+// scopes a personal access token can be granted; sessions from signing in have all of them
+const (
+	ScopeUserRead     = "user:read"
+	ScopeUserWrite    = "user:write"
+	ScopeClassesRead  = "classes:read"
+	ScopeClassesWrite = "classes:write"
+)
+
+// every scope, in the order they are shown
+var AllScopes = []string{ScopeUserRead, ScopeUserWrite, ScopeClassesRead, ScopeClassesWrite}
+
+// scopes of an impersonation token; an admin can see and use classes as the user, but not change their account
+var ImpersonationScopes = []string{ScopeUserRead, ScopeClassesRead, ScopeClassesWrite}
+
+// whether a scope is known
+func ValidScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// personal access tokens start with this, so they can be told apart from JWTs and found by secret scanners
+const PersonalAccessTokenPrefix = "pat_"
+
+// whether a bearer token is a personal access token rather than a JWT
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTOTP(t *testing.T) {
+	// the SHA1 secret from RFC 6238, whose 8 digit code at T=59 is 94287082
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(59, 0)
+
+	step, ok := ValidateTOTP(secret, "287082", now)
+	if !ok || step != 1 {
+		t.Fatalf("expected the RFC code to be valid at step 1, got %d %v", step, ok)
+	}
+
+	// one step of drift is allowed, two are not
+	if _, ok := ValidateTOTP(secret, "287082", now.Add(30*time.Second)); !ok {
+		t.Fatal("expected the code to be accepted one step later")
+	}
+	if _, ok := ValidateTOTP(secret, "287082", now.Add(90*time.Second)); ok {
+		t.Fatal("expected the code to be rejected two steps later")
+	}
+	if _, ok := ValidateTOTP(secret, "000000", now); ok {
+		t.Fatal("expected a wrong code to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("alice@example.com", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/PrivateInstruction:alice@example.com?") || !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") {
+		t.Fatalf("unexpected uri %q", uri)
+	}
+}
+
+func TestRecoveryCodeNormalizes(t *testing.T) {
+	code, err := GenerateRecoveryCode()
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCode returned %v", err)
+	}
+	if NormalizeRecoveryCode(" "+strings.ToUpper(code)+" ") != strings.ReplaceAll(code, "-", "") {
+		t.Fatalf("expected %q to normalize", code)
+	}
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -17,3 +19,124 @@ func LoadEnv() {
 func GetDatabaseURL() string {
 	return os.Getenv("DATABASE_URL")
 }
+
+// directory holding the JWT keys, one <kid>.pem or <kid>.secret file per key
+func GetJWTKeysDir() string {
+	return os.Getenv("JWT_KEYS_DIR")
+}
+
+// ID of the key used to sign new tokens
+func GetJWTSigningKeyID() string {
+	return os.Getenv("JWT_SIGNING_KEY_ID")
+}
+
+// shared HS256 secret, used when no keys directory is configured
+func GetJWTSecret() string {
+	return os.Getenv("JWT_SECRET")
+}
+
+// secret used to hash refresh and password reset tokens
+func GetTokenHashKey() string {
+	return os.Getenv("TOKEN_HASH_KEY")
+}
+
+// base URL of the web app, used to build links sent by email
+func GetAppURL() string {
+	if url := os.Getenv("APP_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:3000"
+}
+
+// SMTP server used to send email; when unset, email is written to the log instead
+func GetSMTPHost() string {
+	return os.Getenv("SMTP_HOST")
+}
+
+func GetSMTPPort() string {
+	if port := os.Getenv("SMTP_PORT"); port != "" {
+		return port
+	}
+	return "587"
+}
+
+func GetSMTPUsername() string {
+	return os.Getenv("SMTP_USERNAME")
+}
+
+func GetSMTPPassword() string {
+	return os.Getenv("SMTP_PASSWORD")
+}
+
+// address email is sent from
+func GetMailFrom() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+	return "no-reply@privateinstruction.local"
+}
+
+// file email is appended to when no SMTP server is configured; stdout when unset
+func GetMailLogFile() string {
+	return os.Getenv("MAIL_LOG_FILE")
+}
+
+// whether users must verify their email before creating or joining classes
+func GetRequireVerifiedEmail() bool {
+	return os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+}
+
+// base URL of this server, used to build the redirect URLs registered with OIDC providers
+func GetAPIURL() string {
+	if url := os.Getenv("API_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:8080"
+}
+
+// names of the OIDC providers users can sign in with, e.g. "google,microsoft"
+func GetOIDCProviderNames() []string {
+	var names []string
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// a setting of an OIDC provider, read from OIDC_<NAME>_<KEY>, e.g. OIDC_GOOGLE_CLIENT_ID
+func GetOIDCProviderSetting(name string, key string) string {
+	return os.Getenv("OIDC_" + strings.ToUpper(name) + "_" + key)
+}
+
+// minimum password length; the built in default is used when unset
+func GetPasswordMinLength() int {
+	minLength, _ := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH"))
+	return minLength
+}
+
+// directory holding a breached password list in k-anonymity range format; no check is made when unset
+func GetBreachedPasswordsDir() string {
+	return os.Getenv("BREACHED_PASSWORDS_DIR")
+}
+
+// emails of the accounts given the platform admin role at startup once verified, e.g. "alice@example.com,bob@example.com"
+func GetPlatformAdminEmails() []string {
+	var emails []string
+	for _, email := range strings.Split(os.Getenv("PLATFORM_ADMIN_EMAILS"), ",") {
+		if email = strings.TrimSpace(strings.ToLower(email)); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
+// days audit events are kept for; 0 keeps them forever, and -1 is returned when unset so the built in default is used
+func GetAuditRetentionDays() int {
+	days, err := strconv.Atoi(os.Getenv("AUDIT_RETENTION_DAYS"))
+	if err != nil || days < 0 {
+		return -1
+	}
+	return days
+}
@@ -0,0 +1,507 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hawkerd/privateinstruction/internal/models/api_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/services"
+)
+
+// @Summary		Admin List Users
+// @Description	Search every user by username or email
+// @Produce		json
+// @Security		BearerAuth
+// @Param			Authorization	header		string	true	"Bearer Token"
+// @Param			q				query		string	false	"Text to search for in usernames and emails"
+// @Param			cursor			query		string	false	"Cursor returned by the previous page"
+// @Param			limit			query		int		false	"Page size"
+// @Success		200				{object}	api_models.AdminListUsersResponse
+// @Router			/admin/users [get]
+// @Tags			Admin
+func AdminListUsers(adminService *services.AdminService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// read the query parameters
+		query := r.URL.Query()
+		limit, ok := parseLimit(w, query.Get("limit"))
+		if !ok {
+			return
+		}
+
+		// build the service request
+		sreq := service_models.AdminListUsersRequest{
+			Query:  query.Get("q"),
+			Cursor: query.Get("cursor"),
+			Limit:  limit,
+		}
+
+		// call the service
+		sres, err := adminService.ListUsers(sreq)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidCursor) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// build the response
+		res := api_models.AdminListUsersResponse{
+			Users:      make([]api_models.AdminUserSummary, 0, len(sres.Users)),
+			NextCursor: sres.NextCursor,
+		}
+		for _, user := range sres.Users {
+			res.Users = append(res.Users, api_models.AdminUserSummary{
+				ID:                    user.UserID,
+				Username:              user.Username,
+				Email:                 user.Email,
+				EmailVerified:         user.EmailVerified,
+				Role:                  user.Role,
+				TwoFactorEnabled:      user.TwoFactorEnabled,
+				Disabled:              user.Disabled,
+				PasswordResetRequired: user.PasswordResetRequired,
+				CreatedAt:             user.CreatedAt.Format("2006-01-02 15:04:05"),
+			})
+		}
+
+		// encode the response
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// @Summary		Admin Disable User
+// @Description	Disable an account and sign it out everywhere
+// @Accept			json
+// @Security		BearerAuth
+// @Param			Authorization	header	string							true	"Bearer Token"
+// @Param			id				path	int								true	"User ID"
+// @Param			reason			body	api_models.AdminActionRequest	false	"Why, kept with the record of the action"
+// @Success		204
+// @Router			/admin/users/{id}/disable [post]
+// @Tags			Admin
+func AdminDisableUser(adminService *services.AdminService) http.HandlerFunc {
+	return adminUserAction(adminService.DisableUser)
+}
+
+// @Summary		Admin Enable User
+// @Description	Re-enable a disabled account
+// @Accept			json
+// @Security		BearerAuth
+// @Param			Authorization	header	string							true	"Bearer Token"
+// @Param			id				path	int								true	"User ID"
+// @Param			reason			body	api_models.AdminActionRequest	false	"Why, kept with the record of the action"
+// @Success		204
+// @Router			/admin/users/{id}/enable [post]
+// @Tags			Admin
+func AdminEnableUser(adminService *services.AdminService) http.HandlerFunc {
+	return adminUserAction(adminService.EnableUser)
+}
+
+// @Summary		Admin Force Password Reset
+// @Description	Sign a user out everywhere and refuse sign in until they reset their password with an emailed link
+// @Accept			json
+// @Security		BearerAuth
+// @Param			Authorization	header	string							true	"Bearer Token"
+// @Param			id				path	int								true	"User ID"
+// @Param			reason			body	api_models.AdminActionRequest	false	"Why, kept with the record of the action"
+// @Success		204
+// @Router			/admin/users/{id}/password-reset [post]
+// @Tags			Admin
+func AdminForcePasswordReset(adminService *services.AdminService) http.HandlerFunc {
+	return adminUserAction(adminService.ForcePasswordReset)
+}
+
+// @Summary		Admin Impersonate User
+// @Description	Get a 30 minute access token acting as the user, to see what they see; it can't change their account, and every request made with it is recorded
+// @Accept			json
+// @Produce		json
+// @Security		BearerAuth
+// @Param			Authorization	header		string							true	"Bearer Token"
+// @Param			id				path		int								true	"User ID"
+// @Param			reason			body		api_models.AdminActionRequest	false	"Why, kept with the record of the action"
+// @Success		200				{object}	api_models.AdminImpersonateResponse
+// @Router			/admin/users/{id}/impersonate [post]
+// @Tags			Admin
+func AdminImpersonateUser(adminService *services.AdminService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		adminID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the target user ID from the URL
+		userID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+		if err != nil {
+			http.Error(w, "invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		// decode the request body
+		req, ok := decodeAdminActionRequest(w, r)
+		if !ok {
+			return
+		}
+
+		// build the service request
+		sreq := service_models.AdminImpersonateRequest{
+			AdminID: adminID,
+			UserID:  uint(userID),
+			Audit:   auditContext(r),
+			Reason:  req.Reason,
+		}
+
+		// call the service
+		sres, err := adminService.ImpersonateUser(sreq)
+		if err != nil {
+			if errors.Is(err, services.ErrUserNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else if errors.Is(err, services.ErrCannotTargetSelf) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else if errors.Is(err, services.ErrCannotImpersonateAdmin) || errors.Is(err, services.ErrAccountDisabled) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// build the response
+		res := api_models.AdminImpersonateResponse{
+			ImpersonationID: sres.ImpersonationID,
+			AccessToken:     sres.AccessToken,
+			ExpiresAt:       sres.ExpiresAt.Format("2006-01-02 15:04:05"),
+		}
+
+		// encode the response
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// @Summary		Admin End Impersonation
+// @Description	End an impersonation before its token expires; the token is refused from then on
+// @Accept			json
+// @Security		BearerAuth
+// @Param			Authorization	header	string							true	"Bearer Token"
+// @Param			id				path	int								true	"Impersonation ID"
+// @Param			reason			body	api_models.AdminActionRequest	false	"Why, kept with the record of the action"
+// @Success		204
+// @Router			/admin/impersonations/{id} [delete]
+// @Tags			Admin
+func AdminEndImpersonation(adminService *services.AdminService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		adminID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the impersonation ID from the URL
+		impersonationID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+		if err != nil {
+			http.Error(w, "invalid impersonation ID", http.StatusBadRequest)
+			return
+		}
+
+		// decode the request body
+		req, ok := decodeAdminActionRequest(w, r)
+		if !ok {
+			return
+		}
+
+		// build the service request
+		sreq := service_models.AdminEndImpersonationRequest{
+			AdminID:         adminID,
+			ImpersonationID: uint(impersonationID),
+			Audit:           auditContext(r),
+			Reason:          req.Reason,
+		}
+
+		// call the service
+		if err := adminService.EndImpersonation(sreq); err != nil {
+			if errors.Is(err, services.ErrImpersonationNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else if errors.Is(err, services.ErrImpersonationEnded) {
+				http.Error(w, err.Error(), http.StatusConflict)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary		Admin List Classes
+// @Description	List every class, optionally searching by name
+// @Produce		json
+// @Security		BearerAuth
+// @Param			Authorization	header		string	true	"Bearer Token"
+// @Param			q				query		string	false	"Text to search for in class names"
+// @Param			cursor			query		string	false	"Cursor returned by the previous page"
+// @Param			limit			query		int		false	"Page size"
+// @Success		200				{object}	api_models.ListClassesResponse
+// @Router			/admin/classes [get]
+// @Tags			Admin
+func AdminListClasses(adminService *services.AdminService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// read the query parameters
+		query := r.URL.Query()
+		limit, ok := parseLimit(w, query.Get("limit"))
+		if !ok {
+			return
+		}
+
+		// build the service request
+		sreq := service_models.AdminListClassesRequest{
+			Query:  query.Get("q"),
+			Cursor: query.Get("cursor"),
+			Limit:  limit,
+		}
+
+		// call the service
+		sres, err := adminService.ListClasses(sreq)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidCursor) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// build the response
+		res := api_models.ListClassesResponse{
+			Classes:    make([]api_models.ClassSummary, 0, len(sres.Classes)),
+			NextCursor: sres.NextCursor,
+		}
+		for _, class := range sres.Classes {
+			res.Classes = append(res.Classes, api_models.ClassSummary{
+				ID:          class.ClassID,
+				Name:        class.Name,
+				Description: class.Description,
+				MemberCount: class.MemberCount,
+				CreatedAt:   class.CreatedAt.Format("2006-01-02 15:04:05"),
+				CreatedBy:   class.CreatedBy,
+			})
+		}
+
+		// encode the response
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// @Summary		Admin Delete Class
+// @Description	Delete any class along with its members and join codes
+// @Accept			json
+// @Security		BearerAuth
+// @Param			Authorization	header	string							true	"Bearer Token"
+// @Param			id				path	int								true	"Class ID"
+// @Param			reason			body	api_models.AdminActionRequest	false	"Why, kept with the record of the action"
+// @Success		204
+// @Router			/admin/classes/{id} [delete]
+// @Tags			Admin
+func AdminDeleteClass(adminService *services.AdminService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		adminID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the class ID from the URL
+		classID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+		if err != nil {
+			http.Error(w, "invalid class ID", http.StatusBadRequest)
+			return
+		}
+
+		// decode the request body
+		req, ok := decodeAdminActionRequest(w, r)
+		if !ok {
+			return
+		}
+
+		// build the service request
+		sreq := service_models.AdminDeleteClassRequest{
+			AdminID: adminID,
+			ClassID: uint(classID),
+			Audit:   auditContext(r),
+			Reason:  req.Reason,
+		}
+
+		// call the service
+		if err := adminService.DeleteClass(sreq); err != nil {
+			if errors.Is(err, services.ErrClassNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary		Admin List Actions
+// @Description	List what platform admins have done, newest first
+// @Produce		json
+// @Security		BearerAuth
+// @Param			Authorization	header		string	true	"Bearer Token"
+// @Param			cursor			query		string	false	"Cursor returned by the previous page"
+// @Param			limit			query		int		false	"Page size"
+// @Success		200				{object}	api_models.AdminListActionsResponse
+// @Router			/admin/actions [get]
+// @Tags			Admin
+func AdminListActions(adminService *services.AdminService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// read the query parameters
+		query := r.URL.Query()
+		limit, ok := parseLimit(w, query.Get("limit"))
+		if !ok {
+			return
+		}
+
+		// build the service request
+		sreq := service_models.AdminListActionsRequest{
+			Cursor: query.Get("cursor"),
+			Limit:  limit,
+		}
+
+		// call the service
+		sres, err := adminService.ListActions(sreq)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidCursor) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// build the response
+		res := api_models.AdminListActionsResponse{
+			Actions:    make([]api_models.AdminActionSummary, 0, len(sres.Actions)),
+			NextCursor: sres.NextCursor,
+		}
+		for _, action := range sres.Actions {
+			res.Actions = append(res.Actions, api_models.AdminActionSummary{
+				ID:         action.ID,
+				AdminID:    action.AdminID,
+				Admin:      action.Admin,
+				Action:     action.Action,
+				TargetType: action.TargetType,
+				TargetID:   action.TargetID,
+				IPAddress:  action.IPAddress,
+				Details:    action.Details,
+				CreatedAt:  action.CreatedAt.Format("2006-01-02 15:04:05"),
+			})
+		}
+
+		// encode the response
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// helper function to build a handler for an admin action on the user in the URL
+func adminUserAction(action func(service_models.AdminUserActionRequest) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		adminID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the target user ID from the URL
+		userID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+		if err != nil {
+			http.Error(w, "invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		// decode the request body
+		req, ok := decodeAdminActionRequest(w, r)
+		if !ok {
+			return
+		}
+
+		// build the service request
+		sreq := service_models.AdminUserActionRequest{
+			AdminID: adminID,
+			UserID:  uint(userID),
+			Audit:   auditContext(r),
+			Reason:  req.Reason,
+		}
+
+		// call the service
+		if err := action(sreq); err != nil {
+			if errors.Is(err, services.ErrUserNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else if errors.Is(err, services.ErrCannotTargetSelf) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else if errors.Is(err, services.ErrAccountAlreadyDisabled) || errors.Is(err, services.ErrAccountNotDisabled) {
+				http.Error(w, err.Error(), http.StatusConflict)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// helper function to decode the optional body of an admin action
+func decodeAdminActionRequest(w http.ResponseWriter, r *http.Request) (api_models.AdminActionRequest, bool) {
+	var req api_models.AdminActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// helper function to read the limit query parameter, writing an error if it isn't valid
+func parseLimit(w http.ResponseWriter, limitStr string) (int, bool) {
+	if limitStr == "" {
+		return 0, true
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 0 {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/hawkerd/privateinstruction/internal/models/api_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/services"
+)
+
+// @Summary		Admin List Audit Events
+// @Description	List the audit log, newest first
+// @Produce		json
+// @Security		BearerAuth
+// @Param			Authorization	header		string	true	"Bearer Token"
+// @Param			actor_id		query		int		false	"Only events by this user"
+// @Param			action			query		string	false	"Only events with this action, e.g. class.delete"
+// @Param			target_type		query		string	false	"Only events on this kind of target, e.g. user"
+// @Param			target_id		query		int		false	"Only events on this target"
+// @Param			class_id		query		int		false	"Only events in this class"
+// @Param			cursor			query		string	false	"Cursor returned by the previous page"
+// @Param			limit			query		int		false	"Page size"
+// @Success		200				{object}	api_models.ListAuditEventsResponse
+// @Router			/admin/audit-events [get]
+// @Tags			Admin
+func AdminListAuditEvents(adminService *services.AdminService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// read the query parameters
+		query := r.URL.Query()
+		limit, ok := parseLimit(w, query.Get("limit"))
+		if !ok {
+			return
+		}
+		actorID, ok := parseIDFilter(w, query.Get("actor_id"))
+		if !ok {
+			return
+		}
+		targetID, ok := parseIDFilter(w, query.Get("target_id"))
+		if !ok {
+			return
+		}
+		classID, ok := parseIDFilter(w, query.Get("class_id"))
+		if !ok {
+			return
+		}
+
+		// build the service request
+		sreq := service_models.AdminListAuditEventsRequest{
+			ActorID:    actorID,
+			Action:     query.Get("action"),
+			TargetType: query.Get("target_type"),
+			TargetID:   targetID,
+			ClassID:    classID,
+			Cursor:     query.Get("cursor"),
+			Limit:      limit,
+		}
+
+		// call the service
+		sres, err := adminService.ListAuditEvents(sreq)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidCursor) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		writeAuditEvents(w, sres)
+	}
+}
+
+// @Summary		List Class Audit Events
+// @Description	List the audit log of a class, newest first; only class admins can see it
+// @Produce		json
+// @Security		BearerAuth
+// @Param			Authorization	header		string	true	"Bearer Token"
+// @Param			id				path		int		true	"Class ID"
+// @Param			actor_id		query		int		false	"Only events by this user"
+// @Param			action			query		string	false	"Only events with this action, e.g. class.remove_member"
+// @Param			cursor			query		string	false	"Cursor returned by the previous page"
+// @Param			limit			query		int		false	"Page size"
+// @Success		200				{object}	api_models.ListClassAuditEventsResponse
+// @Router			/class/{id}/audit-events [get]
+// @Tags			Class
+func ListClassAuditEvents(classService *services.ClassService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the class ID from the URL
+		classID, err := getClassIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// read the query parameters
+		query := r.URL.Query()
+		limit, ok := parseLimit(w, query.Get("limit"))
+		if !ok {
+			return
+		}
+		actorID, ok := parseIDFilter(w, query.Get("actor_id"))
+		if !ok {
+			return
+		}
+
+		// build the service request
+		sreq := service_models.ListClassAuditEventsRequest{
+			ClassID: classID,
+			UserID:  userID,
+			ActorID: actorID,
+			Action:  query.Get("action"),
+			Cursor:  query.Get("cursor"),
+			Limit:   limit,
+		}
+
+		// call the service
+		sres, err := classService.ListAuditEvents(sreq)
+		if err != nil {
+			if errors.Is(err, services.ErrClassNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else if errors.Is(err, services.ErrUnauthorized) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+			} else if errors.Is(err, services.ErrInvalidCursor) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// build the response
+		res := api_models.ListClassAuditEventsResponse{
+			Events:     make([]api_models.ClassAuditEventSummary, 0, len(sres.Events)),
+			NextCursor: sres.NextCursor,
+		}
+		for _, event := range sres.Events {
+			res.Events = append(res.Events, api_models.ClassAuditEventSummary{
+				ID:         event.ID,
+				ActorID:    event.ActorID,
+				Action:     event.Action,
+				TargetType: event.TargetType,
+				TargetID:   event.TargetID,
+				Before:     auditFieldsJSON(event.Before),
+				After:      auditFieldsJSON(event.After),
+				RequestID:  event.RequestID,
+				CreatedAt:  event.CreatedAt.Format("2006-01-02 15:04:05"),
+			})
+		}
+
+		// encode the response
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// helper function to build and encode a page of audit events
+func writeAuditEvents(w http.ResponseWriter, sres service_models.ListAuditEventsResponse) {
+	// build the response
+	res := api_models.ListAuditEventsResponse{
+		Events:     make([]api_models.AuditEventSummary, 0, len(sres.Events)),
+		NextCursor: sres.NextCursor,
+	}
+	for _, event := range sres.Events {
+		res.Events = append(res.Events, api_models.AuditEventSummary{
+			ID:             event.ID,
+			ActorID:        event.ActorID,
+			ImpersonatorID: event.ImpersonatorID,
+			Action:         event.Action,
+			TargetType:     event.TargetType,
+			TargetID:       event.TargetID,
+			ClassID:        event.ClassID,
+			Before:         auditFieldsJSON(event.Before),
+			After:          auditFieldsJSON(event.After),
+			IPAddress:      event.IPAddress,
+			RequestID:      event.RequestID,
+			CreatedAt:      event.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+
+	// encode the response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// helper function to pass recorded fields through as JSON; nil when nothing was recorded, so the field is left out
+func auditFieldsJSON(fields string) json.RawMessage {
+	if fields == "" {
+		return nil
+	}
+	return json.RawMessage(fields)
+}
+
+// helper function to parse an optional ID filter from the query string; zero when absent
+func parseIDFilter(w http.ResponseWriter, idStr string) (uint, bool) {
+	if idStr == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return 0, false
+	}
+	return uint(id), true
+}
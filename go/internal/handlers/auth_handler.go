@@ -3,21 +3,57 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/config"
 	"github.com/hawkerd/privateinstruction/internal/models/api_models"
 	"github.com/hawkerd/privateinstruction/internal/models/service_models"
 	"github.com/hawkerd/privateinstruction/internal/services"
 )
 
+// the refresh token cookie is sent to /auth/refresh and /auth/logout
+const refreshTokenCookie = "refresh_token"
+const refreshTokenCookiePath = "/auth"
+
+// helper function to store the refresh token in an http-only cookie
+func setRefreshTokenCookie(w http.ResponseWriter, refreshToken string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    refreshToken,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   false, // for testing
+		Path:     refreshTokenCookiePath,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// helper function to remove the refresh token cookie
+func clearRefreshTokenCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   false, // for testing
+		Path:     refreshTokenCookiePath,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
 // @Summary		Sign Up
 // @Description	Sign up a new user
 // @Accept			json
 // @Produce		json
 // @Param			user	body		api_models.SignUpRequest	true	"User details for sign up"
 // @Success		201		{string}	string						"User created successfully"
-// @Failure		400		{string}	string						"Bad Request"
+// @Failure		400		{object}	api_models.PasswordPolicyErrorResponse	"Password breaks the policy"
 // @Failure		409		{string}	string						"Conflict"
 // @Failure		500		{string}	string						"Internal Server Error"
 // @Router			/signup [post]
@@ -42,12 +78,16 @@ func SignUp(authService *services.AuthService) http.HandlerFunc {
 			Username: req.Username,
 			Password: req.Password,
 			Email:    req.Email,
+			Audit:    auditContext(r),
 		}
 
 		// call the service
 		if err := authService.SignUp(sreq); err != nil {
 			// return appropriate error message
-			if errors.Is(err, services.ErrUserExists) {
+			var policyErr *services.PasswordPolicyError
+			if errors.As(err, &policyErr) {
+				writePasswordPolicyError(w, policyErr)
+			} else if errors.Is(err, services.ErrUserExists) {
 				http.Error(w, err.Error(), http.StatusConflict)
 			} else {
 				http.Error(w, "Something went wrong", http.StatusInternalServerError)
@@ -64,9 +104,11 @@ func SignUp(authService *services.AuthService) http.HandlerFunc {
 //		@Summary		Sign In
 //		@Description	Sign in an existing user with username/email and password
 //	 @Description	Also sets the refresh token in the cookie
+//	 @Description	With two-factor authentication enabled, returns an MFA token for /signin/mfa instead
 //		@Accept			json
 //		@Produce		json
 //		@Param			user	body	api_models.SignInRequest	true	"User credentials for sign in"
+//		@Failure		429	{string}	string	"Too many failed attempts; see Retry-After"
 //		@Router			/signin [post]
 //		@Tags			Auth
 func SignIn(authService *services.AuthService) http.HandlerFunc {
@@ -86,37 +128,40 @@ func SignIn(authService *services.AuthService) http.HandlerFunc {
 
 		// build the service request
 		sreq := service_models.SignInRequest{
-			Username: req.Username,
-			Email:    req.Email,
-			Password: req.Password,
+			Username:  req.Username,
+			Email:     req.Email,
+			Password:  req.Password,
+			UserAgent: r.UserAgent(),
+			IPAddress: auth.ClientIP(r),
 		}
 
 		// call the service
 		sres, err := authService.SignIn(sreq)
 		if err != nil {
 			// return appropriate error message
-			if errors.Is(err, services.ErrInvalidCredentials) {
+			var retryErr *services.RetryAfterError
+			if errors.As(err, &retryErr) {
+				writeRetryAfter(w, retryErr)
+			} else if errors.Is(err, services.ErrInvalidCredentials) {
 				http.Error(w, err.Error(), http.StatusUnauthorized)
+			} else if errors.Is(err, services.ErrAccountDisabled) || errors.Is(err, services.ErrPasswordReset) {
+				http.Error(w, err.Error(), http.StatusForbidden)
 			} else {
 				http.Error(w, "internal server error", http.StatusInternalServerError)
 			}
 			return
 		}
 
-		// set the refresh token in the cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "refresh_token",
-			Value:    sres.RefreshToken,
-			Expires:  sres.RefreshTokenExpiration,
-			HttpOnly: true,
-			Secure:   false, // for testing
-			Path:     "/auth/refresh",
-			SameSite: http.SameSiteStrictMode,
-		})
+		// set the refresh token in the cookie, unless a second factor is still needed
+		if !sres.MFARequired {
+			setRefreshTokenCookie(w, sres.RefreshToken, sres.RefreshTokenExpiration)
+		}
 
 		// build the response
 		res := api_models.SignInResponse{
 			AccessToken: sres.AccessToken,
+			MFARequired: sres.MFARequired,
+			MFAToken:    sres.MFAToken,
 		}
 
 		// encode the response
@@ -129,6 +174,31 @@ func SignIn(authService *services.AuthService) http.HandlerFunc {
 	}
 }
 
+// helper function to refuse a throttled request, saying when to try again
+func writeRetryAfter(w http.ResponseWriter, err *services.RetryAfterError) {
+	seconds := int(math.Ceil(err.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
+
+// helper function to refuse a password that breaks the policy, listing the rules it broke
+func writePasswordPolicyError(w http.ResponseWriter, err *services.PasswordPolicyError) {
+	res := api_models.PasswordPolicyErrorResponse{
+		Error:      err.Error(),
+		Violations: make([]api_models.PasswordViolation, 0, len(err.Violations)),
+	}
+	for _, violation := range err.Violations {
+		res.Violations = append(res.Violations, api_models.PasswordViolation{
+			Code:    violation.Code,
+			Message: violation.Message,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(res)
+}
+
 // @Summary		Update Password
 // @Description	Update the password for an existing user
 // @Accept			json
@@ -136,12 +206,13 @@ func SignIn(authService *services.AuthService) http.HandlerFunc {
 // @Security		BearerAuth
 // @Param			Authorization	header	string								true	"Bearer Token"
 // @Param			user			body	api_models.UpdatePasswordRequest	true	"User credentials for updating password"
+// @Failure		400	{object}	api_models.PasswordPolicyErrorResponse	"Password breaks the policy"
 // @Router			/me/password [put]
 // @Tags			Auth
 func UpdatePassword(authService *services.AuthService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// extract the user ID from the request context
-		userID, ok := r.Context().Value(userIDKey).(uint)
+		userID, ok := userIDFromContext(r)
 		if !ok {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
@@ -165,12 +236,16 @@ func UpdatePassword(authService *services.AuthService) http.HandlerFunc {
 			UserID:      userID,
 			OldPassword: req.OldPassword,
 			NewPassword: req.NewPassword,
+			Audit:       auditContext(r),
 		}
 
 		// call the service
 		if err := authService.UpdatePassword(sreq); err != nil {
 			// return appropriate error message
-			if errors.Is(err, services.ErrInvalidCredentials) {
+			var policyErr *services.PasswordPolicyError
+			if errors.As(err, &policyErr) {
+				writePasswordPolicyError(w, policyErr)
+			} else if errors.Is(err, services.ErrInvalidCredentials) {
 				http.Error(w, err.Error(), http.StatusUnauthorized)
 			} else if errors.Is(err, services.ErrUserNotFound) {
 				http.Error(w, err.Error(), http.StatusNotFound)
@@ -184,31 +259,181 @@ func UpdatePassword(authService *services.AuthService) http.HandlerFunc {
 	}
 }
 
-// @Summary		Refresh Access Token
-// @Description	Refresh the access token using the refresh token
-// @Accept		json
-// @Produce		json
+// @Summary		Verify Email
+// @Description	Confirm an email address with the token from a verification email
+// @Accept			json
+// @Param			user	body	api_models.VerifyEmailRequest	true	"Verification token"
+// @Success		204
+// @Router			/auth/verify-email [post]
+// @Tags			Auth
+func VerifyEmail(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// decode the request body
+		var req api_models.VerifyEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// input validation
+		if req.Token == "" {
+			http.Error(w, "token is required", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.VerifyEmailRequest{
+			Token: req.Token,
+			Audit: auditContext(r),
+		}
+
+		// call the service
+		if err := authService.VerifyEmail(sreq); err != nil {
+			if errors.Is(err, services.ErrInvalidVerification) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else if errors.Is(err, services.ErrUserExists) {
+				http.Error(w, err.Error(), http.StatusConflict)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary		Resend Verification Email
+// @Description	Send another verification email, to the pending email if a change is waiting
+// @Security		BearerAuth
 // @Param			Authorization	header	string	true	"Bearer Token"
-// @Router			/auth/refresh [post]
+// @Success		202
+// @Router			/auth/verify-email/resend [post]
 // @Tags			Auth
-func RefreshToken(authService *services.AuthService) http.HandlerFunc {
+func ResendVerification(authService *services.AuthService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// extract the JWT from the request header
-		tokenString, err := auth.ExtractJWT(r)
-		if err != nil {
-			http.Error(w, "Missing or invalid token", http.StatusUnauthorized)
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		// parse the token to extract the user ID
-		userID, err := auth.ParseID(tokenString)
-		if err != nil {
-			http.Error(w, "invalid token", http.StatusUnauthorized)
+		// build the service request
+		sreq := service_models.ResendVerificationRequest{
+			UserID: userID,
+		}
+
+		// call the service
+		if err := authService.ResendVerification(sreq); err != nil {
+			if errors.Is(err, services.ErrAlreadyVerified) {
+				http.Error(w, err.Error(), http.StatusConflict)
+			} else if errors.Is(err, services.ErrUserNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// @Summary		Forgot Password
+// @Description	Email a password reset link; the response is the same whether or not the email has an account
+// @Accept			json
+// @Param			user	body	api_models.ForgotPasswordRequest	true	"Account email"
+// @Success		202
+// @Router			/auth/password/forgot [post]
+// @Tags			Auth
+func ForgotPassword(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// decode the request body
+		var req api_models.ForgotPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// input validation
+		if req.Email == "" {
+			http.Error(w, "email is required", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.ForgotPasswordRequest{
+			Email: req.Email,
+		}
+
+		// call the service
+		if err := authService.ForgotPassword(sreq); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
 			return
 		}
 
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// @Summary		Reset Password
+// @Description	Set a new password with the token from a reset email; signs out every session
+// @Accept			json
+// @Param			user	body	api_models.ResetPasswordRequest	true	"Reset token and new password"
+// @Success		204
+// @Failure		400	{object}	api_models.PasswordPolicyErrorResponse	"Password breaks the policy"
+// @Router			/auth/password/reset [post]
+// @Tags			Auth
+func ResetPassword(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// decode the request body
+		var req api_models.ResetPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// input validation
+		if req.Token == "" || req.NewPassword == "" {
+			http.Error(w, "token and new_password are required", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.ResetPasswordRequest{
+			Token:       req.Token,
+			NewPassword: req.NewPassword,
+			Audit:       auditContext(r),
+		}
+
+		// call the service
+		if err := authService.ResetPassword(sreq); err != nil {
+			var policyErr *services.PasswordPolicyError
+			if errors.As(err, &policyErr) {
+				writePasswordPolicyError(w, policyErr)
+			} else if errors.Is(err, services.ErrInvalidResetToken) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary		Refresh Access Token
+// @Description	Refresh the access token using the refresh token cookie
+// @Accept		json
+// @Produce		json
+// @Router			/auth/refresh [post]
+// @Tags			Auth
+func RefreshToken(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		// extract the refresh token from the cookie
-		cookie, err := r.Cookie("refresh_token")
+		cookie, err := r.Cookie(refreshTokenCookie)
 		if err != nil {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
@@ -224,14 +449,21 @@ func RefreshToken(authService *services.AuthService) http.HandlerFunc {
 		// build the service request
 		sreq := service_models.RefreshTokenRequest{
 			RefreshToken: refreshToken,
-			UserID:       userID,
+			UserAgent:    r.UserAgent(),
+			IPAddress:    auth.ClientIP(r),
 		}
 
 		// call the service
 		sres, err := authService.RefreshAccessToken(sreq)
 		if err != nil {
-			if errors.Is(err, services.ErrInvalidCredentials) {
+			if errors.Is(err, services.ErrRefreshTokenReused) {
+				// the session was revoked, so the cookie is useless
+				clearRefreshTokenCookie(w)
+				http.Error(w, services.ErrInvalidCredentials.Error(), http.StatusUnauthorized)
+			} else if errors.Is(err, services.ErrInvalidCredentials) {
 				http.Error(w, err.Error(), http.StatusUnauthorized)
+			} else if errors.Is(err, services.ErrAccountDisabled) || errors.Is(err, services.ErrPasswordReset) {
+				http.Error(w, err.Error(), http.StatusForbidden)
 			} else {
 				http.Error(w, "internal server error", http.StatusInternalServerError)
 			}
@@ -239,15 +471,7 @@ func RefreshToken(authService *services.AuthService) http.HandlerFunc {
 		}
 
 		// set the refresh token in the cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "refresh_token",
-			Value:    sres.RefreshToken,
-			Expires:  sres.RefreshTokenExpiration,
-			HttpOnly: true,
-			Secure:   false, // for testing
-			Path:     "/auth/refresh",
-			SameSite: http.SameSiteStrictMode,
-		})
+		setRefreshTokenCookie(w, sres.RefreshToken, sres.RefreshTokenExpiration)
 
 		// build the response
 		res := api_models.RefreshTokenResponse{
@@ -262,3 +486,305 @@ func RefreshToken(authService *services.AuthService) http.HandlerFunc {
 		}
 	}
 }
+
+// @Summary		Logout
+// @Description	Revoke the refresh token of the current session and clear the cookie
+// @Success		204
+// @Router			/auth/logout [post]
+// @Tags			Auth
+func Logout(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// the cookie is cleared even if the session is already gone
+		clearRefreshTokenCookie(w)
+
+		// extract the refresh token from the cookie
+		cookie, err := r.Cookie(refreshTokenCookie)
+		if err != nil || cookie.Value == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.LogoutRequest{
+			RefreshToken: cookie.Value,
+		}
+
+		// call the service
+		if err := authService.Logout(sreq); err != nil && !errors.Is(err, services.ErrInvalidCredentials) {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary		Logout All
+// @Description	Revoke every session of the user and clear the cookie
+// @Security		BearerAuth
+// @Param			Authorization	header	string	true	"Bearer Token"
+// @Success		204
+// @Router			/auth/logout-all [post]
+// @Tags			Auth
+func LogoutAll(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.LogoutAllRequest{
+			UserID: userID,
+			Audit:  auditContext(r),
+		}
+
+		// call the service
+		if err := authService.LogoutAll(sreq); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		clearRefreshTokenCookie(w)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary		ListSessions
+// @Description	List the devices the user is signed in on
+// @Produce		json
+// @Param			Authorization	header	string	true	"Bearer token"
+// @Router			/me/sessions [get]
+// @Security		Bearer
+// @Tags			Auth
+func ListSessions(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the principal from the request context; the session ID marks the current session
+		principal, ok := auth.PrincipalFromContext(r.Context())
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.ListSessionsRequest{
+			UserID:           principal.UserID,
+			CurrentSessionID: principal.SessionID,
+		}
+
+		// call the service
+		sres, err := authService.ListSessions(sreq)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// build the response
+		res := api_models.ListSessionsResponse{
+			Sessions: make([]api_models.SessionSummary, 0, len(sres.Sessions)),
+		}
+		for _, session := range sres.Sessions {
+			res.Sessions = append(res.Sessions, api_models.SessionSummary{
+				ID:          session.ID,
+				DeviceLabel: session.DeviceLabel,
+				UserAgent:   session.UserAgent,
+				IPAddress:   session.IPAddress,
+				CreatedAt:   session.CreatedAt.Format("2006-01-02 15:04:05"),
+				LastUsedAt:  session.LastUsedAt.Format("2006-01-02 15:04:05"),
+				ExpiresAt:   session.ExpiresAt.Format("2006-01-02 15:04:05"),
+				Current:     session.Current,
+			})
+		}
+
+		// encode the response
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// @Summary		RevokeSession
+// @Description	Sign out one of the user's devices
+// @Param			Authorization	header	string	true	"Bearer token"
+// @Param			id				path	int		true	"Session ID"
+// @Router			/me/sessions/{id} [delete]
+// @Security		Bearer
+// @Tags			Auth
+func RevokeSession(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the session ID from the URL
+		sessionID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+		if err != nil {
+			http.Error(w, "invalid session ID", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.RevokeSessionRequest{
+			UserID:    userID,
+			SessionID: uint(sessionID),
+			Audit:     auditContext(r),
+		}
+
+		// call the service
+		if err := authService.RevokeSession(sreq); err != nil {
+			if errors.Is(err, services.ErrSessionNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary		JWKS
+// @Description	Public keys used to verify access tokens
+// @Produce		json
+// @Router			/.well-known/jwks.json [get]
+// @Tags			Auth
+func JWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// encode the public keys
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if err := json.NewEncoder(w).Encode(auth.CurrentKeySet().JWKS()); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// the OIDC state cookie is sent back to the provider callback only
+const oidcStateCookie = "oidc_state"
+const oidcStateCookiePath = "/auth/oidc"
+
+// the web app page an OIDC login returns to; it gets an access token from /auth/refresh, or finishes with /signin/mfa
+const oidcReturnPath = "/signin/oidc"
+
+// @Summary		OIDC Login
+// @Description	Redirect to an OIDC provider to sign in
+// @Param			provider	path	string	true	"Provider name"
+// @Success		302
+// @Failure		404	{string}	string	"Unknown provider"
+// @Router			/auth/oidc/{provider}/login [get]
+// @Tags			Auth
+func OIDCLogin(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// build the service request
+		sreq := service_models.StartOIDCLoginRequest{
+			Provider: chi.URLParam(r, "provider"),
+		}
+
+		// call the service
+		sres, err := authService.StartOIDCLogin(sreq)
+		if err != nil {
+			if errors.Is(err, services.ErrUnknownProvider) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else if errors.Is(err, services.ErrOIDCLoginFailed) {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// remember the login in this browser; lax, since the provider redirects back from another site
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookie,
+			Value:    sres.StateToken,
+			Expires:  auth.OIDCStateTokenExpiration(),
+			HttpOnly: true,
+			Secure:   false, // for testing
+			Path:     oidcStateCookiePath,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		http.Redirect(w, r, sres.AuthURL, http.StatusFound)
+	}
+}
+
+// @Summary		OIDC Callback
+// @Description	Finish signing in with an OIDC provider and redirect to the web app
+// @Description	Sets the refresh token in the cookie; errors and MFA tokens are passed in the URL fragment
+// @Param			provider	path	string	true	"Provider name"
+// @Param			code		query	string	true	"Authorization code"
+// @Param			state		query	string	true	"State from the login redirect"
+// @Success		302
+// @Router			/auth/oidc/{provider}/callback [get]
+// @Tags			Auth
+func OIDCCallback(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		returnURL := config.GetAppURL() + oidcReturnPath
+
+		// the state cookie is only good for one callback
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookie,
+			Value:    "",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   false, // for testing
+			Path:     oidcStateCookiePath,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		// the provider reports errors, such as the user declining, in the query
+		query := r.URL.Query()
+		stateCookie, err := r.Cookie(oidcStateCookie)
+		if query.Get("error") != "" || query.Get("code") == "" || err != nil {
+			message := services.ErrOIDCLoginFailed.Error()
+			if err != nil {
+				message = services.ErrInvalidOIDCState.Error()
+			}
+			http.Redirect(w, r, returnURL+"#error="+url.QueryEscape(message), http.StatusFound)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.FinishOIDCLoginRequest{
+			Provider:   chi.URLParam(r, "provider"),
+			Code:       query.Get("code"),
+			State:      query.Get("state"),
+			StateToken: stateCookie.Value,
+			UserAgent:  r.UserAgent(),
+			IPAddress:  auth.ClientIP(r),
+			Audit:      auditContext(r),
+		}
+
+		// call the service
+		sres, err := authService.FinishOIDCLogin(sreq)
+		if err != nil {
+			message := err.Error()
+			if errors.Is(err, services.ErrInternalServerError) || errors.Is(err, services.ErrTokenGeneration) {
+				message = "Something went wrong"
+			}
+			http.Redirect(w, r, returnURL+"#error="+url.QueryEscape(message), http.StatusFound)
+			return
+		}
+
+		// a second factor is still needed
+		if sres.MFARequired {
+			http.Redirect(w, r, returnURL+"#mfa_token="+url.QueryEscape(sres.MFAToken), http.StatusFound)
+			return
+		}
+
+		// set the refresh token in the cookie
+		setRefreshTokenCookie(w, sres.RefreshToken, sres.RefreshTokenExpiration)
+
+		http.Redirect(w, r, returnURL, http.StatusFound)
+	}
+}
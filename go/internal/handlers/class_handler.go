@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"strconv"
 
@@ -28,6 +29,21 @@ func getClassIDFromRequest(r *http.Request) (uint, error) {
 	return uint(classID), nil
 }
 
+// helper function to extract the member's user ID from the request
+func getMemberIDFromRequest(r *http.Request) (uint, error) {
+	memberIDStr := chi.URLParam(r, "memberID")
+	if memberIDStr == "" {
+		return 0, errors.New("member ID is required")
+	}
+
+	memberID, err := strconv.ParseUint(memberIDStr, 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid member ID")
+	}
+
+	return uint(memberID), nil
+}
+
 // @Summary		CreateClass
 // @Description	Create a new class
 // @Accept			json
@@ -40,7 +56,7 @@ func getClassIDFromRequest(r *http.Request) (uint, error) {
 func CreateClass(classService *services.ClassService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// extract the user ID from the request context
-		userID, ok := r.Context().Value(userIDKey).(uint)
+		userID, ok := userIDFromContext(r)
 		if !ok {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
@@ -60,13 +76,19 @@ func CreateClass(classService *services.ClassService) http.HandlerFunc {
 
 		// build the service request
 		sreq := service_models.CreateClassRequest{
-			Name:        req.Name,
-			Description: req.Description,
-			UserID:      userID,
+			Name:            req.Name,
+			Description:     req.Description,
+			RequireApproval: req.RequireApproval,
+			UserID:          userID,
+			Audit:           auditContext(r),
 		}
 
 		// call the service
 		if err := classService.CreateClass(sreq); err != nil {
+			if errors.Is(err, services.ErrEmailNotVerified) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 			return
 		}
@@ -87,7 +109,7 @@ func CreateClass(classService *services.ClassService) http.HandlerFunc {
 func DeleteClass(classService *services.ClassService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// extract the user ID from the request context
-		userID, ok := r.Context().Value(userIDKey).(uint)
+		userID, ok := userIDFromContext(r)
 		if !ok {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
@@ -104,6 +126,7 @@ func DeleteClass(classService *services.ClassService) http.HandlerFunc {
 		sreq := service_models.DeleteClassRequest{
 			ClassID: classID,
 			UserID:  userID,
+			Audit:   auditContext(r),
 		}
 
 		// call the service
@@ -137,7 +160,7 @@ func DeleteClass(classService *services.ClassService) http.HandlerFunc {
 func ReadClass(classService *services.ClassService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// extract the user ID from the request context
-		userID, ok := r.Context().Value(userIDKey).(uint)
+		userID, ok := userIDFromContext(r)
 		if !ok {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
@@ -172,10 +195,11 @@ func ReadClass(classService *services.ClassService) http.HandlerFunc {
 
 		// build the response
 		res := api_models.ReadClassResponse{
-			Name:        sres.Name,
-			Description: sres.Description,
-			CreatedAt:   sres.CreatedAt,
-			CreatedBy:   sres.CreatedBy,
+			Name:            sres.Name,
+			Description:     sres.Description,
+			RequireApproval: sres.RequireApproval,
+			CreatedAt:       sres.CreatedAt,
+			CreatedBy:       sres.CreatedBy,
 		}
 
 		// encode the response
@@ -200,7 +224,7 @@ func ReadClass(classService *services.ClassService) http.HandlerFunc {
 func UpdateClass(classService *services.ClassService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// extract the user ID from the request context
-		userID, ok := r.Context().Value(userIDKey).(uint)
+		userID, ok := userIDFromContext(r)
 		if !ok {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
@@ -227,10 +251,12 @@ func UpdateClass(classService *services.ClassService) http.HandlerFunc {
 
 		// build the service request
 		sreq := service_models.UpdateClassRequest{
-			ClassID:     classID,
-			Name:        req.Name,
-			Description: req.Description,
-			UserID:      userID,
+			ClassID:         classID,
+			Name:            req.Name,
+			Description:     req.Description,
+			RequireApproval: req.RequireApproval,
+			UserID:          userID,
+			Audit:           auditContext(r),
 		}
 		// call the service
 		err = classService.UpdateClass(sreq)
@@ -252,18 +278,20 @@ func UpdateClass(classService *services.ClassService) http.HandlerFunc {
 }
 
 // @Summary		GenerateJoinCode
-// @Description	Generate a join code for a class
+// @Description	Generate a join code for a class; earlier codes keep working until they expire, are used up or are revoked
 // @Accept			json
 // @Produce		json
-// @Param			Authorization	header	string							true	"Bearer token"
-// @Param			id				path	int								true	"Class ID"
+// @Param			Authorization	header		string								true	"Bearer token"
+// @Param			id				path		int									true	"Class ID"
+// @Param			code			body		api_models.GenerateJoinCodeRequest	false	"Name, role, expiry and use limit of the code"
+// @Success		200				{object}	api_models.GenerateJoinCodeResponse
 // @Router			/class/{id}/joincode [post]
 // @Security		Bearer
 // @Tags			Class
 func GenerateJoinCode(classService *services.ClassService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// extract the user ID from the request context
-		userID, ok := r.Context().Value(userIDKey).(uint)
+		userID, ok := userIDFromContext(r)
 		if !ok {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
@@ -276,10 +304,23 @@ func GenerateJoinCode(classService *services.ClassService) http.HandlerFunc {
 			return
 		}
 
+		// decode the request body; every setting is optional
+		var req api_models.GenerateJoinCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
 		// build the service request
 		sreq := service_models.GenerateJoinCodeRequest{
-			ClassID: classID,
-			UserID:  userID,
+			ClassID:        classID,
+			UserID:         userID,
+			Name:           req.Name,
+			Role:           req.Role,
+			ExpiresInHours: req.ExpiresInHours,
+			NeverExpires:   req.NeverExpires,
+			MaxUses:        req.MaxUses,
+			Audit:          auditContext(r),
 		}
 
 		// call the service
@@ -291,6 +332,9 @@ func GenerateJoinCode(classService *services.ClassService) http.HandlerFunc {
 			} else if errors.Is(err, services.ErrUnauthorized) {
 				http.Error(w, err.Error(), http.StatusUnauthorized)
 				return
+			} else if errors.Is(err, services.ErrInvalidJoinCode) || errors.Is(err, services.ErrInvalidRole) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
 			}
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 			return
@@ -298,8 +342,69 @@ func GenerateJoinCode(classService *services.ClassService) http.HandlerFunc {
 
 		// build the response
 		res := api_models.GenerateJoinCodeResponse{
-			Code:         sres.Code,
-			ExpirationDT: sres.ExpirationDT.Format("2006-01-02 15:04:05"),
+			JoinCodeSummary: joinCodeSummary(sres.JoinCodeSummary),
+		}
+
+		// encode the response
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// @Summary		ListJoinCodes
+// @Description	List the join codes of a class, including expired and used up ones until they are revoked
+// @Produce		json
+// @Param			Authorization	header		string	true	"Bearer token"
+// @Param			id				path		int		true	"Class ID"
+// @Success		200				{object}	api_models.ListJoinCodesResponse
+// @Router			/class/{id}/joincode [get]
+// @Security		Bearer
+// @Tags			Class
+func ListJoinCodes(classService *services.ClassService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the class ID from the URL
+		classID, err := getClassIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.ListJoinCodesRequest{
+			ClassID: classID,
+			UserID:  userID,
+		}
+
+		// call the service
+		sres, err := classService.ListJoinCodes(sreq)
+		if err != nil {
+			if errors.Is(err, services.ErrClassNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			} else if errors.Is(err, services.ErrUnauthorized) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// build the response
+		res := api_models.ListJoinCodesResponse{
+			JoinCodes: make([]api_models.JoinCodeSummary, 0, len(sres.JoinCodes)),
+		}
+		for _, joinCode := range sres.JoinCodes {
+			res.JoinCodes = append(res.JoinCodes, joinCodeSummary(joinCode))
 		}
 
 		// encode the response
@@ -311,19 +416,93 @@ func GenerateJoinCode(classService *services.ClassService) http.HandlerFunc {
 	}
 }
 
+// @Summary		RevokeJoinCode
+// @Description	Revoke one of the join codes of a class
+// @Param			Authorization	header	string	true	"Bearer token"
+// @Param			id				path	int		true	"Class ID"
+// @Param			codeID			path	int		true	"Join code ID"
+// @Success		204
+// @Router			/class/{id}/joincode/{codeID} [delete]
+// @Security		Bearer
+// @Tags			Class
+func RevokeJoinCode(classService *services.ClassService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the class and join code IDs from the URL
+		classID, err := getClassIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		codeID, err := strconv.ParseUint(chi.URLParam(r, "codeID"), 10, 32)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.RevokeJoinCodeRequest{
+			ClassID:    classID,
+			UserID:     userID,
+			JoinCodeID: uint(codeID),
+			Audit:      auditContext(r),
+		}
+
+		// call the service
+		if err := classService.RevokeJoinCode(sreq); err != nil {
+			if errors.Is(err, services.ErrClassNotFound) || errors.Is(err, services.ErrJoinCodeNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			} else if errors.Is(err, services.ErrUnauthorized) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// helper function to build the API view of a join code
+func joinCodeSummary(joinCode service_models.JoinCodeSummary) api_models.JoinCodeSummary {
+	summary := api_models.JoinCodeSummary{
+		ID:            joinCode.ID,
+		Code:          joinCode.Code,
+		Name:          joinCode.Name,
+		Role:          joinCode.Role,
+		MaxUses:       joinCode.MaxUses,
+		RemainingUses: joinCode.RemainingUses,
+		CreatedAt:     joinCode.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+	if joinCode.ExpirationDT != nil {
+		summary.ExpirationDT = joinCode.ExpirationDT.Format("2006-01-02 15:04:05")
+	}
+	return summary
+}
+
 // @Summary		JoinClass
-// @Description	Join a class using a join code
+// @Description	Join a class using a join code; when the class requires approval, a pending join request is created instead
 // @Accept			json
 // @Produce		json
-// @Param			Authorization	header	string							true	"Bearer token"
-// @Param			class			body	api_models.JoinClassRequest		true	"Join code"
+// @Param			Authorization	header		string							true	"Bearer token"
+// @Param			class			body		api_models.JoinClassRequest		true	"Join code"
+// @Success		202				{object}	api_models.JoinClassResponse
+// @Success		204
 // @Router			/class/join [post]
 // @Security		Bearer
 // @Tags			Class
 func JoinClass(classService *services.ClassService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// extract the user ID from the request context
-		userID, ok := r.Context().Value(userIDKey).(uint)
+		userID, ok := userIDFromContext(r)
 		if !ok {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
@@ -346,10 +525,181 @@ func JoinClass(classService *services.ClassService) http.HandlerFunc {
 		sreq := service_models.JoinClassRequest{
 			UserID:   userID,
 			JoinCode: req.JoinCode,
+			Audit:    auditContext(r),
+		}
+
+		// call the service
+		sres, err := classService.JoinClass(sreq)
+		if err != nil {
+			if errors.Is(err, services.ErrClassNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			} else if errors.Is(err, services.ErrUnauthorized) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			} else if errors.Is(err, services.ErrEmailNotVerified) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			} else if errors.Is(err, services.ErrAlreadyMember) || errors.Is(err, services.ErrJoinRequestPending) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// the user joins once an admin approves the request
+		if sres.Pending {
+			res := api_models.JoinClassResponse{
+				RequestID: sres.RequestID,
+				Status:    "pending",
+			}
+
+			// encode the response
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			if err := json.NewEncoder(w).Encode(res); err != nil {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary		GetClasses
+// @Description	List the classes the user belongs to, with their role in each
+// @Accept			json
+// @Produce		json
+// @Param			Authorization	header	string	true	"Bearer token"
+// @Param			role			query	string	false	"Filter by role (admin or user)"
+// @Param			sort			query	string	false	"Sort by created_at (default) or name"
+// @Param			order			query	string	false	"Sort order (asc or desc)"
+// @Param			cursor			query	string	false	"Cursor returned by the previous page"
+// @Param			limit			query	int		false	"Page size"
+// @Router			/classes [get]
+// @Security		Bearer
+// @Tags			Class
+func GetClasses(classService *services.ClassService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// read the query parameters
+		query := r.URL.Query()
+		role := query.Get("role")
+		sortBy := query.Get("sort")
+		order := query.Get("order")
+		limit := 0
+		if limitStr := query.Get("limit"); limitStr != "" {
+			parsed, err := strconv.Atoi(limitStr)
+			if err != nil || parsed < 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		// input validation
+		if role != "" && role != "admin" && role != "user" {
+			http.Error(w, "role must be admin or user", http.StatusBadRequest)
+			return
+		}
+		if sortBy != "" && sortBy != "created_at" && sortBy != "name" {
+			http.Error(w, "sort must be created_at or name", http.StatusBadRequest)
+			return
+		}
+		if order != "" && order != "asc" && order != "desc" {
+			http.Error(w, "order must be asc or desc", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.ListClassesRequest{
+			UserID: userID,
+			Role:   role,
+			SortBy: sortBy,
+			Order:  order,
+			Cursor: query.Get("cursor"),
+			Limit:  limit,
+		}
+
+		// call the service
+		sres, err := classService.ListClasses(sreq)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidCursor) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// build the response
+		res := api_models.ListClassesResponse{
+			Classes:    make([]api_models.ClassSummary, 0, len(sres.Classes)),
+			NextCursor: sres.NextCursor,
+		}
+		for _, class := range sres.Classes {
+			res.Classes = append(res.Classes, api_models.ClassSummary{
+				ID:          class.ClassID,
+				Name:        class.Name,
+				Description: class.Description,
+				Role:        class.Role,
+				MemberCount: class.MemberCount,
+				CreatedAt:   class.CreatedAt.Format("2006-01-02 15:04:05"),
+				CreatedBy:   class.CreatedBy,
+			})
+		}
+
+		// encode the response
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// @Summary		ListMembers
+// @Description	List the members of a class
+// @Accept			json
+// @Produce		json
+// @Param			Authorization	header	string	true	"Bearer token"
+// @Param			id				path	int		true	"Class ID"
+// @Router			/class/{id}/members [get]
+// @Security		Bearer
+// @Tags			Class
+func ListMembers(classService *services.ClassService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the class ID from the URL
+		classID, err := getClassIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.ListMembersRequest{
+			ClassID: classID,
+			UserID:  userID,
 		}
 
 		// call the service
-		err := classService.JoinClass(sreq)
+		sres, err := classService.ListMembers(sreq)
 		if err != nil {
 			if errors.Is(err, services.ErrClassNotFound) {
 				http.Error(w, err.Error(), http.StatusNotFound)
@@ -362,6 +712,246 @@ func JoinClass(classService *services.ClassService) http.HandlerFunc {
 			return
 		}
 
+		// build the response
+		res := api_models.ListMembersResponse{
+			Members: make([]api_models.ClassMemberSummary, 0, len(sres.Members)),
+		}
+		for _, member := range sres.Members {
+			res.Members = append(res.Members, api_models.ClassMemberSummary{
+				UserID:   member.UserID,
+				Username: member.Username,
+				Role:     member.Role,
+				JoinedAt: member.JoinedAt.Format("2006-01-02 15:04:05"),
+			})
+		}
+
+		// encode the response
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// @Summary		RemoveMember
+// @Description	Remove a member from a class
+// @Accept			json
+// @Produce		json
+// @Param			Authorization	header	string	true	"Bearer token"
+// @Param			id				path	int		true	"Class ID"
+// @Param			memberID		path	int		true	"User ID of the member"
+// @Router			/class/{id}/members/{memberID} [delete]
+// @Security		Bearer
+// @Tags			Class
+func RemoveMember(classService *services.ClassService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the class and member IDs from the URL
+		classID, err := getClassIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		memberID, err := getMemberIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.RemoveMemberRequest{
+			ClassID:  classID,
+			UserID:   userID,
+			MemberID: memberID,
+			Audit:    auditContext(r),
+		}
+
+		// call the service
+		if err := classService.RemoveMember(sreq); err != nil {
+			writeMemberError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary		UpdateMemberRole
+// @Description	Promote or demote a member of a class
+// @Accept			json
+// @Produce		json
+// @Param			Authorization	header	string								true	"Bearer token"
+// @Param			id				path	int									true	"Class ID"
+// @Param			memberID		path	int									true	"User ID of the member"
+// @Param			role			body	api_models.UpdateMemberRoleRequest	true	"New role (admin or user)"
+// @Router			/class/{id}/members/{memberID} [put]
+// @Security		Bearer
+// @Tags			Class
+func UpdateMemberRole(classService *services.ClassService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the class and member IDs from the URL
+		classID, err := getClassIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		memberID, err := getMemberIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// decode the request body
+		var req api_models.UpdateMemberRoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.UpdateMemberRoleRequest{
+			ClassID:  classID,
+			UserID:   userID,
+			MemberID: memberID,
+			Role:     req.Role,
+			Audit:    auditContext(r),
+		}
+
+		// call the service
+		if err := classService.UpdateMemberRole(sreq); err != nil {
+			writeMemberError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// helper function to map roster errors to responses
+func writeMemberError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrClassNotFound), errors.Is(err, services.ErrMemberNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrUnauthorized):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, services.ErrLastAdmin), errors.Is(err, services.ErrOwnerMustTransfer):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrInvalidRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+// @Summary		LeaveClass
+// @Description	Leave a class
+// @Accept			json
+// @Produce		json
+// @Param			Authorization	header	string	true	"Bearer token"
+// @Param			id				path	int		true	"Class ID"
+// @Router			/class/{id}/leave [post]
+// @Security		Bearer
+// @Tags			Class
+func LeaveClass(classService *services.ClassService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the class ID from the URL
+		classID, err := getClassIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.LeaveClassRequest{
+			ClassID: classID,
+			UserID:  userID,
+			Audit:   auditContext(r),
+		}
+
+		// call the service
+		if err := classService.LeaveClass(sreq); err != nil {
+			writeMemberError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary		TransferOwnership
+// @Description	Transfer ownership of a class to another member
+// @Accept			json
+// @Produce		json
+// @Param			Authorization	header	string								true	"Bearer token"
+// @Param			id				path	int									true	"Class ID"
+// @Param			owner			body	api_models.TransferOwnershipRequest	true	"User ID of the new owner"
+// @Router			/class/{id}/transfer [post]
+// @Security		Bearer
+// @Tags			Class
+func TransferOwnership(classService *services.ClassService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the class ID from the URL
+		classID, err := getClassIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// decode the request body
+		var req api_models.TransferOwnershipRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// input validation
+		if req.UserID == 0 {
+			http.Error(w, "user_id is required", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.TransferOwnershipRequest{
+			ClassID:    classID,
+			UserID:     userID,
+			NewOwnerID: req.UserID,
+			Audit:      auditContext(r),
+		}
+
+		// call the service
+		if err := classService.TransferOwnership(sreq); err != nil {
+			writeMemberError(w, err)
+			return
+		}
+
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/hawkerd/privateinstruction/internal/models/api_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/services"
+)
+
+// helper function to extract the join request ID from the request
+func getJoinRequestIDFromRequest(r *http.Request) (uint, error) {
+	requestIDStr := chi.URLParam(r, "requestID")
+	if requestIDStr == "" {
+		return 0, errors.New("join request ID is required")
+	}
+
+	requestID, err := strconv.ParseUint(requestIDStr, 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid join request ID")
+	}
+
+	return uint(requestID), nil
+}
+
+// @Summary		ListJoinRequests
+// @Description	List the join requests of a class that requires approval
+// @Produce		json
+// @Param			Authorization	header		string	true	"Bearer token"
+// @Param			id				path		int		true	"Class ID"
+// @Param			status			query		string	false	"Filter by status (pending, approved, rejected or cancelled); pending by default"
+// @Success		200				{object}	api_models.ListJoinRequestsResponse
+// @Router			/class/{id}/join-requests [get]
+// @Security		Bearer
+// @Tags			Class
+func ListJoinRequests(classService *services.ClassService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the class ID from the URL
+		classID, err := getClassIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.ListJoinRequestsRequest{
+			ClassID: classID,
+			UserID:  userID,
+			Status:  r.URL.Query().Get("status"),
+		}
+
+		// call the service
+		sres, err := classService.ListJoinRequests(sreq)
+		if err != nil {
+			if errors.Is(err, services.ErrClassNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			} else if errors.Is(err, services.ErrUnauthorized) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			} else if errors.Is(err, services.ErrInvalidStatus) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJoinRequests(w, sres)
+	}
+}
+
+// @Summary		ListMyJoinRequests
+// @Description	List the user's own join requests, newest first
+// @Produce		json
+// @Param			Authorization	header		string	true	"Bearer token"
+// @Success		200				{object}	api_models.ListJoinRequestsResponse
+// @Router			/class/join-requests [get]
+// @Security		Bearer
+// @Tags			Class
+func ListMyJoinRequests(classService *services.ClassService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// call the service
+		sres, err := classService.ListMyJoinRequests(service_models.ListMyJoinRequestsRequest{UserID: userID})
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJoinRequests(w, sres)
+	}
+}
+
+// @Summary		ApproveJoinRequest
+// @Description	Approve a pending join request, adding the requester to the class
+// @Param			Authorization	header	string	true	"Bearer token"
+// @Param			id				path	int		true	"Class ID"
+// @Param			requestID		path	int		true	"Join request ID"
+// @Success		204
+// @Router			/class/{id}/join-requests/{requestID}/approve [post]
+// @Security		Bearer
+// @Tags			Class
+func ApproveJoinRequest(classService *services.ClassService) http.HandlerFunc {
+	return decideJoinRequest(classService.ApproveJoinRequest)
+}
+
+// @Summary		RejectJoinRequest
+// @Description	Reject a pending join request
+// @Param			Authorization	header	string	true	"Bearer token"
+// @Param			id				path	int		true	"Class ID"
+// @Param			requestID		path	int		true	"Join request ID"
+// @Success		204
+// @Router			/class/{id}/join-requests/{requestID}/reject [post]
+// @Security		Bearer
+// @Tags			Class
+func RejectJoinRequest(classService *services.ClassService) http.HandlerFunc {
+	return decideJoinRequest(classService.RejectJoinRequest)
+}
+
+// @Summary		CancelJoinRequest
+// @Description	Cancel one of the user's own pending join requests
+// @Param			Authorization	header	string	true	"Bearer token"
+// @Param			requestID		path	int		true	"Join request ID"
+// @Success		204
+// @Router			/class/join-requests/{requestID} [delete]
+// @Security		Bearer
+// @Tags			Class
+func CancelJoinRequest(classService *services.ClassService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the join request ID from the URL
+		requestID, err := getJoinRequestIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.CancelJoinRequestRequest{
+			UserID:    userID,
+			RequestID: requestID,
+			Audit:     auditContext(r),
+		}
+
+		// call the service
+		if err := classService.CancelJoinRequest(sreq); err != nil {
+			writeJoinRequestError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// helper function to build the handler for an admin's decision on a join request
+func decideJoinRequest(decide func(service_models.DecideJoinRequestRequest) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// extract the class and join request IDs from the URL
+		classID, err := getClassIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		requestID, err := getJoinRequestIDFromRequest(r)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.DecideJoinRequestRequest{
+			ClassID:   classID,
+			UserID:    userID,
+			RequestID: requestID,
+			Audit:     auditContext(r),
+		}
+
+		// call the service
+		if err := decide(sreq); err != nil {
+			writeJoinRequestError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// helper function to map join request errors to responses
+func writeJoinRequestError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrClassNotFound), errors.Is(err, services.ErrJoinRequestNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrUnauthorized):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, services.ErrJoinRequestNotPending), errors.Is(err, services.ErrAlreadyMember):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+// helper function to encode a list of join requests
+func writeJoinRequests(w http.ResponseWriter, sres service_models.ListJoinRequestsResponse) {
+	// build the response
+	res := api_models.ListJoinRequestsResponse{
+		JoinRequests: make([]api_models.JoinRequestSummary, 0, len(sres.JoinRequests)),
+	}
+	for _, joinRequest := range sres.JoinRequests {
+		summary := api_models.JoinRequestSummary{
+			ID:        joinRequest.ID,
+			ClassID:   joinRequest.ClassID,
+			ClassName: joinRequest.ClassName,
+			UserID:    joinRequest.UserID,
+			Username:  joinRequest.Username,
+			Role:      joinRequest.Role,
+			Status:    joinRequest.Status,
+			CreatedAt: joinRequest.CreatedAt.Format("2006-01-02 15:04:05"),
+		}
+		if joinRequest.DecidedAt != nil {
+			summary.DecidedAt = joinRequest.DecidedAt.Format("2006-01-02 15:04:05")
+		}
+		res.JoinRequests = append(res.JoinRequests, summary)
+	}
+
+	// encode the response
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hawkerd/privateinstruction/internal/models/api_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/services"
+)
+
+// @Summary		Create Personal Access Token
+// @Description	Mint a named, scoped token for scripts; the token is only shown in this response
+// @Accept			json
+// @Produce		json
+// @Security		BearerAuth
+// @Param			Authorization	header		string										true	"Bearer Token"
+// @Param			token			body		api_models.CreatePersonalAccessTokenRequest	true	"Name, scopes and lifetime in days (default 30, at most 365)"
+// @Success		201				{object}	api_models.CreatePersonalAccessTokenResponse
+// @Router			/me/tokens [post]
+// @Tags			Auth
+func CreatePersonalAccessToken(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// a token can't be used to mint more tokens, nor can an admin mint them for a user they impersonate
+		if !signedIn(r) {
+			http.Error(w, "only a signed in session can manage tokens", http.StatusForbidden)
+			return
+		}
+
+		// decode the request body
+		var req api_models.CreatePersonalAccessTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.CreatePersonalAccessTokenRequest{
+			UserID:        userID,
+			Name:          req.Name,
+			Scopes:        req.Scopes,
+			ExpiresInDays: req.ExpiresInDays,
+			Audit:         auditContext(r),
+		}
+
+		// call the service
+		sres, err := authService.CreatePersonalAccessToken(sreq)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidTokenName) || errors.Is(err, services.ErrInvalidScope) || errors.Is(err, services.ErrInvalidTokenExpiration) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// build the response
+		res := api_models.CreatePersonalAccessTokenResponse{
+			PersonalAccessTokenSummary: personalAccessTokenSummary(sres.PersonalAccessTokenSummary),
+			Token:                      sres.Token,
+		}
+
+		// encode the response
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// @Summary		List Personal Access Tokens
+// @Description	List the user's personal access tokens with when and where they were last used
+// @Produce		json
+// @Security		BearerAuth
+// @Param			Authorization	header		string	true	"Bearer Token"
+// @Success		200				{object}	api_models.ListPersonalAccessTokensResponse
+// @Router			/me/tokens [get]
+// @Tags			Auth
+func ListPersonalAccessTokens(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !signedIn(r) {
+			http.Error(w, "only a signed in session can manage tokens", http.StatusForbidden)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.ListPersonalAccessTokensRequest{
+			UserID: userID,
+		}
+
+		// call the service
+		sres, err := authService.ListPersonalAccessTokens(sreq)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// build the response
+		res := api_models.ListPersonalAccessTokensResponse{
+			Tokens: make([]api_models.PersonalAccessTokenSummary, 0, len(sres.Tokens)),
+		}
+		for _, token := range sres.Tokens {
+			res.Tokens = append(res.Tokens, personalAccessTokenSummary(token))
+		}
+
+		// encode the response
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// @Summary		Revoke Personal Access Token
+// @Description	Revoke one of the user's personal access tokens
+// @Security		BearerAuth
+// @Param			Authorization	header	string	true	"Bearer Token"
+// @Param			id				path	int		true	"Token ID"
+// @Success		204
+// @Router			/me/tokens/{id} [delete]
+// @Tags			Auth
+func RevokePersonalAccessToken(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !signedIn(r) {
+			http.Error(w, "only a signed in session can manage tokens", http.StatusForbidden)
+			return
+		}
+
+		// extract the token ID from the URL
+		tokenID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+		if err != nil {
+			http.Error(w, "invalid token ID", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.RevokePersonalAccessTokenRequest{
+			UserID:  userID,
+			TokenID: uint(tokenID),
+			Audit:   auditContext(r),
+		}
+
+		// call the service
+		if err := authService.RevokePersonalAccessToken(sreq); err != nil {
+			if errors.Is(err, services.ErrTokenNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// helper function to format a token for the response
+func personalAccessTokenSummary(token service_models.PersonalAccessTokenSummary) api_models.PersonalAccessTokenSummary {
+	summary := api_models.PersonalAccessTokenSummary{
+		ID:         token.ID,
+		Name:       token.Name,
+		Scopes:     token.Scopes,
+		CreatedAt:  token.CreatedAt.Format("2006-01-02 15:04:05"),
+		ExpiresAt:  token.ExpiresAt.Format("2006-01-02 15:04:05"),
+		LastUsedIP: token.LastUsedIP,
+	}
+	if token.LastUsedAt != nil {
+		summary.LastUsedAt = token.LastUsedAt.Format("2006-01-02 15:04:05")
+	}
+	return summary
+}
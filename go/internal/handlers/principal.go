@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+)
+
+// helper function to get the ID of the user making the request, set by TokenAuthMiddleware
+func userIDFromContext(r *http.Request) (uint, bool) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		return 0, false
+	}
+	return principal.UserID, true
+}
+
+// helper function to tell whether the request was made with the user's own signed in session, rather than a personal access token or impersonation
+func signedIn(r *http.Request) bool {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	return ok && principal.IsSession()
+}
+
+// helper function to describe who made the request and where from, for the audit log
+func auditContext(r *http.Request) service_models.AuditContext {
+	audit := service_models.AuditContext{
+		IPAddress: auth.ClientIP(r),
+		RequestID: auth.RequestIDFromContext(r.Context()),
+	}
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok && principal.Actor != nil {
+		audit.ImpersonatorID = principal.Actor.UserID
+	}
+	return audit
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/models/api_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/services"
+)
+
+// @Summary		Verify MFA
+// @Description	Finish a sign in with a TOTP code or a recovery code
+// @Description	Also sets the refresh token in the cookie
+// @Accept			json
+// @Produce		json
+// @Param			user	body	api_models.VerifyMFARequest	true	"MFA token from /signin and the code"
+// @Failure		429	{string}	string	"Too many failed attempts; see Retry-After"
+// @Router			/signin/mfa [post]
+// @Tags			Auth
+func VerifyMFA(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// decode the request body
+		var req api_models.VerifyMFARequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// input validation
+		if req.MFAToken == "" || req.Code == "" {
+			http.Error(w, "mfa token and code are required", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.VerifyMFARequest{
+			MFAToken:  req.MFAToken,
+			Code:      req.Code,
+			UserAgent: r.UserAgent(),
+			IPAddress: auth.ClientIP(r),
+		}
+
+		// call the service
+		sres, err := authService.VerifyMFA(sreq)
+		if err != nil {
+			var retryErr *services.RetryAfterError
+			if errors.As(err, &retryErr) {
+				writeRetryAfter(w, retryErr)
+			} else if errors.Is(err, services.ErrInvalidCredentials) || errors.Is(err, services.ErrInvalidMFACode) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+			} else if errors.Is(err, services.ErrAccountDisabled) || errors.Is(err, services.ErrPasswordReset) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// set the refresh token in the cookie
+		setRefreshTokenCookie(w, sres.RefreshToken, sres.RefreshTokenExpiration)
+
+		// build the response
+		res := api_models.SignInResponse{
+			AccessToken: sres.AccessToken,
+		}
+
+		// encode the response
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// @Summary		Set Up Two-Factor Authentication
+// @Description	Generate a TOTP secret; two-factor authentication is enabled once a code is confirmed
+// @Produce		json
+// @Security		BearerAuth
+// @Param			Authorization	header		string	true	"Bearer Token"
+// @Success		200				{object}	api_models.SetupTwoFactorResponse
+// @Router			/me/2fa/setup [post]
+// @Tags			Auth
+func SetupTwoFactor(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.SetupTwoFactorRequest{
+			UserID: userID,
+		}
+
+		// call the service
+		sres, err := authService.SetupTwoFactor(sreq)
+		if err != nil {
+			if errors.Is(err, services.ErrTwoFactorEnabled) {
+				http.Error(w, err.Error(), http.StatusConflict)
+			} else if errors.Is(err, services.ErrUserNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// build the response
+		res := api_models.SetupTwoFactorResponse{
+			Secret:     sres.Secret,
+			OTPAuthURI: sres.URI,
+		}
+
+		// encode the response
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// @Summary		Confirm Two-Factor Authentication
+// @Description	Enable two-factor authentication with a code from the authenticator; returns one-time recovery codes
+// @Accept			json
+// @Produce		json
+// @Security		BearerAuth
+// @Param			Authorization	header		string							true	"Bearer Token"
+// @Param			code			body		api_models.TwoFactorCodeRequest	true	"Code from the authenticator"
+// @Success		200				{object}	api_models.ConfirmTwoFactorResponse
+// @Router			/me/2fa/confirm [post]
+// @Tags			Auth
+func ConfirmTwoFactor(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// decode the request body
+		var req api_models.TwoFactorCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+			http.Error(w, "code is required", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.ConfirmTwoFactorRequest{
+			UserID: userID,
+			Code:   req.Code,
+			Audit:  auditContext(r),
+		}
+
+		// call the service
+		sres, err := authService.ConfirmTwoFactor(sreq)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidMFACode) || errors.Is(err, services.ErrTwoFactorNotSetUp) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else if errors.Is(err, services.ErrTwoFactorEnabled) {
+				http.Error(w, err.Error(), http.StatusConflict)
+			} else if errors.Is(err, services.ErrUserNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// build the response
+		res := api_models.ConfirmTwoFactorResponse{
+			RecoveryCodes: sres.RecoveryCodes,
+		}
+
+		// encode the response
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// @Summary		Disable Two-Factor Authentication
+// @Description	Turn off two-factor authentication with a current code or a recovery code
+// @Accept			json
+// @Security		BearerAuth
+// @Param			Authorization	header	string							true	"Bearer Token"
+// @Param			code			body	api_models.TwoFactorCodeRequest	true	"Code from the authenticator or a recovery code"
+// @Success		204
+// @Router			/me/2fa [delete]
+// @Tags			Auth
+func DisableTwoFactor(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract the user ID from the request context
+		userID, ok := userIDFromContext(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// decode the request body
+		var req api_models.TwoFactorCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+			http.Error(w, "code is required", http.StatusBadRequest)
+			return
+		}
+
+		// build the service request
+		sreq := service_models.DisableTwoFactorRequest{
+			UserID: userID,
+			Code:   req.Code,
+			Audit:  auditContext(r),
+		}
+
+		// call the service
+		if err := authService.DisableTwoFactor(sreq); err != nil {
+			if errors.Is(err, services.ErrInvalidMFACode) || errors.Is(err, services.ErrTwoFactorNotEnabled) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else if errors.Is(err, services.ErrUserNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
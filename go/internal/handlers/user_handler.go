@@ -5,13 +5,12 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/hawkerd/privateinstruction/internal/auth"
 	"github.com/hawkerd/privateinstruction/internal/models/api_models"
 	"github.com/hawkerd/privateinstruction/internal/models/service_models"
 	"github.com/hawkerd/privateinstruction/internal/services"
 )
 
-const userIDKey = "userID"
-
 //	@Summary		ReadUser
 //	@Description	Get user info
 //	@Accept			json
@@ -23,7 +22,7 @@ const userIDKey = "userID"
 func ReadUser(userService *services.UserService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// extract the user ID from the request context
-		userID, ok := r.Context().Value(userIDKey).(uint)
+		userID, ok := userIDFromContext(r)
 		if !ok {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
@@ -47,8 +46,21 @@ func ReadUser(userService *services.UserService) http.HandlerFunc {
 
 		// build the response
 		res := api_models.ReadUserResponse{
-			Username: sres.Username,
-			Email:    sres.Email,
+			Username:         sres.Username,
+			Email:            sres.Email,
+			EmailVerified:    sres.EmailVerified,
+			PendingEmail:     sres.PendingEmail,
+			TwoFactorEnabled: sres.TwoFactorEnabled,
+			Role:             sres.Role,
+		}
+
+		// show when an admin is acting as the user
+		if principal, ok := auth.PrincipalFromContext(r.Context()); ok && principal.IsImpersonated() {
+			res.ImpersonatedBy = &api_models.ImpersonatorSummary{
+				ID:        principal.Actor.UserID,
+				Username:  principal.Actor.Username,
+				ExpiresAt: principal.ExpiresAt.Format("2006-01-02 15:04:05"),
+			}
 		}
 
 		// encode the response
@@ -72,7 +84,7 @@ func ReadUser(userService *services.UserService) http.HandlerFunc {
 func DeleteUser(userService *services.UserService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// extract the user ID from the request context
-		userID, ok := r.Context().Value(userIDKey).(uint)
+		userID, ok := userIDFromContext(r)
 		if !ok {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
@@ -81,6 +93,7 @@ func DeleteUser(userService *services.UserService) http.HandlerFunc {
 		// build the service request
 		sreq := service_models.DeleteUserRequest{
 			UserID: userID,
+			Audit:  auditContext(r),
 		}
 
 		// call the service to delete user
@@ -109,7 +122,7 @@ func DeleteUser(userService *services.UserService) http.HandlerFunc {
 func UpdateUser(userService *services.UserService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// extract the user ID from the request context
-		userID, ok := r.Context().Value(userIDKey).(uint)
+		userID, ok := userIDFromContext(r)
 		if !ok {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
@@ -127,6 +140,7 @@ func UpdateUser(userService *services.UserService) http.HandlerFunc {
 			UserID:   userID,
 			Username: req.Username,
 			Email:    req.Email,
+			Audit:    auditContext(r),
 		}
 
 		// call the service to update user info
@@ -134,6 +148,9 @@ func UpdateUser(userService *services.UserService) http.HandlerFunc {
 			if errors.Is(err, services.ErrUserNotFound) {
 				http.Error(w, err.Error(), http.StatusNotFound)
 				return
+			} else if errors.Is(err, services.ErrUserExists) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
 			}
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 			return
@@ -0,0 +1,30 @@
+package mail
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Mailer that writes email to a file or stdout instead of sending it, for local development and tests
+type LogMailer struct {
+	mu     sync.Mutex
+	Writer io.Writer
+}
+
+// create and return a new LogMailer instance
+func NewLogMailer(w io.Writer) *LogMailer {
+	return &LogMailer{
+		Writer: w,
+	}
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.Writer, "----- %s -----\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format("2006-01-02 15:04:05"), msg.To, msg.Subject, msg.Body)
+	return err
+}
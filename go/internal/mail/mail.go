@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"os"
+
+	"github.com/hawkerd/privateinstruction/internal/config"
+)
+
+// an email to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// build the mailer described by the config: SMTP when a host is set, otherwise the log
+func NewMailerFromConfig() (Mailer, error) {
+	if host := config.GetSMTPHost(); host != "" {
+		return NewSMTPMailer(host, config.GetSMTPPort(), config.GetSMTPUsername(), config.GetSMTPPassword(), config.GetMailFrom()), nil
+	}
+
+	if path := config.GetMailLogFile(); path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		return NewLogMailer(file), nil
+	}
+	return NewLogMailer(os.Stdout), nil
+}
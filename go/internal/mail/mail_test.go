@@ -0,0 +1,30 @@
+package mail
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLogMailerWritesMessage(t *testing.T) {
+	var out bytes.Buffer
+	err := NewLogMailer(&out).Send(Message{To: "alice@example.com", Subject: "Hello", Body: "line one\nline two"})
+	if err != nil {
+		t.Fatalf("Send returned %v", err)
+	}
+
+	for _, want := range []string{"To: alice@example.com", "Subject: Hello", "line one\nline two"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in %q", want, out.String())
+		}
+	}
+}
+
+func TestSMTPMailerRejectsHeaderInjection(t *testing.T) {
+	m := NewSMTPMailer("localhost", "1", "", "", "no-reply@example.com")
+
+	err := m.Send(Message{To: "alice@example.com\r\nBcc: mallory@example.com", Subject: "Hello"})
+	if err == nil || !strings.Contains(err.Error(), "invalid header") {
+		t.Fatalf("expected an invalid header error, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// Mailer that delivers email through an SMTP server
+type SMTPMailer struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+}
+
+// create and return a new SMTPMailer instance; auth is skipped when no username is given
+func NewSMTPMailer(host string, port string, username string, password string, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{
+		Addr: net.JoinHostPort(host, port),
+		Auth: auth,
+		From: from,
+	}
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	// header values must not contain line breaks
+	for _, value := range []string{m.From, msg.To, msg.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid header value %q", value)
+		}
+	}
+
+	body := "From: " + m.From + "\r\n" +
+		"To: " + msg.To + "\r\n" +
+		"Subject: " + msg.Subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(msg.Body, "\n", "\r\n")
+
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{msg.To}, []byte(body))
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/services"
+)
+
+// only let platform admins through, checking the role on every request so a demotion takes effect at once; goes behind TokenAuthMiddleware
+func RequirePlatformAdmin(adminService *services.AdminService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := auth.PrincipalFromContext(r.Context())
+			if !ok {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			// the admin API is for people signed in as themselves, not scripts or impersonation
+			if !principal.IsSession() {
+				http.Error(w, "only a signed in session can use the admin API", http.StatusForbidden)
+				return
+			}
+
+			isAdmin, err := adminService.IsPlatformAdmin(principal.UserID)
+			if err != nil {
+				log.Printf("failed to check platform role of user %d: %v", principal.UserID, err)
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+			if !isAdmin {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// record every request an admin makes while impersonating a user, along with its status; goes behind TokenAuthMiddleware
+func RecordImpersonation(adminService *services.AdminService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := auth.PrincipalFromContext(r.Context())
+			if !ok || !principal.IsImpersonated() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			// the response has already gone out, so a failure can only be logged
+			err := adminService.RecordImpersonatedRequest(service_models.RecordImpersonatedRequestRequest{
+				AdminID:   principal.Actor.UserID,
+				UserID:    principal.UserID,
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Status:    recorder.status,
+				IPAddress: auth.ClientIP(r),
+			})
+			if err != nil {
+				log.Printf("failed to record request %s %s by admin %d impersonating user %d: %v", r.Method, r.URL.Path, principal.Actor.UserID, principal.UserID, err)
+			}
+		})
+	}
+}
+
+// a response writer remembering the status code written to it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -1,37 +1,98 @@
 package middleware
 
 import (
-	"context"
 	"net/http"
+	"strings"
 
 	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/services"
 )
 
-func TokenAuthMiddleware(next http.Handler) http.Handler {
+// tag each request with an ID, keeping the one a proxy passed in when it is valid, and echo it back so it can be matched with the audit log
+func RequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// extract the token
-		tokenString, err := auth.ExtractJWT(r)
-		if err != nil {
-			http.Error(w, "Missing or invalid token", http.StatusUnauthorized)
-			return
+		id := r.Header.Get(auth.RequestIDHeader)
+		if !auth.ValidRequestID(id) {
+			id = auth.GenerateRequestID()
 		}
+		w.Header().Set(auth.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(auth.WithRequestID(r.Context(), id)))
+	})
+}
 
-		// parse the token
-		claims, err := auth.ParseJWT(tokenString)
-		if err != nil {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
+// accept a JWT access token, or a personal access token checked against the store, and put the principal in the request context
+func TokenAuthMiddleware(authService *services.AuthService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// extract the token
+			tokenString, err := auth.ExtractJWT(r)
+			if err != nil {
+				http.Error(w, "Missing or invalid token", http.StatusUnauthorized)
+				return
+			}
 
-		// extract the user ID
-		userID, ok := claims["user_id"].(float64)
-		if !ok {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
+			var principal *auth.Principal
+			if auth.IsPersonalAccessToken(tokenString) {
+				// personal access tokens are opaque, so the service has to look them up
+				sres, err := authService.AuthenticatePersonalAccessToken(service_models.AuthenticatePersonalAccessTokenRequest{
+					Token:     tokenString,
+					IPAddress: auth.ClientIP(r),
+				})
+				if err != nil {
+					http.Error(w, "Invalid token", http.StatusUnauthorized)
+					return
+				}
+				principal = &auth.Principal{
+					UserID:    sres.UserID,
+					Username:  sres.Username,
+					Scopes:    sres.Scopes,
+					TokenType: auth.TokenTypePersonalAccess,
+				}
+			} else {
+				// parse the token
+				principal, err = auth.ParseAccessToken(tokenString)
+				if err != nil {
+					http.Error(w, "Invalid token", http.StatusUnauthorized)
+					return
+				}
 
-		// add user ID to the request context
-		ctx := context.WithValue(r.Context(), "userID", uint(userID))
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+				// a disabled account, a revoked session or an ended impersonation loses its access at once, not when the token expires
+				sreq := service_models.AuthenticateAccessTokenRequest{UserID: principal.UserID, SessionID: principal.SessionID}
+				if principal.Actor != nil {
+					sreq.ActorID = principal.Actor.UserID
+					sreq.ImpersonationID = principal.Actor.ImpersonationID
+				}
+				if err := authService.AuthenticateAccessToken(sreq); err != nil {
+					http.Error(w, "Invalid token", http.StatusUnauthorized)
+					return
+				}
+			}
+
+			// add the principal to the request context
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// refuse requests whose principal wasn't granted all of the scopes; goes behind TokenAuthMiddleware
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := auth.PrincipalFromContext(r.Context())
+			if !ok {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			for _, scope := range scopes {
+				if !principal.HasScope(scope) {
+					// tell the client which scopes the token needs, as in RFC 6750
+					w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+					http.Error(w, "Token is missing the "+scope+" scope", http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
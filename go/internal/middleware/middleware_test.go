@@ -0,0 +1,337 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/mail"
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+	"github.com/hawkerd/privateinstruction/internal/services"
+)
+
+// a handler behind TokenAuthMiddleware and RequireScope that records the principal it saw
+func newScopedHandler(authService *services.AuthService, scope string, seen **auth.Principal) http.Handler {
+	final := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*seen, _ = auth.PrincipalFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return TokenAuthMiddleware(authService)(RequireScope(scope)(final))
+}
+
+func serveWithToken(handler http.Handler, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/classes", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// store a refresh token for a user, returning the session ID an access token for it carries
+func startTestSession(t *testing.T, store repositories.Store, userID uint) uint {
+	t.Helper()
+
+	refreshToken := db_models.RefreshToken{UserID: userID, Selector: fmt.Sprintf("selector-%d", userID), HashedToken: "hashed", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.RefreshTokens().Create(&refreshToken); err != nil {
+		t.Fatalf("failed to create refresh token: %v", err)
+	}
+	refreshToken.FamilyID = refreshToken.ID
+	if err := store.RefreshTokens().Update(&refreshToken); err != nil {
+		t.Fatalf("failed to update refresh token: %v", err)
+	}
+	return refreshToken.FamilyID
+}
+
+func TestTokenAuthMiddlewareAccessToken(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	authService := services.NewAuthService(store, mail.NewLogMailer(io.Discard))
+	user := db_models.User{Username: "alice", Email: "alice@example.com", HashedPassword: "hashed"}
+	if err := store.Users().Create(&user); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	var seen *auth.Principal
+	handler := newScopedHandler(authService, auth.ScopeClassesWrite, &seen)
+
+	if w := serveWithToken(handler, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", w.Code)
+	}
+
+	sessionID := startTestSession(t, store, user.ID)
+	token, err := auth.GenerateJWT(user.ID, "alice", sessionID)
+	if err != nil {
+		t.Fatalf("GenerateJWT returned %v", err)
+	}
+	if w := serveWithToken(handler, token); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if seen == nil || seen.UserID != user.ID || seen.Username != "alice" || seen.SessionID != sessionID || !seen.IsSession() {
+		t.Fatalf("unexpected principal %+v", seen)
+	}
+	for _, scope := range auth.AllScopes {
+		if !seen.HasScope(scope) {
+			t.Fatalf("expected a session to have the %s scope", scope)
+		}
+	}
+
+	// MFA tokens aren't access tokens
+	mfaToken, _ := auth.GenerateMFAToken(user.ID)
+	if w := serveWithToken(handler, mfaToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an MFA token, got %d", w.Code)
+	}
+
+	// a token for a user that doesn't exist is refused
+	unknownToken, _ := auth.GenerateJWT(user.ID+1, "bob", sessionID)
+	if w := serveWithToken(handler, unknownToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an unknown user, got %d", w.Code)
+	}
+
+	// so is one for a session that was never started
+	staleToken, _ := auth.GenerateJWT(user.ID, "alice", sessionID+1)
+	if w := serveWithToken(handler, staleToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an unknown session, got %d", w.Code)
+	}
+}
+
+// sign up and sign in a user through the service, returning its tokens
+func signInTestUser(t *testing.T, authService *services.AuthService, username string) service_models.SignInResponse {
+	t.Helper()
+
+	if err := authService.SignUp(service_models.SignUpRequest{Username: username, Email: username + "@example.com", Password: "password"}); err != nil {
+		t.Fatalf("SignUp returned %v", err)
+	}
+	res, err := authService.SignIn(service_models.SignInRequest{Username: username, Password: "password"})
+	if err != nil {
+		t.Fatalf("SignIn returned %v", err)
+	}
+	return res
+}
+
+func TestTokenAuthMiddlewareRefusesSignedOutSession(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	authService := services.NewAuthService(store, mail.NewLogMailer(io.Discard))
+	var seen *auth.Principal
+	handler := newScopedHandler(authService, auth.ScopeClassesRead, &seen)
+
+	session := signInTestUser(t, authService, "alice")
+	if w := serveWithToken(handler, session.AccessToken); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 before signing out, got %d", w.Code)
+	}
+
+	// the access token stops working with the refresh token, not when it expires
+	if err := authService.Logout(service_models.LogoutRequest{RefreshToken: session.RefreshToken}); err != nil {
+		t.Fatalf("Logout returned %v", err)
+	}
+	if w := serveWithToken(handler, session.AccessToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 after signing out, got %d", w.Code)
+	}
+}
+
+func TestTokenAuthMiddlewareRefusesRevokedSession(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	authService := services.NewAuthService(store, mail.NewLogMailer(io.Discard))
+	var seen *auth.Principal
+	handler := newScopedHandler(authService, auth.ScopeClassesRead, &seen)
+
+	session := signInTestUser(t, authService, "alice")
+	other, err := authService.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("SignIn returned %v", err)
+	}
+	if w := serveWithToken(handler, session.AccessToken); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 before the session is revoked, got %d", w.Code)
+	}
+	sessionID := seen.SessionID
+
+	// revoking the session from another device signs the first one out at once
+	if err := authService.RevokeSession(service_models.RevokeSessionRequest{UserID: seen.UserID, SessionID: sessionID}); err != nil {
+		t.Fatalf("RevokeSession returned %v", err)
+	}
+	if w := serveWithToken(handler, session.AccessToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 after the session is revoked, got %d", w.Code)
+	}
+	if w := serveWithToken(handler, other.AccessToken); w.Code != http.StatusNoContent {
+		t.Fatalf("expected the other session to keep working, got %d", w.Code)
+	}
+}
+
+func TestTokenAuthMiddlewareRefusesDisabledUser(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	authService := services.NewAuthService(store, mail.NewLogMailer(io.Discard))
+	adminService := services.NewAdminService(store, mail.NewLogMailer(io.Discard))
+	admin := db_models.User{Username: "root", Email: "root@example.com", HashedPassword: "hashed", Role: db_models.PlatformRoleAdmin}
+	user := db_models.User{Username: "alice", Email: "alice@example.com", HashedPassword: "hashed"}
+	store.Users().Create(&admin)
+	store.Users().Create(&user)
+
+	var seen *auth.Principal
+	handler := newScopedHandler(authService, auth.ScopeClassesRead, &seen)
+	token, _ := auth.GenerateJWT(user.ID, user.Username, startTestSession(t, store, user.ID))
+	if w := serveWithToken(handler, token); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 before the account is disabled, got %d", w.Code)
+	}
+
+	// the access token the user already holds stops working at once
+	if err := adminService.DisableUser(service_models.AdminUserActionRequest{AdminID: admin.ID, UserID: user.ID}); err != nil {
+		t.Fatalf("DisableUser returned %v", err)
+	}
+	if w := serveWithToken(handler, token); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a disabled user, got %d", w.Code)
+	}
+}
+
+func TestRequireScopeWithPersonalAccessToken(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	authService := services.NewAuthService(store, mail.NewLogMailer(io.Discard))
+	user := db_models.User{Username: "alice", Email: "alice@example.com", HashedPassword: "hashed"}
+	if err := store.Users().Create(&user); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	created, err := authService.CreatePersonalAccessToken(service_models.CreatePersonalAccessTokenRequest{
+		UserID: user.ID,
+		Name:   "roster sync",
+		Scopes: []string{auth.ScopeClassesRead},
+	})
+	if err != nil {
+		t.Fatalf("CreatePersonalAccessToken returned %v", err)
+	}
+
+	// a granted scope lets the request through
+	var seen *auth.Principal
+	w := serveWithToken(newScopedHandler(authService, auth.ScopeClassesRead, &seen), created.Token)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if seen.UserID != user.ID || seen.Username != "alice" || seen.TokenType != auth.TokenTypePersonalAccess || seen.IsSession() {
+		t.Fatalf("unexpected principal %+v", seen)
+	}
+
+	// a missing one is refused and named
+	seen = nil
+	w = serveWithToken(newScopedHandler(authService, auth.ScopeClassesWrite, &seen), created.Token)
+	if w.Code != http.StatusForbidden || seen != nil {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+	if got := w.Header().Get("WWW-Authenticate"); got != `Bearer error="insufficient_scope", scope="classes:write"` {
+		t.Fatalf("unexpected WWW-Authenticate %q", got)
+	}
+
+	// an unknown token is refused before any scope check
+	if w := serveWithToken(newScopedHandler(authService, auth.ScopeClassesRead, &seen), auth.PersonalAccessTokenPrefix+"nope"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an unknown token, got %d", w.Code)
+	}
+}
+
+func TestRequirePlatformAdmin(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	adminService := services.NewAdminService(store, mail.NewLogMailer(io.Discard))
+	admin := db_models.User{Username: "root", Email: "root@example.com", HashedPassword: "hashed", Role: db_models.PlatformRoleAdmin}
+	user := db_models.User{Username: "alice", Email: "alice@example.com", HashedPassword: "hashed", Role: db_models.PlatformRoleUser}
+	store.Users().Create(&admin)
+	store.Users().Create(&user)
+
+	handler := RequirePlatformAdmin(adminService)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(principal *auth.Principal) int {
+		r := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+		r = r.WithContext(auth.WithPrincipal(r.Context(), principal))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := serve(&auth.Principal{UserID: admin.ID, TokenType: auth.TokenTypeAccess}); code != http.StatusNoContent {
+		t.Fatalf("expected an admin to get through, got %d", code)
+	}
+	if code := serve(&auth.Principal{UserID: user.ID, TokenType: auth.TokenTypeAccess}); code != http.StatusForbidden {
+		t.Fatalf("expected a regular user to be refused, got %d", code)
+	}
+	if code := serve(&auth.Principal{UserID: admin.ID, TokenType: auth.TokenTypePersonalAccess, Scopes: auth.AllScopes}); code != http.StatusForbidden {
+		t.Fatalf("expected an admin's personal access token to be refused, got %d", code)
+	}
+
+	// a disabled admin loses access at once
+	now := time.Now()
+	admin.DisabledAt = &now
+	store.Users().Update(&admin)
+	if code := serve(&auth.Principal{UserID: admin.ID, TokenType: auth.TokenTypeAccess}); code != http.StatusForbidden {
+		t.Fatalf("expected a disabled admin to be refused, got %d", code)
+	}
+}
+
+func TestRecordImpersonation(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	authService := services.NewAuthService(store, mail.NewLogMailer(io.Discard))
+	adminService := services.NewAdminService(store, mail.NewLogMailer(io.Discard))
+	admin := db_models.User{Username: "root", Email: "root@example.com", HashedPassword: "hashed", Role: db_models.PlatformRoleAdmin}
+	user := db_models.User{Username: "alice", Email: "alice@example.com", HashedPassword: "hashed"}
+	store.Users().Create(&admin)
+	store.Users().Create(&user)
+
+	impersonation, err := adminService.ImpersonateUser(service_models.AdminImpersonateRequest{AdminID: admin.ID, UserID: user.ID})
+	if err != nil {
+		t.Fatalf("ImpersonateUser returned %v", err)
+	}
+
+	// account changes are out of scope, class routes aren't
+	final := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	chain := func(scope string) http.Handler {
+		return TokenAuthMiddleware(authService)(RecordImpersonation(adminService)(RequireScope(scope)(final)))
+	}
+	if w := serveWithToken(chain(auth.ScopeClassesRead), impersonation.AccessToken); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if w := serveWithToken(chain(auth.ScopeUserWrite), impersonation.AccessToken); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+
+	// both requests were recorded with their status, but not the user's own
+	token, _ := auth.GenerateJWT(user.ID, user.Username, startTestSession(t, store, user.ID))
+	if w := serveWithToken(chain(auth.ScopeClassesRead), token); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 for the user's own request, got %d", w.Code)
+	}
+	actions, _ := adminService.ListActions(service_models.AdminListActionsRequest{})
+	if len(actions.Actions) != 3 || actions.Actions[0].Details != "GET /classes 403" || actions.Actions[1].Details != "GET /classes 204" {
+		t.Fatalf("unexpected actions %+v", actions.Actions)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = auth.RequestIDFromContext(r.Context())
+	}))
+
+	request := func(id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/classes", nil)
+		if id != "" {
+			r.Header.Set(auth.RequestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// an ID passed in by a proxy is kept
+	if w := request("abc-123"); seen != "abc-123" || w.Header().Get(auth.RequestIDHeader) != "abc-123" {
+		t.Fatalf("expected the request ID to be kept, got %q", seen)
+	}
+
+	// one is generated when missing or unusable
+	for _, id := range []string{"", "bad id\n"} {
+		w := request(id)
+		if seen == "" || seen == id || w.Header().Get(auth.RequestIDHeader) != seen {
+			t.Fatalf("expected a generated request ID, got %q", seen)
+		}
+	}
+}
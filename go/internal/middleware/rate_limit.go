@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/ratelimit"
+)
+
+// how much of a request body is read to find the email address it names
+const maxRateLimitBodyBytes = 64 << 10
+
+// limit requests under a policy, keyed by the signed in user or else the client IP, and report the limit in RateLimit headers
+func RateLimit(store ratelimit.Store, policy ratelimit.Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// take a token from every bucket the request counts against, reporting the one closest to its limit
+			var res ratelimit.Result
+			now := time.Now()
+			for i, key := range rateLimitKeys(r, policy) {
+				keyRes, err := store.Take(key, policy, now)
+				if err != nil {
+					// let the request through if the store is unavailable, rather than taking the API down with it
+					log.Printf("rate limit store failed: %v", err)
+					next.ServeHTTP(w, r)
+					return
+				}
+				if i == 0 || moreRestrictive(keyRes, res) {
+					res = keyRes
+				}
+			}
+
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, ceilSeconds(policy.Window)))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				http.Error(w, "Too many requests, please try again later", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// helper function to pick the buckets a request counts against; the principal is only there behind TokenAuthMiddleware
+func rateLimitKeys(r *http.Request, policy ratelimit.Policy) []string {
+	ipKey := policy.Name + ":ip:" + auth.ClientIP(r)
+	principal, signedIn := auth.PrincipalFromContext(r.Context())
+	if !policy.KeyByEmail {
+		if signedIn {
+			return []string{policy.Name + ":user:" + strconv.FormatUint(uint64(principal.UserID), 10)}
+		}
+		return []string{ipKey}
+	}
+
+	// a signed in user's own address is covered by their user bucket
+	keys := []string{ipKey}
+	if signedIn {
+		keys = append(keys, policy.Name+":user:"+strconv.FormatUint(uint64(principal.UserID), 10))
+	}
+	if email := requestEmail(r); email != "" {
+		keys = append(keys, policy.Name+":email:"+email)
+	}
+	return keys
+}
+
+// helper function to read the email address named in a JSON request body, leaving the body for the handler
+func requestEmail(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+	// the server closes the original body once the handler returns
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRateLimitBodyBytes))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return ""
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.ToLower(req.Email))
+}
+
+// helper function to tell whether a result is closer to refusing than another
+func moreRestrictive(res ratelimit.Result, than ratelimit.Result) bool {
+	if res.Allowed != than.Allowed {
+		return !res.Allowed
+	}
+	if !res.Allowed {
+		return res.RetryAfter > than.RetryAfter
+	}
+	return res.Remaining < than.Remaining
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/ratelimit"
+)
+
+func TestRateLimitSetsHeadersAndRefuses(t *testing.T) {
+	policy := ratelimit.Policy{Name: "test", Limit: 1, Window: time.Minute}
+	handler := RateLimit(ratelimit.NewMemoryStore(), policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	request := func(remoteAddr string, userID uint) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/signin", nil)
+		r.RemoteAddr = remoteAddr
+		if userID != 0 {
+			r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{UserID: userID}))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request("203.0.113.1:1234", 0)
+	if w.Code != http.StatusNoContent || w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("RateLimit-Policy") != "1;w=60" {
+		t.Fatalf("unexpected first response %d %v", w.Code, w.Header())
+	}
+
+	w = request("203.0.113.1:5678", 0)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("expected 429 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+
+	// signed in users get their own bucket, wherever they connect from
+	if w := request("203.0.113.1:1234", 7); w.Code != http.StatusNoContent {
+		t.Fatalf("expected the user to have their own limit, got %d", w.Code)
+	}
+	if w := request("198.51.100.1:1234", 7); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the user's limit to follow them, got %d", w.Code)
+	}
+}
+
+func TestRateLimitByEmail(t *testing.T) {
+	policy := ratelimit.Policy{Name: "test", Limit: 1, Window: time.Minute, KeyByEmail: true}
+	var body string
+	handler := RateLimit(ratelimit.NewMemoryStore(), policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		read, _ := io.ReadAll(r.Body)
+		body = string(read)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	request := func(remoteAddr string, userID uint, email string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/auth/password/forgot", strings.NewReader(`{"email":"`+email+`"}`))
+		r.RemoteAddr = remoteAddr
+		if userID != 0 {
+			r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{UserID: userID}))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// the handler still reads the whole body
+	if w := request("203.0.113.1:1234", 0, "alice@example.com"); w.Code != http.StatusNoContent || body != `{"email":"alice@example.com"}` {
+		t.Fatalf("unexpected first response %d with body %q", w.Code, body)
+	}
+
+	// one address can't be targeted from many IPs, however it is written
+	if w := request("198.51.100.1:1234", 0, " Alice@Example.com"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the address to be limited, got %d", w.Code)
+	}
+
+	// nor can one IP go through many addresses
+	if w := request("203.0.113.1:5678", 0, "bob@example.com"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the IP to be limited, got %d", w.Code)
+	}
+
+	// signing in doesn't lift the limit on the IP, and a user without an address in the body is limited as themselves
+	if w := request("203.0.113.1:1234", 7, ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the IP to stay limited for a signed in user, got %d", w.Code)
+	}
+	if w := request("192.0.2.1:1234", 8, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected a fresh IP and user to be allowed, got %d", w.Code)
+	}
+	if w := request("192.0.2.2:1234", 8, ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the user to be limited from another IP, got %d", w.Code)
+	}
+}
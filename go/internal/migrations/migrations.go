@@ -1,25 +1,91 @@
 package migrations
 
 import (
-	"log"
-
-	"github.com/hawkerd/privateinstruction/internal/models/db_models"
-	"gorm.io/gorm"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 )
 
-func Migrate(db *gorm.DB) error {
-	// run migrations for all models
-	err := db.AutoMigrate(
-		&db_models.User{},
-		&db_models.Class{},
-		&db_models.ClassMember{},
-		&db_models.JoinCode{},
-		&db_models.RefreshToken{},
-	)
+//go:embed sql/*.sql
+var sqlFiles embed.FS
+
+// a single versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// load the migrations shipped with the server
+func All() ([]Migration, error) {
+	files, err := fs.Sub(sqlFiles, "sql")
+	if err != nil {
+		return nil, err
+	}
+	return Load(files)
+}
+
+// load migrations from files named <version>_<name>.up.sql and <version>_<name>.down.sql
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		// split the file name into its parts
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		direction := path.Ext(base)
+		base = strings.TrimSuffix(base, direction)
+		versionStr, name, ok := strings.Cut(base, "_")
+		if !ok || (direction != ".up" && direction != ".down") {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %q", entry.Name())
+		}
+
+		contents, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		// group the up and down files of each version
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+		if migration.Name != name {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, migration.Name, name)
+		}
+		if direction == ".up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	// order the migrations by version
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d is missing its up file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
 	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
 
-	log.Println("Database migrated successfully")
-	return nil
+	return migrations, nil
 }
@@ -0,0 +1,236 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// define custom error messages
+var (
+	ErrUnknownVersion = errors.New("unknown migration version")
+	ErrNoDownFile     = errors.New("migration cannot be rolled back")
+	ErrPending        = errors.New("database has pending migrations")
+)
+
+// a row of the schema_migrations table
+type appliedMigration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+func (appliedMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// whether a migration has been applied
+type Status struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies and rolls back migrations, tracking them in schema_migrations
+type Migrator struct {
+	DB         *gorm.DB
+	Migrations []Migration
+}
+
+// create and return a new Migrator instance
+func NewMigrator(db *gorm.DB, migrations []Migration) *Migrator {
+	return &Migrator{
+		DB:         db,
+		Migrations: migrations,
+	}
+}
+
+// create the schema_migrations table if needed
+func (m *Migrator) init() error {
+	return m.DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamp NOT NULL
+	)`).Error
+}
+
+// load the applied migrations keyed by version
+func (m *Migrator) applied() (map[int]appliedMigration, error) {
+	if err := m.init(); err != nil {
+		return nil, err
+	}
+
+	var rows []appliedMigration
+	if err := m.DB.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]appliedMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// report every known migration and whether it has been applied
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		row, ok := applied[migration.Version]
+		statuses = append(statuses, Status{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: row.AppliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+// the highest applied version, or 0 if none are applied
+func (m *Migrator) Version() (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// apply every pending migration
+func (m *Migrator) Up() error {
+	if len(m.Migrations) == 0 {
+		return nil
+	}
+	return m.To(m.Migrations[len(m.Migrations)-1].Version)
+}
+
+// roll back the last n applied migrations
+func (m *Migrator) Down(n int) error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.Migrations) - 1; i >= 0 && n > 0; i-- {
+		migration := m.Migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if err := m.rollback(migration); err != nil {
+			return err
+		}
+		n--
+	}
+	return nil
+}
+
+// apply or roll back migrations until the database is at the given version
+func (m *Migrator) To(version int) error {
+	if version != 0 && !m.known(version) {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	// roll back everything above the target, newest first
+	for i := len(m.Migrations) - 1; i >= 0; i-- {
+		migration := m.Migrations[i]
+		if _, ok := applied[migration.Version]; ok && migration.Version > version {
+			if err := m.rollback(migration); err != nil {
+				return err
+			}
+		}
+	}
+
+	// apply everything up to the target, oldest first
+	for _, migration := range m.Migrations {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+			if err := m.apply(migration); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// return an error if any known migration hasn't been applied
+func (m *Migrator) EnsureCurrent() error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			return fmt.Errorf("%w: %d_%s", ErrPending, status.Version, status.Name)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) known(version int) bool {
+	for _, migration := range m.Migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// run a migration and record it in the same transaction
+func (m *Migrator) apply(migration Migration) error {
+	err := m.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(migration.Up).Error; err != nil {
+			return err
+		}
+		return tx.Create(&appliedMigration{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: time.Now().UTC(),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	log.Printf("Applied migration %d_%s", migration.Version, migration.Name)
+	return nil
+}
+
+// undo a migration and forget it in the same transaction
+func (m *Migrator) rollback(migration Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("%w: %d_%s", ErrNoDownFile, migration.Version, migration.Name)
+	}
+
+	err := m.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(migration.Down).Error; err != nil {
+			return err
+		}
+		return tx.Where("version = ?", migration.Version).Delete(&appliedMigration{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to roll back migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	log.Printf("Rolled back migration %d_%s", migration.Version, migration.Name)
+	return nil
+}
@@ -0,0 +1,144 @@
+package migrations
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// migrations that only use sql understood by sqlite
+var testFiles = fstest.MapFS{
+	"0001_people.up.sql":   {Data: []byte(`CREATE TABLE people (id integer PRIMARY KEY);`)},
+	"0001_people.down.sql": {Data: []byte(`DROP TABLE people;`)},
+	"0002_pets.up.sql":     {Data: []byte(`CREATE TABLE pets (id integer PRIMARY KEY); CREATE TABLE toys (id integer PRIMARY KEY);`)},
+	"0002_pets.down.sql":   {Data: []byte(`DROP TABLE toys; DROP TABLE pets;`)},
+	"0003_rename.up.sql":   {Data: []byte(`ALTER TABLE people RENAME TO persons;`)},
+	"0003_rename.down.sql": {Data: []byte(`ALTER TABLE persons RENAME TO people;`)},
+}
+
+func newTestMigrator(t *testing.T) *Migrator {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	migrations, err := Load(testFiles)
+	if err != nil {
+		t.Fatalf("Load returned %v", err)
+	}
+	return NewMigrator(db, migrations)
+}
+
+func hasTable(m *Migrator, table string) bool {
+	return m.DB.Migrator().HasTable(table)
+}
+
+func TestLoadOrdersMigrations(t *testing.T) {
+	migrations, err := Load(testFiles)
+	if err != nil {
+		t.Fatalf("Load returned %v", err)
+	}
+	if len(migrations) != 3 || migrations[0].Name != "people" || migrations[2].Version != 3 {
+		t.Fatalf("unexpected migrations %+v", migrations)
+	}
+
+	if _, err := Load(fstest.MapFS{"bad.up.sql": {}}); err == nil {
+		t.Fatal("expected an invalid file name to be rejected")
+	}
+	if _, err := Load(fstest.MapFS{"0001_x.down.sql": {Data: []byte("x")}}); err == nil {
+		t.Fatal("expected a missing up file to be rejected")
+	}
+}
+
+func TestAllLoadsBaseline(t *testing.T) {
+	migrations, err := All()
+	if err != nil {
+		t.Fatalf("All returned %v", err)
+	}
+	if len(migrations) == 0 || migrations[0].Version != 1 || migrations[0].Down == "" {
+		t.Fatalf("unexpected migrations %+v", migrations)
+	}
+}
+
+func TestUpDownAndStatus(t *testing.T) {
+	m := newTestMigrator(t)
+
+	if err := m.EnsureCurrent(); !errors.Is(err, ErrPending) {
+		t.Fatalf("expected ErrPending, got %v", err)
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("Up returned %v", err)
+	}
+	if !hasTable(m, "persons") || !hasTable(m, "toys") {
+		t.Fatal("expected every migration to be applied")
+	}
+	if err := m.EnsureCurrent(); err != nil {
+		t.Fatalf("EnsureCurrent returned %v", err)
+	}
+
+	// running up again is a no-op
+	if err := m.Up(); err != nil {
+		t.Fatalf("Up returned %v", err)
+	}
+
+	if err := m.Down(2); err != nil {
+		t.Fatalf("Down returned %v", err)
+	}
+	if !hasTable(m, "people") || hasTable(m, "pets") {
+		t.Fatal("expected the last two migrations to be rolled back")
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatalf("Status returned %v", err)
+	}
+	if !statuses[0].Applied || statuses[1].Applied || statuses[2].Applied {
+		t.Fatalf("unexpected statuses %+v", statuses)
+	}
+}
+
+func TestTo(t *testing.T) {
+	m := newTestMigrator(t)
+
+	if err := m.To(2); err != nil {
+		t.Fatalf("To returned %v", err)
+	}
+	if version, _ := m.Version(); version != 2 {
+		t.Fatalf("expected version 2, got %d", version)
+	}
+
+	if err := m.To(0); err != nil {
+		t.Fatalf("To returned %v", err)
+	}
+	if hasTable(m, "people") {
+		t.Fatal("expected every migration to be rolled back")
+	}
+
+	if err := m.To(9); !errors.Is(err, ErrUnknownVersion) {
+		t.Fatalf("expected ErrUnknownVersion, got %v", err)
+	}
+}
+
+func TestFailedMigrationIsNotRecorded(t *testing.T) {
+	m := newTestMigrator(t)
+	m.Migrations = append(m.Migrations, Migration{Version: 4, Name: "broken", Up: "CREATE TABLE persons (id integer);"})
+
+	if err := m.Up(); err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+	if version, _ := m.Version(); version != 3 {
+		t.Fatalf("expected version 3, got %d", version)
+	}
+}
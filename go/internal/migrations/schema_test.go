@@ -0,0 +1,434 @@
+package migrations
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// every model the server stores; a table created by the migrations must belong to one of them
+var models = []interface{}{
+	&db_models.User{},
+	&db_models.Class{},
+	&db_models.ClassMember{},
+	&db_models.JoinCode{},
+	&db_models.JoinRequest{},
+	&db_models.RefreshToken{},
+	&db_models.SecurityEvent{},
+	&db_models.PasswordResetToken{},
+	&db_models.EmailVerificationToken{},
+	&db_models.RecoveryCode{},
+	&db_models.Identity{},
+	&db_models.SignInThrottle{},
+	&db_models.PersonalAccessToken{},
+	&db_models.AdminAction{},
+	&db_models.AuditEvent{},
+	&db_models.Impersonation{},
+}
+
+// a column as the migrations leave it
+type sqlColumn struct {
+	NotNull bool
+}
+
+// an index or unique constraint as the migrations leave it
+type sqlIndex struct {
+	Table   string
+	Columns string
+	Unique  bool
+	Where   string
+}
+
+// the schema built by replaying the statements of the migrations
+type sqlSchema struct {
+	Tables  map[string]map[string]sqlColumn
+	Indexes map[string]sqlIndex
+}
+
+func newSQLSchema() *sqlSchema {
+	return &sqlSchema{Tables: map[string]map[string]sqlColumn{}, Indexes: map[string]sqlIndex{}}
+}
+
+func (s *sqlSchema) clone() *sqlSchema {
+	c := newSQLSchema()
+	for table, columns := range s.Tables {
+		c.Tables[table] = map[string]sqlColumn{}
+		for name, column := range columns {
+			c.Tables[table][name] = column
+		}
+	}
+	for name, index := range s.Indexes {
+		c.Indexes[name] = index
+	}
+	return c
+}
+
+// the statements the migrations use
+var (
+	dollarQuoted     = regexp.MustCompile(`(?s)\$\$.*?\$\$`)
+	lineComment      = regexp.MustCompile(`--[^\n]*`)
+	createTable      = regexp.MustCompile(`(?is)^CREATE TABLE (?:IF NOT EXISTS )?"?(\w+)"? \((.*)\)$`)
+	dropTable        = regexp.MustCompile(`(?i)^DROP TABLE (?:IF EXISTS )?"?(\w+)"?$`)
+	addColumn        = regexp.MustCompile(`(?i)^ALTER TABLE "?(\w+)"? ADD COLUMN (\w+) (.*)$`)
+	dropColumn       = regexp.MustCompile(`(?i)^ALTER TABLE "?(\w+)"? DROP COLUMN (\w+)$`)
+	alterNotNull     = regexp.MustCompile(`(?i)^ALTER TABLE "?(\w+)"? ALTER COLUMN (\w+) (SET|DROP) NOT NULL$`)
+	addConstraint    = regexp.MustCompile(`(?i)^ALTER TABLE "?(\w+)"? ADD CONSTRAINT "?(\w+)"? UNIQUE \((.*)\)$`)
+	dropConstraint   = regexp.MustCompile(`(?i)^ALTER TABLE "?(\w+)"? DROP CONSTRAINT "?(\w+)"?$`)
+	createIndex      = regexp.MustCompile(`(?i)^CREATE (UNIQUE )?INDEX (?:IF NOT EXISTS )?"?(\w+)"? ON "?(\w+)"? \(([^)]*)\)(?: WHERE (.*))?$`)
+	dropIndex        = regexp.MustCompile(`(?i)^DROP INDEX (?:IF EXISTS )?"?(\w+)"?$`)
+	uniqueInTable    = regexp.MustCompile(`(?i)^CONSTRAINT "?(\w+)"? UNIQUE \((.*)\)$`)
+	ignoredStatement = regexp.MustCompile(`(?i)^(UPDATE|DELETE FROM|CREATE OR REPLACE FUNCTION|CREATE TRIGGER|DROP FUNCTION) `)
+)
+
+// replay the statements of a migration file
+func (s *sqlSchema) apply(t *testing.T, file string, contents string) {
+	t.Helper()
+
+	contents = dollarQuoted.ReplaceAllString(contents, "")
+	contents = lineComment.ReplaceAllString(contents, "")
+	for _, statement := range strings.Split(contents, ";") {
+		statement = strings.Join(strings.Fields(statement), " ")
+		if statement == "" {
+			continue
+		}
+		if err := s.applyStatement(statement); err != nil {
+			t.Fatalf("%s: %v in %q", file, err, statement)
+		}
+	}
+}
+
+func (s *sqlSchema) applyStatement(statement string) error {
+	if m := createTable.FindStringSubmatch(statement); m != nil {
+		if _, ok := s.Tables[m[1]]; ok {
+			return nil
+		}
+		columns := map[string]sqlColumn{}
+		for _, def := range splitDefinitions(m[2]) {
+			if u := uniqueInTable.FindStringSubmatch(def); u != nil {
+				s.Indexes[u[1]] = sqlIndex{Table: m[1], Columns: normalizeColumns(u[2]), Unique: true}
+				continue
+			}
+			if strings.HasPrefix(strings.ToUpper(def), "CONSTRAINT ") {
+				continue
+			}
+			name, rest, _ := strings.Cut(def, " ")
+			rest = strings.ToUpper(rest)
+			columns[name] = sqlColumn{NotNull: strings.Contains(rest, "NOT NULL") || strings.Contains(rest, "PRIMARY KEY")}
+		}
+		s.Tables[m[1]] = columns
+		return nil
+	}
+	if m := dropTable.FindStringSubmatch(statement); m != nil {
+		delete(s.Tables, m[1])
+		for name, index := range s.Indexes {
+			if index.Table == m[1] {
+				delete(s.Indexes, name)
+			}
+		}
+		return nil
+	}
+	if m := addColumn.FindStringSubmatch(statement); m != nil {
+		columns, err := s.table(m[1])
+		if err != nil {
+			return err
+		}
+		if _, ok := columns[m[2]]; ok {
+			return fmt.Errorf("column %s already exists", m[2])
+		}
+		columns[m[2]] = sqlColumn{NotNull: strings.Contains(strings.ToUpper(m[3]), "NOT NULL")}
+		return nil
+	}
+	if m := dropColumn.FindStringSubmatch(statement); m != nil {
+		columns, err := s.table(m[1])
+		if err != nil {
+			return err
+		}
+		if _, ok := columns[m[2]]; !ok {
+			return fmt.Errorf("column %s does not exist", m[2])
+		}
+		delete(columns, m[2])
+		for name, index := range s.Indexes {
+			if index.Table == m[1] && strings.Contains(","+index.Columns+",", ","+m[2]+",") {
+				delete(s.Indexes, name)
+			}
+		}
+		return nil
+	}
+	if m := alterNotNull.FindStringSubmatch(statement); m != nil {
+		columns, err := s.table(m[1])
+		if err != nil {
+			return err
+		}
+		if _, ok := columns[m[2]]; !ok {
+			return fmt.Errorf("column %s does not exist", m[2])
+		}
+		columns[m[2]] = sqlColumn{NotNull: strings.EqualFold(m[3], "SET")}
+		return nil
+	}
+	if m := addConstraint.FindStringSubmatch(statement); m != nil {
+		if _, err := s.table(m[1]); err != nil {
+			return err
+		}
+		s.Indexes[m[2]] = sqlIndex{Table: m[1], Columns: normalizeColumns(m[3]), Unique: true}
+		return nil
+	}
+	if m := dropConstraint.FindStringSubmatch(statement); m != nil {
+		if _, ok := s.Indexes[m[2]]; !ok {
+			return fmt.Errorf("constraint %s does not exist", m[2])
+		}
+		delete(s.Indexes, m[2])
+		return nil
+	}
+	if m := createIndex.FindStringSubmatch(statement); m != nil {
+		if _, err := s.table(m[3]); err != nil {
+			return err
+		}
+		if _, ok := s.Indexes[m[2]]; ok && !strings.Contains(strings.ToUpper(statement), "IF NOT EXISTS") {
+			return fmt.Errorf("index %s already exists", m[2])
+		}
+		s.Indexes[m[2]] = sqlIndex{Table: m[3], Columns: normalizeColumns(m[4]), Unique: m[1] != "", Where: normalizeWhere(m[5])}
+		return nil
+	}
+	if m := dropIndex.FindStringSubmatch(statement); m != nil {
+		if _, ok := s.Indexes[m[1]]; !ok && !strings.Contains(strings.ToUpper(statement), "IF EXISTS") {
+			return fmt.Errorf("index %s does not exist", m[1])
+		}
+		delete(s.Indexes, m[1])
+		return nil
+	}
+	if ignoredStatement.MatchString(statement) {
+		return nil
+	}
+	return fmt.Errorf("statement not understood by the schema test")
+}
+
+func (s *sqlSchema) table(name string) (map[string]sqlColumn, error) {
+	columns, ok := s.Tables[name]
+	if !ok {
+		return nil, fmt.Errorf("table %s does not exist", name)
+	}
+	return columns, nil
+}
+
+// split the body of a CREATE TABLE on the commas outside parentheses
+func splitDefinitions(body string) []string {
+	var defs []string
+	depth, start := 0, 0
+	for i, r := range body {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				defs = append(defs, strings.TrimSpace(body[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(defs, strings.TrimSpace(body[start:]))
+}
+
+func normalizeColumns(columns string) string {
+	parts := strings.Split(columns, ",")
+	for i, part := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(part), `"`)
+	}
+	return strings.Join(parts, ",")
+}
+
+func normalizeWhere(where string) string {
+	return strings.ToLower(strings.Join(strings.Fields(where), " "))
+}
+
+// replay every migration up, checking each down file undoes its up file
+func replayMigrations(t *testing.T) *sqlSchema {
+	t.Helper()
+
+	migrations, err := All()
+	if err != nil {
+		t.Fatalf("All returned %v", err)
+	}
+
+	current := newSQLSchema()
+	for _, migration := range migrations {
+		before := current.clone()
+		current.apply(t, fmt.Sprintf("%04d_%s.up.sql", migration.Version, migration.Name), migration.Up)
+		if migration.Down == "" {
+			t.Fatalf("migration %d has no down file", migration.Version)
+		}
+
+		undone := current.clone()
+		undone.apply(t, fmt.Sprintf("%04d_%s.down.sql", migration.Version, migration.Name), migration.Down)
+		if !reflect.DeepEqual(undone, before) {
+			t.Fatalf("migration %d: down file doesn't undo the up file\nbefore: %+v\nafter down: %+v", migration.Version, before, undone)
+		}
+	}
+	return current
+}
+
+func TestMigrationsMatchModels(t *testing.T) {
+	current := replayMigrations(t)
+
+	cache := &sync.Map{}
+	tables := map[string]bool{}
+	for _, model := range models {
+		sch, err := schema.Parse(model, cache, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatalf("failed to parse %T: %v", model, err)
+		}
+		tables[sch.Table] = true
+
+		columns, ok := current.Tables[sch.Table]
+		if !ok {
+			t.Errorf("%s: no migration creates the table", sch.Table)
+			continue
+		}
+
+		// the same columns, with the same nullability
+		for _, field := range sch.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			column, ok := columns[field.DBName]
+			if !ok {
+				t.Errorf("%s.%s: no migration creates the column", sch.Table, field.DBName)
+				continue
+			}
+			if column.NotNull != (field.NotNull || field.PrimaryKey) {
+				t.Errorf("%s.%s: NOT NULL is %v in the migrations but %v in the model", sch.Table, field.DBName, column.NotNull, field.NotNull)
+			}
+		}
+		for name := range columns {
+			if sch.LookUpField(name) == nil {
+				t.Errorf("%s.%s: the model has no field for the column", sch.Table, name)
+			}
+		}
+
+		// the indexes declared on the model, by name
+		for _, index := range sch.ParseIndexes() {
+			var fields []string
+			for _, option := range index.Fields {
+				fields = append(fields, option.DBName)
+			}
+			got, ok := current.Indexes[index.Name]
+			want := sqlIndex{Table: sch.Table, Columns: strings.Join(fields, ","), Unique: index.Class == "UNIQUE", Where: normalizeWhere(index.Where)}
+			if !ok || got != want {
+				t.Errorf("%s: index %s is %+v in the migrations but %+v in the model", sch.Table, index.Name, got, want)
+			}
+		}
+
+		// uniqueness enforced by the database must be known to the model, and the other way round
+		for _, field := range sch.Fields {
+			if !field.Unique {
+				continue
+			}
+			found := false
+			for _, index := range current.Indexes {
+				if index.Table == sch.Table && index.Unique && index.Columns == field.DBName && index.Where == "" {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("%s.%s: unique in the model but not in the migrations", sch.Table, field.DBName)
+			}
+		}
+		for name, index := range current.Indexes {
+			if index.Table != sch.Table || !index.Unique {
+				continue
+			}
+			if _, ok := sch.ParseIndexes()[name]; ok {
+				continue
+			}
+			if field := sch.LookUpField(index.Columns); field != nil && field.Unique && index.Where == "" {
+				continue
+			}
+			t.Errorf("%s: unique index %s is missing from the model", sch.Table, name)
+		}
+	}
+
+	for table := range current.Tables {
+		if !tables[table] {
+			t.Errorf("%s: the migrations create a table no model uses", table)
+		}
+	}
+}
+
+func TestMigrationsRollBackCompletely(t *testing.T) {
+	migrations, err := All()
+	if err != nil {
+		t.Fatalf("All returned %v", err)
+	}
+
+	current := replayMigrations(t)
+	for i := len(migrations) - 1; i >= 0; i-- {
+		current.apply(t, fmt.Sprintf("%04d_%s.down.sql", migrations[i].Version, migrations[i].Name), migrations[i].Down)
+	}
+	if len(current.Tables) != 0 || len(current.Indexes) != 0 {
+		t.Fatalf("expected nothing to be left, got %+v", current)
+	}
+}
+
+// apply the migrations to a real PostgreSQL database when TEST_DATABASE_URL points at a disposable one
+func TestMigrationsOnPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	migrations, err := All()
+	if err != nil {
+		t.Fatalf("All returned %v", err)
+	}
+	m := NewMigrator(db, migrations)
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("Up returned %v", err)
+	}
+	for _, model := range models {
+		sch, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatalf("failed to parse %T: %v", model, err)
+		}
+		for _, field := range sch.Fields {
+			if field.DBName != "" && !db.Migrator().HasColumn(model, field.DBName) {
+				t.Errorf("%s.%s: missing after the migrations", sch.Table, field.DBName)
+			}
+		}
+		for name := range sch.ParseIndexes() {
+			if !db.Migrator().HasIndex(model, name) {
+				t.Errorf("%s: index %s missing after the migrations", sch.Table, name)
+			}
+		}
+	}
+
+	// every down file runs, and the schema can be rebuilt afterwards
+	if err := m.To(0); err != nil {
+		t.Fatalf("To returned %v", err)
+	}
+	for _, model := range models {
+		if db.Migrator().HasTable(model) {
+			t.Errorf("%T: table left after rolling back", model)
+		}
+	}
+	if err := m.Up(); err != nil {
+		t.Fatalf("Up returned %v", err)
+	}
+}
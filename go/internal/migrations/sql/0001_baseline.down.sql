@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS "RefreshToken";
+DROP TABLE IF EXISTS "JoinCode";
+DROP TABLE IF EXISTS "ClassMember";
+DROP TABLE IF EXISTS "Class";
+DROP TABLE IF EXISTS "User";
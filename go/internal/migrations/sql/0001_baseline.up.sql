@@ -0,0 +1,63 @@
+-- tables previously created by gorm's AutoMigrate
+CREATE TABLE IF NOT EXISTS "User" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    username text NOT NULL,
+    hashed_password text NOT NULL,
+    email text NOT NULL,
+    CONSTRAINT "uni_User_username" UNIQUE (username),
+    CONSTRAINT "uni_User_email" UNIQUE (email)
+);
+CREATE INDEX IF NOT EXISTS "idx_User_deleted_at" ON "User" (deleted_at);
+
+CREATE TABLE IF NOT EXISTS "Class" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    name text NOT NULL,
+    description text,
+    creator_id bigint,
+    CONSTRAINT "fk_Class_created_by" FOREIGN KEY (creator_id) REFERENCES "User" (id)
+);
+CREATE INDEX IF NOT EXISTS "idx_Class_deleted_at" ON "Class" (deleted_at);
+
+CREATE TABLE IF NOT EXISTS "ClassMember" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    class_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    role text NOT NULL,
+    CONSTRAINT "fk_ClassMember_class" FOREIGN KEY (class_id) REFERENCES "Class" (id),
+    CONSTRAINT "fk_ClassMember_user" FOREIGN KEY (user_id) REFERENCES "User" (id)
+);
+CREATE INDEX IF NOT EXISTS "idx_ClassMember_deleted_at" ON "ClassMember" (deleted_at);
+
+CREATE TABLE IF NOT EXISTS "JoinCode" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    code text NOT NULL,
+    class_id bigint,
+    expiration_dt timestamptz NOT NULL,
+    CONSTRAINT "fk_JoinCode_class" FOREIGN KEY (class_id) REFERENCES "Class" (id)
+);
+CREATE INDEX IF NOT EXISTS "idx_JoinCode_deleted_at" ON "JoinCode" (deleted_at);
+
+CREATE TABLE IF NOT EXISTS "RefreshToken" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    hashed_token text NOT NULL,
+    user_id bigint NOT NULL,
+    expires_at timestamptz NOT NULL,
+    CONSTRAINT "uni_RefreshToken_hashed_token" UNIQUE (hashed_token),
+    CONSTRAINT "fk_RefreshToken_user" FOREIGN KEY (user_id) REFERENCES "User" (id)
+);
+CREATE INDEX IF NOT EXISTS "idx_RefreshToken_deleted_at" ON "RefreshToken" (deleted_at);
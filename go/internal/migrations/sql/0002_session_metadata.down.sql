@@ -0,0 +1,4 @@
+ALTER TABLE "RefreshToken" DROP COLUMN last_used_at;
+ALTER TABLE "RefreshToken" DROP COLUMN device_label;
+ALTER TABLE "RefreshToken" DROP COLUMN ip_address;
+ALTER TABLE "RefreshToken" DROP COLUMN user_agent;
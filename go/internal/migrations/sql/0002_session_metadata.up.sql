@@ -0,0 +1,6 @@
+-- device metadata shown in the active sessions list
+ALTER TABLE "RefreshToken" ADD COLUMN user_agent text NOT NULL DEFAULT '';
+ALTER TABLE "RefreshToken" ADD COLUMN ip_address text NOT NULL DEFAULT '';
+ALTER TABLE "RefreshToken" ADD COLUMN device_label text NOT NULL DEFAULT '';
+ALTER TABLE "RefreshToken" ADD COLUMN last_used_at timestamptz;
+UPDATE "RefreshToken" SET last_used_at = updated_at;
@@ -0,0 +1,4 @@
+DELETE FROM "RefreshToken";
+DROP INDEX "idx_RefreshToken_selector";
+ALTER TABLE "RefreshToken" DROP COLUMN selector;
+ALTER TABLE "RefreshToken" ADD CONSTRAINT "uni_RefreshToken_hashed_token" UNIQUE (hashed_token);
@@ -0,0 +1,6 @@
+-- refresh tokens become <selector>.<verifier> with an HMAC of the verifier;
+-- bcrypt hashed tokens can't be converted, so existing sessions are signed out
+DELETE FROM "RefreshToken";
+ALTER TABLE "RefreshToken" DROP CONSTRAINT "uni_RefreshToken_hashed_token";
+ALTER TABLE "RefreshToken" ADD COLUMN selector text NOT NULL;
+CREATE UNIQUE INDEX "idx_RefreshToken_selector" ON "RefreshToken" (selector);
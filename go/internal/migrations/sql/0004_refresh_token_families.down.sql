@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS "SecurityEvent";
+
+DELETE FROM "RefreshToken" WHERE rotated_at IS NOT NULL;
+DROP INDEX "idx_RefreshToken_family_id";
+ALTER TABLE "RefreshToken" DROP COLUMN rotated_at;
+ALTER TABLE "RefreshToken" DROP COLUMN parent_id;
+ALTER TABLE "RefreshToken" DROP COLUMN family_id;
@@ -0,0 +1,21 @@
+-- rotated refresh tokens are kept, chained to their parent, so replays can be detected
+ALTER TABLE "RefreshToken" ADD COLUMN family_id bigint NOT NULL DEFAULT 0;
+ALTER TABLE "RefreshToken" ADD COLUMN parent_id bigint;
+ALTER TABLE "RefreshToken" ADD COLUMN rotated_at timestamptz;
+UPDATE "RefreshToken" SET family_id = id;
+CREATE INDEX "idx_RefreshToken_family_id" ON "RefreshToken" (family_id);
+
+CREATE TABLE IF NOT EXISTS "SecurityEvent" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    user_id bigint NOT NULL,
+    type text NOT NULL,
+    ip_address text NOT NULL DEFAULT '',
+    user_agent text NOT NULL DEFAULT '',
+    details text NOT NULL DEFAULT '',
+    CONSTRAINT "fk_SecurityEvent_user" FOREIGN KEY (user_id) REFERENCES "User" (id)
+);
+CREATE INDEX IF NOT EXISTS "idx_SecurityEvent_deleted_at" ON "SecurityEvent" (deleted_at);
+CREATE INDEX IF NOT EXISTS "idx_SecurityEvent_user_id" ON "SecurityEvent" (user_id);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS "PasswordResetToken" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    selector text NOT NULL,
+    hashed_token text NOT NULL,
+    user_id bigint NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at timestamptz,
+    CONSTRAINT "fk_PasswordResetToken_user" FOREIGN KEY (user_id) REFERENCES "User" (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_PasswordResetToken_selector" ON "PasswordResetToken" (selector);
+CREATE INDEX IF NOT EXISTS "idx_PasswordResetToken_user_id" ON "PasswordResetToken" (user_id);
+CREATE INDEX IF NOT EXISTS "idx_PasswordResetToken_deleted_at" ON "PasswordResetToken" (deleted_at);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS "EmailVerificationToken";
+
+ALTER TABLE "User" DROP COLUMN pending_email;
+ALTER TABLE "User" DROP COLUMN email_verified;
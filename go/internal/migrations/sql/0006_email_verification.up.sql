@@ -0,0 +1,19 @@
+ALTER TABLE "User" ADD COLUMN email_verified boolean NOT NULL DEFAULT false;
+ALTER TABLE "User" ADD COLUMN pending_email text NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS "EmailVerificationToken" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    selector text NOT NULL,
+    hashed_token text NOT NULL,
+    user_id bigint NOT NULL,
+    email text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at timestamptz,
+    CONSTRAINT "fk_EmailVerificationToken_user" FOREIGN KEY (user_id) REFERENCES "User" (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_EmailVerificationToken_selector" ON "EmailVerificationToken" (selector);
+CREATE INDEX IF NOT EXISTS "idx_EmailVerificationToken_user_id" ON "EmailVerificationToken" (user_id);
+CREATE INDEX IF NOT EXISTS "idx_EmailVerificationToken_deleted_at" ON "EmailVerificationToken" (deleted_at);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS "RecoveryCode";
+
+ALTER TABLE "User" DROP COLUMN totp_last_step;
+ALTER TABLE "User" DROP COLUMN totp_enabled;
+ALTER TABLE "User" DROP COLUMN totp_secret;
@@ -0,0 +1,16 @@
+ALTER TABLE "User" ADD COLUMN totp_secret text NOT NULL DEFAULT '';
+ALTER TABLE "User" ADD COLUMN totp_enabled boolean NOT NULL DEFAULT false;
+ALTER TABLE "User" ADD COLUMN totp_last_step bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS "RecoveryCode" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    user_id bigint NOT NULL,
+    hashed_code text NOT NULL,
+    used_at timestamptz,
+    CONSTRAINT "fk_RecoveryCode_user" FOREIGN KEY (user_id) REFERENCES "User" (id)
+);
+CREATE INDEX IF NOT EXISTS "idx_RecoveryCode_user_id" ON "RecoveryCode" (user_id);
+CREATE INDEX IF NOT EXISTS "idx_RecoveryCode_deleted_at" ON "RecoveryCode" (deleted_at);
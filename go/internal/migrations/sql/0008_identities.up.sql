@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS "Identity" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    user_id bigint NOT NULL,
+    provider text NOT NULL,
+    subject text NOT NULL,
+    email text NOT NULL DEFAULT '',
+    CONSTRAINT "fk_Identity_user" FOREIGN KEY (user_id) REFERENCES "User" (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_Identity_provider_subject" ON "Identity" (provider, subject);
+CREATE INDEX IF NOT EXISTS "idx_Identity_user_id" ON "Identity" (user_id);
+CREATE INDEX IF NOT EXISTS "idx_Identity_deleted_at" ON "Identity" (deleted_at);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS "SignInThrottle" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    key text NOT NULL,
+    failures bigint NOT NULL DEFAULT 0,
+    last_failure_at timestamptz,
+    locked_until timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_SignInThrottle_key" ON "SignInThrottle" (key);
+CREATE INDEX IF NOT EXISTS "idx_SignInThrottle_deleted_at" ON "SignInThrottle" (deleted_at);
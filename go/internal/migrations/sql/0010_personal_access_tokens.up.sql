@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS "PersonalAccessToken" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    user_id bigint NOT NULL,
+    name text NOT NULL,
+    selector text NOT NULL,
+    hashed_token text NOT NULL,
+    scopes text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    last_used_at timestamptz,
+    last_used_ip text NOT NULL DEFAULT '',
+    CONSTRAINT "fk_PersonalAccessToken_user" FOREIGN KEY (user_id) REFERENCES "User" (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_PersonalAccessToken_selector" ON "PersonalAccessToken" (selector);
+CREATE INDEX IF NOT EXISTS "idx_PersonalAccessToken_user_id" ON "PersonalAccessToken" (user_id);
+CREATE INDEX IF NOT EXISTS "idx_PersonalAccessToken_deleted_at" ON "PersonalAccessToken" (deleted_at);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS "AdminAction";
+ALTER TABLE "User" DROP COLUMN password_reset_required;
+ALTER TABLE "User" DROP COLUMN disabled_at;
+ALTER TABLE "User" DROP COLUMN role;
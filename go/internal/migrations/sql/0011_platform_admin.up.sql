@@ -0,0 +1,20 @@
+-- platform role and the account states admins can set
+ALTER TABLE "User" ADD COLUMN role text NOT NULL DEFAULT 'user';
+ALTER TABLE "User" ADD COLUMN disabled_at timestamptz;
+ALTER TABLE "User" ADD COLUMN password_reset_required boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS "AdminAction" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    admin_id bigint NOT NULL,
+    action text NOT NULL,
+    target_type text NOT NULL,
+    target_id bigint NOT NULL,
+    ip_address text NOT NULL DEFAULT '',
+    details text NOT NULL DEFAULT '',
+    CONSTRAINT "fk_AdminAction_admin" FOREIGN KEY (admin_id) REFERENCES "User" (id)
+);
+CREATE INDEX IF NOT EXISTS "idx_AdminAction_admin_id" ON "AdminAction" (admin_id);
+CREATE INDEX IF NOT EXISTS "idx_AdminAction_deleted_at" ON "AdminAction" (deleted_at);
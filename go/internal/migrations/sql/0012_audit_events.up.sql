@@ -0,0 +1,30 @@
+-- append-only log of security and class relevant events
+CREATE TABLE IF NOT EXISTS "AuditEvent" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    actor_id bigint NOT NULL,
+    impersonator_id bigint NOT NULL DEFAULT 0,
+    action text NOT NULL,
+    target_type text NOT NULL,
+    target_id bigint NOT NULL,
+    class_id bigint NOT NULL DEFAULT 0,
+    before text NOT NULL DEFAULT '',
+    after text NOT NULL DEFAULT '',
+    ip_address text NOT NULL DEFAULT '',
+    request_id text NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS "idx_AuditEvent_actor_id" ON "AuditEvent" (actor_id);
+CREATE INDEX IF NOT EXISTS "idx_AuditEvent_class_id" ON "AuditEvent" (class_id);
+CREATE INDEX IF NOT EXISTS "idx_AuditEvent_created_at" ON "AuditEvent" (created_at);
+CREATE INDEX IF NOT EXISTS "idx_AuditEvent_deleted_at" ON "AuditEvent" (deleted_at);
+
+-- events can be pruned once they are past retention, but never changed
+CREATE OR REPLACE FUNCTION "AuditEvent_reject_update"() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit events cannot be changed';
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER "AuditEvent_append_only" BEFORE UPDATE ON "AuditEvent"
+    FOR EACH ROW EXECUTE FUNCTION "AuditEvent_reject_update"();
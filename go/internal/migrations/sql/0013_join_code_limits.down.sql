@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS "idx_JoinCode_class_id";
+-- codes without an expiry stop working rather than blocking the rollback
+UPDATE "JoinCode" SET expiration_dt = now() WHERE expiration_dt IS NULL;
+ALTER TABLE "JoinCode" ALTER COLUMN expiration_dt SET NOT NULL;
+ALTER TABLE "JoinCode" DROP COLUMN remaining_uses;
+ALTER TABLE "JoinCode" DROP COLUMN max_uses;
+ALTER TABLE "JoinCode" DROP COLUMN role;
+ALTER TABLE "JoinCode" DROP COLUMN name;
@@ -0,0 +1,7 @@
+-- several named codes per class, each with its own expiry, use limit and role
+ALTER TABLE "JoinCode" ADD COLUMN name text NOT NULL DEFAULT '';
+ALTER TABLE "JoinCode" ADD COLUMN role text NOT NULL DEFAULT 'user';
+ALTER TABLE "JoinCode" ADD COLUMN max_uses bigint NOT NULL DEFAULT 0;
+ALTER TABLE "JoinCode" ADD COLUMN remaining_uses bigint NOT NULL DEFAULT 0;
+ALTER TABLE "JoinCode" ALTER COLUMN expiration_dt DROP NOT NULL;
+CREATE INDEX IF NOT EXISTS "idx_JoinCode_class_id" ON "JoinCode" (class_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "JoinRequest";
+ALTER TABLE "Class" DROP COLUMN require_approval;
@@ -0,0 +1,23 @@
+-- classes can require an admin to approve everyone who joins with a code
+ALTER TABLE "Class" ADD COLUMN require_approval boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS "JoinRequest" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    class_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    join_code_id bigint NOT NULL,
+    role text NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    decided_by_id bigint,
+    decided_at timestamptz,
+    CONSTRAINT "fk_JoinRequest_class" FOREIGN KEY (class_id) REFERENCES "Class" (id),
+    CONSTRAINT "fk_JoinRequest_user" FOREIGN KEY (user_id) REFERENCES "User" (id)
+);
+CREATE INDEX IF NOT EXISTS "idx_JoinRequest_class_id" ON "JoinRequest" (class_id);
+CREATE INDEX IF NOT EXISTS "idx_JoinRequest_user_id" ON "JoinRequest" (user_id);
+CREATE INDEX IF NOT EXISTS "idx_JoinRequest_deleted_at" ON "JoinRequest" (deleted_at);
+-- a user has at most one pending request per class
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_JoinRequest_pending" ON "JoinRequest" (class_id, user_id) WHERE status = 'pending';
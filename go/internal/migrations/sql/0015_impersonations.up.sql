@@ -0,0 +1,16 @@
+-- each impersonation is kept so an admin can end it before its token expires
+CREATE TABLE IF NOT EXISTS "Impersonation" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    admin_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    expires_at timestamptz NOT NULL,
+    ended_at timestamptz,
+    CONSTRAINT "fk_Impersonation_admin" FOREIGN KEY (admin_id) REFERENCES "User" (id),
+    CONSTRAINT "fk_Impersonation_user" FOREIGN KEY (user_id) REFERENCES "User" (id)
+);
+CREATE INDEX IF NOT EXISTS "idx_Impersonation_admin_id" ON "Impersonation" (admin_id);
+CREATE INDEX IF NOT EXISTS "idx_Impersonation_user_id" ON "Impersonation" (user_id);
+CREATE INDEX IF NOT EXISTS "idx_Impersonation_deleted_at" ON "Impersonation" (deleted_at);
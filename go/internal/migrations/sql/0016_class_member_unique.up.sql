@@ -0,0 +1,8 @@
+-- a user is a member of a class at most once; keep the oldest of any duplicates left by concurrent joins
+UPDATE "ClassMember" SET deleted_at = now()
+WHERE deleted_at IS NULL AND EXISTS (
+    SELECT 1 FROM "ClassMember" AS older
+    WHERE older.class_id = "ClassMember".class_id AND older.user_id = "ClassMember".user_id
+        AND older.deleted_at IS NULL AND older.id < "ClassMember".id
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_ClassMember_live" ON "ClassMember" (class_id, user_id) WHERE deleted_at IS NULL;
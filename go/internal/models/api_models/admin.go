@@ -0,0 +1,47 @@
+package api_models
+
+// list users
+type AdminUserSummary struct {
+	ID                    uint   `json:"id"`
+	Username              string `json:"username"`
+	Email                 string `json:"email"`
+	EmailVerified         bool   `json:"email_verified"`
+	Role                  string `json:"role"`
+	TwoFactorEnabled      bool   `json:"two_factor_enabled"`
+	Disabled              bool   `json:"disabled"`
+	PasswordResetRequired bool   `json:"password_reset_required"`
+	CreatedAt             string `json:"created_at"`
+}
+type AdminListUsersResponse struct {
+	Users      []AdminUserSummary `json:"users"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+// the reason an admin gives for an action, kept with the record of it
+type AdminActionRequest struct {
+	Reason string `json:"reason"`
+}
+
+// impersonation
+type AdminImpersonateResponse struct {
+	ImpersonationID uint   `json:"impersonation_id"`
+	AccessToken     string `json:"access_token"`
+	ExpiresAt       string `json:"expires_at"`
+}
+
+// list admin actions
+type AdminActionSummary struct {
+	ID         uint   `json:"id"`
+	AdminID    uint   `json:"admin_id"`
+	Admin      string `json:"admin"`
+	Action     string `json:"action"`
+	TargetType string `json:"target_type"`
+	TargetID   uint   `json:"target_id"`
+	IPAddress  string `json:"ip_address"`
+	Details    string `json:"details"`
+	CreatedAt  string `json:"created_at"`
+}
+type AdminListActionsResponse struct {
+	Actions    []AdminActionSummary `json:"actions"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
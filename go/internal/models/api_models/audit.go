@@ -0,0 +1,42 @@
+package api_models
+
+import "encoding/json"
+
+// list audit events
+type AuditEventSummary struct {
+	ID             uint   `json:"id"`
+	ActorID        uint   `json:"actor_id"`
+	ImpersonatorID uint   `json:"impersonator_id,omitempty"`
+	Action         string `json:"action"`
+	TargetType     string `json:"target_type"`
+	TargetID       uint   `json:"target_id"`
+	ClassID        uint   `json:"class_id,omitempty"`
+	// the fields the action changed, before and after
+	Before    json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After     json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+	IPAddress string          `json:"ip_address"`
+	RequestID string          `json:"request_id"`
+	CreatedAt string          `json:"created_at"`
+}
+type ListAuditEventsResponse struct {
+	Events     []AuditEventSummary `json:"events"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+// list the audit events of a class
+type ClassAuditEventSummary struct {
+	ID         uint   `json:"id"`
+	ActorID    uint   `json:"actor_id"`
+	Action     string `json:"action"`
+	TargetType string `json:"target_type"`
+	TargetID   uint   `json:"target_id"`
+	// the fields the action changed, before and after
+	Before    json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After     json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+	RequestID string          `json:"request_id"`
+	CreatedAt string          `json:"created_at"`
+}
+type ListClassAuditEventsResponse struct {
+	Events     []ClassAuditEventSummary `json:"events"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+}
@@ -14,7 +14,25 @@ type SignInRequest struct {
 	Email    string `json:"email"`
 }
 type SignInResponse struct {
-	AccessToken string `json:"access_token"`
+	AccessToken string `json:"access_token,omitempty"`
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+}
+
+// two-factor authentication
+type VerifyMFARequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+type SetupTwoFactorResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+type ConfirmTwoFactorResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
 }
 
 // update password
@@ -25,5 +43,67 @@ type UpdatePasswordRequest struct {
 
 // refresh token
 type RefreshTokenResponse struct {
-	AccessToken  string `json:"access_token"`
-}
\ No newline at end of file
+	AccessToken string `json:"access_token"`
+}
+
+// sessions
+type SessionSummary struct {
+	ID          uint   `json:"id"`
+	DeviceLabel string `json:"device_label"`
+	UserAgent   string `json:"user_agent"`
+	IPAddress   string `json:"ip_address"`
+	CreatedAt   string `json:"created_at"`
+	LastUsedAt  string `json:"last_used_at"`
+	ExpiresAt   string `json:"expires_at"`
+	Current     bool   `json:"current"`
+}
+type ListSessionsResponse struct {
+	Sessions []SessionSummary `json:"sessions"`
+}
+
+// email verification
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// password reset
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// password policy
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+type PasswordPolicyErrorResponse struct {
+	Error      string              `json:"error"`
+	Violations []PasswordViolation `json:"violations"`
+}
+
+// personal access tokens
+type CreatePersonalAccessTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+type PersonalAccessTokenSummary struct {
+	ID         uint     `json:"id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	LastUsedIP string   `json:"last_used_ip,omitempty"`
+}
+type CreatePersonalAccessTokenResponse struct {
+	PersonalAccessTokenSummary
+	Token string `json:"token"`
+}
+type ListPersonalAccessTokensResponse struct {
+	Tokens []PersonalAccessTokenSummary `json:"tokens"`
+}
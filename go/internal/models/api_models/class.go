@@ -4,29 +4,118 @@ package api_models
 type CreateClassRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	// joining with a code creates a request an admin must approve
+	RequireApproval bool `json:"require_approval"`
 }
 
 // read class
 type ReadClassResponse struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	CreatedAt   string `json:"created_at"`
-	CreatedBy   string `json:"created_by"`
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	RequireApproval bool   `json:"require_approval"`
+	CreatedAt       string `json:"created_at"`
+	CreatedBy       string `json:"created_by"`
 }
 
 // update class
 type UpdateClassRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	// the current setting is kept when left out
+	RequireApproval *bool `json:"require_approval,omitempty"`
 }
 
 // generate join code
+type GenerateJoinCodeRequest struct {
+	Name string `json:"name"`
+	// "user" for students or "admin" for co-teachers; "user" when empty
+	Role string `json:"role"`
+	// hours until the code expires; 24 when zero
+	ExpiresInHours int  `json:"expires_in_hours"`
+	NeverExpires   bool `json:"never_expires"`
+	// how many members can join with the code; no limit when zero
+	MaxUses int `json:"max_uses"`
+}
+type JoinCodeSummary struct {
+	ID   uint   `json:"id"`
+	Code string `json:"code"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+	// empty when the code never expires
+	ExpirationDT  string `json:"expiration_dt,omitempty"`
+	MaxUses       int    `json:"max_uses"`
+	RemainingUses int    `json:"remaining_uses"`
+	CreatedAt     string `json:"created_at"`
+}
 type GenerateJoinCodeResponse struct {
-	Code         string `json:"code"`
-	ExpirationDT string `json:"expiration_dt"`
+	JoinCodeSummary
+}
+
+// list join codes
+type ListJoinCodesResponse struct {
+	JoinCodes []JoinCodeSummary `json:"join_codes"`
 }
 
 // join class
 type JoinClassRequest struct {
 	JoinCode string `json:"join_code"`
 }
+type JoinClassResponse struct {
+	// the join request waiting for approval
+	RequestID uint   `json:"request_id"`
+	Status    string `json:"status"`
+}
+
+// join requests
+type JoinRequestSummary struct {
+	ID      uint `json:"id"`
+	ClassID uint `json:"class_id"`
+	// set when a user lists their own requests
+	ClassName string `json:"class_name,omitempty"`
+	UserID    uint   `json:"user_id"`
+	// set when an admin lists the requests of a class
+	Username  string `json:"username,omitempty"`
+	Role      string `json:"role"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"created_at"`
+	DecidedAt string `json:"decided_at,omitempty"`
+}
+type ListJoinRequestsResponse struct {
+	JoinRequests []JoinRequestSummary `json:"join_requests"`
+}
+
+// list classes
+type ClassSummary struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Role        string `json:"role"`
+	MemberCount int64  `json:"member_count"`
+	CreatedAt   string `json:"created_at"`
+	CreatedBy   string `json:"created_by"`
+}
+type ListClassesResponse struct {
+	Classes    []ClassSummary `json:"classes"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// list members
+type ClassMemberSummary struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	JoinedAt string `json:"joined_at"`
+}
+type ListMembersResponse struct {
+	Members []ClassMemberSummary `json:"members"`
+}
+
+// update member role
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// transfer ownership
+type TransferOwnershipRequest struct {
+	UserID uint `json:"user_id"`
+}
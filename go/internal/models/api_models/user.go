@@ -2,8 +2,20 @@ package api_models
 
 // read user
 type ReadUserResponse struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
+	Username         string `json:"username"`
+	Email            string `json:"email"`
+	EmailVerified    bool   `json:"email_verified"`
+	PendingEmail     string `json:"pending_email,omitempty"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled"`
+	Role             string `json:"role"`
+	// set while an admin is impersonating the user
+	ImpersonatedBy *ImpersonatorSummary `json:"impersonated_by,omitempty"`
+}
+
+type ImpersonatorSummary struct {
+	ID        uint   `json:"id"`
+	Username  string `json:"username"`
+	ExpiresAt string `json:"expires_at"`
 }
 
 // update user
@@ -0,0 +1,39 @@
+package db_models
+
+import (
+	"gorm.io/gorm"
+)
+
+// admin action types
+const (
+	AdminActionDisableUser        = "disable_user"
+	AdminActionEnableUser         = "enable_user"
+	AdminActionForcePasswordReset = "force_password_reset"
+	AdminActionDeleteClass        = "delete_class"
+	AdminActionImpersonateUser    = "impersonate_user"
+	AdminActionEndImpersonation   = "end_impersonation"
+	// a request made while impersonating a user
+	AdminActionImpersonatedRequest = "impersonated_request"
+)
+
+// admin action target types
+const (
+	AdminTargetUser  = "user"
+	AdminTargetClass = "class"
+)
+
+// something a platform admin did, kept so admins can be held to account
+type AdminAction struct {
+	gorm.Model
+	AdminID    uint   `gorm:"index;not null"`
+	Admin      User   `gorm:"foreignKey:AdminID;references:ID"`
+	Action     string `gorm:"not null"`
+	TargetType string `gorm:"not null"`
+	TargetID   uint   `gorm:"not null"`
+	IPAddress  string `gorm:"not null;default:''"`
+	Details    string `gorm:"not null;default:''"`
+}
+
+func (AdminAction) TableName() string {
+	return "AdminAction"
+}
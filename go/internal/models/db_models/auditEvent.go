@@ -0,0 +1,78 @@
+package db_models
+
+import (
+	"gorm.io/gorm"
+)
+
+// audit event actions
+const (
+	AuditActionSignUp                    = "user.sign_up"
+	AuditActionLinkIdentity              = "user.link_identity"
+	AuditActionUpdateUser                = "user.update"
+	AuditActionDeleteUser                = "user.delete"
+	AuditActionVerifyEmail               = "user.verify_email"
+	AuditActionChangePassword            = "user.change_password"
+	AuditActionResetPassword             = "user.reset_password"
+	AuditActionEnableTwoFactor           = "user.enable_two_factor"
+	AuditActionDisableTwoFactor          = "user.disable_two_factor"
+	AuditActionRevokeSession             = "user.revoke_session"
+	AuditActionRevokeAllSessions         = "user.revoke_all_sessions"
+	AuditActionCreatePersonalAccessToken = "user.create_token"
+	AuditActionRevokePersonalAccessToken = "user.revoke_token"
+	AuditActionCreateClass               = "class.create"
+	AuditActionUpdateClass               = "class.update"
+	AuditActionDeleteClass               = "class.delete"
+	AuditActionGenerateJoinCode          = "class.generate_join_code"
+	AuditActionRevokeJoinCode            = "class.revoke_join_code"
+	AuditActionJoinClass                 = "class.join"
+	AuditActionRequestToJoin             = "class.request_to_join"
+	AuditActionApproveJoinRequest        = "class.approve_join_request"
+	AuditActionRejectJoinRequest         = "class.reject_join_request"
+	AuditActionCancelJoinRequest         = "class.cancel_join_request"
+	AuditActionLeaveClass                = "class.leave"
+	AuditActionRemoveMember              = "class.remove_member"
+	AuditActionUpdateMemberRole          = "class.update_member_role"
+	AuditActionTransferOwnership         = "class.transfer_ownership"
+	AuditActionAdminDisableUser          = "admin.disable_user"
+	AuditActionAdminEnableUser           = "admin.enable_user"
+	AuditActionAdminForcePasswordReset   = "admin.force_password_reset"
+	AuditActionAdminImpersonateUser      = "admin.impersonate_user"
+	AuditActionAdminEndImpersonation     = "admin.end_impersonation"
+	AuditActionAdminDeleteClass          = "admin.delete_class"
+	AuditActionPromotePlatformAdmin      = "admin.promote_platform_admin"
+)
+
+// audit event target types
+const (
+	AuditTargetUser  = "user"
+	AuditTargetClass = "class"
+	// identified by the member's user ID
+	AuditTargetClassMember         = "class_member"
+	AuditTargetJoinCode            = "join_code"
+	AuditTargetJoinRequest         = "join_request"
+	AuditTargetSession             = "session"
+	AuditTargetPersonalAccessToken = "personal_access_token"
+)
+
+// an entry in the append-only audit log; users and classes can be deleted, so the IDs aren't foreign keys
+type AuditEvent struct {
+	gorm.Model
+	// the user the action was taken as; zero for changes the server makes itself
+	ActorID uint `gorm:"index;not null"`
+	// the platform admin impersonating the actor; zero otherwise
+	ImpersonatorID uint   `gorm:"not null;default:0"`
+	Action         string `gorm:"not null"`
+	TargetType     string `gorm:"not null"`
+	TargetID       uint   `gorm:"not null"`
+	// the class the event happened in, so its admins can see it; zero outside a class
+	ClassID uint `gorm:"index;not null;default:0"`
+	// JSON objects holding the fields the action changed, before and after
+	Before    string `gorm:"not null;default:''"`
+	After     string `gorm:"not null;default:''"`
+	IPAddress string `gorm:"not null;default:''"`
+	RequestID string `gorm:"not null;default:''"`
+}
+
+func (AuditEvent) TableName() string {
+	return "AuditEvent"
+}
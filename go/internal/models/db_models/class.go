@@ -10,6 +10,8 @@ type Class struct {
 	Description string
 	CreatorID   uint
 	CreatedBy   User `gorm:"foreignKey:CreatorID"`
+	// whether joining with a code needs an admin's approval
+	RequireApproval bool `gorm:"not null;default:false"`
 }
 
 func (Class) TableName() string {
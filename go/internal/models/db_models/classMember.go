@@ -6,9 +6,10 @@ import (
 
 type ClassMember struct {
 	gorm.Model
-	ClassID uint   `gorm:"not null;constraint:OnDelete:CASCADE;"`
+	// a user is a member of a class at most once
+	ClassID uint   `gorm:"not null;uniqueIndex:idx_ClassMember_live,where:deleted_at IS NULL;constraint:OnDelete:CASCADE;"`
 	Class   Class  `gorm:"foreignKey:ClassID"`
-	UserID  uint   `gorm:"not null;constraint:OnDelete:CASCADE;"`
+	UserID  uint   `gorm:"not null;uniqueIndex:idx_ClassMember_live,where:deleted_at IS NULL;constraint:OnDelete:CASCADE;"`
 	User    User   `gorm:"foreignKey:UserID"`
 	Role    string `gorm:"not null"` // e.g., "student", "teacher"
 }
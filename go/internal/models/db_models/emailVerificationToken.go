@@ -0,0 +1,24 @@
+package db_models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type EmailVerificationToken struct {
+	gorm.Model
+	Selector    string `gorm:"uniqueIndex;not null"`
+	HashedToken string `gorm:"not null"`
+	UserID      uint   `gorm:"index;not null"`
+	User        User   `gorm:"foreignKey:UserID;references:ID"`
+	// the address the token was sent to
+	Email     string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	// set once the token has been used
+	UsedAt *time.Time
+}
+
+func (EmailVerificationToken) TableName() string {
+	return "EmailVerificationToken"
+}
@@ -0,0 +1,21 @@
+package db_models
+
+import (
+	"gorm.io/gorm"
+)
+
+// an account at an external OpenID Connect provider that signs a user in
+type Identity struct {
+	gorm.Model
+	UserID uint `gorm:"index;not null"`
+	User   User `gorm:"foreignKey:UserID;references:ID"`
+	// the provider name from the config and the provider's stable ID for the account
+	Provider string `gorm:"uniqueIndex:idx_Identity_provider_subject;not null"`
+	Subject  string `gorm:"uniqueIndex:idx_Identity_provider_subject;not null"`
+	// email the provider reported when the identity was linked
+	Email string `gorm:"not null;default:''"`
+}
+
+func (Identity) TableName() string {
+	return "Identity"
+}
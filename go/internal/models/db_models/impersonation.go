@@ -0,0 +1,22 @@
+package db_models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// a stretch of time an admin acts as a user, kept so it can be ended before its token expires
+type Impersonation struct {
+	gorm.Model
+	AdminID   uint      `gorm:"index;not null"`
+	Admin     User      `gorm:"foreignKey:AdminID;references:ID"`
+	UserID    uint      `gorm:"index;not null"`
+	User      User      `gorm:"foreignKey:UserID;references:ID"`
+	ExpiresAt time.Time `gorm:"not null"`
+	EndedAt   *time.Time
+}
+
+func (Impersonation) TableName() string {
+	return "Impersonation"
+}
@@ -8,10 +8,19 @@ import (
 
 type JoinCode struct {
 	gorm.Model
-	Code         string `gorm:"not null"`
-	ClassID      uint
-	Class        Class     `gorm:"foreignKey:ClassID"`
-	ExpirationDT time.Time `gorm:"not null"`
+	Code    string `gorm:"not null"`
+	ClassID uint   `gorm:"index"`
+	Class   Class  `gorm:"foreignKey:ClassID"`
+	// label shown to class admins, e.g. "Spring semester"
+	Name string `gorm:"not null;default:''"`
+	// role given to members who join with the code: "user" for students, "admin" for co-teachers
+	Role string `gorm:"not null;default:'user'"`
+	// nil when the code never expires
+	ExpirationDT *time.Time
+	// how many members can join with the code; zero for no limit
+	MaxUses int `gorm:"not null;default:0"`
+	// uses left when MaxUses is set, counted down as members join
+	RemainingUses int `gorm:"not null;default:0"`
 }
 
 func (JoinCode) TableName() string {
@@ -0,0 +1,36 @@
+package db_models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// join request statuses
+const (
+	JoinRequestPending   = "pending"
+	JoinRequestApproved  = "approved"
+	JoinRequestRejected  = "rejected"
+	JoinRequestCancelled = "cancelled"
+)
+
+// a request to join a class that requires approval, waiting for one of its admins
+type JoinRequest struct {
+	gorm.Model
+	// a user has at most one pending request per class
+	ClassID uint  `gorm:"index;uniqueIndex:idx_JoinRequest_pending,where:status = 'pending';not null"`
+	Class   Class `gorm:"foreignKey:ClassID;references:ID"`
+	UserID  uint  `gorm:"index;uniqueIndex:idx_JoinRequest_pending,where:status = 'pending';not null"`
+	User    User  `gorm:"foreignKey:UserID;references:ID"`
+	// the join code used and the role it grants once approved
+	JoinCodeID uint   `gorm:"not null"`
+	Role       string `gorm:"not null"`
+	Status     string `gorm:"not null;default:'pending'"`
+	// the admin who approved or rejected the request
+	DecidedByID *uint
+	DecidedAt   *time.Time
+}
+
+func (JoinRequest) TableName() string {
+	return "JoinRequest"
+}
@@ -0,0 +1,22 @@
+package db_models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PasswordResetToken struct {
+	gorm.Model
+	Selector    string    `gorm:"uniqueIndex;not null"`
+	HashedToken string    `gorm:"not null"`
+	UserID      uint      `gorm:"index;not null"`
+	User        User      `gorm:"foreignKey:UserID;references:ID"`
+	ExpiresAt   time.Time `gorm:"not null"`
+	// set once the token has been used to reset the password
+	UsedAt *time.Time
+}
+
+func (PasswordResetToken) TableName() string {
+	return "PasswordResetToken"
+}
@@ -0,0 +1,26 @@
+package db_models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// a long lived token a user mints for scripts; only a hash of its verifier is stored
+type PersonalAccessToken struct {
+	gorm.Model
+	UserID      uint   `gorm:"index;not null"`
+	User        User   `gorm:"foreignKey:UserID;references:ID"`
+	Name        string `gorm:"not null"`
+	Selector    string `gorm:"uniqueIndex;not null"`
+	HashedToken string `gorm:"not null"`
+	// space separated scopes the token grants
+	Scopes     string    `gorm:"not null"`
+	ExpiresAt  time.Time `gorm:"not null"`
+	LastUsedAt *time.Time
+	LastUsedIP string `gorm:"not null;default:''"`
+}
+
+func (PersonalAccessToken) TableName() string {
+	return "PersonalAccessToken"
+}
@@ -0,0 +1,20 @@
+package db_models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// a one-time code that stands in for a TOTP code when the authenticator is lost
+type RecoveryCode struct {
+	gorm.Model
+	UserID     uint   `gorm:"index;not null"`
+	User       User   `gorm:"foreignKey:UserID;references:ID"`
+	HashedCode string `gorm:"not null"`
+	UsedAt     *time.Time
+}
+
+func (RecoveryCode) TableName() string {
+	return "RecoveryCode"
+}
@@ -8,10 +8,21 @@ import (
 
 type RefreshToken struct {
 	gorm.Model
-	HashedToken 	 string `gorm:"unique;not null"`
-	UserID   uint   `gorm:"not null"`
-	User	 User   `gorm:"foreignKey:UserID;references:ID"`
-	ExpiresAt time.Time `gorm:"not null"`
+	Selector    string    `gorm:"uniqueIndex;not null"`
+	HashedToken string    `gorm:"not null"`
+	UserID      uint      `gorm:"not null"`
+	User        User      `gorm:"foreignKey:UserID;references:ID"`
+	ExpiresAt   time.Time `gorm:"not null"`
+	UserAgent   string    `gorm:"not null;default:''"`
+	IPAddress   string    `gorm:"not null;default:''"`
+	DeviceLabel string    `gorm:"not null;default:''"`
+	LastUsedAt  time.Time
+	// every token rotated from the same sign in shares the ID of the first one
+	FamilyID uint `gorm:"index;not null;default:0"`
+	// the token this one was rotated from
+	ParentID *uint
+	// set once the token has been exchanged for a new one
+	RotatedAt *time.Time
 }
 
 func (RefreshToken) TableName() string {
@@ -0,0 +1,25 @@
+package db_models
+
+import (
+	"gorm.io/gorm"
+)
+
+// security event types
+const (
+	SecurityEventRefreshTokenReuse = "refresh_token_reuse"
+	SecurityEventAccountLocked     = "account_locked"
+)
+
+type SecurityEvent struct {
+	gorm.Model
+	UserID    uint   `gorm:"index;not null"`
+	User      User   `gorm:"foreignKey:UserID;references:ID"`
+	Type      string `gorm:"not null"`
+	IPAddress string `gorm:"not null;default:''"`
+	UserAgent string `gorm:"not null;default:''"`
+	Details   string `gorm:"not null;default:''"`
+}
+
+func (SecurityEvent) TableName() string {
+	return "SecurityEvent"
+}
@@ -0,0 +1,22 @@
+package db_models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// recent failed sign ins for an account or an IP address
+type SignInThrottle struct {
+	gorm.Model
+	// "account:<user id>" or "ip:<address>"
+	Key           string `gorm:"uniqueIndex;not null"`
+	Failures      int    `gorm:"not null;default:0"`
+	LastFailureAt time.Time
+	// sign in is refused until then
+	LockedUntil time.Time
+}
+
+func (SignInThrottle) TableName() string {
+	return "SignInThrottle"
+}
@@ -1,14 +1,36 @@
 package db_models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// platform roles; admins can use the /admin API
+const (
+	PlatformRoleUser  = "user"
+	PlatformRoleAdmin = "admin"
+)
+
 type User struct {
 	gorm.Model
 	Username       string `gorm:"unique;not null"`
 	HashedPassword string `gorm:"not null"`
 	Email          string `gorm:"unique;not null"`
+	EmailVerified  bool   `gorm:"not null;default:false"`
+	// new email waiting to be confirmed; Email stays in use until then
+	PendingEmail string `gorm:"not null;default:''"`
+	// base32 TOTP secret; set during enrollment and only enforced once TOTPEnabled
+	TOTPSecret  string `gorm:"not null;default:''"`
+	TOTPEnabled bool   `gorm:"not null;default:false"`
+	// the last time step a code was accepted for, so a code can't be used twice
+	TOTPLastStep int64 `gorm:"not null;default:0"`
+	// PlatformRoleUser or PlatformRoleAdmin
+	Role string `gorm:"not null;default:'user'"`
+	// set while an admin has disabled the account
+	DisabledAt *time.Time
+	// set by an admin; sign in is refused until the password is reset by email
+	PasswordResetRequired bool `gorm:"not null;default:false"`
 }
 
 func (User) TableName() string {
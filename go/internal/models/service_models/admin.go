@@ -0,0 +1,104 @@
+package service_models
+
+import "time"
+
+type AdminListUsersRequest struct {
+	Query  string
+	Cursor string
+	Limit  int
+}
+
+type AdminUserSummary struct {
+	UserID                uint
+	Username              string
+	Email                 string
+	EmailVerified         bool
+	Role                  string
+	TwoFactorEnabled      bool
+	Disabled              bool
+	PasswordResetRequired bool
+	CreatedAt             time.Time
+}
+
+type AdminListUsersResponse struct {
+	Users      []AdminUserSummary
+	NextCursor string
+}
+
+// an admin acting on a user account
+type AdminUserActionRequest struct {
+	AdminID uint
+	UserID  uint
+	Reason  string
+	Audit   AuditContext
+}
+
+type AdminListClassesRequest struct {
+	Query  string
+	Cursor string
+	Limit  int
+}
+
+type AdminListClassesResponse struct {
+	Classes    []ClassSummary
+	NextCursor string
+}
+
+type AdminDeleteClassRequest struct {
+	AdminID uint
+	ClassID uint
+	Reason  string
+	Audit   AuditContext
+}
+
+type AdminListActionsRequest struct {
+	Cursor string
+	Limit  int
+}
+
+type AdminActionSummary struct {
+	ID         uint
+	AdminID    uint
+	Admin      string
+	Action     string
+	TargetType string
+	TargetID   uint
+	IPAddress  string
+	Details    string
+	CreatedAt  time.Time
+}
+
+type AdminListActionsResponse struct {
+	Actions    []AdminActionSummary
+	NextCursor string
+}
+
+type AdminImpersonateRequest struct {
+	AdminID uint
+	UserID  uint
+	Reason  string
+	Audit   AuditContext
+}
+
+type AdminImpersonateResponse struct {
+	ImpersonationID uint
+	AccessToken     string
+	ExpiresAt       time.Time
+}
+
+type AdminEndImpersonationRequest struct {
+	AdminID         uint
+	ImpersonationID uint
+	Reason          string
+	Audit           AuditContext
+}
+
+// a request an admin made while impersonating a user
+type RecordImpersonatedRequestRequest struct {
+	AdminID   uint
+	UserID    uint
+	Method    string
+	Path      string
+	Status    int
+	IPAddress string
+}
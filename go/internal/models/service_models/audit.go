@@ -0,0 +1,70 @@
+package service_models
+
+import "time"
+
+// who made a request and where from, recorded with the audit events it causes
+type AuditContext struct {
+	// the platform admin impersonating the user making the request; zero otherwise
+	ImpersonatorID uint
+	IPAddress      string
+	RequestID      string
+}
+
+type AuditEventSummary struct {
+	ID             uint
+	ActorID        uint
+	ImpersonatorID uint
+	Action         string
+	TargetType     string
+	TargetID       uint
+	ClassID        uint
+	// JSON objects holding the changed fields; empty when nothing was recorded
+	Before    string
+	After     string
+	IPAddress string
+	RequestID string
+	CreatedAt time.Time
+}
+
+type ListAuditEventsResponse struct {
+	Events     []AuditEventSummary
+	NextCursor string
+}
+
+// an audit event as class admins see it, without where the request came from or who was impersonating
+type ClassAuditEventSummary struct {
+	ID         uint
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   uint
+	// JSON objects holding the changed fields; empty when nothing was recorded
+	Before    string
+	After     string
+	RequestID string
+	CreatedAt time.Time
+}
+
+type ListClassAuditEventsResponse struct {
+	Events     []ClassAuditEventSummary
+	NextCursor string
+}
+
+type AdminListAuditEventsRequest struct {
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   uint
+	ClassID    uint
+	Cursor     string
+	Limit      int
+}
+
+type ListClassAuditEventsRequest struct {
+	ClassID uint
+	UserID  uint
+	ActorID uint
+	Action  string
+	Cursor  string
+	Limit   int
+}
@@ -8,33 +8,205 @@ type SignUpRequest struct {
 	Username string
 	Password string
 	Email    string
+	Audit    AuditContext
 }
 
 type SignInRequest struct {
-	Username string
-	Email    string
-	Password string
+	Username  string
+	Email     string
+	Password  string
+	UserAgent string
+	IPAddress string
 }
 
 type SignInResponse struct {
-	AccessToken string
-	RefreshToken string
+	AccessToken            string
+	RefreshToken           string
 	RefreshTokenExpiration time.Time
+	// set instead of the tokens when a second factor is needed
+	MFARequired bool
+	MFAToken    string
+}
+
+type VerifyMFARequest struct {
+	MFAToken  string
+	Code      string
+	UserAgent string
+	IPAddress string
+}
+
+type SetupTwoFactorRequest struct {
+	UserID uint
+}
+
+type SetupTwoFactorResponse struct {
+	Secret string
+	URI    string
+}
+
+type ConfirmTwoFactorRequest struct {
+	UserID uint
+	Code   string
+	Audit  AuditContext
+}
+
+type ConfirmTwoFactorResponse struct {
+	RecoveryCodes []string
+}
+
+type DisableTwoFactorRequest struct {
+	UserID uint
+	Code   string
+	Audit  AuditContext
 }
 
 type UpdatePasswordRequest struct {
 	UserID      uint
 	OldPassword string
 	NewPassword string
+	Audit       AuditContext
 }
 
 type RefreshTokenRequest struct {
 	RefreshToken string
-	UserID	  uint
+	UserAgent    string
+	IPAddress    string
 }
 
 type RefreshTokenResponse struct {
-	AccessToken string
-	RefreshToken string
+	AccessToken            string
+	RefreshToken           string
 	RefreshTokenExpiration time.Time
-}
\ No newline at end of file
+}
+
+type LogoutRequest struct {
+	RefreshToken string
+}
+
+type LogoutAllRequest struct {
+	UserID uint
+	Audit  AuditContext
+}
+
+type ListSessionsRequest struct {
+	UserID           uint
+	CurrentSessionID uint
+}
+
+type SessionSummary struct {
+	ID          uint
+	DeviceLabel string
+	UserAgent   string
+	IPAddress   string
+	CreatedAt   time.Time
+	LastUsedAt  time.Time
+	ExpiresAt   time.Time
+	Current     bool
+}
+
+type ListSessionsResponse struct {
+	Sessions []SessionSummary
+}
+
+type RevokeSessionRequest struct {
+	UserID    uint
+	SessionID uint
+	Audit     AuditContext
+}
+
+type ForgotPasswordRequest struct {
+	Email string
+}
+
+type VerifyEmailRequest struct {
+	Token string
+	Audit AuditContext
+}
+
+type ResendVerificationRequest struct {
+	UserID uint
+}
+
+type ResetPasswordRequest struct {
+	Token       string
+	NewPassword string
+	Audit       AuditContext
+}
+
+type StartOIDCLoginRequest struct {
+	Provider string
+}
+
+type StartOIDCLoginResponse struct {
+	AuthURL    string
+	StateToken string
+}
+
+type FinishOIDCLoginRequest struct {
+	Provider   string
+	Code       string
+	State      string
+	StateToken string
+	UserAgent  string
+	IPAddress  string
+	Audit      AuditContext
+}
+
+type CreatePersonalAccessTokenRequest struct {
+	UserID        uint
+	Name          string
+	Scopes        []string
+	ExpiresInDays int
+	Audit         AuditContext
+}
+
+type PersonalAccessTokenSummary struct {
+	ID         uint
+	Name       string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
+	LastUsedIP string
+}
+
+type CreatePersonalAccessTokenResponse struct {
+	PersonalAccessTokenSummary
+	// the token itself, only ever returned here
+	Token string
+}
+
+type ListPersonalAccessTokensRequest struct {
+	UserID uint
+}
+
+type ListPersonalAccessTokensResponse struct {
+	Tokens []PersonalAccessTokenSummary
+}
+
+type RevokePersonalAccessTokenRequest struct {
+	UserID  uint
+	TokenID uint
+	Audit   AuditContext
+}
+
+type AuthenticateAccessTokenRequest struct {
+	UserID uint
+	// the session the token was issued for; zero for impersonation tokens
+	SessionID uint
+	// set for impersonation tokens
+	ActorID         uint
+	ImpersonationID uint
+}
+
+type AuthenticatePersonalAccessTokenRequest struct {
+	Token     string
+	IPAddress string
+}
+
+type AuthenticatePersonalAccessTokenResponse struct {
+	UserID   uint
+	Username string
+	Scopes   []string
+	TokenID  uint
+}
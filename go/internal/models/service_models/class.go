@@ -5,12 +5,16 @@ import "time"
 type CreateClassRequest struct {
 	Name        string
 	Description string
-	UserID      uint
+	// joining with a code creates a request an admin must approve
+	RequireApproval bool
+	UserID          uint
+	Audit           AuditContext
 }
 
 type DeleteClassRequest struct {
 	ClassID uint
 	UserID  uint
+	Audit   AuditContext
 }
 
 type ReadClassRequest struct {
@@ -18,30 +22,185 @@ type ReadClassRequest struct {
 	UserID  uint
 }
 type ReadClassResponse struct {
-	Name        string
-	Description string
-	CreatedAt   string
-	CreatedBy   string
+	Name            string
+	Description     string
+	RequireApproval bool
+	CreatedAt       string
+	CreatedBy       string
 }
 
 type UpdateClassRequest struct {
 	Name        string
 	Description string
-	UserID      uint
-	ClassID     uint
+	// nil keeps the current setting
+	RequireApproval *bool
+	UserID          uint
+	ClassID         uint
+	Audit           AuditContext
 }
 
 type GenerateJoinCodeRequest struct {
 	ClassID uint
 	UserID  uint
+	Name    string
+	// role given to members who join with the code; "user" when empty
+	Role string
+	// hours until the code expires; the default when zero
+	ExpiresInHours int
+	NeverExpires   bool
+	// zero for no limit
+	MaxUses int
+	Audit   AuditContext
+}
+
+type JoinCodeSummary struct {
+	ID            uint
+	Code          string
+	Name          string
+	Role          string
+	ExpirationDT  *time.Time
+	MaxUses       int
+	RemainingUses int
+	CreatedAt     time.Time
 }
 
 type GenerateJoinCodeResponse struct {
-	Code         string
-	ExpirationDT time.Time
+	JoinCodeSummary
+}
+
+type ListJoinCodesRequest struct {
+	ClassID uint
+	UserID  uint
+}
+
+type ListJoinCodesResponse struct {
+	JoinCodes []JoinCodeSummary
+}
+
+type RevokeJoinCodeRequest struct {
+	ClassID    uint
+	UserID     uint
+	JoinCodeID uint
+	Audit      AuditContext
 }
 
 type JoinClassRequest struct {
 	JoinCode string
 	UserID   uint
+	Audit    AuditContext
+}
+
+type JoinClassResponse struct {
+	// set when the class requires approval, so the user isn't a member yet
+	Pending   bool
+	RequestID uint
+}
+
+type JoinRequestSummary struct {
+	ID        uint
+	ClassID   uint
+	ClassName string
+	UserID    uint
+	Username  string
+	Role      string
+	Status    string
+	CreatedAt time.Time
+	DecidedAt *time.Time
+}
+
+type ListJoinRequestsRequest struct {
+	ClassID uint
+	UserID  uint
+	// "pending" when empty
+	Status string
+}
+
+type ListJoinRequestsResponse struct {
+	JoinRequests []JoinRequestSummary
+}
+
+// a class admin approving or rejecting a join request
+type DecideJoinRequestRequest struct {
+	ClassID   uint
+	UserID    uint
+	RequestID uint
+	Audit     AuditContext
+}
+
+type ListMyJoinRequestsRequest struct {
+	UserID uint
+}
+
+type CancelJoinRequestRequest struct {
+	UserID    uint
+	RequestID uint
+	Audit     AuditContext
+}
+
+type ListClassesRequest struct {
+	UserID uint
+	Role   string
+	SortBy string
+	Order  string
+	Cursor string
+	Limit  int
+}
+
+type ClassSummary struct {
+	ClassID     uint
+	Name        string
+	Description string
+	Role        string
+	MemberCount int64
+	CreatedAt   time.Time
+	CreatedBy   string
+}
+
+type ListClassesResponse struct {
+	Classes    []ClassSummary
+	NextCursor string
+}
+
+type ListMembersRequest struct {
+	ClassID uint
+	UserID  uint
+}
+
+type ClassMemberSummary struct {
+	UserID   uint
+	Username string
+	Role     string
+	JoinedAt time.Time
+}
+
+type ListMembersResponse struct {
+	Members []ClassMemberSummary
+}
+
+type RemoveMemberRequest struct {
+	ClassID  uint
+	UserID   uint
+	MemberID uint
+	Audit    AuditContext
+}
+
+type UpdateMemberRoleRequest struct {
+	ClassID  uint
+	UserID   uint
+	MemberID uint
+	Role     string
+	Audit    AuditContext
+}
+
+type LeaveClassRequest struct {
+	ClassID uint
+	UserID  uint
+	Audit   AuditContext
+}
+
+type TransferOwnershipRequest struct {
+	ClassID    uint
+	UserID     uint
+	NewOwnerID uint
+	Audit      AuditContext
 }
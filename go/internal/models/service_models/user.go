@@ -4,16 +4,22 @@ type ReadUserRequest struct {
 	UserID uint
 }
 type ReadUserResponse struct {
-	Username string
-	Email    string
+	Username         string
+	Email            string
+	EmailVerified    bool
+	PendingEmail     string
+	TwoFactorEnabled bool
+	Role             string
 }
 
 type DeleteUserRequest struct {
 	UserID uint
+	Audit  AuditContext
 }
 
 type UpdateUserRequest struct {
 	UserID   uint
 	Username string
 	Email    string
+	Audit    AuditContext
 }
@@ -0,0 +1,30 @@
+package oidc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hawkerd/privateinstruction/internal/config"
+)
+
+// build the providers listed in the config, keyed by name
+func ProvidersFromConfig() (map[string]*Provider, error) {
+	providers := map[string]*Provider{}
+	for _, name := range config.GetOIDCProviderNames() {
+		provider := &Provider{
+			Name:         name,
+			Issuer:       config.GetOIDCProviderSetting(name, "ISSUER"),
+			ClientID:     config.GetOIDCProviderSetting(name, "CLIENT_ID"),
+			ClientSecret: config.GetOIDCProviderSetting(name, "CLIENT_SECRET"),
+			RedirectURL:  strings.TrimSuffix(config.GetAPIURL(), "/") + "/auth/oidc/" + name + "/callback",
+		}
+		if scopes := config.GetOIDCProviderSetting(name, "SCOPES"); scopes != "" {
+			provider.Scopes = strings.Fields(scopes)
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			return nil, fmt.Errorf("OIDC provider %q needs an issuer and a client id", name)
+		}
+		providers[name] = provider
+	}
+	return providers, nil
+}
@@ -0,0 +1,85 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hawkerd/privateinstruction/internal/oidc"
+	"github.com/hawkerd/privateinstruction/internal/oidc/oidctest"
+)
+
+func TestAuthCodeURLCarriesPKCEChallenge(t *testing.T) {
+	server := oidctest.NewServer()
+	defer server.Close()
+	provider := server.Provider("test", "http://localhost/callback")
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("AuthCodeURL returned %v", err)
+	}
+	if !strings.HasPrefix(authURL, server.URL+"/authorize?") || !strings.Contains(authURL, "code_challenge="+oidc.CodeChallenge("verifier")) {
+		t.Fatalf("unexpected auth url %q", authURL)
+	}
+}
+
+func TestExchangeVerifiesIDToken(t *testing.T) {
+	server := oidctest.NewServer()
+	defer server.Close()
+	provider := server.Provider("test", "http://localhost/callback")
+	user := oidctest.User{Subject: "123", Email: "alice@example.com", EmailVerified: true}
+
+	authURL, _ := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	code, state, err := server.Authorize(authURL, user)
+	if err != nil || state != "state" {
+		t.Fatalf("Authorize returned %q %v", state, err)
+	}
+
+	claims, err := provider.Exchange(context.Background(), code, "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Exchange returned %v", err)
+	}
+	if claims.Subject != "123" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	// codes can't be exchanged twice
+	if _, err := provider.Exchange(context.Background(), code, "verifier", "nonce"); !errors.Is(err, oidc.ErrExchange) {
+		t.Fatalf("expected ErrExchange, got %v", err)
+	}
+}
+
+func TestExchangeRejectsWrongVerifierAndNonce(t *testing.T) {
+	server := oidctest.NewServer()
+	defer server.Close()
+	provider := server.Provider("test", "http://localhost/callback")
+	user := oidctest.User{Subject: "123", Email: "alice@example.com", EmailVerified: true}
+
+	authURL, _ := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	code, _, _ := server.Authorize(authURL, user)
+	if _, err := provider.Exchange(context.Background(), code, "other-verifier", "nonce"); !errors.Is(err, oidc.ErrExchange) {
+		t.Fatalf("expected ErrExchange for a wrong code verifier, got %v", err)
+	}
+
+	code, _, _ = server.Authorize(authURL, user)
+	if _, err := provider.Exchange(context.Background(), code, "verifier", "other-nonce"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Fatalf("expected ErrInvalidIDToken for a wrong nonce, got %v", err)
+	}
+}
+
+func TestExchangeRejectsTokensForAnotherClient(t *testing.T) {
+	server := oidctest.NewServer()
+	defer server.Close()
+	provider := server.Provider("test", "http://localhost/callback")
+	user := oidctest.User{Subject: "123", Email: "alice@example.com", EmailVerified: true}
+
+	authURL, _ := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	code, _, _ := server.Authorize(authURL, user)
+
+	// a token issued to another client must not sign anyone in here
+	server.Audience = "someone-else"
+	if _, err := provider.Exchange(context.Background(), code, "verifier", "nonce"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Fatalf("expected ErrInvalidIDToken, got %v", err)
+	}
+}
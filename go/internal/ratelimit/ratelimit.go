@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// how many requests a key may make; the bucket holds Limit tokens and refills completely over Window
+type Policy struct {
+	// keeps the buckets of different policies apart
+	Name   string
+	Limit  int
+	Window time.Duration
+	// also limit the client IP and the email address a request names, even for signed in users
+	KeyByEmail bool
+}
+
+// policies for the routes that get their own limits
+var (
+	// every request, keyed by IP address
+	Default = Policy{Name: "default", Limit: 300, Window: time.Minute}
+	// every authenticated request, keyed by user
+	Authenticated = Policy{Name: "authenticated", Limit: 120, Window: time.Minute}
+	// creating accounts
+	SignUp = Policy{Name: "signup", Limit: 5, Window: time.Hour}
+	// signing in, on top of the per-account throttling in the auth service
+	SignIn = Policy{Name: "signin", Limit: 10, Window: time.Minute}
+	// joining classes, so join codes can't be guessed
+	JoinClass = Policy{Name: "join", Limit: 10, Window: 10 * time.Minute}
+	// sending password reset and verification emails, so no one can flood an inbox or send mail in bulk
+	SendEmail = Policy{Name: "email", Limit: 5, Window: time.Hour, KeyByEmail: true}
+)
+
+// the outcome of taking a token
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// time until the bucket is full again
+	Reset time.Duration
+	// time until a token is available, when not allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps the token buckets; a shared backend lets several servers enforce the same limits
+type Store interface {
+	Take(key string, policy Policy, now time.Time) (Result, error)
+}
+
+// a token bucket
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// when the bucket will have refilled, after which it can be forgotten
+	full time.Time
+}
+
+// MemoryStore keeps buckets in memory, so limits apply per server process
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// create and return a new MemoryStore instance
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: map[string]*bucket{},
+	}
+}
+
+// how often full buckets are dropped
+const sweepInterval = time.Minute
+
+func (s *MemoryStore) Take(key string, policy Policy, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limit := float64(policy.Limit)
+	rate := limit / policy.Window.Seconds()
+
+	// refill the bucket for the time since it was last used
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit, last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(limit, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	result := Result{Limit: policy.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = seconds((limit - b.tokens) / rate)
+	b.full = now.Add(result.Reset)
+
+	s.sweep(now)
+	return result, nil
+}
+
+// drop buckets that have refilled, since they behave the same as missing ones
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
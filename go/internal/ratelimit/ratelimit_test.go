@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTakesAndRefills(t *testing.T) {
+	store := NewMemoryStore()
+	policy := Policy{Name: "test", Limit: 2, Window: time.Minute}
+	now := time.Unix(0, 0)
+
+	for i, remaining := range []int{1, 0} {
+		res, _ := store.Take("key", policy, now)
+		if !res.Allowed || res.Remaining != remaining {
+			t.Fatalf("request %d: expected to be allowed with %d remaining, got %+v", i, remaining, res)
+		}
+	}
+
+	// the bucket is empty until a token refills, which takes half the window
+	res, _ := store.Take("key", policy, now)
+	if res.Allowed || res.RetryAfter != 30*time.Second || res.Reset != time.Minute {
+		t.Fatalf("expected to be refused for 30s, got %+v", res)
+	}
+	if res, _ := store.Take("key", policy, now.Add(30*time.Second)); !res.Allowed {
+		t.Fatalf("expected a token after 30s, got %+v", res)
+	}
+
+	// other keys and policies have their own buckets
+	if res, _ := store.Take("other", policy, now); !res.Allowed {
+		t.Fatalf("expected another key to be allowed, got %+v", res)
+	}
+}
+
+func TestMemoryStoreForgetsFullBuckets(t *testing.T) {
+	store := NewMemoryStore()
+	policy := Policy{Name: "test", Limit: 1, Window: time.Hour}
+	now := time.Unix(0, 0)
+
+	store.Take("key", policy, now)
+	store.Take("other", policy, now.Add(2*time.Minute))
+	if _, ok := store.buckets["key"]; !ok {
+		t.Fatal("expected a bucket that hasn't refilled to be kept")
+	}
+
+	store.Take("other", policy, now.Add(2*time.Hour))
+	if _, ok := store.buckets["key"]; ok {
+		t.Fatal("expected a full bucket to be dropped")
+	}
+}
@@ -0,0 +1,63 @@
+package repositories
+
+import (
+	"sort"
+
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+)
+
+// AdminActionRepository stores what platform admins have done
+type AdminActionRepository interface {
+	Create(adminAction *db_models.AdminAction) error
+	// list actions newest first, before the given ID when it isn't zero
+	List(beforeID uint, limit int) ([]db_models.AdminAction, error)
+}
+
+type gormAdminActionRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormAdminActionRepository) Create(adminAction *db_models.AdminAction) error {
+	return r.db.Create(adminAction).Error
+}
+
+func (r *gormAdminActionRepository) List(beforeID uint, limit int) ([]db_models.AdminAction, error) {
+	query := r.db.Preload("Admin").Order("id DESC").Limit(limit)
+	if beforeID != 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+	var adminActions []db_models.AdminAction
+	if err := query.Find(&adminActions).Error; err != nil {
+		return nil, err
+	}
+	return adminActions, nil
+}
+
+type memoryAdminActionRepository struct {
+	s *MemoryStore
+}
+
+func (r *memoryAdminActionRepository) Create(adminAction *db_models.AdminAction) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.adminActions.insert(adminAction)
+	return nil
+}
+
+func (r *memoryAdminActionRepository) List(beforeID uint, limit int) ([]db_models.AdminAction, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	adminActions := r.s.data.adminActions.filter(func(adminAction db_models.AdminAction) bool {
+		return beforeID == 0 || adminAction.ID < beforeID
+	})
+	sort.Slice(adminActions, func(i, j int) bool { return adminActions[i].ID > adminActions[j].ID })
+	if limit > 0 && len(adminActions) > limit {
+		adminActions = adminActions[:limit]
+	}
+	for i := range adminActions {
+		adminActions[i].Admin, _ = r.s.data.users.get(adminActions[i].AdminID)
+	}
+	return adminActions, nil
+}
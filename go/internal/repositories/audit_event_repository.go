@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+)
+
+// AuditEventRepository stores the audit log; events are only ever added, or pruned once past retention
+type AuditEventRepository interface {
+	Create(auditEvent *db_models.AuditEvent) error
+	// list the events matching the query newest first
+	List(query AuditEventQuery) ([]db_models.AuditEvent, error)
+	// permanently delete the events created before the cutoff, returning how many there were
+	DeleteCreatedBefore(cutoff time.Time) (int64, error)
+}
+
+// filters for listing audit events; zero values match everything
+type AuditEventQuery struct {
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   uint
+	ClassID    uint
+	// only events with a lower ID, to fetch the next page
+	BeforeID uint
+	Limit    int
+}
+
+type gormAuditEventRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormAuditEventRepository) Create(auditEvent *db_models.AuditEvent) error {
+	return r.db.Create(auditEvent).Error
+}
+
+func (r *gormAuditEventRepository) List(q AuditEventQuery) ([]db_models.AuditEvent, error) {
+	query := r.db.Order("id DESC").Limit(q.Limit)
+	if q.ActorID != 0 {
+		query = query.Where("actor_id = ?", q.ActorID)
+	}
+	if q.Action != "" {
+		query = query.Where("action = ?", q.Action)
+	}
+	if q.TargetType != "" {
+		query = query.Where("target_type = ?", q.TargetType)
+	}
+	if q.TargetID != 0 {
+		query = query.Where("target_id = ?", q.TargetID)
+	}
+	if q.ClassID != 0 {
+		query = query.Where("class_id = ?", q.ClassID)
+	}
+	if q.BeforeID != 0 {
+		query = query.Where("id < ?", q.BeforeID)
+	}
+	var auditEvents []db_models.AuditEvent
+	if err := query.Find(&auditEvents).Error; err != nil {
+		return nil, err
+	}
+	return auditEvents, nil
+}
+
+func (r *gormAuditEventRepository) DeleteCreatedBefore(cutoff time.Time) (int64, error) {
+	// a soft delete would be an update, which the table refuses
+	result := r.db.Unscoped().Where("created_at < ?", cutoff).Delete(&db_models.AuditEvent{})
+	return result.RowsAffected, result.Error
+}
+
+type memoryAuditEventRepository struct {
+	s *MemoryStore
+}
+
+func (r *memoryAuditEventRepository) Create(auditEvent *db_models.AuditEvent) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.auditEvents.insert(auditEvent)
+	return nil
+}
+
+func (r *memoryAuditEventRepository) List(q AuditEventQuery) ([]db_models.AuditEvent, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	auditEvents := r.s.data.auditEvents.filter(func(auditEvent db_models.AuditEvent) bool {
+		return (q.ActorID == 0 || auditEvent.ActorID == q.ActorID) &&
+			(q.Action == "" || auditEvent.Action == q.Action) &&
+			(q.TargetType == "" || auditEvent.TargetType == q.TargetType) &&
+			(q.TargetID == 0 || auditEvent.TargetID == q.TargetID) &&
+			(q.ClassID == 0 || auditEvent.ClassID == q.ClassID) &&
+			(q.BeforeID == 0 || auditEvent.ID < q.BeforeID)
+	})
+	sort.Slice(auditEvents, func(i, j int) bool { return auditEvents[i].ID > auditEvents[j].ID })
+	if q.Limit > 0 && len(auditEvents) > q.Limit {
+		auditEvents = auditEvents[:q.Limit]
+	}
+	return auditEvents, nil
+}
+
+func (r *memoryAuditEventRepository) DeleteCreatedBefore(cutoff time.Time) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var deleted int64
+	r.s.data.auditEvents.remove(func(auditEvent db_models.AuditEvent) bool {
+		if auditEvent.CreatedAt.Before(cutoff) {
+			deleted++
+			return true
+		}
+		return false
+	})
+	return deleted, nil
+}
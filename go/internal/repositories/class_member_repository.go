@@ -0,0 +1,140 @@
+package repositories
+
+import (
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ClassMemberRepository stores the memberships of users in classes
+type ClassMemberRepository interface {
+	// add a member, returning ErrDuplicate when the user is already in the class
+	Create(classMember *db_models.ClassMember) error
+	Find(classID uint, userID uint) (*db_models.ClassMember, error)
+	// list the members of a class with their users loaded, oldest first
+	ListByClass(classID uint) ([]db_models.ClassMember, error)
+	// list the admins of a class, locking them until the transaction ends
+	ListAdmins(classID uint) ([]db_models.ClassMember, error)
+	Update(classMember *db_models.ClassMember) error
+	Delete(id uint) error
+	DeleteByClass(classID uint) error
+}
+
+type gormClassMemberRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormClassMemberRepository) Create(classMember *db_models.ClassMember) error {
+	// the partial unique index turns a second membership into a no-op rather than an error that aborts the transaction
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(classMember)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+func (r *gormClassMemberRepository) Find(classID uint, userID uint) (*db_models.ClassMember, error) {
+	var classMember db_models.ClassMember
+	if err := r.db.Where("class_id = ? AND user_id = ?", classID, userID).First(&classMember).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &classMember, nil
+}
+
+func (r *gormClassMemberRepository) ListByClass(classID uint) ([]db_models.ClassMember, error) {
+	var classMembers []db_models.ClassMember
+	if err := r.db.Preload("User").Where("class_id = ?", classID).Order("created_at ASC").Find(&classMembers).Error; err != nil {
+		return nil, err
+	}
+	return classMembers, nil
+}
+
+func (r *gormClassMemberRepository) ListAdmins(classID uint) ([]db_models.ClassMember, error) {
+	var classMembers []db_models.ClassMember
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("class_id = ? AND role = ?", classID, "admin").Find(&classMembers).Error; err != nil {
+		return nil, err
+	}
+	return classMembers, nil
+}
+
+func (r *gormClassMemberRepository) Update(classMember *db_models.ClassMember) error {
+	return r.db.Omit(clause.Associations).Save(classMember).Error
+}
+
+func (r *gormClassMemberRepository) Delete(id uint) error {
+	return r.db.Delete(&db_models.ClassMember{}, id).Error
+}
+
+func (r *gormClassMemberRepository) DeleteByClass(classID uint) error {
+	return r.db.Where("class_id = ?", classID).Delete(&db_models.ClassMember{}).Error
+}
+
+type memoryClassMemberRepository struct {
+	s *MemoryStore
+}
+
+func (r *memoryClassMemberRepository) Create(classMember *db_models.ClassMember) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	_, exists := r.s.data.classMembers.first(func(other db_models.ClassMember) bool {
+		return other.ClassID == classMember.ClassID && other.UserID == classMember.UserID
+	})
+	if exists {
+		return ErrDuplicate
+	}
+	r.s.data.classMembers.insert(classMember)
+	return nil
+}
+
+func (r *memoryClassMemberRepository) Find(classID uint, userID uint) (*db_models.ClassMember, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	classMember, ok := r.s.data.classMembers.first(func(classMember db_models.ClassMember) bool {
+		return classMember.ClassID == classID && classMember.UserID == userID
+	})
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &classMember, nil
+}
+
+func (r *memoryClassMemberRepository) ListByClass(classID uint) ([]db_models.ClassMember, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	classMembers := r.s.data.classMembers.filter(func(classMember db_models.ClassMember) bool { return classMember.ClassID == classID })
+	for i := range classMembers {
+		classMembers[i].User, _ = r.s.data.users.get(classMembers[i].UserID)
+	}
+	return classMembers, nil
+}
+
+func (r *memoryClassMemberRepository) ListAdmins(classID uint) ([]db_models.ClassMember, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.data.classMembers.filter(func(classMember db_models.ClassMember) bool {
+		return classMember.ClassID == classID && classMember.Role == "admin"
+	}), nil
+}
+
+func (r *memoryClassMemberRepository) Update(classMember *db_models.ClassMember) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.data.classMembers.update(classMember)
+}
+
+func (r *memoryClassMemberRepository) Delete(id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.classMembers.remove(func(classMember db_models.ClassMember) bool { return classMember.ID == id })
+	return nil
+}
+
+func (r *memoryClassMemberRepository) DeleteByClass(classID uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.classMembers.remove(func(classMember db_models.ClassMember) bool { return classMember.ClassID == classID })
+	return nil
+}
@@ -0,0 +1,265 @@
+package repositories
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ClassRepository stores classes
+type ClassRepository interface {
+	Create(class *db_models.Class) error
+	FindByID(id uint) (*db_models.Class, error)
+	Update(class *db_models.Class) error
+	Delete(id uint) error
+	// list the classes a user is a member of, one page at a time
+	ListForUser(query ClassListQuery) ([]ClassListItem, error)
+	// list every class whose name contains the search text, one page at a time in ID order
+	Search(query ClassSearchQuery) ([]ClassListItem, error)
+}
+
+// which page of a user's classes to list
+type ClassListQuery struct {
+	UserID     uint
+	Role       string
+	SortByName bool
+	Desc       bool
+	Limit      int
+
+	// position of the last row of the previous page, if any
+	AfterID        uint
+	AfterName      string
+	AfterCreatedAt time.Time
+}
+
+// which page of a class search to list
+type ClassSearchQuery struct {
+	// matched case insensitively against the name; every class when empty
+	Text    string
+	AfterID uint
+	Limit   int
+}
+
+// a class along with the membership of the user listing it
+type ClassListItem struct {
+	ID          uint
+	Name        string
+	Description string
+	Role        string
+	MemberCount int64
+	CreatedAt   time.Time
+	CreatedBy   string
+}
+
+type gormClassRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormClassRepository) Create(class *db_models.Class) error {
+	return r.db.Create(class).Error
+}
+
+func (r *gormClassRepository) FindByID(id uint) (*db_models.Class, error) {
+	var class db_models.Class
+	if err := r.db.First(&class, id).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &class, nil
+}
+
+func (r *gormClassRepository) Update(class *db_models.Class) error {
+	return r.db.Omit(clause.Associations).Save(class).Error
+}
+
+func (r *gormClassRepository) Delete(id uint) error {
+	return r.db.Delete(&db_models.Class{}, id).Error
+}
+
+func (r *gormClassRepository) ListForUser(q ClassListQuery) ([]ClassListItem, error) {
+	// resolve the sort column and direction
+	sortColumn := `"Class".created_at`
+	if q.SortByName {
+		sortColumn = `"Class".name`
+	}
+	direction, comparison := "ASC", ">"
+	if q.Desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	// join the memberships of the user to their classes
+	query := r.db.Model(&db_models.ClassMember{}).
+		Select(`"Class".id, "Class".name, "Class".description, "ClassMember".role, "Class".created_at, "User".username AS created_by, `+
+			`(SELECT COUNT(*) FROM "ClassMember" cm WHERE cm.class_id = "Class".id AND cm.deleted_at IS NULL) AS member_count`).
+		Joins(`JOIN "Class" ON "Class".id = "ClassMember".class_id AND "Class".deleted_at IS NULL`).
+		Joins(`LEFT JOIN "User" ON "User".id = "Class".creator_id`).
+		Where(`"ClassMember".user_id = ?`, q.UserID)
+
+	// filter by role
+	if q.Role != "" {
+		query = query.Where(`"ClassMember".role = ?`, q.Role)
+	}
+
+	// continue after the cursor
+	if q.AfterID != 0 {
+		var value interface{} = q.AfterCreatedAt
+		if q.SortByName {
+			value = q.AfterName
+		}
+		query = query.Where(
+			sortColumn+" "+comparison+" ? OR ("+sortColumn+" = ? AND \"Class\".id "+comparison+" ?)",
+			value, value, q.AfterID,
+		)
+	}
+
+	var items []ClassListItem
+	if err := query.Order(sortColumn + " " + direction).Order(`"Class".id ` + direction).Limit(q.Limit).Scan(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (r *gormClassRepository) Search(q ClassSearchQuery) ([]ClassListItem, error) {
+	query := r.db.Model(&db_models.Class{}).
+		Select(`"Class".id, "Class".name, "Class".description, "Class".created_at, "User".username AS created_by, ` +
+			`(SELECT COUNT(*) FROM "ClassMember" cm WHERE cm.class_id = "Class".id AND cm.deleted_at IS NULL) AS member_count`).
+		Joins(`LEFT JOIN "User" ON "User".id = "Class".creator_id`)
+	if q.Text != "" {
+		query = query.Where(`LOWER("Class".name) LIKE ? ESCAPE '\'`, containsPattern(q.Text))
+	}
+	if q.AfterID != 0 {
+		query = query.Where(`"Class".id > ?`, q.AfterID)
+	}
+
+	var items []ClassListItem
+	if err := query.Order(`"Class".id`).Limit(q.Limit).Scan(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+type memoryClassRepository struct {
+	s *MemoryStore
+}
+
+func (r *memoryClassRepository) Create(class *db_models.Class) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.classes.insert(class)
+	return nil
+}
+
+func (r *memoryClassRepository) FindByID(id uint) (*db_models.Class, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	class, ok := r.s.data.classes.get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &class, nil
+}
+
+func (r *memoryClassRepository) Update(class *db_models.Class) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.data.classes.update(class)
+}
+
+func (r *memoryClassRepository) Delete(id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.classes.remove(func(class db_models.Class) bool { return class.ID == id })
+	return nil
+}
+
+func (r *memoryClassRepository) ListForUser(q ClassListQuery) ([]ClassListItem, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	// join the memberships of the user to their classes
+	var items []ClassListItem
+	for _, classMember := range r.s.data.classMembers.filter(func(classMember db_models.ClassMember) bool {
+		return classMember.UserID == q.UserID && (q.Role == "" || classMember.Role == q.Role)
+	}) {
+		class, ok := r.s.data.classes.get(classMember.ClassID)
+		if !ok {
+			continue
+		}
+		creator, _ := r.s.data.users.get(class.CreatorID)
+		memberCount := len(r.s.data.classMembers.filter(func(other db_models.ClassMember) bool { return other.ClassID == class.ID }))
+		items = append(items, ClassListItem{
+			ID:          class.ID,
+			Name:        class.Name,
+			Description: class.Description,
+			Role:        classMember.Role,
+			MemberCount: int64(memberCount),
+			CreatedAt:   class.CreatedAt,
+			CreatedBy:   creator.Username,
+		})
+	}
+
+	// compare two rows by the sort key, then by ID
+	less := func(a ClassListItem, bName string, bCreatedAt time.Time, bID uint) bool {
+		if q.SortByName && a.Name != bName {
+			return a.Name < bName
+		}
+		if !q.SortByName && !a.CreatedAt.Equal(bCreatedAt) {
+			return a.CreatedAt.Before(bCreatedAt)
+		}
+		return a.ID < bID
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if q.Desc {
+			return less(items[j], items[i].Name, items[i].CreatedAt, items[i].ID)
+		}
+		return less(items[i], items[j].Name, items[j].CreatedAt, items[j].ID)
+	})
+
+	// continue after the cursor
+	page := make([]ClassListItem, 0, len(items))
+	for _, item := range items {
+		if q.AfterID != 0 {
+			after := less(ClassListItem{Name: q.AfterName, CreatedAt: q.AfterCreatedAt, ID: q.AfterID}, item.Name, item.CreatedAt, item.ID)
+			if q.Desc {
+				after = less(item, q.AfterName, q.AfterCreatedAt, q.AfterID)
+			}
+			if !after {
+				continue
+			}
+		}
+		page = append(page, item)
+		if q.Limit > 0 && len(page) == q.Limit {
+			break
+		}
+	}
+	return page, nil
+}
+
+func (r *memoryClassRepository) Search(q ClassSearchQuery) ([]ClassListItem, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	text := strings.ToLower(q.Text)
+	var items []ClassListItem
+	for _, class := range r.s.data.classes.filter(func(class db_models.Class) bool {
+		return class.ID > q.AfterID && strings.Contains(strings.ToLower(class.Name), text)
+	}) {
+		creator, _ := r.s.data.users.get(class.CreatorID)
+		memberCount := len(r.s.data.classMembers.filter(func(classMember db_models.ClassMember) bool { return classMember.ClassID == class.ID }))
+		items = append(items, ClassListItem{
+			ID:          class.ID,
+			Name:        class.Name,
+			Description: class.Description,
+			MemberCount: int64(memberCount),
+			CreatedAt:   class.CreatedAt,
+			CreatedBy:   creator.Username,
+		})
+		if q.Limit > 0 && len(items) == q.Limit {
+			break
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,77 @@
+package repositories
+
+import (
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EmailVerificationTokenRepository stores hashed email verification tokens
+type EmailVerificationTokenRepository interface {
+	Create(emailVerificationToken *db_models.EmailVerificationToken) error
+	FindBySelector(selector string) (*db_models.EmailVerificationToken, error)
+	Update(emailVerificationToken *db_models.EmailVerificationToken) error
+	DeleteByUser(userID uint) error
+}
+
+type gormEmailVerificationTokenRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormEmailVerificationTokenRepository) Create(emailVerificationToken *db_models.EmailVerificationToken) error {
+	return r.db.Create(emailVerificationToken).Error
+}
+
+func (r *gormEmailVerificationTokenRepository) FindBySelector(selector string) (*db_models.EmailVerificationToken, error) {
+	var emailVerificationToken db_models.EmailVerificationToken
+	if err := r.db.Where("selector = ?", selector).First(&emailVerificationToken).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &emailVerificationToken, nil
+}
+
+func (r *gormEmailVerificationTokenRepository) Update(emailVerificationToken *db_models.EmailVerificationToken) error {
+	return r.db.Omit(clause.Associations).Save(emailVerificationToken).Error
+}
+
+func (r *gormEmailVerificationTokenRepository) DeleteByUser(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&db_models.EmailVerificationToken{}).Error
+}
+
+type memoryEmailVerificationTokenRepository struct {
+	s *MemoryStore
+}
+
+func (r *memoryEmailVerificationTokenRepository) Create(emailVerificationToken *db_models.EmailVerificationToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.emailVerificationTokens.insert(emailVerificationToken)
+	return nil
+}
+
+func (r *memoryEmailVerificationTokenRepository) FindBySelector(selector string) (*db_models.EmailVerificationToken, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	emailVerificationToken, ok := r.s.data.emailVerificationTokens.first(func(emailVerificationToken db_models.EmailVerificationToken) bool {
+		return emailVerificationToken.Selector == selector
+	})
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &emailVerificationToken, nil
+}
+
+func (r *memoryEmailVerificationTokenRepository) Update(emailVerificationToken *db_models.EmailVerificationToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.data.emailVerificationTokens.update(emailVerificationToken)
+}
+
+func (r *memoryEmailVerificationTokenRepository) DeleteByUser(userID uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.emailVerificationTokens.remove(func(emailVerificationToken db_models.EmailVerificationToken) bool {
+		return emailVerificationToken.UserID == userID
+	})
+	return nil
+}
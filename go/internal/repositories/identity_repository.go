@@ -0,0 +1,78 @@
+package repositories
+
+import (
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+)
+
+// IdentityRepository stores external identities linked to users
+type IdentityRepository interface {
+	Create(identity *db_models.Identity) error
+	FindByProviderSubject(provider string, subject string) (*db_models.Identity, error)
+	ListByUser(userID uint) ([]db_models.Identity, error)
+	DeleteByUser(userID uint) error
+}
+
+type gormIdentityRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormIdentityRepository) Create(identity *db_models.Identity) error {
+	return r.db.Create(identity).Error
+}
+
+func (r *gormIdentityRepository) FindByProviderSubject(provider string, subject string) (*db_models.Identity, error) {
+	var identity db_models.Identity
+	if err := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &identity, nil
+}
+
+func (r *gormIdentityRepository) ListByUser(userID uint) ([]db_models.Identity, error) {
+	var identities []db_models.Identity
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&identities).Error; err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (r *gormIdentityRepository) DeleteByUser(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&db_models.Identity{}).Error
+}
+
+type memoryIdentityRepository struct {
+	s *MemoryStore
+}
+
+func (r *memoryIdentityRepository) Create(identity *db_models.Identity) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.identities.insert(identity)
+	return nil
+}
+
+func (r *memoryIdentityRepository) FindByProviderSubject(provider string, subject string) (*db_models.Identity, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	identity, ok := r.s.data.identities.first(func(identity db_models.Identity) bool {
+		return identity.Provider == provider && identity.Subject == subject
+	})
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &identity, nil
+}
+
+func (r *memoryIdentityRepository) ListByUser(userID uint) ([]db_models.Identity, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.data.identities.filter(func(identity db_models.Identity) bool { return identity.UserID == userID }), nil
+}
+
+func (r *memoryIdentityRepository) DeleteByUser(userID uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.identities.remove(func(identity db_models.Identity) bool { return identity.UserID == userID })
+	return nil
+}
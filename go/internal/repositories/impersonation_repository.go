@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+)
+
+// ImpersonationRepository stores the times admins act as users
+type ImpersonationRepository interface {
+	Create(impersonation *db_models.Impersonation) error
+	FindByID(id uint) (*db_models.Impersonation, error)
+	// end an impersonation that hasn't ended yet, reporting whether it did
+	End(id uint, endedAt time.Time) (bool, error)
+}
+
+type gormImpersonationRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormImpersonationRepository) Create(impersonation *db_models.Impersonation) error {
+	return r.db.Create(impersonation).Error
+}
+
+func (r *gormImpersonationRepository) FindByID(id uint) (*db_models.Impersonation, error) {
+	var impersonation db_models.Impersonation
+	if err := r.db.First(&impersonation, id).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &impersonation, nil
+}
+
+func (r *gormImpersonationRepository) End(id uint, endedAt time.Time) (bool, error) {
+	result := r.db.Model(&db_models.Impersonation{}).
+		Where("id = ? AND ended_at IS NULL", id).
+		Update("ended_at", endedAt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+type memoryImpersonationRepository struct {
+	s *MemoryStore
+}
+
+func (r *memoryImpersonationRepository) Create(impersonation *db_models.Impersonation) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.impersonations.insert(impersonation)
+	return nil
+}
+
+func (r *memoryImpersonationRepository) FindByID(id uint) (*db_models.Impersonation, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	impersonation, ok := r.s.data.impersonations.get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &impersonation, nil
+}
+
+func (r *memoryImpersonationRepository) End(id uint, endedAt time.Time) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	impersonation, ok := r.s.data.impersonations.get(id)
+	if !ok || impersonation.EndedAt != nil {
+		return false, nil
+	}
+	impersonation.EndedAt = &endedAt
+	return true, r.s.data.impersonations.update(&impersonation)
+}
@@ -0,0 +1,132 @@
+package repositories
+
+import (
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+)
+
+// JoinCodeRepository stores the codes used to join classes
+type JoinCodeRepository interface {
+	Create(joinCode *db_models.JoinCode) error
+	FindByID(id uint) (*db_models.JoinCode, error)
+	FindByCode(code string) (*db_models.JoinCode, error)
+	// list the codes of a class, oldest first
+	ListByClass(classID uint) ([]db_models.JoinCode, error)
+	// take one use of a limited code, returning false when none are left; safe against concurrent joins
+	ConsumeUse(id uint) (bool, error)
+	Delete(id uint) error
+	DeleteByClass(classID uint) error
+}
+
+type gormJoinCodeRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormJoinCodeRepository) Create(joinCode *db_models.JoinCode) error {
+	return r.db.Create(joinCode).Error
+}
+
+func (r *gormJoinCodeRepository) FindByID(id uint) (*db_models.JoinCode, error) {
+	var joinCode db_models.JoinCode
+	if err := r.db.First(&joinCode, id).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &joinCode, nil
+}
+
+func (r *gormJoinCodeRepository) FindByCode(code string) (*db_models.JoinCode, error) {
+	var joinCode db_models.JoinCode
+	if err := r.db.Where("code = ?", code).First(&joinCode).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &joinCode, nil
+}
+
+func (r *gormJoinCodeRepository) ListByClass(classID uint) ([]db_models.JoinCode, error) {
+	var joinCodes []db_models.JoinCode
+	if err := r.db.Where("class_id = ?", classID).Order("id").Find(&joinCodes).Error; err != nil {
+		return nil, err
+	}
+	return joinCodes, nil
+}
+
+func (r *gormJoinCodeRepository) ConsumeUse(id uint) (bool, error) {
+	// the check and the decrement are one statement, so two joins can't both take the last use
+	result := r.db.Model(&db_models.JoinCode{}).
+		Where("id = ? AND remaining_uses > 0", id).
+		UpdateColumn("remaining_uses", gorm.Expr("remaining_uses - 1"))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *gormJoinCodeRepository) Delete(id uint) error {
+	return r.db.Delete(&db_models.JoinCode{}, id).Error
+}
+
+func (r *gormJoinCodeRepository) DeleteByClass(classID uint) error {
+	return r.db.Where("class_id = ?", classID).Delete(&db_models.JoinCode{}).Error
+}
+
+type memoryJoinCodeRepository struct {
+	s *MemoryStore
+}
+
+func (r *memoryJoinCodeRepository) Create(joinCode *db_models.JoinCode) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.joinCodes.insert(joinCode)
+	return nil
+}
+
+func (r *memoryJoinCodeRepository) FindByID(id uint) (*db_models.JoinCode, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	joinCode, ok := r.s.data.joinCodes.get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &joinCode, nil
+}
+
+func (r *memoryJoinCodeRepository) FindByCode(code string) (*db_models.JoinCode, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	joinCode, ok := r.s.data.joinCodes.first(func(joinCode db_models.JoinCode) bool { return joinCode.Code == code })
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &joinCode, nil
+}
+
+func (r *memoryJoinCodeRepository) ListByClass(classID uint) ([]db_models.JoinCode, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.data.joinCodes.filter(func(joinCode db_models.JoinCode) bool { return joinCode.ClassID == classID }), nil
+}
+
+func (r *memoryJoinCodeRepository) ConsumeUse(id uint) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	joinCode, ok := r.s.data.joinCodes.get(id)
+	if !ok || joinCode.RemainingUses <= 0 {
+		return false, nil
+	}
+	joinCode.RemainingUses--
+	return true, r.s.data.joinCodes.update(&joinCode)
+}
+
+func (r *memoryJoinCodeRepository) Delete(id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.joinCodes.remove(func(joinCode db_models.JoinCode) bool { return joinCode.ID == id })
+	return nil
+}
+
+func (r *memoryJoinCodeRepository) DeleteByClass(classID uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.joinCodes.remove(func(joinCode db_models.JoinCode) bool { return joinCode.ClassID == classID })
+	return nil
+}
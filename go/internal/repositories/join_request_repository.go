@@ -0,0 +1,177 @@
+package repositories
+
+import (
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JoinRequestRepository stores requests to join classes that require approval
+type JoinRequestRepository interface {
+	// create a request, returning ErrDuplicate when the user already has one pending for the class
+	Create(joinRequest *db_models.JoinRequest) error
+	FindByID(id uint) (*db_models.JoinRequest, error)
+	// find a user's pending request to join a class
+	FindPending(classID uint, userID uint) (*db_models.JoinRequest, error)
+	// list the requests of a class with the given status and their users loaded, oldest first
+	ListByClass(classID uint, status string) ([]db_models.JoinRequest, error)
+	// list a user's requests with their classes loaded, newest first
+	ListByUser(userID uint) ([]db_models.JoinRequest, error)
+	// save the status of a request that is still pending, along with who decided it and when, reporting whether it was
+	UpdatePending(joinRequest *db_models.JoinRequest) (bool, error)
+	DeleteByClass(classID uint) error
+}
+
+type gormJoinRequestRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormJoinRequestRepository) Create(joinRequest *db_models.JoinRequest) error {
+	// the partial unique index turns a second pending request into a no-op rather than an error that aborts the transaction
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(joinRequest)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+func (r *gormJoinRequestRepository) FindByID(id uint) (*db_models.JoinRequest, error) {
+	var joinRequest db_models.JoinRequest
+	if err := r.db.First(&joinRequest, id).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &joinRequest, nil
+}
+
+func (r *gormJoinRequestRepository) FindPending(classID uint, userID uint) (*db_models.JoinRequest, error) {
+	var joinRequest db_models.JoinRequest
+	if err := r.db.Where("class_id = ? AND user_id = ? AND status = ?", classID, userID, db_models.JoinRequestPending).First(&joinRequest).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &joinRequest, nil
+}
+
+func (r *gormJoinRequestRepository) ListByClass(classID uint, status string) ([]db_models.JoinRequest, error) {
+	var joinRequests []db_models.JoinRequest
+	if err := r.db.Preload("User").Where("class_id = ? AND status = ?", classID, status).Order("id").Find(&joinRequests).Error; err != nil {
+		return nil, err
+	}
+	return joinRequests, nil
+}
+
+func (r *gormJoinRequestRepository) ListByUser(userID uint) ([]db_models.JoinRequest, error) {
+	var joinRequests []db_models.JoinRequest
+	if err := r.db.Preload("Class").Where("user_id = ?", userID).Order("id DESC").Find(&joinRequests).Error; err != nil {
+		return nil, err
+	}
+	return joinRequests, nil
+}
+
+func (r *gormJoinRequestRepository) UpdatePending(joinRequest *db_models.JoinRequest) (bool, error) {
+	// the check and the update are one statement, so two admins can't both decide the request
+	result := r.db.Model(&db_models.JoinRequest{}).
+		Where("id = ? AND status = ?", joinRequest.ID, db_models.JoinRequestPending).
+		Updates(map[string]interface{}{
+			"status":        joinRequest.Status,
+			"decided_by_id": joinRequest.DecidedByID,
+			"decided_at":    joinRequest.DecidedAt,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *gormJoinRequestRepository) DeleteByClass(classID uint) error {
+	return r.db.Where("class_id = ?", classID).Delete(&db_models.JoinRequest{}).Error
+}
+
+type memoryJoinRequestRepository struct {
+	s *MemoryStore
+}
+
+func (r *memoryJoinRequestRepository) Create(joinRequest *db_models.JoinRequest) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if joinRequest.Status == db_models.JoinRequestPending {
+		_, exists := r.s.data.joinRequests.first(func(other db_models.JoinRequest) bool {
+			return other.ClassID == joinRequest.ClassID && other.UserID == joinRequest.UserID && other.Status == db_models.JoinRequestPending
+		})
+		if exists {
+			return ErrDuplicate
+		}
+	}
+	r.s.data.joinRequests.insert(joinRequest)
+	return nil
+}
+
+func (r *memoryJoinRequestRepository) FindByID(id uint) (*db_models.JoinRequest, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	joinRequest, ok := r.s.data.joinRequests.get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &joinRequest, nil
+}
+
+func (r *memoryJoinRequestRepository) FindPending(classID uint, userID uint) (*db_models.JoinRequest, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	joinRequest, ok := r.s.data.joinRequests.first(func(joinRequest db_models.JoinRequest) bool {
+		return joinRequest.ClassID == classID && joinRequest.UserID == userID && joinRequest.Status == db_models.JoinRequestPending
+	})
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &joinRequest, nil
+}
+
+func (r *memoryJoinRequestRepository) ListByClass(classID uint, status string) ([]db_models.JoinRequest, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	joinRequests := r.s.data.joinRequests.filter(func(joinRequest db_models.JoinRequest) bool {
+		return joinRequest.ClassID == classID && joinRequest.Status == status
+	})
+	for i := range joinRequests {
+		joinRequests[i].User, _ = r.s.data.users.get(joinRequests[i].UserID)
+	}
+	return joinRequests, nil
+}
+
+func (r *memoryJoinRequestRepository) ListByUser(userID uint) ([]db_models.JoinRequest, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	joinRequests := r.s.data.joinRequests.filter(func(joinRequest db_models.JoinRequest) bool { return joinRequest.UserID == userID })
+	// newest first
+	for i, j := 0, len(joinRequests)-1; i < j; i, j = i+1, j-1 {
+		joinRequests[i], joinRequests[j] = joinRequests[j], joinRequests[i]
+	}
+	for i := range joinRequests {
+		joinRequests[i].Class, _ = r.s.data.classes.get(joinRequests[i].ClassID)
+	}
+	return joinRequests, nil
+}
+
+func (r *memoryJoinRequestRepository) UpdatePending(joinRequest *db_models.JoinRequest) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	stored, ok := r.s.data.joinRequests.get(joinRequest.ID)
+	if !ok || stored.Status != db_models.JoinRequestPending {
+		return false, nil
+	}
+	stored.Status = joinRequest.Status
+	stored.DecidedByID = joinRequest.DecidedByID
+	stored.DecidedAt = joinRequest.DecidedAt
+	return true, r.s.data.joinRequests.update(&stored)
+}
+
+func (r *memoryJoinRequestRepository) DeleteByClass(classID uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.joinRequests.remove(func(joinRequest db_models.JoinRequest) bool { return joinRequest.ClassID == classID })
+	return nil
+}
@@ -0,0 +1,75 @@
+package repositories
+
+import (
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PasswordResetTokenRepository stores hashed password reset tokens
+type PasswordResetTokenRepository interface {
+	Create(passwordResetToken *db_models.PasswordResetToken) error
+	FindBySelector(selector string) (*db_models.PasswordResetToken, error)
+	Update(passwordResetToken *db_models.PasswordResetToken) error
+	DeleteByUser(userID uint) error
+}
+
+type gormPasswordResetTokenRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormPasswordResetTokenRepository) Create(passwordResetToken *db_models.PasswordResetToken) error {
+	return r.db.Create(passwordResetToken).Error
+}
+
+func (r *gormPasswordResetTokenRepository) FindBySelector(selector string) (*db_models.PasswordResetToken, error) {
+	var passwordResetToken db_models.PasswordResetToken
+	if err := r.db.Where("selector = ?", selector).First(&passwordResetToken).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &passwordResetToken, nil
+}
+
+func (r *gormPasswordResetTokenRepository) Update(passwordResetToken *db_models.PasswordResetToken) error {
+	return r.db.Omit(clause.Associations).Save(passwordResetToken).Error
+}
+
+func (r *gormPasswordResetTokenRepository) DeleteByUser(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&db_models.PasswordResetToken{}).Error
+}
+
+type memoryPasswordResetTokenRepository struct {
+	s *MemoryStore
+}
+
+func (r *memoryPasswordResetTokenRepository) Create(passwordResetToken *db_models.PasswordResetToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.passwordResetTokens.insert(passwordResetToken)
+	return nil
+}
+
+func (r *memoryPasswordResetTokenRepository) FindBySelector(selector string) (*db_models.PasswordResetToken, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	passwordResetToken, ok := r.s.data.passwordResetTokens.first(func(passwordResetToken db_models.PasswordResetToken) bool {
+		return passwordResetToken.Selector == selector
+	})
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &passwordResetToken, nil
+}
+
+func (r *memoryPasswordResetTokenRepository) Update(passwordResetToken *db_models.PasswordResetToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.data.passwordResetTokens.update(passwordResetToken)
+}
+
+func (r *memoryPasswordResetTokenRepository) DeleteByUser(userID uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.passwordResetTokens.remove(func(passwordResetToken db_models.PasswordResetToken) bool { return passwordResetToken.UserID == userID })
+	return nil
+}
@@ -0,0 +1,106 @@
+package repositories
+
+import (
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PersonalAccessTokenRepository stores personal access tokens
+type PersonalAccessTokenRepository interface {
+	Create(personalAccessToken *db_models.PersonalAccessToken) error
+	FindBySelector(selector string) (*db_models.PersonalAccessToken, error)
+	ListByUser(userID uint) ([]db_models.PersonalAccessToken, error)
+	Update(personalAccessToken *db_models.PersonalAccessToken) error
+	Delete(id uint) error
+	DeleteByUser(userID uint) error
+}
+
+type gormPersonalAccessTokenRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormPersonalAccessTokenRepository) Create(personalAccessToken *db_models.PersonalAccessToken) error {
+	return r.db.Create(personalAccessToken).Error
+}
+
+func (r *gormPersonalAccessTokenRepository) FindBySelector(selector string) (*db_models.PersonalAccessToken, error) {
+	var personalAccessToken db_models.PersonalAccessToken
+	if err := r.db.Where("selector = ?", selector).First(&personalAccessToken).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &personalAccessToken, nil
+}
+
+func (r *gormPersonalAccessTokenRepository) ListByUser(userID uint) ([]db_models.PersonalAccessToken, error) {
+	var personalAccessTokens []db_models.PersonalAccessToken
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&personalAccessTokens).Error; err != nil {
+		return nil, err
+	}
+	return personalAccessTokens, nil
+}
+
+func (r *gormPersonalAccessTokenRepository) Update(personalAccessToken *db_models.PersonalAccessToken) error {
+	return r.db.Omit(clause.Associations).Save(personalAccessToken).Error
+}
+
+func (r *gormPersonalAccessTokenRepository) Delete(id uint) error {
+	return r.db.Delete(&db_models.PersonalAccessToken{}, id).Error
+}
+
+func (r *gormPersonalAccessTokenRepository) DeleteByUser(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&db_models.PersonalAccessToken{}).Error
+}
+
+type memoryPersonalAccessTokenRepository struct {
+	s *MemoryStore
+}
+
+func (r *memoryPersonalAccessTokenRepository) Create(personalAccessToken *db_models.PersonalAccessToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.personalAccessTokens.insert(personalAccessToken)
+	return nil
+}
+
+func (r *memoryPersonalAccessTokenRepository) FindBySelector(selector string) (*db_models.PersonalAccessToken, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	personalAccessToken, ok := r.s.data.personalAccessTokens.first(func(personalAccessToken db_models.PersonalAccessToken) bool {
+		return personalAccessToken.Selector == selector
+	})
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &personalAccessToken, nil
+}
+
+func (r *memoryPersonalAccessTokenRepository) ListByUser(userID uint) ([]db_models.PersonalAccessToken, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.data.personalAccessTokens.filter(func(personalAccessToken db_models.PersonalAccessToken) bool {
+		return personalAccessToken.UserID == userID
+	}), nil
+}
+
+func (r *memoryPersonalAccessTokenRepository) Update(personalAccessToken *db_models.PersonalAccessToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.data.personalAccessTokens.update(personalAccessToken)
+}
+
+func (r *memoryPersonalAccessTokenRepository) Delete(id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.personalAccessTokens.remove(func(personalAccessToken db_models.PersonalAccessToken) bool { return personalAccessToken.ID == id })
+	return nil
+}
+
+func (r *memoryPersonalAccessTokenRepository) DeleteByUser(userID uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.personalAccessTokens.remove(func(personalAccessToken db_models.PersonalAccessToken) bool {
+		return personalAccessToken.UserID == userID
+	})
+	return nil
+}
@@ -0,0 +1,82 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+)
+
+// RecoveryCodeRepository stores hashed two-factor recovery codes
+type RecoveryCodeRepository interface {
+	Create(recoveryCode *db_models.RecoveryCode) error
+	ListByUser(userID uint) ([]db_models.RecoveryCode, error)
+	// mark a code as used, returning false when it already was; safe against concurrent sign ins
+	Use(id uint, usedAt time.Time) (bool, error)
+	DeleteByUser(userID uint) error
+}
+
+type gormRecoveryCodeRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormRecoveryCodeRepository) Create(recoveryCode *db_models.RecoveryCode) error {
+	return r.db.Create(recoveryCode).Error
+}
+
+func (r *gormRecoveryCodeRepository) ListByUser(userID uint) ([]db_models.RecoveryCode, error) {
+	var recoveryCodes []db_models.RecoveryCode
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&recoveryCodes).Error; err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+func (r *gormRecoveryCodeRepository) Use(id uint, usedAt time.Time) (bool, error) {
+	result := r.db.Model(&db_models.RecoveryCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", usedAt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *gormRecoveryCodeRepository) DeleteByUser(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&db_models.RecoveryCode{}).Error
+}
+
+type memoryRecoveryCodeRepository struct {
+	s *MemoryStore
+}
+
+func (r *memoryRecoveryCodeRepository) Create(recoveryCode *db_models.RecoveryCode) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.recoveryCodes.insert(recoveryCode)
+	return nil
+}
+
+func (r *memoryRecoveryCodeRepository) ListByUser(userID uint) ([]db_models.RecoveryCode, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.data.recoveryCodes.filter(func(recoveryCode db_models.RecoveryCode) bool { return recoveryCode.UserID == userID }), nil
+}
+
+func (r *memoryRecoveryCodeRepository) Use(id uint, usedAt time.Time) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	recoveryCode, ok := r.s.data.recoveryCodes.get(id)
+	if !ok || recoveryCode.UsedAt != nil {
+		return false, nil
+	}
+	recoveryCode.UsedAt = &usedAt
+	return true, r.s.data.recoveryCodes.update(&recoveryCode)
+}
+
+func (r *memoryRecoveryCodeRepository) DeleteByUser(userID uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.recoveryCodes.remove(func(recoveryCode db_models.RecoveryCode) bool { return recoveryCode.UserID == userID })
+	return nil
+}
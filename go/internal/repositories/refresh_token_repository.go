@@ -0,0 +1,211 @@
+package repositories
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RefreshTokenRepository stores hashed refresh tokens
+type RefreshTokenRepository interface {
+	Create(refreshToken *db_models.RefreshToken) error
+	FindByID(id uint) (*db_models.RefreshToken, error)
+	FindBySelector(selector string) (*db_models.RefreshToken, error)
+	ListByUser(userID uint) ([]db_models.RefreshToken, error)
+	// list every token rotated from the same sign in
+	ListByFamily(familyID uint) ([]db_models.RefreshToken, error)
+	// whether any token of a sign in is left, i.e. the session hasn't been revoked
+	FamilyExists(familyID uint) (bool, error)
+	Update(refreshToken *db_models.RefreshToken) error
+	// mark a token as rotated, returning false when it already was; safe against concurrent refreshes
+	Rotate(id uint, rotatedAt time.Time) (bool, error)
+	// delete every token rotated from the same sign in
+	DeleteFamily(familyID uint) error
+	DeleteByUser(userID uint) error
+	// delete the tokens of families whose every token expired before the cutoff, returning how many were deleted;
+	// a family still in use keeps its first token, since that identifies the session
+	DeleteExpired(cutoff time.Time) (int64, error)
+}
+
+type gormRefreshTokenRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormRefreshTokenRepository) Create(refreshToken *db_models.RefreshToken) error {
+	return r.db.Create(refreshToken).Error
+}
+
+func (r *gormRefreshTokenRepository) FindByID(id uint) (*db_models.RefreshToken, error) {
+	var refreshToken db_models.RefreshToken
+	if err := r.db.First(&refreshToken, id).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &refreshToken, nil
+}
+
+func (r *gormRefreshTokenRepository) FindBySelector(selector string) (*db_models.RefreshToken, error) {
+	var refreshToken db_models.RefreshToken
+	if err := r.db.Where("selector = ?", selector).First(&refreshToken).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &refreshToken, nil
+}
+
+func (r *gormRefreshTokenRepository) ListByUser(userID uint) ([]db_models.RefreshToken, error) {
+	var refreshTokens []db_models.RefreshToken
+	if err := r.db.Where("user_id = ?", userID).Find(&refreshTokens).Error; err != nil {
+		return nil, err
+	}
+	return refreshTokens, nil
+}
+
+func (r *gormRefreshTokenRepository) Update(refreshToken *db_models.RefreshToken) error {
+	return r.db.Omit(clause.Associations).Save(refreshToken).Error
+}
+
+func (r *gormRefreshTokenRepository) Rotate(id uint, rotatedAt time.Time) (bool, error) {
+	// the check and the update are one statement, so two refreshes can't both rotate the token
+	result := r.db.Model(&db_models.RefreshToken{}).
+		Where("id = ? AND rotated_at IS NULL", id).
+		Update("rotated_at", rotatedAt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *gormRefreshTokenRepository) DeleteFamily(familyID uint) error {
+	return r.db.Where("family_id = ?", familyID).Delete(&db_models.RefreshToken{}).Error
+}
+
+func (r *gormRefreshTokenRepository) DeleteByUser(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&db_models.RefreshToken{}).Error
+}
+
+func (r *gormRefreshTokenRepository) ListByFamily(familyID uint) ([]db_models.RefreshToken, error) {
+	var refreshTokens []db_models.RefreshToken
+	if err := r.db.Where("family_id = ?", familyID).Order("id").Find(&refreshTokens).Error; err != nil {
+		return nil, err
+	}
+	return refreshTokens, nil
+}
+
+func (r *gormRefreshTokenRepository) FamilyExists(familyID uint) (bool, error) {
+	var refreshTokens []db_models.RefreshToken
+	if err := r.db.Select("id").Where("family_id = ?", familyID).Limit(1).Find(&refreshTokens).Error; err != nil {
+		return false, err
+	}
+	return len(refreshTokens) > 0, nil
+}
+
+func (r *gormRefreshTokenRepository) DeleteExpired(cutoff time.Time) (int64, error) {
+	live := r.db.Model(&db_models.RefreshToken{}).Select("family_id").Where("expires_at >= ?", cutoff)
+	result := r.db.Unscoped().Where("expires_at < ? AND family_id NOT IN (?)", cutoff, live).Delete(&db_models.RefreshToken{})
+	return result.RowsAffected, result.Error
+}
+
+type memoryRefreshTokenRepository struct {
+	s *MemoryStore
+}
+
+func (r *memoryRefreshTokenRepository) Create(refreshToken *db_models.RefreshToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.refreshTokens.insert(refreshToken)
+	return nil
+}
+
+func (r *memoryRefreshTokenRepository) FindByID(id uint) (*db_models.RefreshToken, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	refreshToken, ok := r.s.data.refreshTokens.get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &refreshToken, nil
+}
+
+func (r *memoryRefreshTokenRepository) FindBySelector(selector string) (*db_models.RefreshToken, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	refreshToken, ok := r.s.data.refreshTokens.first(func(refreshToken db_models.RefreshToken) bool { return refreshToken.Selector == selector })
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &refreshToken, nil
+}
+
+func (r *memoryRefreshTokenRepository) ListByUser(userID uint) ([]db_models.RefreshToken, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.data.refreshTokens.filter(func(refreshToken db_models.RefreshToken) bool { return refreshToken.UserID == userID }), nil
+}
+
+func (r *memoryRefreshTokenRepository) Update(refreshToken *db_models.RefreshToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.data.refreshTokens.update(refreshToken)
+}
+
+func (r *memoryRefreshTokenRepository) Rotate(id uint, rotatedAt time.Time) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	refreshToken, ok := r.s.data.refreshTokens.get(id)
+	if !ok || refreshToken.RotatedAt != nil {
+		return false, nil
+	}
+	refreshToken.RotatedAt = &rotatedAt
+	return true, r.s.data.refreshTokens.update(&refreshToken)
+}
+
+func (r *memoryRefreshTokenRepository) DeleteFamily(familyID uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.refreshTokens.remove(func(refreshToken db_models.RefreshToken) bool { return refreshToken.FamilyID == familyID })
+	return nil
+}
+
+func (r *memoryRefreshTokenRepository) DeleteByUser(userID uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.refreshTokens.remove(func(refreshToken db_models.RefreshToken) bool { return refreshToken.UserID == userID })
+	return nil
+}
+
+func (r *memoryRefreshTokenRepository) ListByFamily(familyID uint) ([]db_models.RefreshToken, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	refreshTokens := r.s.data.refreshTokens.filter(func(refreshToken db_models.RefreshToken) bool { return refreshToken.FamilyID == familyID })
+	sort.Slice(refreshTokens, func(i, j int) bool { return refreshTokens[i].ID < refreshTokens[j].ID })
+	return refreshTokens, nil
+}
+
+func (r *memoryRefreshTokenRepository) FamilyExists(familyID uint) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	_, ok := r.s.data.refreshTokens.first(func(refreshToken db_models.RefreshToken) bool { return refreshToken.FamilyID == familyID })
+	return ok, nil
+}
+
+func (r *memoryRefreshTokenRepository) DeleteExpired(cutoff time.Time) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	live := map[uint]bool{}
+	for _, refreshToken := range r.s.data.refreshTokens.filter(func(refreshToken db_models.RefreshToken) bool { return !refreshToken.ExpiresAt.Before(cutoff) }) {
+		live[refreshToken.FamilyID] = true
+	}
+
+	var deleted int64
+	r.s.data.refreshTokens.remove(func(refreshToken db_models.RefreshToken) bool {
+		if refreshToken.ExpiresAt.Before(cutoff) && !live[refreshToken.FamilyID] {
+			deleted++
+			return true
+		}
+		return false
+	})
+	return deleted, nil
+}
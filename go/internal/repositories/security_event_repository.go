@@ -0,0 +1,45 @@
+package repositories
+
+import (
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+)
+
+// SecurityEventRepository stores suspicious activity on user accounts
+type SecurityEventRepository interface {
+	Create(securityEvent *db_models.SecurityEvent) error
+	ListByUser(userID uint) ([]db_models.SecurityEvent, error)
+}
+
+type gormSecurityEventRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormSecurityEventRepository) Create(securityEvent *db_models.SecurityEvent) error {
+	return r.db.Create(securityEvent).Error
+}
+
+func (r *gormSecurityEventRepository) ListByUser(userID uint) ([]db_models.SecurityEvent, error) {
+	var securityEvents []db_models.SecurityEvent
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&securityEvents).Error; err != nil {
+		return nil, err
+	}
+	return securityEvents, nil
+}
+
+type memorySecurityEventRepository struct {
+	s *MemoryStore
+}
+
+func (r *memorySecurityEventRepository) Create(securityEvent *db_models.SecurityEvent) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.securityEvents.insert(securityEvent)
+	return nil
+}
+
+func (r *memorySecurityEventRepository) ListByUser(userID uint) ([]db_models.SecurityEvent, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.data.securityEvents.filter(func(securityEvent db_models.SecurityEvent) bool { return securityEvent.UserID == userID }), nil
+}
@@ -0,0 +1,95 @@
+package repositories
+
+import (
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SignInThrottleRepository stores failed sign in counters; rows are reset rather than deleted
+type SignInThrottleRepository interface {
+	Create(signInThrottle *db_models.SignInThrottle) error
+	FindByKey(key string) (*db_models.SignInThrottle, error)
+	// find the counter of a key, creating it when missing, and lock it until the transaction ends;
+	// safe against concurrent failures for the same key
+	FindOrCreateForUpdate(key string) (*db_models.SignInThrottle, error)
+	Update(signInThrottle *db_models.SignInThrottle) error
+}
+
+type gormSignInThrottleRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormSignInThrottleRepository) Create(signInThrottle *db_models.SignInThrottle) error {
+	return r.db.Create(signInThrottle).Error
+}
+
+func (r *gormSignInThrottleRepository) FindByKey(key string) (*db_models.SignInThrottle, error) {
+	var signInThrottle db_models.SignInThrottle
+	if err := r.db.Where("key = ?", key).First(&signInThrottle).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &signInThrottle, nil
+}
+
+func (r *gormSignInThrottleRepository) FindOrCreateForUpdate(key string) (*db_models.SignInThrottle, error) {
+	// a concurrent first failure may have created the row already, which is fine
+	err := r.db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "key"}}, DoNothing: true}).
+		Create(&db_models.SignInThrottle{Key: key}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var signInThrottle db_models.SignInThrottle
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&signInThrottle).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &signInThrottle, nil
+}
+
+func (r *gormSignInThrottleRepository) Update(signInThrottle *db_models.SignInThrottle) error {
+	return r.db.Save(signInThrottle).Error
+}
+
+type memorySignInThrottleRepository struct {
+	s *MemoryStore
+}
+
+func (r *memorySignInThrottleRepository) Create(signInThrottle *db_models.SignInThrottle) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.signInThrottles.insert(signInThrottle)
+	return nil
+}
+
+func (r *memorySignInThrottleRepository) FindByKey(key string) (*db_models.SignInThrottle, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	signInThrottle, ok := r.s.data.signInThrottles.first(func(signInThrottle db_models.SignInThrottle) bool {
+		return signInThrottle.Key == key
+	})
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &signInThrottle, nil
+}
+
+func (r *memorySignInThrottleRepository) FindOrCreateForUpdate(key string) (*db_models.SignInThrottle, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	signInThrottle, ok := r.s.data.signInThrottles.first(func(signInThrottle db_models.SignInThrottle) bool {
+		return signInThrottle.Key == key
+	})
+	if !ok {
+		// transactions on the memory store already run one at a time
+		signInThrottle = db_models.SignInThrottle{Key: key}
+		r.s.data.signInThrottles.insert(&signInThrottle)
+	}
+	return &signInThrottle, nil
+}
+
+func (r *memorySignInThrottleRepository) Update(signInThrottle *db_models.SignInThrottle) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.data.signInThrottles.update(signInThrottle)
+}
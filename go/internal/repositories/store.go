@@ -0,0 +1,387 @@
+package repositories
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+)
+
+// define custom error messages
+var (
+	ErrNotFound  = errors.New("record not found")
+	ErrDuplicate = errors.New("record already exists")
+)
+
+// UnitOfWork runs a group of repository operations as a single transaction
+type UnitOfWork interface {
+	// run fn in a transaction, committing if it returns nil and rolling back otherwise
+	Do(fn func(store Store) error) error
+}
+
+// Store gives access to every repository
+type Store interface {
+	UnitOfWork
+	Users() UserRepository
+	Classes() ClassRepository
+	ClassMembers() ClassMemberRepository
+	JoinCodes() JoinCodeRepository
+	RefreshTokens() RefreshTokenRepository
+	SecurityEvents() SecurityEventRepository
+	PasswordResetTokens() PasswordResetTokenRepository
+	EmailVerificationTokens() EmailVerificationTokenRepository
+	RecoveryCodes() RecoveryCodeRepository
+	Identities() IdentityRepository
+	SignInThrottles() SignInThrottleRepository
+	PersonalAccessTokens() PersonalAccessTokenRepository
+	AdminActions() AdminActionRepository
+	AuditEvents() AuditEventRepository
+	JoinRequests() JoinRequestRepository
+	Impersonations() ImpersonationRepository
+}
+
+// helper function to map gorm errors to repository errors
+func translateError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// helper function to build a LIKE pattern matching text anywhere, case insensitively; needs ESCAPE '\'
+func containsPattern(text string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(text))
+	return "%" + escaped + "%"
+}
+
+// Store backed by a gorm database
+type GormStore struct {
+	DB *gorm.DB
+}
+
+// create and return a new GormStore instance
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{
+		DB: db,
+	}
+}
+
+// run fn in a database transaction
+func (s *GormStore) Do(fn func(store Store) error) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		return fn(NewGormStore(tx))
+	})
+}
+
+func (s *GormStore) Users() UserRepository {
+	return &gormUserRepository{db: s.DB}
+}
+
+func (s *GormStore) Classes() ClassRepository {
+	return &gormClassRepository{db: s.DB}
+}
+
+func (s *GormStore) ClassMembers() ClassMemberRepository {
+	return &gormClassMemberRepository{db: s.DB}
+}
+
+func (s *GormStore) JoinCodes() JoinCodeRepository {
+	return &gormJoinCodeRepository{db: s.DB}
+}
+
+func (s *GormStore) RefreshTokens() RefreshTokenRepository {
+	return &gormRefreshTokenRepository{db: s.DB}
+}
+
+func (s *GormStore) SecurityEvents() SecurityEventRepository {
+	return &gormSecurityEventRepository{db: s.DB}
+}
+
+func (s *GormStore) PasswordResetTokens() PasswordResetTokenRepository {
+	return &gormPasswordResetTokenRepository{db: s.DB}
+}
+
+func (s *GormStore) EmailVerificationTokens() EmailVerificationTokenRepository {
+	return &gormEmailVerificationTokenRepository{db: s.DB}
+}
+
+func (s *GormStore) RecoveryCodes() RecoveryCodeRepository {
+	return &gormRecoveryCodeRepository{db: s.DB}
+}
+
+func (s *GormStore) Identities() IdentityRepository {
+	return &gormIdentityRepository{db: s.DB}
+}
+
+func (s *GormStore) SignInThrottles() SignInThrottleRepository {
+	return &gormSignInThrottleRepository{db: s.DB}
+}
+
+func (s *GormStore) PersonalAccessTokens() PersonalAccessTokenRepository {
+	return &gormPersonalAccessTokenRepository{db: s.DB}
+}
+
+func (s *GormStore) AdminActions() AdminActionRepository {
+	return &gormAdminActionRepository{db: s.DB}
+}
+
+func (s *GormStore) AuditEvents() AuditEventRepository {
+	return &gormAuditEventRepository{db: s.DB}
+}
+
+func (s *GormStore) JoinRequests() JoinRequestRepository {
+	return &gormJoinRequestRepository{db: s.DB}
+}
+
+func (s *GormStore) Impersonations() ImpersonationRepository {
+	return &gormImpersonationRepository{db: s.DB}
+}
+
+// Store kept in memory, used by tests
+type MemoryStore struct {
+	txMu sync.Mutex
+	mu   sync.Mutex
+	data *memoryData
+}
+
+// every table of the memory store
+type memoryData struct {
+	users                   *memoryTable[db_models.User]
+	classes                 *memoryTable[db_models.Class]
+	classMembers            *memoryTable[db_models.ClassMember]
+	joinCodes               *memoryTable[db_models.JoinCode]
+	refreshTokens           *memoryTable[db_models.RefreshToken]
+	securityEvents          *memoryTable[db_models.SecurityEvent]
+	passwordResetTokens     *memoryTable[db_models.PasswordResetToken]
+	emailVerificationTokens *memoryTable[db_models.EmailVerificationToken]
+	recoveryCodes           *memoryTable[db_models.RecoveryCode]
+	identities              *memoryTable[db_models.Identity]
+	signInThrottles         *memoryTable[db_models.SignInThrottle]
+	personalAccessTokens    *memoryTable[db_models.PersonalAccessToken]
+	adminActions            *memoryTable[db_models.AdminAction]
+	auditEvents             *memoryTable[db_models.AuditEvent]
+	joinRequests            *memoryTable[db_models.JoinRequest]
+	impersonations          *memoryTable[db_models.Impersonation]
+}
+
+// create and return a new, empty MemoryStore instance
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		data: &memoryData{
+			users:                   newMemoryTable(func(row *db_models.User) *gorm.Model { return &row.Model }),
+			classes:                 newMemoryTable(func(row *db_models.Class) *gorm.Model { return &row.Model }),
+			classMembers:            newMemoryTable(func(row *db_models.ClassMember) *gorm.Model { return &row.Model }),
+			joinCodes:               newMemoryTable(func(row *db_models.JoinCode) *gorm.Model { return &row.Model }),
+			refreshTokens:           newMemoryTable(func(row *db_models.RefreshToken) *gorm.Model { return &row.Model }),
+			securityEvents:          newMemoryTable(func(row *db_models.SecurityEvent) *gorm.Model { return &row.Model }),
+			passwordResetTokens:     newMemoryTable(func(row *db_models.PasswordResetToken) *gorm.Model { return &row.Model }),
+			emailVerificationTokens: newMemoryTable(func(row *db_models.EmailVerificationToken) *gorm.Model { return &row.Model }),
+			recoveryCodes:           newMemoryTable(func(row *db_models.RecoveryCode) *gorm.Model { return &row.Model }),
+			identities:              newMemoryTable(func(row *db_models.Identity) *gorm.Model { return &row.Model }),
+			signInThrottles:         newMemoryTable(func(row *db_models.SignInThrottle) *gorm.Model { return &row.Model }),
+			personalAccessTokens:    newMemoryTable(func(row *db_models.PersonalAccessToken) *gorm.Model { return &row.Model }),
+			adminActions:            newMemoryTable(func(row *db_models.AdminAction) *gorm.Model { return &row.Model }),
+			auditEvents:             newMemoryTable(func(row *db_models.AuditEvent) *gorm.Model { return &row.Model }),
+			joinRequests:            newMemoryTable(func(row *db_models.JoinRequest) *gorm.Model { return &row.Model }),
+			impersonations:          newMemoryTable(func(row *db_models.Impersonation) *gorm.Model { return &row.Model }),
+		},
+	}
+}
+
+// run fn against a snapshot of the store, discarding its changes if it fails
+func (s *MemoryStore) Do(fn func(store Store) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	return (&memoryTx{s}).Do(fn)
+}
+
+func (s *MemoryStore) Users() UserRepository {
+	return &memoryUserRepository{s}
+}
+
+func (s *MemoryStore) Classes() ClassRepository {
+	return &memoryClassRepository{s}
+}
+
+func (s *MemoryStore) ClassMembers() ClassMemberRepository {
+	return &memoryClassMemberRepository{s}
+}
+
+func (s *MemoryStore) JoinCodes() JoinCodeRepository {
+	return &memoryJoinCodeRepository{s}
+}
+
+func (s *MemoryStore) RefreshTokens() RefreshTokenRepository {
+	return &memoryRefreshTokenRepository{s}
+}
+
+func (s *MemoryStore) SecurityEvents() SecurityEventRepository {
+	return &memorySecurityEventRepository{s}
+}
+
+func (s *MemoryStore) PasswordResetTokens() PasswordResetTokenRepository {
+	return &memoryPasswordResetTokenRepository{s}
+}
+
+func (s *MemoryStore) EmailVerificationTokens() EmailVerificationTokenRepository {
+	return &memoryEmailVerificationTokenRepository{s}
+}
+
+func (s *MemoryStore) RecoveryCodes() RecoveryCodeRepository {
+	return &memoryRecoveryCodeRepository{s}
+}
+
+func (s *MemoryStore) Identities() IdentityRepository {
+	return &memoryIdentityRepository{s}
+}
+
+func (s *MemoryStore) SignInThrottles() SignInThrottleRepository {
+	return &memorySignInThrottleRepository{s}
+}
+
+func (s *MemoryStore) PersonalAccessTokens() PersonalAccessTokenRepository {
+	return &memoryPersonalAccessTokenRepository{s}
+}
+
+func (s *MemoryStore) AdminActions() AdminActionRepository {
+	return &memoryAdminActionRepository{s}
+}
+
+func (s *MemoryStore) AuditEvents() AuditEventRepository {
+	return &memoryAuditEventRepository{s}
+}
+
+func (s *MemoryStore) JoinRequests() JoinRequestRepository {
+	return &memoryJoinRequestRepository{s}
+}
+
+func (s *MemoryStore) Impersonations() ImpersonationRepository {
+	return &memoryImpersonationRepository{s}
+}
+
+// store handed to fn inside MemoryStore.Do, so nested calls don't deadlock
+type memoryTx struct {
+	*MemoryStore
+}
+
+func (t *memoryTx) Do(fn func(store Store) error) error {
+	t.mu.Lock()
+	snapshot := t.data.clone()
+	t.mu.Unlock()
+
+	if err := fn(t); err != nil {
+		t.mu.Lock()
+		t.data = snapshot
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (d *memoryData) clone() *memoryData {
+	return &memoryData{
+		users:                   d.users.clone(),
+		classes:                 d.classes.clone(),
+		classMembers:            d.classMembers.clone(),
+		joinCodes:               d.joinCodes.clone(),
+		refreshTokens:           d.refreshTokens.clone(),
+		securityEvents:          d.securityEvents.clone(),
+		passwordResetTokens:     d.passwordResetTokens.clone(),
+		emailVerificationTokens: d.emailVerificationTokens.clone(),
+		recoveryCodes:           d.recoveryCodes.clone(),
+		identities:              d.identities.clone(),
+		signInThrottles:         d.signInThrottles.clone(),
+		personalAccessTokens:    d.personalAccessTokens.clone(),
+		adminActions:            d.adminActions.clone(),
+		auditEvents:             d.auditEvents.clone(),
+		joinRequests:            d.joinRequests.clone(),
+		impersonations:          d.impersonations.clone(),
+	}
+}
+
+// a table of rows keyed by ID
+type memoryTable[T any] struct {
+	rows   map[uint]T
+	nextID uint
+	model  func(row *T) *gorm.Model
+}
+
+func newMemoryTable[T any](model func(row *T) *gorm.Model) *memoryTable[T] {
+	return &memoryTable[T]{rows: map[uint]T{}, model: model}
+}
+
+// add a row, assigning its ID and timestamps
+func (t *memoryTable[T]) insert(row *T) {
+	model := t.model(row)
+	t.nextID++
+	now := time.Now()
+	model.ID = t.nextID
+	model.CreatedAt = now
+	model.UpdatedAt = now
+	t.rows[model.ID] = *row
+}
+
+// replace a row, refreshing its update timestamp
+func (t *memoryTable[T]) update(row *T) error {
+	model := t.model(row)
+	if _, ok := t.rows[model.ID]; !ok {
+		return ErrNotFound
+	}
+	model.UpdatedAt = time.Now()
+	t.rows[model.ID] = *row
+	return nil
+}
+
+// return the row with the given ID
+func (t *memoryTable[T]) get(id uint) (T, bool) {
+	row, ok := t.rows[id]
+	return row, ok
+}
+
+// return the rows matching pred, ordered by ID
+func (t *memoryTable[T]) filter(pred func(T) bool) []T {
+	ids := make([]uint, 0, len(t.rows))
+	for id, row := range t.rows {
+		if pred(row) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	rows := make([]T, 0, len(ids))
+	for _, id := range ids {
+		rows = append(rows, t.rows[id])
+	}
+	return rows
+}
+
+// return the first row matching pred
+func (t *memoryTable[T]) first(pred func(T) bool) (T, bool) {
+	rows := t.filter(pred)
+	if len(rows) == 0 {
+		var zero T
+		return zero, false
+	}
+	return rows[0], true
+}
+
+// remove the rows matching pred
+func (t *memoryTable[T]) remove(pred func(T) bool) {
+	for id, row := range t.rows {
+		if pred(row) {
+			delete(t.rows, id)
+		}
+	}
+}
+
+func (t *memoryTable[T]) clone() *memoryTable[T] {
+	rows := make(map[uint]T, len(t.rows))
+	for id, row := range t.rows {
+		rows[id] = row
+	}
+	return &memoryTable[T]{rows: rows, nextID: t.nextID, model: t.model}
+}
@@ -0,0 +1,280 @@
+package repositories
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var errRollback = errors.New("rollback")
+
+// open a fresh in-memory database with every table migrated
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get database handle: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	err = db.AutoMigrate(
+		&db_models.User{},
+		&db_models.Class{},
+		&db_models.ClassMember{},
+		&db_models.JoinCode{},
+		&db_models.RefreshToken{},
+		&db_models.SecurityEvent{},
+		&db_models.PasswordResetToken{},
+		&db_models.EmailVerificationToken{},
+		&db_models.RecoveryCode{},
+		&db_models.Identity{},
+		&db_models.SignInThrottle{},
+		&db_models.PersonalAccessToken{},
+		&db_models.AdminAction{},
+		&db_models.AuditEvent{},
+		&db_models.JoinRequest{},
+		&db_models.Impersonation{},
+	)
+	if err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return db
+}
+
+// run the same checks against every store implementation
+func forEachStore(t *testing.T, fn func(t *testing.T, store Store)) {
+	t.Run("gorm", func(t *testing.T) { fn(t, NewGormStore(newTestDB(t))) })
+	t.Run("memory", func(t *testing.T) { fn(t, NewMemoryStore()) })
+}
+
+func TestStoreCommits(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		err := store.Do(func(store Store) error {
+			return store.Users().Create(&db_models.User{Username: "a", Email: "a@example.com", HashedPassword: "x"})
+		})
+		if err != nil {
+			t.Fatalf("Do returned %v", err)
+		}
+
+		if _, err := store.Users().FindByUsername("a"); err != nil {
+			t.Fatalf("expected the user to be committed, got %v", err)
+		}
+	})
+}
+
+func TestStoreRollsBack(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		err := store.Do(func(store Store) error {
+			if err := store.Users().Create(&db_models.User{Username: "a", Email: "a@example.com", HashedPassword: "x"}); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Fatalf("expected the rollback error, got %v", err)
+		}
+
+		if _, err := store.Users().FindByUsername("a"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected the user to be rolled back, got %v", err)
+		}
+	})
+}
+
+func TestListForUserMatchesAcrossStores(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		owner := db_models.User{Username: "owner", Email: "owner@example.com", HashedPassword: "x"}
+		store.Users().Create(&owner)
+		for _, name := range []string{"B", "A", "C"} {
+			class := db_models.Class{Name: name, CreatorID: owner.ID}
+			store.Classes().Create(&class)
+			store.ClassMembers().Create(&db_models.ClassMember{ClassID: class.ID, UserID: owner.ID, Role: "admin"})
+		}
+
+		items, err := store.Classes().ListForUser(ClassListQuery{UserID: owner.ID, SortByName: true, Desc: true, Limit: 2})
+		if err != nil {
+			t.Fatalf("ListForUser returned %v", err)
+		}
+		if len(items) != 2 || items[0].Name != "C" || items[1].Name != "B" {
+			t.Fatalf("unexpected first page %+v", items)
+		}
+		if items[0].MemberCount != 1 || items[0].CreatedBy != "owner" || items[0].Role != "admin" {
+			t.Fatalf("unexpected item %+v", items[0])
+		}
+
+		items, err = store.Classes().ListForUser(ClassListQuery{UserID: owner.ID, SortByName: true, Desc: true, Limit: 2, AfterID: items[1].ID, AfterName: items[1].Name})
+		if err != nil {
+			t.Fatalf("ListForUser returned %v", err)
+		}
+		if len(items) != 1 || items[0].Name != "A" {
+			t.Fatalf("unexpected second page %+v", items)
+		}
+	})
+}
+
+func TestSearchMatchesAcrossStores(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		for _, username := range []string{"alice", "Malice", "bob", "al_ice"} {
+			store.Users().Create(&db_models.User{Username: username, Email: username + "@example.com", HashedPassword: "x"})
+		}
+
+		users, err := store.Users().Search(UserSearchQuery{Text: "ALIC", Limit: 10})
+		if err != nil {
+			t.Fatalf("Search returned %v", err)
+		}
+		if len(users) != 2 || users[0].Username != "alice" || users[1].Username != "Malice" {
+			t.Fatalf("unexpected users %+v", users)
+		}
+
+		// wildcards in the search text are matched literally
+		users, _ = store.Users().Search(UserSearchQuery{Text: "_", Limit: 10})
+		if len(users) != 1 || users[0].Username != "al_ice" {
+			t.Fatalf("unexpected users %+v", users)
+		}
+
+		// pages continue after the last ID
+		users, _ = store.Users().Search(UserSearchQuery{Limit: 2})
+		users, _ = store.Users().Search(UserSearchQuery{AfterID: users[1].ID, Limit: 2})
+		if len(users) != 2 || users[0].Username != "bob" {
+			t.Fatalf("unexpected second page %+v", users)
+		}
+
+		owner, _ := store.Users().FindByUsername("bob")
+		for _, name := range []string{"Algebra", "Biology", "Linear Algebra"} {
+			class := db_models.Class{Name: name, CreatorID: owner.ID}
+			store.Classes().Create(&class)
+			store.ClassMembers().Create(&db_models.ClassMember{ClassID: class.ID, UserID: owner.ID, Role: "admin"})
+		}
+		items, err := store.Classes().Search(ClassSearchQuery{Text: "algebra", Limit: 10})
+		if err != nil {
+			t.Fatalf("Search returned %v", err)
+		}
+		if len(items) != 2 || items[1].Name != "Linear Algebra" || items[1].CreatedBy != "bob" || items[1].MemberCount != 1 {
+			t.Fatalf("unexpected classes %+v", items)
+		}
+	})
+}
+
+func TestFindOrCreateForUpdateReusesTheRow(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		err := store.Do(func(store Store) error {
+			first, err := store.SignInThrottles().FindOrCreateForUpdate("ip:10.0.0.1")
+			if err != nil {
+				return err
+			}
+			first.Failures = 2
+			return store.SignInThrottles().Update(first)
+		})
+		if err != nil {
+			t.Fatalf("Do returned %v", err)
+		}
+
+		// a second failure finds the same counter instead of hitting the unique key
+		second, err := store.SignInThrottles().FindOrCreateForUpdate("ip:10.0.0.1")
+		if err != nil {
+			t.Fatalf("FindOrCreateForUpdate returned %v", err)
+		}
+		if second.Failures != 2 {
+			t.Fatalf("expected the existing counter, got %+v", second)
+		}
+	})
+}
+
+func TestJoinRequestsAllowOnePendingPerUser(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		first := db_models.JoinRequest{ClassID: 1, UserID: 2, JoinCodeID: 3, Role: "user", Status: db_models.JoinRequestPending}
+		if err := store.JoinRequests().Create(&first); err != nil {
+			t.Fatalf("Create returned %v", err)
+		}
+		second := db_models.JoinRequest{ClassID: 1, UserID: 2, JoinCodeID: 3, Role: "user", Status: db_models.JoinRequestPending}
+		if err := store.JoinRequests().Create(&second); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("expected ErrDuplicate, got %v", err)
+		}
+
+		// only a pending request can be decided, and only once
+		first.Status = db_models.JoinRequestRejected
+		if ok, err := store.JoinRequests().UpdatePending(&first); err != nil || !ok {
+			t.Fatalf("expected the pending request to be updated, got %v, %v", ok, err)
+		}
+		first.Status = db_models.JoinRequestApproved
+		if ok, err := store.JoinRequests().UpdatePending(&first); err != nil || ok {
+			t.Fatalf("expected a decided request to be left alone, got %v, %v", ok, err)
+		}
+		if stored, _ := store.JoinRequests().FindByID(first.ID); stored.Status != db_models.JoinRequestRejected {
+			t.Fatalf("expected the request to stay rejected, got %+v", stored)
+		}
+
+		// once decided, the user can ask again
+		third := db_models.JoinRequest{ClassID: 1, UserID: 2, JoinCodeID: 3, Role: "user", Status: db_models.JoinRequestPending}
+		if err := store.JoinRequests().Create(&third); err != nil {
+			t.Fatalf("Create returned %v", err)
+		}
+	})
+}
+
+func TestClassMembersAllowOneMembershipPerUser(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		first := db_models.ClassMember{ClassID: 1, UserID: 2, Role: "user"}
+		if err := store.ClassMembers().Create(&first); err != nil {
+			t.Fatalf("Create returned %v", err)
+		}
+		second := db_models.ClassMember{ClassID: 1, UserID: 2, Role: "admin"}
+		if err := store.ClassMembers().Create(&second); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("expected ErrDuplicate, got %v", err)
+		}
+
+		// once removed, the user can join again
+		if err := store.ClassMembers().Delete(first.ID); err != nil {
+			t.Fatalf("Delete returned %v", err)
+		}
+		third := db_models.ClassMember{ClassID: 1, UserID: 2, Role: "user"}
+		if err := store.ClassMembers().Create(&third); err != nil {
+			t.Fatalf("Create returned %v", err)
+		}
+	})
+}
+
+func TestDeleteExpiredKeepsFamiliesInUse(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		now := time.Now()
+		tokens := []db_models.RefreshToken{
+			// a session kept alive by refreshing, whose first token has expired
+			{Selector: "a1", UserID: 1, FamilyID: 1, ExpiresAt: now.Add(-time.Hour)},
+			{Selector: "a2", UserID: 1, FamilyID: 1, ExpiresAt: now.Add(time.Hour)},
+			// a session that has expired entirely
+			{Selector: "b1", UserID: 1, FamilyID: 3, ExpiresAt: now.Add(-2 * time.Hour)},
+			{Selector: "b2", UserID: 1, FamilyID: 3, ExpiresAt: now.Add(-time.Hour)},
+		}
+		for i := range tokens {
+			if err := store.RefreshTokens().Create(&tokens[i]); err != nil {
+				t.Fatalf("Create returned %v", err)
+			}
+		}
+
+		deleted, err := store.RefreshTokens().DeleteExpired(now)
+		if err != nil || deleted != 2 {
+			t.Fatalf("expected the expired session to be deleted, got %d, %v", deleted, err)
+		}
+		if left, _ := store.RefreshTokens().ListByFamily(1); len(left) != 2 {
+			t.Fatalf("expected the session in use to keep both tokens, got %+v", left)
+		}
+		if exists, _ := store.RefreshTokens().FamilyExists(1); !exists {
+			t.Fatalf("expected the session in use to exist")
+		}
+		if exists, _ := store.RefreshTokens().FamilyExists(3); exists {
+			t.Fatalf("expected the expired session to be gone")
+		}
+	})
+}
@@ -0,0 +1,204 @@
+package repositories
+
+import (
+	"strings"
+
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UserRepository stores user accounts
+type UserRepository interface {
+	Create(user *db_models.User) error
+	FindByID(id uint) (*db_models.User, error)
+	// find a user, locking the row until the transaction ends
+	FindByIDForUpdate(id uint) (*db_models.User, error)
+	FindByUsername(username string) (*db_models.User, error)
+	FindByEmail(email string) (*db_models.User, error)
+	// find a user matching either the username or the email
+	FindByUsernameOrEmail(username string, email string) (*db_models.User, error)
+	Update(user *db_models.User) error
+	// record the time step of an accepted TOTP code, returning false when the step was already reached; safe against concurrent sign ins
+	AdvanceTOTPStep(id uint, step int64) (bool, error)
+	Delete(id uint) error
+	// list users whose username or email contains the search text, one page at a time in ID order
+	Search(query UserSearchQuery) ([]db_models.User, error)
+}
+
+// which page of a user search to list
+type UserSearchQuery struct {
+	// matched case insensitively against the username and email; every user when empty
+	Text    string
+	AfterID uint
+	Limit   int
+}
+
+type gormUserRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormUserRepository) Create(user *db_models.User) error {
+	return r.db.Create(user).Error
+}
+
+func (r *gormUserRepository) FindByID(id uint) (*db_models.User, error) {
+	var user db_models.User
+	if err := r.db.First(&user, id).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepository) FindByIDForUpdate(id uint) (*db_models.User, error) {
+	var user db_models.User
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepository) AdvanceTOTPStep(id uint, step int64) (bool, error) {
+	// the check and the update are one statement, so the same code can't be accepted twice
+	result := r.db.Model(&db_models.User{}).
+		Where("id = ? AND totp_last_step < ?", id, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *gormUserRepository) FindByUsername(username string) (*db_models.User, error) {
+	var user db_models.User
+	if err := r.db.Where("username = ?", username).First(&user).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepository) FindByEmail(email string) (*db_models.User, error) {
+	var user db_models.User
+	if err := r.db.Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepository) FindByUsernameOrEmail(username string, email string) (*db_models.User, error) {
+	var user db_models.User
+	if err := r.db.Where("email = ? OR username = ?", email, username).First(&user).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepository) Update(user *db_models.User) error {
+	return r.db.Omit(clause.Associations).Save(user).Error
+}
+
+func (r *gormUserRepository) Delete(id uint) error {
+	return r.db.Delete(&db_models.User{}, id).Error
+}
+
+func (r *gormUserRepository) Search(q UserSearchQuery) ([]db_models.User, error) {
+	query := r.db.Order("id").Limit(q.Limit)
+	if q.Text != "" {
+		pattern := containsPattern(q.Text)
+		query = query.Where(`LOWER(username) LIKE ? ESCAPE '\' OR LOWER(email) LIKE ? ESCAPE '\'`, pattern, pattern)
+	}
+	if q.AfterID != 0 {
+		query = query.Where("id > ?", q.AfterID)
+	}
+	var users []db_models.User
+	if err := query.Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+type memoryUserRepository struct {
+	s *MemoryStore
+}
+
+func (r *memoryUserRepository) Create(user *db_models.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.users.insert(user)
+	return nil
+}
+
+func (r *memoryUserRepository) FindByID(id uint) (*db_models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	user, ok := r.s.data.users.get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+// rows aren't locked in memory; MemoryStore.Do already runs one transaction at a time
+func (r *memoryUserRepository) FindByIDForUpdate(id uint) (*db_models.User, error) {
+	return r.FindByID(id)
+}
+
+func (r *memoryUserRepository) AdvanceTOTPStep(id uint, step int64) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	user, ok := r.s.data.users.get(id)
+	if !ok || user.TOTPLastStep >= step {
+		return false, nil
+	}
+	user.TOTPLastStep = step
+	return true, r.s.data.users.update(&user)
+}
+
+func (r *memoryUserRepository) FindByUsername(username string) (*db_models.User, error) {
+	return r.findFirst(func(user db_models.User) bool { return user.Username == username })
+}
+
+func (r *memoryUserRepository) FindByEmail(email string) (*db_models.User, error) {
+	return r.findFirst(func(user db_models.User) bool { return user.Email == email })
+}
+
+func (r *memoryUserRepository) FindByUsernameOrEmail(username string, email string) (*db_models.User, error) {
+	return r.findFirst(func(user db_models.User) bool { return user.Username == username || user.Email == email })
+}
+
+func (r *memoryUserRepository) findFirst(pred func(db_models.User) bool) (*db_models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	user, ok := r.s.data.users.first(pred)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r *memoryUserRepository) Update(user *db_models.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.data.users.update(user)
+}
+
+func (r *memoryUserRepository) Delete(id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.data.users.remove(func(user db_models.User) bool { return user.ID == id })
+	return nil
+}
+
+func (r *memoryUserRepository) Search(q UserSearchQuery) ([]db_models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	text := strings.ToLower(q.Text)
+	users := r.s.data.users.filter(func(user db_models.User) bool {
+		return user.ID > q.AfterID && (strings.Contains(strings.ToLower(user.Username), text) || strings.Contains(strings.ToLower(user.Email), text))
+	})
+	if q.Limit > 0 && len(users) > q.Limit {
+		users = users[:q.Limit]
+	}
+	return users, nil
+}
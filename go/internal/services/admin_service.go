@@ -0,0 +1,480 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/mail"
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+// define custom error messages
+var (
+	ErrCannotTargetSelf       = errors.New("Admins can't do this to their own account")
+	ErrAccountNotDisabled     = errors.New("Account is not disabled")
+	ErrAccountAlreadyDisabled = errors.New("Account is already disabled")
+	ErrCannotImpersonateAdmin = errors.New("Platform admins can't be impersonated")
+	ErrImpersonationNotFound  = errors.New("Impersonation not found")
+	ErrImpersonationEnded     = errors.New("Impersonation has already ended")
+)
+
+type AdminService struct {
+	Store  repositories.Store
+	Mailer mail.Mailer
+}
+
+// create and return a new AdminService instance
+func NewAdminService(store repositories.Store, mailer mail.Mailer) *AdminService {
+	return &AdminService{
+		Store:  store,
+		Mailer: mailer,
+	}
+}
+
+// whether a user may use the admin API; disabled admins may not
+func (s *AdminService) IsPlatformAdmin(userID uint) (bool, error) {
+	user, err := s.Store.Users().FindByID(userID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return false, nil
+		}
+		return false, ErrInternalServerError
+	}
+	return user.Role == db_models.PlatformRoleAdmin && user.DisabledAt == nil, nil
+}
+
+// give the platform admin role to the users with the given emails, so a fresh install has someone to run the admin API;
+// an email has to be verified first, or whoever signed up with it first would get the role
+func (s *AdminService) PromotePlatformAdmins(emails []string) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		for _, email := range emails {
+			user, err := store.Users().FindByEmail(email)
+			if errors.Is(err, repositories.ErrNotFound) {
+				log.Printf("platform admin %q has not signed up yet", email)
+				continue
+			}
+			if err != nil {
+				return ErrInternalServerError
+			}
+			if !user.EmailVerified {
+				log.Printf("platform admin %q has not verified their email yet", email)
+				continue
+			}
+			if user.Role == db_models.PlatformRoleAdmin {
+				continue
+			}
+			previousRole := user.Role
+			user.Role = db_models.PlatformRoleAdmin
+			if err := store.Users().Update(user); err != nil {
+				return ErrInternalServerError
+			}
+
+			// the server itself grants the role, so there is no actor
+			event := db_models.AuditEvent{Action: db_models.AuditActionPromotePlatformAdmin, TargetType: db_models.AuditTargetUser, TargetID: user.ID}
+			if err := recordAuditEvent(store, service_models.AuditContext{}, event, auditFields{"role": previousRole}, auditFields{"role": user.Role}); err != nil {
+				return err
+			}
+			log.Printf("made %q a platform admin", email)
+		}
+		return nil
+	})
+}
+
+// search users by username or email
+func (s *AdminService) ListUsers(req service_models.AdminListUsersRequest) (service_models.AdminListUsersResponse, error) {
+	limit := clampAdminPageSize(req.Limit)
+	query := repositories.UserSearchQuery{
+		Text:  strings.TrimSpace(req.Query),
+		Limit: limit + 1,
+	}
+	if req.Cursor != "" {
+		cursor, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return service_models.AdminListUsersResponse{}, err
+		}
+		query.AfterID = cursor.ID
+	}
+
+	users, err := s.Store.Users().Search(query)
+	if err != nil {
+		return service_models.AdminListUsersResponse{}, ErrInternalServerError
+	}
+
+	// build the response
+	resp := service_models.AdminListUsersResponse{
+		Users: make([]service_models.AdminUserSummary, 0, len(users)),
+	}
+	if len(users) > limit {
+		users = users[:limit]
+		resp.NextCursor = encodeCursor(pageCursor{ID: users[limit-1].ID})
+	}
+	for _, user := range users {
+		resp.Users = append(resp.Users, service_models.AdminUserSummary{
+			UserID:                user.ID,
+			Username:              user.Username,
+			Email:                 user.Email,
+			EmailVerified:         user.EmailVerified,
+			Role:                  user.Role,
+			TwoFactorEnabled:      user.TOTPEnabled,
+			Disabled:              user.DisabledAt != nil,
+			PasswordResetRequired: user.PasswordResetRequired,
+			CreatedAt:             user.CreatedAt,
+		})
+	}
+
+	return resp, nil
+}
+
+// disable an account, signing out every session
+func (s *AdminService) DisableUser(req service_models.AdminUserActionRequest) error {
+	if req.UserID == req.AdminID {
+		return ErrCannotTargetSelf
+	}
+
+	return s.Store.Do(func(store repositories.Store) error {
+		user, err := findUser(store, req.UserID)
+		if err != nil {
+			return err
+		}
+		if user.DisabledAt != nil {
+			return ErrAccountAlreadyDisabled
+		}
+
+		now := time.Now()
+		user.DisabledAt = &now
+		if err := store.Users().Update(user); err != nil {
+			return ErrInternalServerError
+		}
+		if err := store.RefreshTokens().DeleteByUser(user.ID); err != nil {
+			return ErrInternalServerError
+		}
+
+		if err := recordAdminAction(store, req.AdminID, db_models.AdminActionDisableUser, db_models.AdminTargetUser, user.ID, req.Audit.IPAddress, req.Reason); err != nil {
+			return err
+		}
+
+		event := db_models.AuditEvent{ActorID: req.AdminID, Action: db_models.AuditActionAdminDisableUser, TargetType: db_models.AuditTargetUser, TargetID: user.ID}
+		return recordAuditEvent(store, req.Audit, event, auditFields{"disabled": false}, auditFields{"disabled": true, "reason": req.Reason})
+	})
+}
+
+// re-enable a disabled account
+func (s *AdminService) EnableUser(req service_models.AdminUserActionRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		user, err := findUser(store, req.UserID)
+		if err != nil {
+			return err
+		}
+		if user.DisabledAt == nil {
+			return ErrAccountNotDisabled
+		}
+
+		user.DisabledAt = nil
+		if err := store.Users().Update(user); err != nil {
+			return ErrInternalServerError
+		}
+
+		if err := recordAdminAction(store, req.AdminID, db_models.AdminActionEnableUser, db_models.AdminTargetUser, user.ID, req.Audit.IPAddress, req.Reason); err != nil {
+			return err
+		}
+
+		event := db_models.AuditEvent{ActorID: req.AdminID, Action: db_models.AuditActionAdminEnableUser, TargetType: db_models.AuditTargetUser, TargetID: user.ID}
+		return recordAuditEvent(store, req.Audit, event, auditFields{"disabled": true}, auditFields{"disabled": false, "reason": req.Reason})
+	})
+}
+
+// sign a user out everywhere and refuse sign in until they reset their password with the link emailed to them
+func (s *AdminService) ForcePasswordReset(req service_models.AdminUserActionRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		user, err := findUser(store, req.UserID)
+		if err != nil {
+			return err
+		}
+
+		wasRequired := user.PasswordResetRequired
+		user.PasswordResetRequired = true
+		if err := store.Users().Update(user); err != nil {
+			return ErrInternalServerError
+		}
+		if err := store.RefreshTokens().DeleteByUser(user.ID); err != nil {
+			return ErrInternalServerError
+		}
+
+		if err := recordAdminAction(store, req.AdminID, db_models.AdminActionForcePasswordReset, db_models.AdminTargetUser, user.ID, req.Audit.IPAddress, req.Reason); err != nil {
+			return err
+		}
+		event := db_models.AuditEvent{ActorID: req.AdminID, Action: db_models.AuditActionAdminForcePasswordReset, TargetType: db_models.AuditTargetUser, TargetID: user.ID}
+		if err := recordAuditEvent(store, req.Audit, event, auditFields{"password_reset_required": wasRequired}, auditFields{"password_reset_required": true, "reason": req.Reason}); err != nil {
+			return err
+		}
+
+		return sendPasswordResetEmail(store, s.Mailer, user, func(link string) string {
+			return fmt.Sprintf("Hi %s,\n\nAn administrator has asked you to choose a new password, "+
+				"and you have been signed out everywhere. Open the link below within the next hour to set one:\n\n%s\n\n"+
+				"Once it expires you can ask for a new link from the sign in page.", user.Username, link)
+		})
+	})
+}
+
+// issue a short lived access token letting an admin act as a user, naming the admin in its act claim
+func (s *AdminService) ImpersonateUser(req service_models.AdminImpersonateRequest) (service_models.AdminImpersonateResponse, error) {
+	if req.UserID == req.AdminID {
+		return service_models.AdminImpersonateResponse{}, ErrCannotTargetSelf
+	}
+
+	var res service_models.AdminImpersonateResponse
+	err := s.Store.Do(func(store repositories.Store) error {
+		admin, err := findUser(store, req.AdminID)
+		if err != nil {
+			return err
+		}
+		user, err := findUser(store, req.UserID)
+		if err != nil {
+			return err
+		}
+
+		// impersonating another admin would hand over their admin rights
+		if user.Role == db_models.PlatformRoleAdmin {
+			return ErrCannotImpersonateAdmin
+		}
+		if user.DisabledAt != nil {
+			return ErrAccountDisabled
+		}
+
+		if err := recordAdminAction(store, admin.ID, db_models.AdminActionImpersonateUser, db_models.AdminTargetUser, user.ID, req.Audit.IPAddress, req.Reason); err != nil {
+			return err
+		}
+
+		// keep the impersonation, so it can be ended before the token expires
+		impersonation := db_models.Impersonation{
+			AdminID:   admin.ID,
+			UserID:    user.ID,
+			ExpiresAt: auth.ImpersonationTokenExpiration(),
+		}
+		if err := store.Impersonations().Create(&impersonation); err != nil {
+			return ErrInternalServerError
+		}
+
+		actor := auth.Actor{UserID: admin.ID, Username: admin.Username, ImpersonationID: impersonation.ID}
+		res.AccessToken, err = auth.GenerateImpersonationJWT(user.ID, user.Username, actor, impersonation.ExpiresAt)
+		if err != nil {
+			return ErrTokenGeneration
+		}
+		res.ImpersonationID = impersonation.ID
+		res.ExpiresAt = impersonation.ExpiresAt
+
+		event := db_models.AuditEvent{ActorID: admin.ID, Action: db_models.AuditActionAdminImpersonateUser, TargetType: db_models.AuditTargetUser, TargetID: user.ID}
+		return recordAuditEvent(store, req.Audit, event, nil, auditFields{"impersonation_id": impersonation.ID, "reason": req.Reason})
+	})
+	if err != nil {
+		return service_models.AdminImpersonateResponse{}, err
+	}
+
+	return res, nil
+}
+
+// end an impersonation before its token expires; any admin may end one, not only the admin who started it
+func (s *AdminService) EndImpersonation(req service_models.AdminEndImpersonationRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		impersonation, err := store.Impersonations().FindByID(req.ImpersonationID)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrImpersonationNotFound
+			}
+			return ErrInternalServerError
+		}
+
+		now := time.Now()
+		if now.After(impersonation.ExpiresAt) {
+			return ErrImpersonationEnded
+		}
+		ended, err := store.Impersonations().End(impersonation.ID, now)
+		if err != nil {
+			return ErrInternalServerError
+		}
+		if !ended {
+			return ErrImpersonationEnded
+		}
+
+		if err := recordAdminAction(store, req.AdminID, db_models.AdminActionEndImpersonation, db_models.AdminTargetUser, impersonation.UserID, req.Audit.IPAddress, req.Reason); err != nil {
+			return err
+		}
+
+		event := db_models.AuditEvent{ActorID: req.AdminID, Action: db_models.AuditActionAdminEndImpersonation, TargetType: db_models.AuditTargetUser, TargetID: impersonation.UserID}
+		return recordAuditEvent(store, req.Audit, event, nil, auditFields{"impersonation_id": impersonation.ID, "reason": req.Reason})
+	})
+}
+
+// record a request an admin made while impersonating a user
+func (s *AdminService) RecordImpersonatedRequest(req service_models.RecordImpersonatedRequestRequest) error {
+	details := fmt.Sprintf("%s %s %d", req.Method, req.Path, req.Status)
+	return recordAdminAction(s.Store, req.AdminID, db_models.AdminActionImpersonatedRequest, db_models.AdminTargetUser, req.UserID, req.IPAddress, details)
+}
+
+// list every class, optionally searching by name
+func (s *AdminService) ListClasses(req service_models.AdminListClassesRequest) (service_models.AdminListClassesResponse, error) {
+	limit := clampAdminPageSize(req.Limit)
+	query := repositories.ClassSearchQuery{
+		Text:  strings.TrimSpace(req.Query),
+		Limit: limit + 1,
+	}
+	if req.Cursor != "" {
+		cursor, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return service_models.AdminListClassesResponse{}, err
+		}
+		query.AfterID = cursor.ID
+	}
+
+	rows, err := s.Store.Classes().Search(query)
+	if err != nil {
+		return service_models.AdminListClassesResponse{}, ErrInternalServerError
+	}
+
+	// build the response
+	resp := service_models.AdminListClassesResponse{
+		Classes: make([]service_models.ClassSummary, 0, len(rows)),
+	}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		resp.NextCursor = encodeCursor(pageCursor{ID: rows[limit-1].ID})
+	}
+	for _, row := range rows {
+		resp.Classes = append(resp.Classes, service_models.ClassSummary{
+			ClassID:     row.ID,
+			Name:        row.Name,
+			Description: row.Description,
+			MemberCount: row.MemberCount,
+			CreatedAt:   row.CreatedAt,
+			CreatedBy:   row.CreatedBy,
+		})
+	}
+
+	return resp, nil
+}
+
+// delete any class, along with its members, join codes and join requests
+func (s *AdminService) DeleteClass(req service_models.AdminDeleteClassRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		class, err := store.Classes().FindByID(req.ClassID)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrClassNotFound
+			}
+			return ErrInternalServerError
+		}
+
+		if err := store.Classes().Delete(class.ID); err != nil {
+			return ErrInternalServerError
+		}
+		if err := store.ClassMembers().DeleteByClass(class.ID); err != nil {
+			return ErrInternalServerError
+		}
+		if err := store.JoinCodes().DeleteByClass(class.ID); err != nil {
+			return ErrInternalServerError
+		}
+		if err := store.JoinRequests().DeleteByClass(class.ID); err != nil {
+			return ErrInternalServerError
+		}
+
+		// keep the name, since the class itself is gone
+		details := fmt.Sprintf("class %q", class.Name)
+		if req.Reason != "" {
+			details += ": " + req.Reason
+		}
+		if err := recordAdminAction(store, req.AdminID, db_models.AdminActionDeleteClass, db_models.AdminTargetClass, class.ID, req.Audit.IPAddress, details); err != nil {
+			return err
+		}
+
+		// the class ID puts the deletion in the class's own trail
+		event := db_models.AuditEvent{ActorID: req.AdminID, Action: db_models.AuditActionAdminDeleteClass, TargetType: db_models.AuditTargetClass, TargetID: class.ID, ClassID: class.ID}
+		return recordAuditEvent(store, req.Audit, event, auditFields{"name": class.Name, "description": class.Description}, auditFields{"reason": req.Reason})
+	})
+}
+
+// list what admins have done, newest first
+func (s *AdminService) ListActions(req service_models.AdminListActionsRequest) (service_models.AdminListActionsResponse, error) {
+	limit := clampAdminPageSize(req.Limit)
+	var beforeID uint
+	if req.Cursor != "" {
+		cursor, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return service_models.AdminListActionsResponse{}, err
+		}
+		beforeID = cursor.ID
+	}
+
+	adminActions, err := s.Store.AdminActions().List(beforeID, limit+1)
+	if err != nil {
+		return service_models.AdminListActionsResponse{}, ErrInternalServerError
+	}
+
+	// build the response
+	resp := service_models.AdminListActionsResponse{
+		Actions: make([]service_models.AdminActionSummary, 0, len(adminActions)),
+	}
+	if len(adminActions) > limit {
+		adminActions = adminActions[:limit]
+		resp.NextCursor = encodeCursor(pageCursor{ID: adminActions[limit-1].ID})
+	}
+	for _, adminAction := range adminActions {
+		resp.Actions = append(resp.Actions, service_models.AdminActionSummary{
+			ID:         adminAction.ID,
+			AdminID:    adminAction.AdminID,
+			Admin:      adminAction.Admin.Username,
+			Action:     adminAction.Action,
+			TargetType: adminAction.TargetType,
+			TargetID:   adminAction.TargetID,
+			IPAddress:  adminAction.IPAddress,
+			Details:    adminAction.Details,
+			CreatedAt:  adminAction.CreatedAt,
+		})
+	}
+
+	return resp, nil
+}
+
+// helper function to find a user, mapping a missing one to ErrUserNotFound
+func findUser(store repositories.Store, userID uint) (*db_models.User, error) {
+	user, err := store.Users().FindByID(userID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, ErrInternalServerError
+	}
+	return user, nil
+}
+
+// helper function to record an admin action alongside the change it describes
+func recordAdminAction(store repositories.Store, adminID uint, action string, targetType string, targetID uint, ipAddress string, details string) error {
+	adminAction := db_models.AdminAction{
+		AdminID:    adminID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IPAddress:  ipAddress,
+		Details:    details,
+	}
+	if err := store.AdminActions().Create(&adminAction); err != nil {
+		return ErrInternalServerError
+	}
+	return nil
+}
+
+// helper function to clamp a requested page size, sharing the class list limits
+func clampAdminPageSize(limit int) int {
+	if limit <= 0 {
+		return defaultClassPageSize
+	}
+	if limit > maxClassPageSize {
+		return maxClassPageSize
+	}
+	return limit
+}
@@ -0,0 +1,332 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/mail"
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+// sign up a user and make them a platform admin
+func createTestAdmin(t *testing.T, s *AdminService, authService *AuthService, username string) *db_models.User {
+	t.Helper()
+
+	signUpTestUser(t, authService, username)
+	admin, _ := s.Store.Users().FindByUsername(username)
+	admin.EmailVerified = true
+	if err := s.Store.Users().Update(admin); err != nil {
+		t.Fatalf("failed to verify email: %v", err)
+	}
+	if err := s.PromotePlatformAdmins([]string{admin.Email, "nobody@example.com"}); err != nil {
+		t.Fatalf("PromotePlatformAdmins returned %v", err)
+	}
+	admin, _ = s.Store.Users().FindByUsername(username)
+	return admin
+}
+
+func TestPromotePlatformAdminsRequiresVerifiedEmail(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	authService := newTestAuthService(store)
+	s := NewAdminService(store, mail.NewLogMailer(&bytes.Buffer{}))
+	signUpTestUser(t, authService, "alice")
+	alice, _ := store.Users().FindByUsername("alice")
+
+	// anyone could have signed up with the email, so it isn't trusted until verified
+	if err := s.PromotePlatformAdmins([]string{"alice@example.com"}); err != nil {
+		t.Fatalf("PromotePlatformAdmins returned %v", err)
+	}
+	if ok, _ := s.IsPlatformAdmin(alice.ID); ok {
+		t.Fatal("expected an unverified email not to be promoted")
+	}
+
+	alice.EmailVerified = true
+	store.Users().Update(alice)
+	if err := s.PromotePlatformAdmins([]string{"alice@example.com"}); err != nil {
+		t.Fatalf("PromotePlatformAdmins returned %v", err)
+	}
+	if ok, _ := s.IsPlatformAdmin(alice.ID); !ok {
+		t.Fatal("expected a verified email to be promoted")
+	}
+
+	// the promotion is in the audit log
+	res, err := s.ListAuditEvents(service_models.AdminListAuditEventsRequest{Action: db_models.AuditActionPromotePlatformAdmin})
+	if err != nil || len(res.Events) != 1 || res.Events[0].TargetID != alice.ID || res.Events[0].After != `{"role":"admin"}` {
+		t.Fatalf("unexpected events %+v, %v", res.Events, err)
+	}
+}
+
+func TestAdminDisableAndEnableUser(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	authService := newTestAuthService(store)
+	s := NewAdminService(store, mail.NewLogMailer(&bytes.Buffer{}))
+	admin := createTestAdmin(t, s, authService, "root")
+	signUpTestUser(t, authService, "alice")
+	alice, _ := store.Users().FindByUsername("alice")
+
+	if ok, _ := s.IsPlatformAdmin(admin.ID); !ok {
+		t.Fatal("expected the promoted user to be an admin")
+	}
+	if ok, _ := s.IsPlatformAdmin(alice.ID); ok {
+		t.Fatal("expected a regular user not to be an admin")
+	}
+
+	session, err := authService.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("SignIn returned %v", err)
+	}
+
+	// disabling signs the user out and refuses sign in
+	req := service_models.AdminUserActionRequest{AdminID: admin.ID, UserID: alice.ID, Reason: "spam", Audit: service_models.AuditContext{IPAddress: "203.0.113.7"}}
+	if err := s.DisableUser(req); err != nil {
+		t.Fatalf("DisableUser returned %v", err)
+	}
+	if err := s.DisableUser(req); !errors.Is(err, ErrAccountAlreadyDisabled) {
+		t.Fatalf("expected ErrAccountAlreadyDisabled, got %v", err)
+	}
+	if _, err := authService.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"}); !errors.Is(err, ErrAccountDisabled) {
+		t.Fatalf("expected ErrAccountDisabled, got %v", err)
+	}
+	if _, err := authService.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: session.RefreshToken}); err == nil {
+		t.Fatal("expected the session to be revoked")
+	}
+
+	// a wrong password still just looks wrong
+	if _, err := authService.SignIn(service_models.SignInRequest{Username: "alice", Password: "wrong"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	if err := s.EnableUser(req); err != nil {
+		t.Fatalf("EnableUser returned %v", err)
+	}
+	if err := s.EnableUser(req); !errors.Is(err, ErrAccountNotDisabled) {
+		t.Fatalf("expected ErrAccountNotDisabled, got %v", err)
+	}
+	if _, err := authService.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"}); err != nil {
+		t.Fatalf("expected sign in to work again, got %v", err)
+	}
+
+	// admins can't lock themselves out
+	if err := s.DisableUser(service_models.AdminUserActionRequest{AdminID: admin.ID, UserID: admin.ID}); !errors.Is(err, ErrCannotTargetSelf) {
+		t.Fatalf("expected ErrCannotTargetSelf, got %v", err)
+	}
+
+	// both actions were recorded, newest first
+	actions, err := s.ListActions(service_models.AdminListActionsRequest{})
+	if err != nil {
+		t.Fatalf("ListActions returned %v", err)
+	}
+	if len(actions.Actions) != 2 || actions.Actions[0].Action != db_models.AdminActionEnableUser || actions.Actions[1].Action != db_models.AdminActionDisableUser {
+		t.Fatalf("unexpected actions %+v", actions.Actions)
+	}
+	if recorded := actions.Actions[1]; recorded.Admin != "root" || recorded.TargetID != alice.ID || recorded.Details != "spam" || recorded.IPAddress != "203.0.113.7" {
+		t.Fatalf("unexpected action %+v", recorded)
+	}
+}
+
+func TestAdminForcePasswordReset(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	var sent bytes.Buffer
+	authService := NewAuthService(store, mail.NewLogMailer(&sent))
+	s := NewAdminService(store, mail.NewLogMailer(&sent))
+	admin := createTestAdmin(t, s, authService, "root")
+	signUpTestUser(t, authService, "alice")
+	alice, _ := store.Users().FindByUsername("alice")
+	sent.Reset()
+
+	if err := s.ForcePasswordReset(service_models.AdminUserActionRequest{AdminID: admin.ID, UserID: alice.ID}); err != nil {
+		t.Fatalf("ForcePasswordReset returned %v", err)
+	}
+	if _, err := authService.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"}); !errors.Is(err, ErrPasswordReset) {
+		t.Fatalf("expected ErrPasswordReset, got %v", err)
+	}
+
+	// the emailed link clears the requirement
+	token := tokenFromMail(t, &sent)
+	if err := authService.ResetPassword(service_models.ResetPasswordRequest{Token: token, NewPassword: "a new password"}); err != nil {
+		t.Fatalf("ResetPassword returned %v", err)
+	}
+	if _, err := authService.SignIn(service_models.SignInRequest{Username: "alice", Password: "a new password"}); err != nil {
+		t.Fatalf("expected sign in with the new password, got %v", err)
+	}
+}
+
+func TestAdminListAndDeleteClasses(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	authService := newTestAuthService(store)
+	s := NewAdminService(store, mail.NewLogMailer(&bytes.Buffer{}))
+	classes := NewClassService(store)
+	admin := createTestAdmin(t, s, authService, "root")
+	owner := createTestUser(t, store, "owner")
+	student := createTestUser(t, store, "student")
+	createTestClass(t, classes, owner, "Algebra")
+	spam := createTestClass(t, classes, owner, "Buy cheap watches")
+	joinTestClass(t, classes, spam, owner, student)
+
+	// pages continue from the cursor
+	page, err := s.ListClasses(service_models.AdminListClassesRequest{Limit: 1})
+	if err != nil || len(page.Classes) != 1 || page.Classes[0].Name != "Algebra" || page.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v %v", page, err)
+	}
+	page, err = s.ListClasses(service_models.AdminListClassesRequest{Cursor: page.NextCursor, Limit: 1})
+	if err != nil || len(page.Classes) != 1 || page.Classes[0].Name != "Buy cheap watches" || page.Classes[0].MemberCount != 2 {
+		t.Fatalf("unexpected second page %+v %v", page, err)
+	}
+
+	if err := s.DeleteClass(service_models.AdminDeleteClassRequest{AdminID: admin.ID, ClassID: spam.ID, Reason: "spam"}); err != nil {
+		t.Fatalf("DeleteClass returned %v", err)
+	}
+	if _, err := store.Classes().FindByID(spam.ID); !errors.Is(err, repositories.ErrNotFound) {
+		t.Fatalf("expected the class to be deleted, got %v", err)
+	}
+	if _, err := store.ClassMembers().Find(spam.ID, student.ID); !errors.Is(err, repositories.ErrNotFound) {
+		t.Fatalf("expected the members to be removed, got %v", err)
+	}
+	if err := s.DeleteClass(service_models.AdminDeleteClassRequest{AdminID: admin.ID, ClassID: spam.ID}); !errors.Is(err, ErrClassNotFound) {
+		t.Fatalf("expected ErrClassNotFound, got %v", err)
+	}
+
+	actions, _ := s.ListActions(service_models.AdminListActionsRequest{})
+	if len(actions.Actions) != 1 || actions.Actions[0].Details != `class "Buy cheap watches": spam` {
+		t.Fatalf("unexpected actions %+v", actions.Actions)
+	}
+}
+
+func TestAdminListUsers(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewAdminService(store, mail.NewLogMailer(&bytes.Buffer{}))
+	for _, username := range []string{"alice", "bob", "alicia"} {
+		createTestUser(t, store, username)
+	}
+
+	res, err := s.ListUsers(service_models.AdminListUsersRequest{Query: "ali"})
+	if err != nil {
+		t.Fatalf("ListUsers returned %v", err)
+	}
+	if len(res.Users) != 2 || res.Users[0].Username != "alice" || res.Users[1].Username != "alicia" || res.NextCursor != "" {
+		t.Fatalf("unexpected users %+v", res)
+	}
+
+	if _, err := s.ListUsers(service_models.AdminListUsersRequest{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestAdminImpersonateUser(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	authService := newTestAuthService(store)
+	s := NewAdminService(store, mail.NewLogMailer(&bytes.Buffer{}))
+	admin := createTestAdmin(t, s, authService, "root")
+	other := createTestAdmin(t, s, authService, "other")
+	signUpTestUser(t, authService, "alice")
+	alice, _ := store.Users().FindByUsername("alice")
+
+	res, err := s.ImpersonateUser(service_models.AdminImpersonateRequest{AdminID: admin.ID, UserID: alice.ID, Reason: "ticket 42"})
+	if err != nil {
+		t.Fatalf("ImpersonateUser returned %v", err)
+	}
+	if remaining := time.Until(res.ExpiresAt); remaining <= 0 || remaining > 30*time.Minute {
+		t.Fatalf("unexpected expiry %v", res.ExpiresAt)
+	}
+
+	// the token names both the user and the admin, and can't change the account
+	principal, err := auth.ParseAccessToken(res.AccessToken)
+	if err != nil {
+		t.Fatalf("ParseAccessToken returned %v", err)
+	}
+	if principal.UserID != alice.ID || principal.Username != "alice" || !principal.IsImpersonated() || principal.IsSession() {
+		t.Fatalf("unexpected principal %+v", principal)
+	}
+	if principal.Actor.UserID != admin.ID || principal.Actor.Username != "root" {
+		t.Fatalf("unexpected actor %+v", principal.Actor)
+	}
+	if !principal.HasScope(auth.ScopeClassesWrite) || principal.HasScope(auth.ScopeUserWrite) {
+		t.Fatalf("unexpected scopes %v", principal.Scopes)
+	}
+
+	if _, err := s.ImpersonateUser(service_models.AdminImpersonateRequest{AdminID: admin.ID, UserID: other.ID}); !errors.Is(err, ErrCannotImpersonateAdmin) {
+		t.Fatalf("expected ErrCannotImpersonateAdmin, got %v", err)
+	}
+	if _, err := s.ImpersonateUser(service_models.AdminImpersonateRequest{AdminID: admin.ID, UserID: admin.ID}); !errors.Is(err, ErrCannotTargetSelf) {
+		t.Fatalf("expected ErrCannotTargetSelf, got %v", err)
+	}
+
+	// the start of the impersonation and the requests made with it are recorded
+	if err := s.RecordImpersonatedRequest(service_models.RecordImpersonatedRequestRequest{AdminID: admin.ID, UserID: alice.ID, Method: "GET", Path: "/classes", Status: 200}); err != nil {
+		t.Fatalf("RecordImpersonatedRequest returned %v", err)
+	}
+	actions, _ := s.ListActions(service_models.AdminListActionsRequest{})
+	if len(actions.Actions) != 2 || actions.Actions[1].Action != db_models.AdminActionImpersonateUser || actions.Actions[1].Details != "ticket 42" {
+		t.Fatalf("unexpected actions %+v", actions.Actions)
+	}
+	if recorded := actions.Actions[0]; recorded.Action != db_models.AdminActionImpersonatedRequest || recorded.Details != "GET /classes 200" {
+		t.Fatalf("unexpected action %+v", recorded)
+	}
+}
+
+func TestEndImpersonation(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	authService := newTestAuthService(store)
+	s := NewAdminService(store, mail.NewLogMailer(&bytes.Buffer{}))
+	admin := createTestAdmin(t, s, authService, "root")
+	other := createTestAdmin(t, s, authService, "other")
+	signUpTestUser(t, authService, "alice")
+	alice, _ := store.Users().FindByUsername("alice")
+
+	impersonate := func() *auth.Principal {
+		res, err := s.ImpersonateUser(service_models.AdminImpersonateRequest{AdminID: admin.ID, UserID: alice.ID})
+		if err != nil {
+			t.Fatalf("ImpersonateUser returned %v", err)
+		}
+		principal, err := auth.ParseAccessToken(res.AccessToken)
+		if err != nil || principal.Actor.ImpersonationID != res.ImpersonationID {
+			t.Fatalf("expected the token to name the impersonation, got %+v, %v", principal, err)
+		}
+		return principal
+	}
+	authenticate := func(principal *auth.Principal) error {
+		return authService.AuthenticateAccessToken(service_models.AuthenticateAccessTokenRequest{
+			UserID:          principal.UserID,
+			ActorID:         principal.Actor.UserID,
+			ImpersonationID: principal.Actor.ImpersonationID,
+		})
+	}
+
+	principal := impersonate()
+	if err := authenticate(principal); err != nil {
+		t.Fatalf("expected the impersonation to work, got %v", err)
+	}
+
+	// another admin can end it, after which the token is refused
+	if err := s.EndImpersonation(service_models.AdminEndImpersonationRequest{AdminID: other.ID, ImpersonationID: principal.Actor.ImpersonationID, Reason: "not approved"}); err != nil {
+		t.Fatalf("EndImpersonation returned %v", err)
+	}
+	if err := authenticate(principal); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected an ended impersonation to be refused, got %v", err)
+	}
+	if err := s.EndImpersonation(service_models.AdminEndImpersonationRequest{AdminID: admin.ID, ImpersonationID: principal.Actor.ImpersonationID}); !errors.Is(err, ErrImpersonationEnded) {
+		t.Fatalf("expected ErrImpersonationEnded, got %v", err)
+	}
+	if err := s.EndImpersonation(service_models.AdminEndImpersonationRequest{AdminID: admin.ID, ImpersonationID: 999}); !errors.Is(err, ErrImpersonationNotFound) {
+		t.Fatalf("expected ErrImpersonationNotFound, got %v", err)
+	}
+	actions, _ := s.ListActions(service_models.AdminListActionsRequest{})
+	if ended := actions.Actions[0]; ended.Action != db_models.AdminActionEndImpersonation || ended.AdminID != other.ID || ended.TargetID != alice.ID || ended.Details != "not approved" {
+		t.Fatalf("unexpected action %+v", ended)
+	}
+
+	// a token whose admin has lost the role is refused too
+	principal = impersonate()
+	admin.Role = db_models.PlatformRoleUser
+	if err := store.Users().Update(admin); err != nil {
+		t.Fatalf("failed to demote admin: %v", err)
+	}
+	if err := authenticate(principal); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected a demoted admin's impersonation to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+// default time audit events are kept for
+const DefaultAuditRetention = 365 * 24 * time.Hour
+
+// fields an action changed, keyed by name
+type auditFields map[string]interface{}
+
+// helper function to append an event to the audit log alongside the change it describes; fields with the same value before and after are left out
+func recordAuditEvent(store repositories.Store, audit service_models.AuditContext, event db_models.AuditEvent, before auditFields, after auditFields) error {
+	changedBefore, changedAfter := auditFields{}, auditFields{}
+	for name, value := range before {
+		if other, ok := after[name]; !ok || fmt.Sprint(other) != fmt.Sprint(value) {
+			changedBefore[name] = value
+		}
+	}
+	for name, value := range after {
+		if other, ok := before[name]; !ok || fmt.Sprint(other) != fmt.Sprint(value) {
+			changedAfter[name] = value
+		}
+	}
+
+	event.ImpersonatorID = audit.ImpersonatorID
+	event.IPAddress = audit.IPAddress
+	event.RequestID = audit.RequestID
+	event.Before = encodeAuditFields(changedBefore)
+	event.After = encodeAuditFields(changedAfter)
+	if err := store.AuditEvents().Create(&event); err != nil {
+		return ErrInternalServerError
+	}
+	return nil
+}
+
+func encodeAuditFields(fields auditFields) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	bytes, _ := json.Marshal(fields)
+	return string(bytes)
+}
+
+// helper function to list one page of audit events, newest first
+func listAuditEvents(store repositories.Store, query repositories.AuditEventQuery, cursor string, limit int) (service_models.ListAuditEventsResponse, error) {
+	limit = clampAdminPageSize(limit)
+	if cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil {
+			return service_models.ListAuditEventsResponse{}, err
+		}
+		query.BeforeID = c.ID
+	}
+
+	// fetch one extra row to know if there is another page
+	query.Limit = limit + 1
+	auditEvents, err := store.AuditEvents().List(query)
+	if err != nil {
+		return service_models.ListAuditEventsResponse{}, ErrInternalServerError
+	}
+
+	// build the response
+	resp := service_models.ListAuditEventsResponse{
+		Events: make([]service_models.AuditEventSummary, 0, len(auditEvents)),
+	}
+	if len(auditEvents) > limit {
+		auditEvents = auditEvents[:limit]
+		resp.NextCursor = encodeCursor(pageCursor{ID: auditEvents[limit-1].ID})
+	}
+	for _, auditEvent := range auditEvents {
+		resp.Events = append(resp.Events, service_models.AuditEventSummary{
+			ID:             auditEvent.ID,
+			ActorID:        auditEvent.ActorID,
+			ImpersonatorID: auditEvent.ImpersonatorID,
+			Action:         auditEvent.Action,
+			TargetType:     auditEvent.TargetType,
+			TargetID:       auditEvent.TargetID,
+			ClassID:        auditEvent.ClassID,
+			Before:         auditEvent.Before,
+			After:          auditEvent.After,
+			IPAddress:      auditEvent.IPAddress,
+			RequestID:      auditEvent.RequestID,
+			CreatedAt:      auditEvent.CreatedAt,
+		})
+	}
+
+	return resp, nil
+}
+
+// list the whole audit log for a platform admin
+func (s *AdminService) ListAuditEvents(req service_models.AdminListAuditEventsRequest) (service_models.ListAuditEventsResponse, error) {
+	query := repositories.AuditEventQuery{
+		ActorID:    req.ActorID,
+		Action:     req.Action,
+		TargetType: req.TargetType,
+		TargetID:   req.TargetID,
+		ClassID:    req.ClassID,
+	}
+	return listAuditEvents(s.Store, query, req.Cursor, req.Limit)
+}
+
+// delete the audit events older than the retention period, returning how many were deleted
+func (s *AdminService) PruneAuditEvents(retention time.Duration) (int64, error) {
+	if retention <= 0 {
+		return 0, nil
+	}
+	deleted, err := s.Store.AuditEvents().DeleteCreatedBefore(time.Now().Add(-retention))
+	if err != nil {
+		return 0, ErrInternalServerError
+	}
+	return deleted, nil
+}
+
+// list the audit log of a class for one of its admins; IP addresses and impersonators are only shown to platform admins
+func (s *ClassService) ListAuditEvents(req service_models.ListClassAuditEventsRequest) (service_models.ListClassAuditEventsResponse, error) {
+	if err := s.requireClassAdmin(s.Store, req.ClassID, req.UserID); err != nil {
+		return service_models.ListClassAuditEventsResponse{}, err
+	}
+
+	query := repositories.AuditEventQuery{
+		ActorID: req.ActorID,
+		Action:  req.Action,
+		ClassID: req.ClassID,
+	}
+	page, err := listAuditEvents(s.Store, query, req.Cursor, req.Limit)
+	if err != nil {
+		return service_models.ListClassAuditEventsResponse{}, err
+	}
+
+	// build the response
+	resp := service_models.ListClassAuditEventsResponse{
+		Events:     make([]service_models.ClassAuditEventSummary, 0, len(page.Events)),
+		NextCursor: page.NextCursor,
+	}
+	for _, event := range page.Events {
+		resp.Events = append(resp.Events, service_models.ClassAuditEventSummary{
+			ID:         event.ID,
+			ActorID:    event.ActorID,
+			Action:     event.Action,
+			TargetType: event.TargetType,
+			TargetID:   event.TargetID,
+			Before:     event.Before,
+			After:      event.After,
+			RequestID:  event.RequestID,
+			CreatedAt:  event.CreatedAt,
+		})
+	}
+
+	return resp, nil
+}
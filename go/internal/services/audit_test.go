@@ -0,0 +1,251 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/mail"
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+func TestClassChangesAreAudited(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	student := createTestUser(t, store, "student")
+	class := createTestClass(t, s, owner, "Piano")
+	joinTestClass(t, s, class, owner, student)
+
+	audit := service_models.AuditContext{IPAddress: "203.0.113.7", RequestID: "req-1", ImpersonatorID: 42}
+	if err := s.UpdateClass(service_models.UpdateClassRequest{ClassID: class.ID, UserID: owner.ID, Name: "Guitar", Audit: audit}); err != nil {
+		t.Fatalf("UpdateClass returned %v", err)
+	}
+	if err := s.UpdateMemberRole(service_models.UpdateMemberRoleRequest{ClassID: class.ID, UserID: owner.ID, MemberID: student.ID, Role: "admin"}); err != nil {
+		t.Fatalf("UpdateMemberRole returned %v", err)
+	}
+
+	res, err := s.ListAuditEvents(service_models.ListClassAuditEventsRequest{ClassID: class.ID, UserID: owner.ID})
+	if err != nil {
+		t.Fatalf("ListAuditEvents returned %v", err)
+	}
+	var actions []string
+	for _, event := range res.Events {
+		actions = append(actions, event.Action)
+	}
+	want := []string{
+		db_models.AuditActionUpdateMemberRole,
+		db_models.AuditActionUpdateClass,
+		db_models.AuditActionJoinClass,
+		db_models.AuditActionGenerateJoinCode,
+		db_models.AuditActionCreateClass,
+	}
+	if len(actions) != len(want) {
+		t.Fatalf("expected %v, got %v", want, actions)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, actions)
+		}
+	}
+
+	// only the changed fields are kept, along with who made the request and where from
+	role, update := res.Events[0], res.Events[1]
+	if role.Before != `{"role":"user"}` || role.After != `{"role":"admin"}` || role.TargetID != student.ID {
+		t.Fatalf("unexpected role change %+v", role)
+	}
+	if update.Before != `{"name":"Piano"}` || update.After != `{"name":"Guitar"}` {
+		t.Fatalf("unexpected class update %+v", update)
+	}
+	if update.ActorID != owner.ID || update.RequestID != "req-1" {
+		t.Fatalf("unexpected request details %+v", update)
+	}
+
+	// where the request came from and who was impersonating are kept for platform admins only
+	admin := NewAdminService(store, mail.NewLogMailer(&bytes.Buffer{}))
+	recorded, err := admin.ListAuditEvents(service_models.AdminListAuditEventsRequest{ClassID: class.ID, Action: db_models.AuditActionUpdateClass})
+	if err != nil || len(recorded.Events) != 1 {
+		t.Fatalf("unexpected admin events %+v, %v", recorded, err)
+	}
+	if recorded.Events[0].ImpersonatorID != 42 || recorded.Events[0].IPAddress != "203.0.113.7" {
+		t.Fatalf("unexpected request details %+v", recorded.Events[0])
+	}
+
+	// filters and paging
+	filtered, err := s.ListAuditEvents(service_models.ListClassAuditEventsRequest{ClassID: class.ID, UserID: owner.ID, ActorID: student.ID})
+	if err != nil || len(filtered.Events) != 1 || filtered.Events[0].Action != db_models.AuditActionJoinClass {
+		t.Fatalf("unexpected filtered events %+v, %v", filtered.Events, err)
+	}
+	page, err := s.ListAuditEvents(service_models.ListClassAuditEventsRequest{ClassID: class.ID, UserID: owner.ID, Limit: 3})
+	if err != nil || len(page.Events) != 3 || page.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v, %v", page, err)
+	}
+	page, err = s.ListAuditEvents(service_models.ListClassAuditEventsRequest{ClassID: class.ID, UserID: owner.ID, Limit: 3, Cursor: page.NextCursor})
+	if err != nil || len(page.Events) != 2 || page.NextCursor != "" || page.Events[0].Action != db_models.AuditActionGenerateJoinCode {
+		t.Fatalf("unexpected second page %+v, %v", page, err)
+	}
+}
+
+func TestClassAuditEventsRequireClassAdmin(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	student := createTestUser(t, store, "student")
+	class := createTestClass(t, s, owner, "Piano")
+	joinTestClass(t, s, class, owner, student)
+
+	_, err := s.ListAuditEvents(service_models.ListClassAuditEventsRequest{ClassID: class.ID, UserID: student.ID})
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+
+	// once the class is gone only platform admins can see its history
+	if err := s.DeleteClass(service_models.DeleteClassRequest{ClassID: class.ID, UserID: owner.ID}); err != nil {
+		t.Fatalf("DeleteClass returned %v", err)
+	}
+	if _, err := s.ListAuditEvents(service_models.ListClassAuditEventsRequest{ClassID: class.ID, UserID: owner.ID}); !errors.Is(err, ErrClassNotFound) {
+		t.Fatalf("expected ErrClassNotFound, got %v", err)
+	}
+	admin := NewAdminService(store, mail.NewLogMailer(&bytes.Buffer{}))
+	res, err := admin.ListAuditEvents(service_models.AdminListAuditEventsRequest{ClassID: class.ID, Action: db_models.AuditActionDeleteClass})
+	if err != nil || len(res.Events) != 1 || res.Events[0].Before != `{"description":"","name":"Piano"}` {
+		t.Fatalf("unexpected events %+v, %v", res.Events, err)
+	}
+}
+
+func TestAccountChangesAreAudited(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	authService := newTestAuthService(store)
+	users := NewUserService(store, authService.Mailer)
+	signUpTestUser(t, authService, "alice")
+	alice, _ := store.Users().FindByUsername("alice")
+
+	if err := users.UpdateUser(service_models.UpdateUserRequest{UserID: alice.ID, Username: "alicia", Email: alice.Email}); err != nil {
+		t.Fatalf("UpdateUser returned %v", err)
+	}
+	if err := authService.LogoutAll(service_models.LogoutAllRequest{UserID: alice.ID}); err != nil {
+		t.Fatalf("LogoutAll returned %v", err)
+	}
+
+	admin := NewAdminService(store, authService.Mailer)
+	res, err := admin.ListAuditEvents(service_models.AdminListAuditEventsRequest{ActorID: alice.ID})
+	if err != nil || len(res.Events) != 3 {
+		t.Fatalf("unexpected events %+v, %v", res.Events, err)
+	}
+	if res.Events[0].Action != db_models.AuditActionRevokeAllSessions || res.Events[2].Action != db_models.AuditActionSignUp {
+		t.Fatalf("unexpected events %+v", res.Events)
+	}
+	if update := res.Events[1]; update.Before != `{"username":"alice"}` || update.After != `{"username":"alicia"}` {
+		t.Fatalf("unexpected update %+v", update)
+	}
+}
+
+func TestAdminActionsAreAudited(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	authService := newTestAuthService(store)
+	admin := NewAdminService(store, authService.Mailer)
+	root := createTestAdmin(t, admin, authService, "root")
+	signUpTestUser(t, authService, "alice")
+	alice, _ := store.Users().FindByUsername("alice")
+	classes := NewClassService(store)
+	class := createTestClass(t, classes, *alice, "Piano")
+
+	audit := service_models.AuditContext{IPAddress: "203.0.113.7", RequestID: "req-1"}
+	userReq := service_models.AdminUserActionRequest{AdminID: root.ID, UserID: alice.ID, Reason: "spam", Audit: audit}
+	if err := admin.DisableUser(userReq); err != nil {
+		t.Fatalf("DisableUser returned %v", err)
+	}
+	if err := admin.EnableUser(userReq); err != nil {
+		t.Fatalf("EnableUser returned %v", err)
+	}
+	impersonation, err := admin.ImpersonateUser(service_models.AdminImpersonateRequest{AdminID: root.ID, UserID: alice.ID, Audit: audit})
+	if err != nil {
+		t.Fatalf("ImpersonateUser returned %v", err)
+	}
+	if err := admin.EndImpersonation(service_models.AdminEndImpersonationRequest{AdminID: root.ID, ImpersonationID: impersonation.ImpersonationID, Audit: audit}); err != nil {
+		t.Fatalf("EndImpersonation returned %v", err)
+	}
+	if err := admin.ForcePasswordReset(userReq); err != nil {
+		t.Fatalf("ForcePasswordReset returned %v", err)
+	}
+	if err := admin.DeleteClass(service_models.AdminDeleteClassRequest{AdminID: root.ID, ClassID: class.ID, Reason: "abuse", Audit: audit}); err != nil {
+		t.Fatalf("DeleteClass returned %v", err)
+	}
+
+	// every action shows up in the audit log, attributed to the admin
+	res, err := admin.ListAuditEvents(service_models.AdminListAuditEventsRequest{ActorID: root.ID})
+	if err != nil {
+		t.Fatalf("ListAuditEvents returned %v", err)
+	}
+	want := []string{
+		db_models.AuditActionAdminDeleteClass,
+		db_models.AuditActionAdminForcePasswordReset,
+		db_models.AuditActionAdminEndImpersonation,
+		db_models.AuditActionAdminImpersonateUser,
+		db_models.AuditActionAdminEnableUser,
+		db_models.AuditActionAdminDisableUser,
+	}
+	if len(res.Events) < len(want) {
+		t.Fatalf("expected at least %d events, got %+v", len(want), res.Events)
+	}
+	for i, action := range want {
+		if event := res.Events[i]; event.Action != action || event.IPAddress != "203.0.113.7" || event.RequestID != "req-1" {
+			t.Fatalf("expected event %d to be %s, got %+v", i, action, event)
+		}
+	}
+	if disable := res.Events[5]; disable.TargetID != alice.ID || disable.Before != `{"disabled":false}` || disable.After != `{"disabled":true,"reason":"spam"}` {
+		t.Fatalf("unexpected disable event %+v", disable)
+	}
+
+	// the deletion is part of the class's own trail
+	res, err = admin.ListAuditEvents(service_models.AdminListAuditEventsRequest{ClassID: class.ID, Action: db_models.AuditActionAdminDeleteClass})
+	if err != nil || len(res.Events) != 1 || res.Events[0].Before != `{"description":"","name":"Piano"}` || res.Events[0].After != `{"reason":"abuse"}` {
+		t.Fatalf("unexpected events %+v, %v", res.Events, err)
+	}
+}
+
+func TestAuditEventRollsBackWithTheChange(t *testing.T) {
+	db := newTestDB(t)
+	s := NewClassService(repositories.NewGormStore(db))
+	owner := createTestUser(t, s.Store, "teacher")
+	class := createTestClass(t, s, owner, "Piano")
+
+	// a change that can't be audited doesn't happen
+	failOn(t, db, "create", "AuditEvent")
+	if err := s.UpdateClass(service_models.UpdateClassRequest{ClassID: class.ID, UserID: owner.ID, Name: "Guitar"}); err == nil {
+		t.Fatal("expected UpdateClass to fail")
+	}
+	if n := countRows(t, db, &db_models.Class{}, "name = ?", "Piano"); n != 1 {
+		t.Fatalf("expected the class to keep its name, found %d", n)
+	}
+}
+
+func TestPruneAuditEvents(t *testing.T) {
+	db := newTestDB(t)
+	store := repositories.NewGormStore(db)
+	s := NewAdminService(store, mail.NewLogMailer(&bytes.Buffer{}))
+	for _, age := range []time.Duration{48 * time.Hour, time.Hour} {
+		event := db_models.AuditEvent{ActorID: 1, Action: db_models.AuditActionCreateClass, TargetType: db_models.AuditTargetClass, TargetID: 1}
+		event.CreatedAt = time.Now().Add(-age)
+		if err := store.AuditEvents().Create(&event); err != nil {
+			t.Fatalf("failed to create audit event: %v", err)
+		}
+	}
+
+	deleted, err := s.PruneAuditEvents(24 * time.Hour)
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected 1 event to be pruned, got %d, %v", deleted, err)
+	}
+	// pruned events are gone for good rather than soft deleted
+	if n := countRows(t, db.Unscoped(), &db_models.AuditEvent{}, "1 = 1"); n != 1 {
+		t.Fatalf("expected 1 event to be left, found %d", n)
+	}
+
+	// a zero retention keeps everything
+	if deleted, err := s.PruneAuditEvents(0); err != nil || deleted != 0 {
+		t.Fatalf("expected nothing to be pruned, got %d, %v", deleted, err)
+	}
+}
@@ -2,13 +2,19 @@ package services
 
 import (
 	"errors"
+	"fmt"
+	"net/url"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/config"
+	"github.com/hawkerd/privateinstruction/internal/mail"
 	"github.com/hawkerd/privateinstruction/internal/models/db_models"
 	"github.com/hawkerd/privateinstruction/internal/models/service_models"
-	"gorm.io/gorm"
+	"github.com/hawkerd/privateinstruction/internal/oidc"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
 )
 
 // define custom error messages
@@ -17,16 +23,39 @@ var (
 	ErrInvalidCredentials  = errors.New("Invalid credentials")
 	ErrTokenGeneration     = errors.New("failed to generate token")
 	ErrInternalServerError = errors.New("Something went wrong")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+	ErrInvalidResetToken   = errors.New("Invalid or expired reset link")
+	ErrInvalidVerification = errors.New("Invalid or expired verification link")
+	ErrAlreadyVerified     = errors.New("Email is already verified")
+	ErrAccountDisabled     = errors.New("This account has been disabled")
+	ErrPasswordReset       = errors.New("Your password must be reset before signing in; check your email for a link")
 )
 
+// how long a rotated refresh token is rejected without revoking its family, so concurrent refreshes aren't mistaken for theft
+const refreshTokenReuseGrace = 10 * time.Second
+
 type AuthService struct {
-	DB *gorm.DB
+	Store  repositories.Store
+	Mailer mail.Mailer
+	// OIDC providers users can sign in with, keyed by name
+	OIDCProviders map[string]*oidc.Provider
+	// how failed sign ins slow down further attempts
+	AccountThrottle ThrottlePolicy
+	IPThrottle      ThrottlePolicy
+	// rules new passwords must follow
+	PasswordPolicy auth.PasswordPolicy
 }
 
 // create and return a new AuthService instance
-func NewAuthService(db *gorm.DB) *AuthService {
+func NewAuthService(store repositories.Store, mailer mail.Mailer) *AuthService {
 	return &AuthService{
-		DB: db,
+		Store:           store,
+		Mailer:          mailer,
+		OIDCProviders:   map[string]*oidc.Provider{},
+		AccountThrottle: DefaultAccountThrottle,
+		IPThrottle:      DefaultIPThrottle,
+		PasswordPolicy:  auth.DefaultPasswordPolicy,
 	}
 }
 
@@ -36,188 +65,755 @@ func (s *AuthService) SignUp(req service_models.SignUpRequest) error {
 	req.Username = strings.TrimSpace(req.Username)
 	req.Email = strings.TrimSpace(strings.ToLower(req.Email))
 
-	// check if the user already exists (email or username)
-	var existingUser db_models.User
-	err := s.DB.Where("email = ? OR username = ?", req.Email, req.Username).First(&existingUser).Error
+	return s.Store.Do(func(store repositories.Store) error {
+		// check if the user already exists (email or username)
+		_, err := store.Users().FindByUsernameOrEmail(req.Username, req.Email)
 
-	// if the user exists (no error), return
-	if err == nil {
-		return ErrUserExists
-	}
+		// if the user exists (no error), return
+		if err == nil {
+			return ErrUserExists
+		}
 
-	// if the error is not a record not found error, return it
-	if !errors.Is(err, gorm.ErrRecordNotFound) {
-		return ErrInternalServerError
-	}
+		// if the error is not a record not found error, return it
+		if !errors.Is(err, repositories.ErrNotFound) {
+			return ErrInternalServerError
+		}
 
-	// hash the password
-	hashedPassword, err := auth.HashPassword(req.Password)
-	if err != nil {
-		return ErrInternalServerError
-	}
+		// check the password against the policy
+		if err := s.checkPasswordPolicy(req.Password, req.Username, req.Email); err != nil {
+			return err
+		}
 
-	// create the new user
-	user := db_models.User{
-		Username:       req.Username,
-		Email:          req.Email,
-		HashedPassword: hashedPassword,
-	}
+		// hash the password
+		hashedPassword, err := auth.HashPassword(req.Password)
+		if err != nil {
+			return ErrInternalServerError
+		}
 
-	// create the user in the database
-	if err := s.DB.Create(&user).Error; err != nil {
-		return ErrInternalServerError
-	}
+		// create the new user
+		user := db_models.User{
+			Username:       req.Username,
+			Email:          req.Email,
+			HashedPassword: hashedPassword,
+		}
 
-	return nil
+		// create the user in the database
+		if err := store.Users().Create(&user); err != nil {
+			return ErrInternalServerError
+		}
+
+		event := db_models.AuditEvent{ActorID: user.ID, Action: db_models.AuditActionSignUp, TargetType: db_models.AuditTargetUser, TargetID: user.ID}
+		if err := recordAuditEvent(store, req.Audit, event, nil, auditFields{"username": user.Username, "email": user.Email}); err != nil {
+			return err
+		}
+
+		// ask the user to confirm the email
+		if err := sendVerificationEmail(store, s.Mailer, &user, user.Email); err != nil {
+			return err
+		}
+
+		return nil
+	})
 }
 
 // attempt to authenticate a user, and return a JWT token if successful
 func (s *AuthService) SignIn(req service_models.SignInRequest) (service_models.SignInResponse, error) {
 	// find the user by username or email
-	var user db_models.User
+	var user *db_models.User
+	var err error
 	if req.Username != "" {
-		if err := s.DB.Where("username = ?", req.Username).First(&user).Error; err != nil {
-			return service_models.SignInResponse{}, ErrInvalidCredentials
-		}
+		user, err = s.Store.Users().FindByUsername(req.Username)
 	} else {
-		if err := s.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
-			return service_models.SignInResponse{}, ErrInvalidCredentials
+		user, err = s.Store.Users().FindByEmail(req.Email)
+	}
+	if err != nil {
+		// unknown accounts still count against the IP address
+		if err := checkSignInThrottle(s.Store, ipThrottleKey(req.IPAddress)); err != nil {
+			return service_models.SignInResponse{}, err
+		}
+		if err := s.recordFailedSignIn(nil, req.IPAddress, req.UserAgent); err != nil {
+			return service_models.SignInResponse{}, err
 		}
+		return service_models.SignInResponse{}, ErrInvalidCredentials
+	}
+
+	// refuse to check the password while the account or IP address is locked out
+	if err := checkSignInThrottle(s.Store, accountThrottleKey(user.ID), ipThrottleKey(req.IPAddress)); err != nil {
+		return service_models.SignInResponse{}, err
 	}
 
 	// check the input password against the hashed password
 	if !auth.CheckPassword(user.HashedPassword, req.Password) {
+		if err := s.recordFailedSignIn(user, req.IPAddress, req.UserAgent); err != nil {
+			return service_models.SignInResponse{}, err
+		}
 		return service_models.SignInResponse{}, ErrInvalidCredentials
 	}
 
-	// generate a JWT token
-	accessToken, err := auth.GenerateJWT(user.ID, user.Username)
+	// only tell someone who knows the password that the account can't be used
+	if err := checkAccountStatus(user); err != nil {
+		return service_models.SignInResponse{}, err
+	}
+
+	// with two-factor enabled, the password only earns a challenge for the second factor
+	if user.TOTPEnabled {
+		mfaToken, err := auth.GenerateMFAToken(user.ID)
+		if err != nil {
+			return service_models.SignInResponse{}, ErrTokenGeneration
+		}
+		return service_models.SignInResponse{MFARequired: true, MFAToken: mfaToken}, nil
+	}
+
+	var res service_models.SignInResponse
+	err = s.Store.Do(func(store repositories.Store) error {
+		if err := resetSignInThrottle(store, accountThrottleKey(user.ID)); err != nil {
+			return err
+		}
+		res, err = startSession(store, user, req.UserAgent, req.IPAddress)
+		return err
+	})
 	if err != nil {
-		return service_models.SignInResponse{}, ErrTokenGeneration
+		return service_models.SignInResponse{}, err
 	}
 
-	// generate a refresh token and hash it
-	refreshToken, err := auth.GenerateRefreshToken()
+	return res, nil
+}
+
+// update the user's password
+func (s *AuthService) UpdatePassword(req service_models.UpdatePasswordRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		// find the user by ID
+		user, err := store.Users().FindByID(req.UserID)
+		if err != nil {
+			return ErrInvalidCredentials
+		}
+
+		// check the input password against the hashed password
+		if !auth.CheckPassword(user.HashedPassword, req.OldPassword) {
+			return ErrInvalidCredentials
+		}
+
+		// check the new password against the policy
+		if err := s.checkPasswordPolicy(req.NewPassword, user.Username, user.Email); err != nil {
+			return err
+		}
+
+		// hash the new password
+		hashedPassword, err := auth.HashPassword(req.NewPassword)
+		if err != nil {
+			return ErrInternalServerError
+		}
+
+		// update the user's password in the database
+		user.HashedPassword = hashedPassword
+		if err := store.Users().Update(user); err != nil {
+			return ErrInternalServerError
+		}
+
+		// sign out every session
+		if err := store.RefreshTokens().DeleteByUser(user.ID); err != nil {
+			return ErrInternalServerError
+		}
+
+		event := db_models.AuditEvent{ActorID: user.ID, Action: db_models.AuditActionChangePassword, TargetType: db_models.AuditTargetUser, TargetID: user.ID}
+		return recordAuditEvent(store, req.Audit, event, nil, nil)
+	})
+}
+
+// email the user a link to reset their password; unknown emails are ignored so accounts can't be discovered
+func (s *AuthService) ForgotPassword(req service_models.ForgotPasswordRequest) error {
+	// normalize input
+	req.Email = strings.TrimSpace(strings.ToLower(req.Email))
+
+	return s.Store.Do(func(store repositories.Store) error {
+		user, err := store.Users().FindByEmail(req.Email)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return nil
+			}
+			return ErrInternalServerError
+		}
+
+		return sendPasswordResetEmail(store, s.Mailer, user, func(link string) string {
+			return fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your account. "+
+				"If it was you, open the link below within the next hour:\n\n%s\n\n"+
+				"If it wasn't, you can ignore this email.", user.Username, link)
+		})
+	})
+}
+
+// set a new password using an emailed reset token, signing out every session
+func (s *AuthService) ResetPassword(req service_models.ResetPasswordRequest) error {
+	selector, verifier, err := auth.SplitToken(req.Token)
 	if err != nil {
-		return service_models.SignInResponse{}, ErrTokenGeneration
+		return ErrInvalidResetToken
 	}
-	hashedToken, err := auth.HashPassword(refreshToken)
+
+	return s.Store.Do(func(store repositories.Store) error {
+		// find the token and make sure it is still usable
+		resetToken, err := store.PasswordResetTokens().FindBySelector(selector)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrInvalidResetToken
+			}
+			return ErrInternalServerError
+		}
+		if !auth.CheckVerifier(resetToken.HashedToken, verifier) || resetToken.UsedAt != nil || time.Now().After(resetToken.ExpiresAt) {
+			return ErrInvalidResetToken
+		}
+
+		// find the user
+		user, err := store.Users().FindByID(resetToken.UserID)
+		if err != nil {
+			return ErrInvalidResetToken
+		}
+
+		// check the new password against the policy
+		if err := s.checkPasswordPolicy(req.NewPassword, user.Username, user.Email); err != nil {
+			return err
+		}
+
+		// hash and store the new password
+		hashedPassword, err := auth.HashPassword(req.NewPassword)
+		if err != nil {
+			return ErrInternalServerError
+		}
+		user.HashedPassword = hashedPassword
+		user.PasswordResetRequired = false
+		if err := store.Users().Update(user); err != nil {
+			return ErrInternalServerError
+		}
+
+		// the token can only be used once
+		now := time.Now()
+		resetToken.UsedAt = &now
+		if err := store.PasswordResetTokens().Update(resetToken); err != nil {
+			return ErrInternalServerError
+		}
+
+		// proving access to the email unlocks the account
+		if err := resetSignInThrottle(store, accountThrottleKey(user.ID)); err != nil {
+			return err
+		}
+
+		// sign out every session
+		if err := store.RefreshTokens().DeleteByUser(user.ID); err != nil {
+			return ErrInternalServerError
+		}
+
+		// the emailed token proves who is acting, so the user is the actor
+		event := db_models.AuditEvent{ActorID: user.ID, Action: db_models.AuditActionResetPassword, TargetType: db_models.AuditTargetUser, TargetID: user.ID}
+		return recordAuditEvent(store, req.Audit, event, nil, nil)
+	})
+}
+
+// confirm an email address using an emailed verification token
+func (s *AuthService) VerifyEmail(req service_models.VerifyEmailRequest) error {
+	selector, verifier, err := auth.SplitToken(req.Token)
 	if err != nil {
-		return service_models.SignInResponse{}, ErrInternalServerError
+		return ErrInvalidVerification
+	}
+
+	return s.Store.Do(func(store repositories.Store) error {
+		// find the token and make sure it is still usable
+		verificationToken, err := store.EmailVerificationTokens().FindBySelector(selector)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrInvalidVerification
+			}
+			return ErrInternalServerError
+		}
+		if !auth.CheckVerifier(verificationToken.HashedToken, verifier) || verificationToken.UsedAt != nil || time.Now().After(verificationToken.ExpiresAt) {
+			return ErrInvalidVerification
+		}
+
+		// find the user
+		user, err := store.Users().FindByID(verificationToken.UserID)
+		if err != nil {
+			return ErrInvalidVerification
+		}
+
+		before := auditFields{"email": user.Email, "email_verified": user.EmailVerified}
+		switch verificationToken.Email {
+		case user.Email:
+		case user.PendingEmail:
+			// make sure nobody took the address since the change was requested
+			existing, err := store.Users().FindByEmail(user.PendingEmail)
+			if err == nil && existing.ID != user.ID {
+				return ErrUserExists
+			}
+			if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+				return ErrInternalServerError
+			}
+
+			// switch to the new email
+			user.Email = user.PendingEmail
+			user.PendingEmail = ""
+		default:
+			// the email was changed again after this token was sent
+			return ErrInvalidVerification
+		}
+
+		// mark the email verified
+		user.EmailVerified = true
+		if err := store.Users().Update(user); err != nil {
+			return ErrInternalServerError
+		}
+
+		// the token can only be used once
+		now := time.Now()
+		verificationToken.UsedAt = &now
+		if err := store.EmailVerificationTokens().Update(verificationToken); err != nil {
+			return ErrInternalServerError
+		}
+
+		event := db_models.AuditEvent{ActorID: user.ID, Action: db_models.AuditActionVerifyEmail, TargetType: db_models.AuditTargetUser, TargetID: user.ID}
+		return recordAuditEvent(store, req.Audit, event, before, auditFields{"email": user.Email, "email_verified": user.EmailVerified})
+	})
+}
+
+// send another verification email, to the pending email if a change is waiting
+func (s *AuthService) ResendVerification(req service_models.ResendVerificationRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		user, err := store.Users().FindByID(req.UserID)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrUserNotFound
+			}
+			return ErrInternalServerError
+		}
+
+		if user.PendingEmail != "" {
+			return sendVerificationEmail(store, s.Mailer, user, user.PendingEmail)
+		}
+		if user.EmailVerified {
+			return ErrAlreadyVerified
+		}
+		return sendVerificationEmail(store, s.Mailer, user, user.Email)
+	})
+}
+
+// generate a new access token, rotating the refresh token
+func (s *AuthService) RefreshAccessToken(req service_models.RefreshTokenRequest) (service_models.RefreshTokenResponse, error) {
+	var res service_models.RefreshTokenResponse
+	reused := false
+	err := s.Store.Do(func(store repositories.Store) error {
+		// look up the refresh token
+		refreshToken, err := findRefreshToken(store, req.RefreshToken)
+		if err != nil {
+			return err
+		}
+
+		// a token that was already rotated is being presented again
+		if refreshToken.RotatedAt != nil {
+			if time.Since(*refreshToken.RotatedAt) < refreshTokenReuseGrace {
+				return ErrInvalidCredentials
+			}
+
+			// assume it was stolen and revoke every token of the family, keeping the record of it
+			if err := store.RefreshTokens().DeleteFamily(refreshToken.FamilyID); err != nil {
+				return ErrInternalServerError
+			}
+			event := db_models.SecurityEvent{
+				UserID:    refreshToken.UserID,
+				Type:      db_models.SecurityEventRefreshTokenReuse,
+				IPAddress: req.IPAddress,
+				UserAgent: req.UserAgent,
+				Details:   fmt.Sprintf("refresh token %d of session %d was used after being rotated", refreshToken.ID, refreshToken.FamilyID),
+			}
+			if err := store.SecurityEvents().Create(&event); err != nil {
+				return ErrInternalServerError
+			}
+
+			reused = true
+			return nil
+		}
+
+		// check if the refresh token is expired
+		if time.Now().After(refreshToken.ExpiresAt) {
+			return ErrInvalidCredentials
+		}
+
+		// query the user by ID
+		user, err := store.Users().FindByID(refreshToken.UserID)
+		if err != nil {
+			return ErrInvalidCredentials
+		}
+		// check if the user exists
+		if user.ID == 0 {
+			return ErrInvalidCredentials
+		}
+		if err := checkAccountStatus(user); err != nil {
+			return err
+		}
+
+		// generate a new access token
+		accessToken, err := auth.GenerateJWT(user.ID, user.Username, refreshToken.FamilyID)
+		if err != nil {
+			return ErrTokenGeneration
+		}
+
+		// generate a new refresh token
+		newRefreshToken, selector, verifier, err := auth.GenerateToken()
+		if err != nil {
+			return ErrTokenGeneration
+		}
+
+		// retire the presented token; a concurrent refresh that got there first is treated like a replay within the grace period
+		now := time.Now()
+		rotated, err := store.RefreshTokens().Rotate(refreshToken.ID, now)
+		if err != nil {
+			return ErrInternalServerError
+		}
+		if !rotated {
+			return ErrInvalidCredentials
+		}
+
+		// store its replacement in the same family
+		parentID := refreshToken.ID
+		child := db_models.RefreshToken{
+			UserID:      refreshToken.UserID,
+			Selector:    selector,
+			HashedToken: auth.HashVerifier(verifier),
+			ExpiresAt:   auth.RefreshTokenExpiration(),
+			UserAgent:   refreshToken.UserAgent,
+			IPAddress:   refreshToken.IPAddress,
+			DeviceLabel: refreshToken.DeviceLabel,
+			LastUsedAt:  now,
+			FamilyID:    refreshToken.FamilyID,
+			ParentID:    &parentID,
+		}
+		if req.UserAgent != "" {
+			child.UserAgent = req.UserAgent
+			child.DeviceLabel = auth.DeviceLabel(req.UserAgent)
+		}
+		if req.IPAddress != "" {
+			child.IPAddress = req.IPAddress
+		}
+		if err := store.RefreshTokens().Create(&child); err != nil {
+			return ErrInternalServerError
+		}
+
+		// create the response
+		res = service_models.RefreshTokenResponse{
+			AccessToken:            accessToken,
+			RefreshToken:           newRefreshToken,
+			RefreshTokenExpiration: child.ExpiresAt,
+		}
+
+		return nil
+	})
+	if err != nil {
+		return service_models.RefreshTokenResponse{}, err
+	}
+	if reused {
+		return service_models.RefreshTokenResponse{}, ErrRefreshTokenReused
+	}
+
+	return res, nil
+}
+
+// delete the sessions whose refresh tokens have all expired, returning how many tokens were deleted;
+// a rotated token is only kept to detect replays, which can't succeed once its whole session has expired anyway
+func (s *AuthService) PruneRefreshTokens() (int64, error) {
+	deleted, err := s.Store.RefreshTokens().DeleteExpired(time.Now())
+	if err != nil {
+		return 0, ErrInternalServerError
+	}
+	return deleted, nil
+}
+
+// check that the user and session an access token was issued to can still use it; the token alone stays valid until it expires, so a disabled account or revoked session has to be caught here
+func (s *AuthService) AuthenticateAccessToken(req service_models.AuthenticateAccessTokenRequest) error {
+	user, err := s.Store.Users().FindByID(req.UserID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return ErrInvalidCredentials
+		}
+		return ErrInternalServerError
+	}
+	if err := checkAccountStatus(user); err != nil {
+		return err
+	}
+
+	// signing out, revoking the session or resetting the password deletes its refresh tokens, which ends the access token too
+	if req.SessionID != 0 {
+		exists, err := s.Store.RefreshTokens().FamilyExists(req.SessionID)
+		if err != nil {
+			return ErrInternalServerError
+		}
+		if !exists {
+			return ErrInvalidCredentials
+		}
+	}
+	if req.ActorID == 0 {
+		return nil
+	}
+
+	// an impersonation stops working once it is ended or the admin behind it loses the role
+	impersonation, err := s.Store.Impersonations().FindByID(req.ImpersonationID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return ErrInvalidCredentials
+		}
+		return ErrInternalServerError
+	}
+	if impersonation.EndedAt != nil || impersonation.AdminID != req.ActorID || impersonation.UserID != req.UserID {
+		return ErrInvalidCredentials
+	}
+	actor, err := s.Store.Users().FindByID(req.ActorID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return ErrInvalidCredentials
+		}
+		return ErrInternalServerError
+	}
+	if actor.Role != db_models.PlatformRoleAdmin || actor.DisabledAt != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// revoke the refresh token of the current session
+func (s *AuthService) Logout(req service_models.LogoutRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		refreshToken, err := findRefreshToken(store, req.RefreshToken)
+		if err != nil {
+			return err
+		}
+
+		if err := store.RefreshTokens().DeleteFamily(refreshToken.FamilyID); err != nil {
+			return ErrInternalServerError
+		}
+
+		return nil
+	})
+}
+
+// revoke every refresh token of the user
+func (s *AuthService) LogoutAll(req service_models.LogoutAllRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		if err := store.RefreshTokens().DeleteByUser(req.UserID); err != nil {
+			return ErrInternalServerError
+		}
+
+		event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionRevokeAllSessions, TargetType: db_models.AuditTargetUser, TargetID: req.UserID}
+		return recordAuditEvent(store, req.Audit, event, nil, nil)
+	})
+}
+
+// list the user's active sessions, most recently used first
+func (s *AuthService) ListSessions(req service_models.ListSessionsRequest) (service_models.ListSessionsResponse, error) {
+	refreshTokens, err := s.Store.RefreshTokens().ListByUser(req.UserID)
+	if err != nil {
+		return service_models.ListSessionsResponse{}, ErrInternalServerError
+	}
+
+	// a session starts with the first token of its family
+	startedAt := map[uint]time.Time{}
+	for _, refreshToken := range refreshTokens {
+		if started, ok := startedAt[refreshToken.FamilyID]; !ok || refreshToken.CreatedAt.Before(started) {
+			startedAt[refreshToken.FamilyID] = refreshToken.CreatedAt
+		}
+	}
+
+	// build the response from the live token of each family, skipping expired sessions
+	now := time.Now()
+	sessions := []service_models.SessionSummary{}
+	for _, refreshToken := range refreshTokens {
+		if refreshToken.RotatedAt != nil || now.After(refreshToken.ExpiresAt) {
+			continue
+		}
+		sessions = append(sessions, service_models.SessionSummary{
+			ID:          refreshToken.FamilyID,
+			DeviceLabel: refreshToken.DeviceLabel,
+			UserAgent:   refreshToken.UserAgent,
+			IPAddress:   refreshToken.IPAddress,
+			CreatedAt:   startedAt[refreshToken.FamilyID],
+			LastUsedAt:  refreshToken.LastUsedAt,
+			ExpiresAt:   refreshToken.ExpiresAt,
+			Current:     refreshToken.FamilyID == req.CurrentSessionID,
+		})
+	}
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt) })
+
+	return service_models.ListSessionsResponse{Sessions: sessions}, nil
+}
+
+// revoke one of the user's sessions
+func (s *AuthService) RevokeSession(req service_models.RevokeSessionRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		// a session is identified by its family; make sure it belongs to the user
+		refreshTokens, err := store.RefreshTokens().ListByFamily(req.SessionID)
+		if err != nil {
+			return ErrInternalServerError
+		}
+		if len(refreshTokens) == 0 || refreshTokens[0].UserID != req.UserID {
+			return ErrSessionNotFound
+		}
+		refreshToken := refreshTokens[len(refreshTokens)-1]
+
+		if err := store.RefreshTokens().DeleteFamily(refreshToken.FamilyID); err != nil {
+			return ErrInternalServerError
+		}
+
+		event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionRevokeSession, TargetType: db_models.AuditTargetSession, TargetID: refreshToken.FamilyID}
+		return recordAuditEvent(store, req.Audit, event, auditFields{"device": refreshToken.DeviceLabel, "ip_address": refreshToken.IPAddress}, nil)
+	})
+}
+
+// helper function to create a session for a signed in user, returning its tokens
+func startSession(store repositories.Store, user *db_models.User, userAgent string, ipAddress string) (service_models.SignInResponse, error) {
+	if err := checkAccountStatus(user); err != nil {
+		return service_models.SignInResponse{}, err
+	}
+
+	// generate a refresh token
+	refreshToken, selector, verifier, err := auth.GenerateToken()
+	if err != nil {
+		return service_models.SignInResponse{}, ErrTokenGeneration
 	}
 	expiration := auth.RefreshTokenExpiration()
 
-	// store the refresh token in the database
+	// store the refresh token in the database, along with the device it was issued to
 	refreshTokenRecord := db_models.RefreshToken{
 		UserID:      user.ID,
-		HashedToken: hashedToken,
+		Selector:    selector,
+		HashedToken: auth.HashVerifier(verifier),
 		ExpiresAt:   expiration,
+		UserAgent:   userAgent,
+		IPAddress:   ipAddress,
+		DeviceLabel: auth.DeviceLabel(userAgent),
+		LastUsedAt:  time.Now(),
+	}
+	if err := store.RefreshTokens().Create(&refreshTokenRecord); err != nil {
+		return service_models.SignInResponse{}, ErrInternalServerError
 	}
-	if err := s.DB.Create(&refreshTokenRecord).Error; err != nil {
+
+	// the first token of a sign in starts a new family
+	refreshTokenRecord.FamilyID = refreshTokenRecord.ID
+	if err := store.RefreshTokens().Update(&refreshTokenRecord); err != nil {
 		return service_models.SignInResponse{}, ErrInternalServerError
 	}
 
-	// create the response
-	res := service_models.SignInResponse{
+	// generate a JWT token for the new session
+	accessToken, err := auth.GenerateJWT(user.ID, user.Username, refreshTokenRecord.FamilyID)
+	if err != nil {
+		return service_models.SignInResponse{}, ErrTokenGeneration
+	}
+
+	return service_models.SignInResponse{
 		AccessToken:            accessToken,
 		RefreshToken:           refreshToken,
 		RefreshTokenExpiration: expiration,
-	}
-
-	return res, nil
+	}, nil
 }
 
-// update the user's password
-func (s *AuthService) UpdatePassword(req service_models.UpdatePasswordRequest) error {
-	// find the user by ID
-	var user db_models.User
-	if err := s.DB.First(&user, req.UserID).Error; err != nil {
-		return ErrInvalidCredentials
+// helper function to refuse accounts an admin has disabled or required a password reset for
+func checkAccountStatus(user *db_models.User) error {
+	if user.DisabledAt != nil {
+		return ErrAccountDisabled
+	}
+	if user.PasswordResetRequired {
+		return ErrPasswordReset
 	}
+	return nil
+}
 
-	// check the input password against the hashed password
-	if !auth.CheckPassword(user.HashedPassword, req.OldPassword) {
-		return ErrInvalidCredentials
+// helper function to email a password reset link, replacing any link sent before; body writes the email around the link
+func sendPasswordResetEmail(store repositories.Store, mailer mail.Mailer, user *db_models.User, body func(link string) string) error {
+	// only the latest link works
+	if err := store.PasswordResetTokens().DeleteByUser(user.ID); err != nil {
+		return ErrInternalServerError
 	}
 
-	// hash the new password
-	hashedPassword, err := auth.HashPassword(req.NewPassword)
+	// generate and store the reset token
+	token, selector, verifier, err := auth.GenerateToken()
 	if err != nil {
+		return ErrTokenGeneration
+	}
+	resetToken := db_models.PasswordResetToken{
+		UserID:      user.ID,
+		Selector:    selector,
+		HashedToken: auth.HashVerifier(verifier),
+		ExpiresAt:   auth.PasswordResetTokenExpiration(),
+	}
+	if err := store.PasswordResetTokens().Create(&resetToken); err != nil {
 		return ErrInternalServerError
 	}
 
-	// update the user's password in the database
-	user.HashedPassword = hashedPassword
-	if err := s.DB.Save(&user).Error; err != nil {
+	// send the link; if it can't be sent the token is rolled back
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    body(appLink("/reset-password", token)),
+	}
+	if err := mailer.Send(msg); err != nil {
 		return ErrInternalServerError
 	}
-
 	return nil
 }
 
-// generate a new access token
-func (s *AuthService) RefreshAccessToken(req service_models.RefreshTokenRequest) (service_models.RefreshTokenResponse, error) {
-	var refreshTokens []db_models.RefreshToken
-	if err := s.DB.Where("user_id = ?", req.UserID).Find(&refreshTokens).Error; err != nil {
-		return service_models.RefreshTokenResponse{}, ErrInternalServerError
+// helper function to build a link to a page of the web app carrying a token
+func appLink(path string, token string) string {
+	return strings.TrimSuffix(config.GetAppURL(), "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// helper function to email a verification link for an address, replacing any link sent before
+func sendVerificationEmail(store repositories.Store, mailer mail.Mailer, user *db_models.User, email string) error {
+	// only the latest link works
+	if err := store.EmailVerificationTokens().DeleteByUser(user.ID); err != nil {
+		return ErrInternalServerError
 	}
 
-	// match the passed refresh token with the stored hashed tokens
-	var refreshToken *db_models.RefreshToken
-	for i, token := range refreshTokens {
-		if auth.CheckPassword(token.HashedToken, req.RefreshToken) {
-			refreshToken = &refreshTokens[i]
-			break
-		}
+	// generate and store the verification token
+	token, selector, verifier, err := auth.GenerateToken()
+	if err != nil {
+		return ErrTokenGeneration
 	}
-	if refreshToken == nil {
-		return service_models.RefreshTokenResponse{}, ErrInvalidCredentials
+	verificationToken := db_models.EmailVerificationToken{
+		UserID:      user.ID,
+		Selector:    selector,
+		HashedToken: auth.HashVerifier(verifier),
+		Email:       email,
+		ExpiresAt:   auth.EmailVerificationTokenExpiration(),
 	}
-
-	// check if the refresh token is expired
-	if time.Now().After(refreshToken.ExpiresAt) {
-		return service_models.RefreshTokenResponse{}, ErrInvalidCredentials
+	if err := store.EmailVerificationTokens().Create(&verificationToken); err != nil {
+		return ErrInternalServerError
 	}
 
-	// query the user by ID
-	var user db_models.User
-	if err := s.DB.First(&user, refreshToken.UserID).Error; err != nil {
-		return service_models.RefreshTokenResponse{}, ErrInvalidCredentials
+	// send the link
+	msg := mail.Message{
+		To:      email,
+		Subject: "Confirm your email",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm this is your email address by opening the link below:\n\n%s\n\n"+
+			"If you didn't ask for this, you can ignore this email.", user.Username, appLink("/verify-email", token)),
 	}
-	// check if the user exists
-	if user.ID == 0 {
-		return service_models.RefreshTokenResponse{}, ErrInvalidCredentials
+	if err := mailer.Send(msg); err != nil {
+		return ErrInternalServerError
 	}
 
-	// generate a new access token
-	accessToken, err := auth.GenerateJWT(user.ID, user.Username)
-	if err != nil {
-		return service_models.RefreshTokenResponse{}, ErrTokenGeneration
-	}
+	return nil
+}
 
-	// generate a new refresh token
-	newRefreshToken, err := auth.GenerateRefreshToken()
+// helper function to find the stored refresh token matching a raw token
+func findRefreshToken(store repositories.Store, token string) (*db_models.RefreshToken, error) {
+	selector, verifier, err := auth.SplitToken(token)
 	if err != nil {
-		return service_models.RefreshTokenResponse{}, ErrTokenGeneration
+		return nil, ErrInvalidCredentials
 	}
-	// hash the new refresh token
-	newHashedToken, err := auth.HashPassword(newRefreshToken)
+
+	// find the token by its selector, then check the verifier
+	refreshToken, err := store.RefreshTokens().FindBySelector(selector)
 	if err != nil {
-		return service_models.RefreshTokenResponse{}, ErrInternalServerError
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, ErrInternalServerError
 	}
-	// update the new refresh token in the database
-	refreshToken.HashedToken = newHashedToken
-	refreshToken.ExpiresAt = auth.RefreshTokenExpiration()
-	if err := s.DB.Save(&refreshToken).Error; err != nil {
-		return service_models.RefreshTokenResponse{}, ErrInternalServerError
+	if !auth.CheckVerifier(refreshToken.HashedToken, verifier) {
+		return nil, ErrInvalidCredentials
 	}
 
-	// create the response
-	res := service_models.RefreshTokenResponse{
-		AccessToken:            accessToken,
-		RefreshToken:           newRefreshToken,
-		RefreshTokenExpiration: refreshToken.ExpiresAt,
-	}
-
-	return res, nil
+	return refreshToken, nil
 }
@@ -0,0 +1,599 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/mail"
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+// create an AuthService that discards email
+func newTestAuthService(store repositories.Store) *AuthService {
+	return NewAuthService(store, mail.NewLogMailer(io.Discard))
+}
+
+// sign up a user with a known password
+func signUpTestUser(t *testing.T, s *AuthService, username string) {
+	t.Helper()
+
+	err := s.SignUp(service_models.SignUpRequest{Username: username, Email: username + "@example.com", Password: "password"})
+	if err != nil {
+		t.Fatalf("SignUp returned %v", err)
+	}
+}
+
+func TestSignUpNormalizesAndRejectsDuplicates(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+
+	err := s.SignUp(service_models.SignUpRequest{Username: " alice ", Email: " Alice@Example.com ", Password: "password"})
+	if err != nil {
+		t.Fatalf("SignUp returned %v", err)
+	}
+	user, err := store.Users().FindByUsername("alice")
+	if err != nil {
+		t.Fatalf("expected the user to be stored, got %v", err)
+	}
+	if user.Email != "alice@example.com" || user.HashedPassword == "password" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	err = s.SignUp(service_models.SignUpRequest{Username: "other", Email: "alice@example.com", Password: "password"})
+	if !errors.Is(err, ErrUserExists) {
+		t.Fatalf("expected ErrUserExists, got %v", err)
+	}
+}
+
+func TestSignIn(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	signUpTestUser(t, s, "alice")
+
+	res, err := s.SignIn(service_models.SignInRequest{Email: "alice@example.com", Password: "password"})
+	if err != nil {
+		t.Fatalf("SignIn returned %v", err)
+	}
+	if res.AccessToken == "" || res.RefreshToken == "" {
+		t.Fatalf("expected tokens, got %+v", res)
+	}
+
+	if _, err := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "wrong"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := s.SignIn(service_models.SignInRequest{Username: "bob", Password: "password"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+}
+
+func TestRefreshAccessTokenRotatesToken(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	signUpTestUser(t, s, "alice")
+
+	signIn, err := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("SignIn returned %v", err)
+	}
+
+	// the cookie alone is enough, and a guessed verifier is rejected
+	selector, _, _ := strings.Cut(signIn.RefreshToken, ".")
+	for _, forged := range []string{selector + ".guess", selector, "", "unknown.verifier"} {
+		_, err := s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: forged})
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected ErrInvalidCredentials for %q, got %v", forged, err)
+		}
+	}
+
+	refreshed, err := s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: signIn.RefreshToken})
+	if err != nil {
+		t.Fatalf("RefreshAccessToken returned %v", err)
+	}
+	if refreshed.RefreshToken == signIn.RefreshToken || refreshed.AccessToken == "" {
+		t.Fatalf("expected new tokens, got %+v", refreshed)
+	}
+
+	// the old refresh token no longer works
+	_, err = s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: signIn.RefreshToken})
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+}
+
+func TestUpdatePassword(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	signUpTestUser(t, s, "alice")
+	user, _ := store.Users().FindByUsername("alice")
+
+	err := s.UpdatePassword(service_models.UpdatePasswordRequest{UserID: user.ID, OldPassword: "wrong", NewPassword: "new-password"})
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	signIn, err := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("SignIn returned %v", err)
+	}
+
+	err = s.UpdatePassword(service_models.UpdatePasswordRequest{UserID: user.ID, OldPassword: "password", NewPassword: "new-password"})
+	if err != nil {
+		t.Fatalf("UpdatePassword returned %v", err)
+	}
+
+	// existing sessions are signed out
+	_, err = s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: signIn.RefreshToken})
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "new-password"}); err != nil {
+		t.Fatalf("expected to sign in with the new password, got %v", err)
+	}
+}
+
+func TestLogoutRevokesOnlyTheCurrentSession(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	signUpTestUser(t, s, "alice")
+
+	laptop, _ := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	phone, _ := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+
+	if err := s.Logout(service_models.LogoutRequest{RefreshToken: laptop.RefreshToken}); err != nil {
+		t.Fatalf("Logout returned %v", err)
+	}
+
+	_, err := s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: laptop.RefreshToken})
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: phone.RefreshToken}); err != nil {
+		t.Fatalf("expected the other session to survive, got %v", err)
+	}
+
+	// logging out twice is reported as an unknown token
+	err = s.Logout(service_models.LogoutRequest{RefreshToken: laptop.RefreshToken})
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+}
+
+func TestLogoutAllAndDeleteUserRevokeEverySession(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	signUpTestUser(t, s, "alice")
+	signUpTestUser(t, s, "bob")
+	alice, _ := store.Users().FindByUsername("alice")
+	bob, _ := store.Users().FindByUsername("bob")
+
+	s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	s.SignIn(service_models.SignInRequest{Username: "bob", Password: "password"})
+
+	if err := s.LogoutAll(service_models.LogoutAllRequest{UserID: alice.ID}); err != nil {
+		t.Fatalf("LogoutAll returned %v", err)
+	}
+	if tokens, _ := store.RefreshTokens().ListByUser(alice.ID); len(tokens) != 0 {
+		t.Fatalf("expected alice's sessions to be revoked, got %d", len(tokens))
+	}
+	if tokens, _ := store.RefreshTokens().ListByUser(bob.ID); len(tokens) != 1 {
+		t.Fatalf("expected bob's session to survive, got %d", len(tokens))
+	}
+
+	if err := NewUserService(store, mail.NewLogMailer(io.Discard)).DeleteUser(service_models.DeleteUserRequest{UserID: bob.ID}); err != nil {
+		t.Fatalf("DeleteUser returned %v", err)
+	}
+	if tokens, _ := store.RefreshTokens().ListByUser(bob.ID); len(tokens) != 0 {
+		t.Fatalf("expected bob's sessions to be revoked, got %d", len(tokens))
+	}
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	signUpTestUser(t, s, "alice")
+	signUpTestUser(t, s, "bob")
+	alice, _ := store.Users().FindByUsername("alice")
+	bob, _ := store.Users().FindByUsername("bob")
+
+	laptopUA := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36"
+	phoneUA := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"
+	laptop, _ := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password", UserAgent: laptopUA, IPAddress: "10.0.0.1"})
+	s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password", UserAgent: phoneUA, IPAddress: "10.0.0.2"})
+	s.SignIn(service_models.SignInRequest{Username: "bob", Password: "password"})
+
+	// refreshing the laptop session makes it the most recently used
+	if _, err := s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: laptop.RefreshToken, UserAgent: laptopUA, IPAddress: "10.0.0.3"}); err != nil {
+		t.Fatalf("RefreshAccessToken returned %v", err)
+	}
+
+	tokens, _ := store.RefreshTokens().ListByUser(alice.ID)
+	laptopID, phoneID := tokens[0].ID, tokens[1].ID
+	res, err := s.ListSessions(service_models.ListSessionsRequest{UserID: alice.ID, CurrentSessionID: phoneID})
+	if err != nil {
+		t.Fatalf("ListSessions returned %v", err)
+	}
+	if len(res.Sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %+v", res.Sessions)
+	}
+	first, second := res.Sessions[0], res.Sessions[1]
+	if first.ID != laptopID || first.DeviceLabel != "Chrome on Windows" || first.IPAddress != "10.0.0.3" || first.Current {
+		t.Fatalf("unexpected laptop session %+v", first)
+	}
+	if second.ID != phoneID || second.DeviceLabel != "Safari on iPhone" || !second.Current {
+		t.Fatalf("unexpected phone session %+v", second)
+	}
+
+	// other users' sessions can't be revoked
+	err = s.RevokeSession(service_models.RevokeSessionRequest{UserID: bob.ID, SessionID: laptopID})
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expected ErrSessionNotFound, got %v", err)
+	}
+
+	if err := s.RevokeSession(service_models.RevokeSessionRequest{UserID: alice.ID, SessionID: laptopID}); err != nil {
+		t.Fatalf("RevokeSession returned %v", err)
+	}
+	res, _ = s.ListSessions(service_models.ListSessionsRequest{UserID: alice.ID})
+	if len(res.Sessions) != 1 || res.Sessions[0].ID != phoneID {
+		t.Fatalf("expected only the phone session to remain, got %+v", res.Sessions)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	signUpTestUser(t, s, "alice")
+	alice, _ := store.Users().FindByUsername("alice")
+
+	stolen, _ := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	other, _ := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	rotated, err := s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: stolen.RefreshToken})
+	if err != nil {
+		t.Fatalf("RefreshAccessToken returned %v", err)
+	}
+
+	// a replay right after rotation is rejected without revoking anything
+	_, err = s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: stolen.RefreshToken})
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	// once the grace period is over, a replay revokes the whole family
+	selector, _, _ := strings.Cut(stolen.RefreshToken, ".")
+	retired, _ := store.RefreshTokens().FindBySelector(selector)
+	rotatedAt := retired.RotatedAt.Add(-refreshTokenReuseGrace)
+	retired.RotatedAt = &rotatedAt
+	store.RefreshTokens().Update(retired)
+
+	_, err = s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: stolen.RefreshToken, IPAddress: "10.0.0.9"})
+	if !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	_, err = s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: rotated.RefreshToken})
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected the rotated token to be revoked, got %v", err)
+	}
+	if _, err := s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: other.RefreshToken}); err != nil {
+		t.Fatalf("expected the other session to survive, got %v", err)
+	}
+
+	// the reuse is recorded
+	events, _ := store.SecurityEvents().ListByUser(alice.ID)
+	if len(events) != 1 || events[0].Type != db_models.SecurityEventRefreshTokenReuse || events[0].IPAddress != "10.0.0.9" {
+		t.Fatalf("unexpected security events %+v", events)
+	}
+}
+
+// store that holds every refresh token lookup until both concurrent refreshes have read the token;
+// it skips the memory store's transaction lock, so the refreshes interleave like two database transactions
+type refreshBarrierStore struct {
+	repositories.Store
+	barrier *sync.WaitGroup
+}
+
+func (s refreshBarrierStore) Do(fn func(store repositories.Store) error) error {
+	return fn(s)
+}
+
+func (s refreshBarrierStore) RefreshTokens() repositories.RefreshTokenRepository {
+	return refreshBarrierRepository{RefreshTokenRepository: s.Store.RefreshTokens(), barrier: s.barrier}
+}
+
+type refreshBarrierRepository struct {
+	repositories.RefreshTokenRepository
+	barrier *sync.WaitGroup
+}
+
+func (r refreshBarrierRepository) FindBySelector(selector string) (*db_models.RefreshToken, error) {
+	refreshToken, err := r.RefreshTokenRepository.FindBySelector(selector)
+	r.barrier.Done()
+	r.barrier.Wait()
+	return refreshToken, err
+}
+
+func TestConcurrentRefreshesRotateOnce(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	signUpTestUser(t, newTestAuthService(store), "alice")
+	signIn, err := newTestAuthService(store).SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("SignIn returned %v", err)
+	}
+
+	// both refreshes read the token before either retires it
+	barrier := &sync.WaitGroup{}
+	barrier.Add(2)
+	s := newTestAuthService(refreshBarrierStore{Store: store, barrier: barrier})
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: signIn.RefreshToken})
+			errs <- err
+		}()
+	}
+
+	succeeded := 0
+	for i := 0; i < 2; i++ {
+		err := <-errs
+		if err == nil {
+			succeeded++
+		} else if !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("expected exactly one refresh to succeed, got %d", succeeded)
+	}
+
+	// the session has a single chain: the signed in token and one replacement
+	alice, _ := store.Users().FindByUsername("alice")
+	if tokens, _ := store.RefreshTokens().ListByUser(alice.ID); len(tokens) != 2 {
+		t.Fatalf("expected one replacement token, got %d tokens", len(tokens))
+	}
+}
+
+func TestPruneRefreshTokensDeletesExpired(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	signUpTestUser(t, s, "alice")
+	alice, _ := store.Users().FindByUsername("alice")
+
+	signIn, _ := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	if _, err := s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: signIn.RefreshToken}); err != nil {
+		t.Fatalf("RefreshAccessToken returned %v", err)
+	}
+
+	// once every token of the session has expired, the whole session goes
+	tokens, _ := store.RefreshTokens().ListByUser(alice.ID)
+	for i := range tokens {
+		tokens[i].ExpiresAt = time.Now().Add(-time.Minute)
+		store.RefreshTokens().Update(&tokens[i])
+	}
+
+	deleted, err := s.PruneRefreshTokens()
+	if err != nil || deleted != 2 {
+		t.Fatalf("expected both tokens to be pruned, got %d, %v", deleted, err)
+	}
+	if tokens, _ := store.RefreshTokens().ListByUser(alice.ID); len(tokens) != 0 {
+		t.Fatalf("expected no tokens to be left, got %+v", tokens)
+	}
+}
+
+func TestRevokeSessionAfterPruningARefreshedSession(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	signUpTestUser(t, s, "alice")
+	alice, _ := store.Users().FindByUsername("alice")
+
+	signIn, _ := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	if _, err := s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: signIn.RefreshToken}); err != nil {
+		t.Fatalf("RefreshAccessToken returned %v", err)
+	}
+
+	// the session was signed in 31 days ago and kept alive by refreshing, so its first token has expired
+	selector, _, _ := strings.Cut(signIn.RefreshToken, ".")
+	first, _ := store.RefreshTokens().FindBySelector(selector)
+	first.CreatedAt = time.Now().Add(-31 * 24 * time.Hour)
+	first.ExpiresAt = time.Now().Add(-24 * time.Hour)
+	store.RefreshTokens().Update(first)
+
+	if deleted, err := s.PruneRefreshTokens(); err != nil || deleted != 0 {
+		t.Fatalf("expected a session still in use to be kept, got %d, %v", deleted, err)
+	}
+
+	sessions, _ := s.ListSessions(service_models.ListSessionsRequest{UserID: alice.ID})
+	if len(sessions.Sessions) != 1 || sessions.Sessions[0].ID != first.FamilyID {
+		t.Fatalf("expected the session to be listed, got %+v", sessions.Sessions)
+	}
+	if err := s.RevokeSession(service_models.RevokeSessionRequest{UserID: alice.ID, SessionID: sessions.Sessions[0].ID}); err != nil {
+		t.Fatalf("RevokeSession returned %v", err)
+	}
+	if tokens, _ := store.RefreshTokens().ListByUser(alice.ID); len(tokens) != 0 {
+		t.Fatalf("expected the session to be revoked, got %+v", tokens)
+	}
+}
+
+func TestSessionIDSurvivesRotation(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	signUpTestUser(t, s, "alice")
+	alice, _ := store.Users().FindByUsername("alice")
+
+	signIn, _ := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	before, _ := s.ListSessions(service_models.ListSessionsRequest{UserID: alice.ID})
+	refreshed, _ := s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: signIn.RefreshToken})
+	s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: refreshed.RefreshToken})
+
+	after, _ := s.ListSessions(service_models.ListSessionsRequest{UserID: alice.ID})
+	if len(after.Sessions) != 1 || after.Sessions[0].ID != before.Sessions[0].ID || !after.Sessions[0].CreatedAt.Equal(before.Sessions[0].CreatedAt) {
+		t.Fatalf("expected the same session before and after rotation, got %+v and %+v", before.Sessions, after.Sessions)
+	}
+
+	// revoking the session revokes the latest token
+	if err := s.RevokeSession(service_models.RevokeSessionRequest{UserID: alice.ID, SessionID: after.Sessions[0].ID}); err != nil {
+		t.Fatalf("RevokeSession returned %v", err)
+	}
+	if tokens, _ := store.RefreshTokens().ListByUser(alice.ID); len(tokens) != 0 {
+		t.Fatalf("expected every token of the session to be deleted, got %d", len(tokens))
+	}
+}
+
+// pull the token out of the last emailed link
+func tokenFromMail(t *testing.T, sent *bytes.Buffer) string {
+	t.Helper()
+
+	matches := regexp.MustCompile(`token=(\S+)`).FindAllStringSubmatch(sent.String(), -1)
+	if len(matches) == 0 {
+		t.Fatalf("no reset link in %q", sent.String())
+	}
+	token, err := url.QueryUnescape(matches[len(matches)-1][1])
+	if err != nil {
+		t.Fatalf("failed to unescape token: %v", err)
+	}
+	return token
+}
+
+func TestForgotAndResetPassword(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	var sent bytes.Buffer
+	s := NewAuthService(store, mail.NewLogMailer(&sent))
+	signUpTestUser(t, s, "alice")
+	session, _ := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	sent.Reset()
+
+	// unknown emails look the same to the caller but send nothing
+	if err := s.ForgotPassword(service_models.ForgotPasswordRequest{Email: "nobody@example.com"}); err != nil {
+		t.Fatalf("ForgotPassword returned %v", err)
+	}
+	if sent.Len() != 0 {
+		t.Fatalf("expected no email, got %q", sent.String())
+	}
+
+	// only the latest link works
+	s.ForgotPassword(service_models.ForgotPasswordRequest{Email: " Alice@Example.com "})
+	first := tokenFromMail(t, &sent)
+	s.ForgotPassword(service_models.ForgotPasswordRequest{Email: "alice@example.com"})
+	token := tokenFromMail(t, &sent)
+	if !strings.Contains(sent.String(), "To: alice@example.com") {
+		t.Fatalf("expected the email to go to alice, got %q", sent.String())
+	}
+
+	err := s.ResetPassword(service_models.ResetPasswordRequest{Token: first, NewPassword: "new-password"})
+	if !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expected ErrInvalidResetToken, got %v", err)
+	}
+
+	if err := s.ResetPassword(service_models.ResetPasswordRequest{Token: token, NewPassword: "new-password"}); err != nil {
+		t.Fatalf("ResetPassword returned %v", err)
+	}
+	if _, err := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "new-password"}); err != nil {
+		t.Fatalf("expected to sign in with the new password, got %v", err)
+	}
+
+	// existing sessions are signed out and the token can't be used twice
+	_, err = s.RefreshAccessToken(service_models.RefreshTokenRequest{RefreshToken: session.RefreshToken})
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	err = s.ResetPassword(service_models.ResetPasswordRequest{Token: token, NewPassword: "another-password"})
+	if !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expected ErrInvalidResetToken, got %v", err)
+	}
+}
+
+func TestResetPasswordRejectsExpiredToken(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	var sent bytes.Buffer
+	s := NewAuthService(store, mail.NewLogMailer(&sent))
+	signUpTestUser(t, s, "alice")
+
+	s.ForgotPassword(service_models.ForgotPasswordRequest{Email: "alice@example.com"})
+	token := tokenFromMail(t, &sent)
+
+	selector, _, _ := strings.Cut(token, ".")
+	resetToken, _ := store.PasswordResetTokens().FindBySelector(selector)
+	resetToken.ExpiresAt = time.Now().Add(-time.Minute)
+	store.PasswordResetTokens().Update(resetToken)
+
+	err := s.ResetPassword(service_models.ResetPasswordRequest{Token: token, NewPassword: "new-password"})
+	if !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expected ErrInvalidResetToken, got %v", err)
+	}
+}
+
+func TestSignUpSendsVerificationEmail(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	var sent bytes.Buffer
+	s := NewAuthService(store, mail.NewLogMailer(&sent))
+	signUpTestUser(t, s, "alice")
+
+	user, _ := store.Users().FindByUsername("alice")
+	if user.EmailVerified {
+		t.Fatal("expected a new user to be unverified")
+	}
+
+	if err := s.VerifyEmail(service_models.VerifyEmailRequest{Token: tokenFromMail(t, &sent)}); err != nil {
+		t.Fatalf("VerifyEmail returned %v", err)
+	}
+	user, _ = store.Users().FindByUsername("alice")
+	if !user.EmailVerified {
+		t.Fatal("expected the email to be verified")
+	}
+
+	// the token is single use, and there is nothing left to resend
+	err := s.VerifyEmail(service_models.VerifyEmailRequest{Token: tokenFromMail(t, &sent)})
+	if !errors.Is(err, ErrInvalidVerification) {
+		t.Fatalf("expected ErrInvalidVerification, got %v", err)
+	}
+	err = s.ResendVerification(service_models.ResendVerificationRequest{UserID: user.ID})
+	if !errors.Is(err, ErrAlreadyVerified) {
+		t.Fatalf("expected ErrAlreadyVerified, got %v", err)
+	}
+}
+
+func TestEmailChangeWaitsForVerification(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	var sent bytes.Buffer
+	s := NewAuthService(store, mail.NewLogMailer(&sent))
+	users := NewUserService(store, mail.NewLogMailer(&sent))
+	signUpTestUser(t, s, "alice")
+	signUpTestUser(t, s, "bob")
+	alice, _ := store.Users().FindByUsername("alice")
+
+	// taken emails are rejected
+	err := users.UpdateUser(service_models.UpdateUserRequest{UserID: alice.ID, Username: "alice", Email: "bob@example.com"})
+	if !errors.Is(err, ErrUserExists) {
+		t.Fatalf("expected ErrUserExists, got %v", err)
+	}
+
+	// the old email stays active until the new one is confirmed
+	sent.Reset()
+	err = users.UpdateUser(service_models.UpdateUserRequest{UserID: alice.ID, Username: "alice", Email: "Alice@New.example.com"})
+	if err != nil {
+		t.Fatalf("UpdateUser returned %v", err)
+	}
+	if !strings.Contains(sent.String(), "To: alice@new.example.com") {
+		t.Fatalf("expected the link to go to the new email, got %q", sent.String())
+	}
+	res, _ := users.ReadUser(service_models.ReadUserRequest{UserID: alice.ID})
+	if res.Email != "alice@example.com" || res.PendingEmail != "alice@new.example.com" {
+		t.Fatalf("unexpected user %+v", res)
+	}
+
+	if err := s.VerifyEmail(service_models.VerifyEmailRequest{Token: tokenFromMail(t, &sent)}); err != nil {
+		t.Fatalf("VerifyEmail returned %v", err)
+	}
+	res, _ = users.ReadUser(service_models.ReadUserRequest{UserID: alice.ID})
+	if res.Email != "alice@new.example.com" || res.PendingEmail != "" || !res.EmailVerified {
+		t.Fatalf("unexpected user %+v", res)
+	}
+}
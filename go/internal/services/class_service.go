@@ -4,123 +4,166 @@ import (
 	"crypto/rand"
 	"errors"
 	"math/big"
+	"strings"
 	"time"
 
 	"github.com/hawkerd/privateinstruction/internal/models/db_models"
 	"github.com/hawkerd/privateinstruction/internal/models/service_models"
-	"gorm.io/gorm"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
 )
 
 // define custom error messages
 var (
-	ErrClassNotFound = errors.New("class not found")
-	ErrUnauthorized  = errors.New("unauthorized")
+	ErrClassNotFound     = errors.New("class not found")
+	ErrUnauthorized      = errors.New("unauthorized")
+	ErrInvalidCursor     = errors.New("invalid cursor")
+	ErrMemberNotFound    = errors.New("member not found")
+	ErrLastAdmin         = errors.New("a class must keep at least one admin")
+	ErrInvalidRole       = errors.New("invalid role")
+	ErrOwnerMustTransfer = errors.New("the class owner must transfer ownership first")
+	ErrEmailNotVerified  = errors.New("please verify your email first")
+	ErrAlreadyMember     = errors.New("already a member of this class")
+	ErrJoinCodeNotFound  = errors.New("join code not found")
+	ErrInvalidJoinCode   = errors.New("invalid join code settings")
+)
+
+// pagination limits for listing classes
+const (
+	defaultClassPageSize = 20
+	maxClassPageSize     = 100
+)
+
+// limits on the join codes class admins can create
+const (
+	defaultJoinCodeHours = 24
+	maxJoinCodeHours     = 365 * 24
+	maxJoinCodeNameLen   = 100
 )
 
 type ClassService struct {
-	DB *gorm.DB
+	Store repositories.Store
+	// block creating and joining classes until the user's email is verified
+	RequireVerifiedEmail bool
 }
 
 // create and return a new ClassService instance
-func NewClassService(db *gorm.DB) *ClassService {
+func NewClassService(store repositories.Store) *ClassService {
 	return &ClassService{
-		DB: db,
+		Store: store,
 	}
 }
 
 // create a new class
 func (s *ClassService) CreateClass(req service_models.CreateClassRequest) error {
-	// create a new class
-	class := db_models.Class{
-		Name:        req.Name,
-		Description: req.Description,
-		CreatorID:   req.UserID,
-	}
-	if err := s.DB.Create(&class).Error; err != nil {
-		return ErrInternalServerError
-	}
+	return s.Store.Do(func(store repositories.Store) error {
+		if err := s.checkEmailVerified(store, req.UserID); err != nil {
+			return err
+		}
 
-	// add the creator of the class as a member (admin)
-	classMember := db_models.ClassMember{
-		ClassID: class.ID,
-		UserID:  req.UserID,
-		Role:    "admin",
-	}
-	if err := s.DB.Create(&classMember).Error; err != nil {
-		return ErrInternalServerError
-	}
+		// create a new class
+		class := db_models.Class{
+			Name:            req.Name,
+			Description:     req.Description,
+			CreatorID:       req.UserID,
+			RequireApproval: req.RequireApproval,
+		}
+		if err := store.Classes().Create(&class); err != nil {
+			return ErrInternalServerError
+		}
 
-	return nil
+		// add the creator of the class as a member (admin)
+		classMember := db_models.ClassMember{
+			ClassID: class.ID,
+			UserID:  req.UserID,
+			Role:    "admin",
+		}
+		if err := store.ClassMembers().Create(&classMember); err != nil {
+			return ErrInternalServerError
+		}
+
+		event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionCreateClass, TargetType: db_models.AuditTargetClass, TargetID: class.ID, ClassID: class.ID}
+		return recordAuditEvent(store, req.Audit, event, nil, auditFields{"name": class.Name, "description": class.Description, "require_approval": class.RequireApproval})
+	})
 }
 
 // delete a class
 func (s *ClassService) DeleteClass(req service_models.DeleteClassRequest) error {
-	// find the class
-	var class db_models.Class
-	if err := s.DB.First(&class, req.ClassID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return ErrClassNotFound
+	return s.Store.Do(func(store repositories.Store) error {
+		// find the class
+		class, err := store.Classes().FindByID(req.ClassID)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrClassNotFound
+			}
+			return err
 		}
-		return err
-	}
 
-	// find the class member
-	var classMember db_models.ClassMember
-	if err := s.DB.Where("class_id = ? AND user_id = ?", req.ClassID, req.UserID).First(&classMember).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+		// find the class member
+		classMember, err := store.ClassMembers().Find(req.ClassID, req.UserID)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrUnauthorized
+			}
+			return err
+		}
+
+		// make sure the user is an admin
+		if classMember.Role != "admin" {
 			return ErrUnauthorized
 		}
-	}
 
-	// make sure the user is an admin
-	if classMember.Role != "admin" {
-		return ErrUnauthorized
-	}
+		// delete the class
+		if err := store.Classes().Delete(class.ID); err != nil {
+			return err
+		}
 
-	// delete the class
-	if err := s.DB.Delete(&class).Error; err != nil {
-		return err
-	}
+		// delete all class members
+		if err := store.ClassMembers().DeleteByClass(req.ClassID); err != nil {
+			return err
+		}
 
-	// delete all class members
-	if err := s.DB.Where("class_id = ?", req.ClassID).Delete(&db_models.ClassMember{}).Error; err != nil {
-		return err
-	}
+		// delete all join requests
+		if err := store.JoinRequests().DeleteByClass(req.ClassID); err != nil {
+			return err
+		}
 
-	return nil
+		event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionDeleteClass, TargetType: db_models.AuditTargetClass, TargetID: class.ID, ClassID: class.ID}
+		return recordAuditEvent(store, req.Audit, event, auditFields{"name": class.Name, "description": class.Description}, nil)
+	})
 }
 
 // read a class
 func (s *ClassService) ReadClass(req service_models.ReadClassRequest) (service_models.ReadClassResponse, error) {
 	// find the class
-	var class db_models.Class
-	if err := s.DB.First(&class, req.ClassID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	class, err := s.Store.Classes().FindByID(req.ClassID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
 			return service_models.ReadClassResponse{}, ErrClassNotFound
 		}
 		return service_models.ReadClassResponse{}, err
 	}
 
 	// find the class member
-	var classMember db_models.ClassMember
-	if err := s.DB.Where("class_id = ? AND user_id = ?", req.ClassID, req.UserID).First(&classMember).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	if _, err := s.Store.ClassMembers().Find(req.ClassID, req.UserID); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
 			return service_models.ReadClassResponse{}, ErrUnauthorized
 		}
+		return service_models.ReadClassResponse{}, err
 	}
 
 	// find the user who create the class
-	var creator db_models.User
-	if err := s.DB.First(&creator, req.UserID).Error; err != nil {
-		creator = db_models.User{}
+	creator, err := s.Store.Users().FindByID(req.UserID)
+	if err != nil {
+		creator = &db_models.User{}
 	}
 
 	// build the response
 	resp := service_models.ReadClassResponse{
-		Name:        class.Name,
-		Description: class.Description,
-		CreatedAt:   class.CreatedAt.Format("2006-01-02 15:04:05"),
-		CreatedBy:   creator.Username,
+		Name:            class.Name,
+		Description:     class.Description,
+		RequireApproval: class.RequireApproval,
+		CreatedAt:       class.CreatedAt.Format("2006-01-02 15:04:05"),
+		CreatedBy:       creator.Username,
 	}
 
 	return resp, nil
@@ -142,121 +185,649 @@ func RandomString(length int) string {
 
 // update a class
 func (s *ClassService) UpdateClass(req service_models.UpdateClassRequest) error {
-	// find the class
-	var class db_models.Class
-	if err := s.DB.First(&class, req.ClassID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return ErrClassNotFound
+	return s.Store.Do(func(store repositories.Store) error {
+		// find the class
+		class, err := store.Classes().FindByID(req.ClassID)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrClassNotFound
+			}
+			return err
 		}
-		return err
-	}
 
-	// find the class member
-	var classMember db_models.ClassMember
-	if err := s.DB.Where("class_id = ? AND user_id = ?", req.ClassID, req.UserID).First(&classMember).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+		// find the class member
+		classMember, err := store.ClassMembers().Find(req.ClassID, req.UserID)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrUnauthorized
+			}
+			return err
+		}
+
+		// make sure the user is an admin
+		if classMember.Role != "admin" {
 			return ErrUnauthorized
 		}
+
+		// update the class
+		before := auditFields{"name": class.Name, "description": class.Description, "require_approval": class.RequireApproval}
+		class.Name = req.Name
+		class.Description = req.Description
+		if req.RequireApproval != nil {
+			class.RequireApproval = *req.RequireApproval
+		}
+
+		if err := store.Classes().Update(class); err != nil {
+			return err
+		}
+
+		event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionUpdateClass, TargetType: db_models.AuditTargetClass, TargetID: class.ID, ClassID: class.ID}
+		return recordAuditEvent(store, req.Audit, event, before, auditFields{"name": class.Name, "description": class.Description, "require_approval": class.RequireApproval})
+	})
+}
+
+// generate a join code for a class; a class can have several at once
+func (s *ClassService) GenerateJoinCode(req service_models.GenerateJoinCodeRequest) (service_models.GenerateJoinCodeResponse, error) {
+	// input validation
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Role == "" {
+		req.Role = "user"
+	}
+	if req.Role != "admin" && req.Role != "user" {
+		return service_models.GenerateJoinCodeResponse{}, ErrInvalidRole
+	}
+	if len(req.Name) > maxJoinCodeNameLen || req.MaxUses < 0 || req.ExpiresInHours < 0 || req.ExpiresInHours > maxJoinCodeHours {
+		return service_models.GenerateJoinCodeResponse{}, ErrInvalidJoinCode
+	}
+	if req.NeverExpires && req.ExpiresInHours != 0 {
+		return service_models.GenerateJoinCodeResponse{}, ErrInvalidJoinCode
+	}
+	if req.ExpiresInHours == 0 {
+		req.ExpiresInHours = defaultJoinCodeHours
 	}
 
-	// make sure the user is an admin
-	if classMember.Role != "admin" {
-		return ErrUnauthorized
+	var resp service_models.GenerateJoinCodeResponse
+	err := s.Store.Do(func(store repositories.Store) error {
+		// make sure the user is an admin of the class
+		if err := s.requireClassAdmin(store, req.ClassID, req.UserID); err != nil {
+			return err
+		}
+
+		// generate a join code
+		joinCode := db_models.JoinCode{
+			Code:          RandomString(8),
+			ClassID:       req.ClassID,
+			Name:          req.Name,
+			Role:          req.Role,
+			MaxUses:       req.MaxUses,
+			RemainingUses: req.MaxUses,
+		}
+		expiresAt := "never"
+		if !req.NeverExpires {
+			expirationDT := time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour)
+			joinCode.ExpirationDT = &expirationDT
+			expiresAt = expirationDT.UTC().Format(time.RFC3339)
+		}
+		if err := store.JoinCodes().Create(&joinCode); err != nil {
+			return err
+		}
+
+		// the code itself is left out, since anyone who reads it could join
+		event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionGenerateJoinCode, TargetType: db_models.AuditTargetJoinCode, TargetID: joinCode.ID, ClassID: req.ClassID}
+		after := auditFields{"name": joinCode.Name, "role": joinCode.Role, "max_uses": joinCode.MaxUses, "expires_at": expiresAt}
+		if err := recordAuditEvent(store, req.Audit, event, nil, after); err != nil {
+			return err
+		}
+
+		// generate the response
+		resp = service_models.GenerateJoinCodeResponse{
+			JoinCodeSummary: summarizeJoinCode(joinCode),
+		}
+		return nil
+	})
+	if err != nil {
+		return service_models.GenerateJoinCodeResponse{}, err
 	}
 
-	// update the class
-	class.Name = req.Name
-	class.Description = req.Description
+	return resp, nil
+}
+
+// list the join codes of a class, including expired and used up ones until they are revoked
+func (s *ClassService) ListJoinCodes(req service_models.ListJoinCodesRequest) (service_models.ListJoinCodesResponse, error) {
+	// make sure the user is an admin of the class
+	if err := s.requireClassAdmin(s.Store, req.ClassID, req.UserID); err != nil {
+		return service_models.ListJoinCodesResponse{}, err
+	}
 
-	if err := s.DB.Save(&class).Error; err != nil {
-		return err
+	joinCodes, err := s.Store.JoinCodes().ListByClass(req.ClassID)
+	if err != nil {
+		return service_models.ListJoinCodesResponse{}, err
 	}
 
-	return nil
+	// build the response
+	resp := service_models.ListJoinCodesResponse{
+		JoinCodes: make([]service_models.JoinCodeSummary, 0, len(joinCodes)),
+	}
+	for _, joinCode := range joinCodes {
+		resp.JoinCodes = append(resp.JoinCodes, summarizeJoinCode(joinCode))
+	}
+
+	return resp, nil
 }
 
-// generate a join code for a class
-func (s *ClassService) GenerateJoinCode(req service_models.GenerateJoinCodeRequest) (service_models.GenerateJoinCodeResponse, error) {
-	// find the class
-	var class db_models.Class
-	if err := s.DB.First(&class, req.ClassID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return service_models.GenerateJoinCodeResponse{}, ErrClassNotFound
+// revoke one of the join codes of a class
+func (s *ClassService) RevokeJoinCode(req service_models.RevokeJoinCodeRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		// make sure the user is an admin of the class
+		if err := s.requireClassAdmin(store, req.ClassID, req.UserID); err != nil {
+			return err
 		}
-		return service_models.GenerateJoinCodeResponse{}, err
-	}
 
-	// find the class member
-	var classMember db_models.ClassMember
-	if err := s.DB.Where("class_id = ? AND user_id = ?", req.ClassID, req.UserID).First(&classMember).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return service_models.GenerateJoinCodeResponse{}, ErrUnauthorized
+		// find the join code, making sure it belongs to the class
+		joinCode, err := store.JoinCodes().FindByID(req.JoinCodeID)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrJoinCodeNotFound
+			}
+			return err
+		}
+		if joinCode.ClassID != req.ClassID {
+			return ErrJoinCodeNotFound
 		}
+
+		// revoke the join code
+		if err := store.JoinCodes().Delete(joinCode.ID); err != nil {
+			return err
+		}
+
+		event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionRevokeJoinCode, TargetType: db_models.AuditTargetJoinCode, TargetID: joinCode.ID, ClassID: req.ClassID}
+		return recordAuditEvent(store, req.Audit, event, auditFields{"name": joinCode.Name, "role": joinCode.Role, "remaining_uses": joinCode.RemainingUses}, nil)
+	})
+}
+
+// join a class using a join code, or ask to join when the class requires approval
+func (s *ClassService) JoinClass(req service_models.JoinClassRequest) (service_models.JoinClassResponse, error) {
+	var resp service_models.JoinClassResponse
+	err := s.Store.Do(func(store repositories.Store) error {
+		if err := s.checkEmailVerified(store, req.UserID); err != nil {
+			return err
+		}
+
+		// find the join code
+		joinCode, err := store.JoinCodes().FindByCode(req.JoinCode)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrClassNotFound
+			}
+			return err
+		}
+
+		// check if the join code is expired
+		if joinCode.ExpirationDT != nil && time.Now().After(*joinCode.ExpirationDT) {
+			return ErrClassNotFound
+		}
+
+		// find the class
+		class, err := store.Classes().FindByID(joinCode.ClassID)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrClassNotFound
+			}
+			return err
+		}
+
+		// members don't use up the code by joining again
+		if _, err := store.ClassMembers().Find(class.ID, req.UserID); err == nil {
+			return ErrAlreadyMember
+		} else if !errors.Is(err, repositories.ErrNotFound) {
+			return err
+		}
+
+		// one request at a time, so a pending one doesn't use up the code again
+		if class.RequireApproval {
+			if _, err := store.JoinRequests().FindPending(class.ID, req.UserID); err == nil {
+				return ErrJoinRequestPending
+			} else if !errors.Is(err, repositories.ErrNotFound) {
+				return err
+			}
+		}
+
+		// take one of the remaining uses; a used up code looks like an unknown one
+		if joinCode.MaxUses > 0 {
+			ok, err := store.JoinCodes().ConsumeUse(joinCode.ID)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ErrClassNotFound
+			}
+		}
+
+		// wait for an admin to approve the request
+		if class.RequireApproval {
+			joinRequest := db_models.JoinRequest{
+				ClassID:    class.ID,
+				UserID:     req.UserID,
+				JoinCodeID: joinCode.ID,
+				Role:       joinCode.Role,
+				Status:     db_models.JoinRequestPending,
+			}
+			if err := store.JoinRequests().Create(&joinRequest); err != nil {
+				// another request from the user got in first
+				if errors.Is(err, repositories.ErrDuplicate) {
+					return ErrJoinRequestPending
+				}
+				return err
+			}
+
+			event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionRequestToJoin, TargetType: db_models.AuditTargetJoinRequest, TargetID: joinRequest.ID, ClassID: class.ID}
+			if err := recordAuditEvent(store, req.Audit, event, nil, auditFields{"role": joinRequest.Role, "join_code_id": joinCode.ID, "status": joinRequest.Status}); err != nil {
+				return err
+			}
+
+			resp = service_models.JoinClassResponse{Pending: true, RequestID: joinRequest.ID}
+			return nil
+		}
+
+		// add the user to the class with the role the code grants
+		classMember := db_models.ClassMember{
+			ClassID: class.ID,
+			UserID:  req.UserID,
+			Role:    joinCode.Role,
+		}
+		if err := store.ClassMembers().Create(&classMember); err != nil {
+			// another join by the user got in first
+			if errors.Is(err, repositories.ErrDuplicate) {
+				return ErrAlreadyMember
+			}
+			return err
+		}
+
+		event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionJoinClass, TargetType: db_models.AuditTargetClassMember, TargetID: req.UserID, ClassID: class.ID}
+		return recordAuditEvent(store, req.Audit, event, nil, auditFields{"role": classMember.Role, "join_code_id": joinCode.ID})
+	})
+	if err != nil {
+		return service_models.JoinClassResponse{}, err
 	}
-	// make sure the user is an admin
-	if classMember.Role != "admin" {
-		return service_models.GenerateJoinCodeResponse{}, ErrUnauthorized
+
+	return resp, nil
+}
+
+// helper function to describe a join code to class admins
+func summarizeJoinCode(joinCode db_models.JoinCode) service_models.JoinCodeSummary {
+	return service_models.JoinCodeSummary{
+		ID:            joinCode.ID,
+		Code:          joinCode.Code,
+		Name:          joinCode.Name,
+		Role:          joinCode.Role,
+		ExpirationDT:  joinCode.ExpirationDT,
+		MaxUses:       joinCode.MaxUses,
+		RemainingUses: joinCode.RemainingUses,
+		CreatedAt:     joinCode.CreatedAt,
+	}
+}
+
+// list the classes a user is a member of, along with their role in each
+func (s *ClassService) ListClasses(req service_models.ListClassesRequest) (service_models.ListClassesResponse, error) {
+	// resolve the sort column and direction
+	sortByName := req.SortBy == "name"
+	desc := req.Order != "asc"
+	if sortByName && req.Order == "" {
+		desc = false
 	}
 
-	// remove any existing join codes for the class
-	if err := s.DB.Where("class_id = ?", req.ClassID).Delete(&db_models.JoinCode{}).Error; err != nil {
-		return service_models.GenerateJoinCodeResponse{}, err
+	// clamp the page size
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultClassPageSize
+	}
+	if limit > maxClassPageSize {
+		limit = maxClassPageSize
 	}
 
-	// generate a join code
-	joinCode := RandomString(8)
-	expirationDT := time.Now().Add(24 * time.Hour)
-	if err := s.DB.Create(&db_models.JoinCode{
-		Code:         joinCode,
-		ClassID:      req.ClassID,
-		ExpirationDT: expirationDT,
-	}).Error; err != nil {
-		return service_models.GenerateJoinCodeResponse{}, err
+	// fetch one extra row to know if there is another page
+	query := repositories.ClassListQuery{
+		UserID:     req.UserID,
+		Role:       req.Role,
+		SortByName: sortByName,
+		Desc:       desc,
+		Limit:      limit + 1,
+	}
+
+	// continue after the cursor
+	if req.Cursor != "" {
+		cursor, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return service_models.ListClassesResponse{}, err
+		}
+		query.AfterID = cursor.ID
+		if sortByName {
+			query.AfterName = cursor.Value
+		} else {
+			createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				return service_models.ListClassesResponse{}, ErrInvalidCursor
+			}
+			query.AfterCreatedAt = createdAt
+		}
+	}
+
+	rows, err := s.Store.Classes().ListForUser(query)
+	if err != nil {
+		return service_models.ListClassesResponse{}, ErrInternalServerError
 	}
 
-	// generate the response
-	resp := service_models.GenerateJoinCodeResponse{
-		Code:         joinCode,
-		ExpirationDT: expirationDT,
+	// build the response
+	resp := service_models.ListClassesResponse{
+		Classes: make([]service_models.ClassSummary, 0, len(rows)),
+	}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		last := rows[limit-1]
+		cursor := pageCursor{Value: last.CreatedAt.Format(time.RFC3339Nano), ID: last.ID}
+		if sortByName {
+			cursor.Value = last.Name
+		}
+		resp.NextCursor = encodeCursor(cursor)
+	}
+	for _, row := range rows {
+		resp.Classes = append(resp.Classes, service_models.ClassSummary{
+			ClassID:     row.ID,
+			Name:        row.Name,
+			Description: row.Description,
+			Role:        row.Role,
+			MemberCount: row.MemberCount,
+			CreatedAt:   row.CreatedAt,
+			CreatedBy:   row.CreatedBy,
+		})
 	}
 
 	return resp, nil
 }
 
-// join a class using a join code
-func (s *ClassService) JoinClass(req service_models.JoinClassRequest) error {
-	// find the join code
-	var joinCode db_models.JoinCode
-	if err := s.DB.Where("code = ?", req.JoinCode).First(&joinCode).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+// helper function to find the membership of a user in a class
+func (s *ClassService) findClassMember(store repositories.Store, classID uint, userID uint) (*db_models.ClassMember, error) {
+	classMember, err := store.ClassMembers().Find(classID, userID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrMemberNotFound
+		}
+		return nil, err
+	}
+	return classMember, nil
+}
+
+// helper function to make sure a class exists and the user is one of its admins
+func (s *ClassService) requireClassAdmin(store repositories.Store, classID uint, userID uint) error {
+	if _, err := store.Classes().FindByID(classID); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
 			return ErrClassNotFound
 		}
 		return err
 	}
 
-	// check if the join code is expired
-	if time.Now().After(joinCode.ExpirationDT) {
-		return ErrClassNotFound
+	classMember, err := s.findClassMember(store, classID, userID)
+	if err != nil {
+		if errors.Is(err, ErrMemberNotFound) {
+			return ErrUnauthorized
+		}
+		return err
+	}
+	if classMember.Role != "admin" {
+		return ErrUnauthorized
 	}
 
-	// find the class
-	var class db_models.Class
-	if err := s.DB.First(&class, joinCode.ClassID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	return nil
+}
+
+// helper function to make sure removing or demoting a member leaves the class with an admin
+func (s *ClassService) ensureAnotherAdmin(store repositories.Store, classMember *db_models.ClassMember) error {
+	if classMember.Role != "admin" {
+		return nil
+	}
+
+	admins, err := store.ClassMembers().ListAdmins(classMember.ClassID)
+	if err != nil {
+		return err
+	}
+	if len(admins) <= 1 {
+		return ErrLastAdmin
+	}
+
+	return nil
+}
+
+// helper function to make sure a member being removed or demoted isn't the owner of the class
+func (s *ClassService) ensureNotOwner(store repositories.Store, classID uint, memberID uint) error {
+	class, err := store.Classes().FindByID(classID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
 			return ErrClassNotFound
 		}
 		return err
 	}
+	if class.CreatorID == memberID {
+		return ErrOwnerMustTransfer
+	}
+
+	return nil
+}
 
-	// add the user to the class as a member (user)
-	classMember := db_models.ClassMember{
-		ClassID: class.ID,
-		UserID:  req.UserID,
-		Role:    "user",
+// list the members of a class
+func (s *ClassService) ListMembers(req service_models.ListMembersRequest) (service_models.ListMembersResponse, error) {
+	// find the class
+	if _, err := s.Store.Classes().FindByID(req.ClassID); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return service_models.ListMembersResponse{}, ErrClassNotFound
+		}
+		return service_models.ListMembersResponse{}, err
 	}
-	if err := s.DB.Create(&classMember).Error; err != nil {
-		return err
+
+	// only members can see the roster
+	if _, err := s.findClassMember(s.Store, req.ClassID, req.UserID); err != nil {
+		if errors.Is(err, ErrMemberNotFound) {
+			return service_models.ListMembersResponse{}, ErrUnauthorized
+		}
+		return service_models.ListMembersResponse{}, err
+	}
+
+	// find the members along with their usernames
+	classMembers, err := s.Store.ClassMembers().ListByClass(req.ClassID)
+	if err != nil {
+		return service_models.ListMembersResponse{}, err
+	}
+
+	// build the response
+	resp := service_models.ListMembersResponse{
+		Members: make([]service_models.ClassMemberSummary, 0, len(classMembers)),
+	}
+	for _, classMember := range classMembers {
+		resp.Members = append(resp.Members, service_models.ClassMemberSummary{
+			UserID:   classMember.UserID,
+			Username: classMember.User.Username,
+			Role:     classMember.Role,
+			JoinedAt: classMember.CreatedAt,
+		})
 	}
 
+	return resp, nil
+}
+
+// remove a member from a class
+func (s *ClassService) RemoveMember(req service_models.RemoveMemberRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		// make sure the user is an admin of the class
+		if err := s.requireClassAdmin(store, req.ClassID, req.UserID); err != nil {
+			return err
+		}
+
+		// find the member to remove
+		classMember, err := s.findClassMember(store, req.ClassID, req.MemberID)
+		if err != nil {
+			return err
+		}
+
+		// the owner has to hand the class over first
+		if err := s.ensureNotOwner(store, req.ClassID, req.MemberID); err != nil {
+			return err
+		}
+
+		// never remove the last admin
+		if err := s.ensureAnotherAdmin(store, classMember); err != nil {
+			return err
+		}
+
+		// remove the member
+		if err := store.ClassMembers().Delete(classMember.ID); err != nil {
+			return err
+		}
+
+		event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionRemoveMember, TargetType: db_models.AuditTargetClassMember, TargetID: req.MemberID, ClassID: req.ClassID}
+		return recordAuditEvent(store, req.Audit, event, auditFields{"role": classMember.Role}, nil)
+	})
+}
+
+// change the role of a member of a class
+func (s *ClassService) UpdateMemberRole(req service_models.UpdateMemberRoleRequest) error {
+	// input validation
+	if req.Role != "admin" && req.Role != "user" {
+		return ErrInvalidRole
+	}
+
+	return s.Store.Do(func(store repositories.Store) error {
+		// make sure the user is an admin of the class
+		if err := s.requireClassAdmin(store, req.ClassID, req.UserID); err != nil {
+			return err
+		}
+
+		// find the member to update
+		classMember, err := s.findClassMember(store, req.ClassID, req.MemberID)
+		if err != nil {
+			return err
+		}
+		if classMember.Role == req.Role {
+			return nil
+		}
+
+		// the owner has to hand the class over before being demoted
+		if err := s.ensureNotOwner(store, req.ClassID, req.MemberID); err != nil {
+			return err
+		}
+
+		// never demote the last admin
+		if err := s.ensureAnotherAdmin(store, classMember); err != nil {
+			return err
+		}
+
+		// update the role
+		before := auditFields{"role": classMember.Role}
+		classMember.Role = req.Role
+		if err := store.ClassMembers().Update(classMember); err != nil {
+			return err
+		}
+
+		event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionUpdateMemberRole, TargetType: db_models.AuditTargetClassMember, TargetID: req.MemberID, ClassID: req.ClassID}
+		return recordAuditEvent(store, req.Audit, event, before, auditFields{"role": classMember.Role})
+	})
+}
+
+// leave a class
+func (s *ClassService) LeaveClass(req service_models.LeaveClassRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		// find the class
+		class, err := store.Classes().FindByID(req.ClassID)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrClassNotFound
+			}
+			return err
+		}
+
+		// find the membership of the user
+		classMember, err := s.findClassMember(store, req.ClassID, req.UserID)
+		if err != nil {
+			return err
+		}
+
+		// the owner has to hand the class over first
+		if class.CreatorID == req.UserID {
+			return ErrOwnerMustTransfer
+		}
+
+		// never leave the class without an admin
+		if err := s.ensureAnotherAdmin(store, classMember); err != nil {
+			return err
+		}
+
+		// remove the membership
+		if err := store.ClassMembers().Delete(classMember.ID); err != nil {
+			return err
+		}
+
+		event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionLeaveClass, TargetType: db_models.AuditTargetClassMember, TargetID: req.UserID, ClassID: req.ClassID}
+		return recordAuditEvent(store, req.Audit, event, auditFields{"role": classMember.Role}, nil)
+	})
+}
+
+// transfer ownership of a class to another member
+func (s *ClassService) TransferOwnership(req service_models.TransferOwnershipRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		// find the class
+		class, err := store.Classes().FindByID(req.ClassID)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrClassNotFound
+			}
+			return err
+		}
+
+		// only the owner can transfer the class
+		if class.CreatorID != req.UserID {
+			return ErrUnauthorized
+		}
+		if req.NewOwnerID == req.UserID {
+			return nil
+		}
+
+		// the new owner has to be a member already
+		newOwner, err := s.findClassMember(store, req.ClassID, req.NewOwnerID)
+		if err != nil {
+			return err
+		}
+
+		// hand over the class and make the new owner an admin
+		before := auditFields{"owner_id": class.CreatorID, "new_owner_role": newOwner.Role}
+		class.CreatorID = req.NewOwnerID
+		if err := store.Classes().Update(class); err != nil {
+			return err
+		}
+		if newOwner.Role != "admin" {
+			newOwner.Role = "admin"
+			if err := store.ClassMembers().Update(newOwner); err != nil {
+				return err
+			}
+		}
+
+		event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionTransferOwnership, TargetType: db_models.AuditTargetClass, TargetID: class.ID, ClassID: class.ID}
+		return recordAuditEvent(store, req.Audit, event, before, auditFields{"owner_id": class.CreatorID, "new_owner_role": newOwner.Role})
+	})
+}
+
+// helper function to enforce email verification when it is required
+func (s *ClassService) checkEmailVerified(store repositories.Store, userID uint) error {
+	if !s.RequireVerifiedEmail {
+		return nil
+	}
+
+	user, err := store.Users().FindByID(userID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return ErrUnauthorized
+		}
+		return ErrInternalServerError
+	}
+	if !user.EmailVerified {
+		return ErrEmailNotVerified
+	}
 	return nil
 }
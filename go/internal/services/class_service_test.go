@@ -0,0 +1,437 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+func TestCreateAndReadClass(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+
+	class := createTestClass(t, s, owner, "Piano")
+
+	res, err := s.ReadClass(service_models.ReadClassRequest{ClassID: class.ID, UserID: owner.ID})
+	if err != nil {
+		t.Fatalf("ReadClass returned %v", err)
+	}
+	if res.Name != "Piano" || res.CreatedBy != "teacher" {
+		t.Fatalf("unexpected class %+v", res)
+	}
+
+	member, err := store.ClassMembers().Find(class.ID, owner.ID)
+	if err != nil || member.Role != "admin" {
+		t.Fatalf("expected the creator to be an admin, got %+v, %v", member, err)
+	}
+}
+
+func TestReadClassRequiresMembership(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	stranger := createTestUser(t, store, "stranger")
+	class := createTestClass(t, s, owner, "Piano")
+
+	_, err := s.ReadClass(service_models.ReadClassRequest{ClassID: class.ID, UserID: stranger.ID})
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+
+	_, err = s.ReadClass(service_models.ReadClassRequest{ClassID: class.ID + 100, UserID: owner.ID})
+	if !errors.Is(err, ErrClassNotFound) {
+		t.Fatalf("expected ErrClassNotFound, got %v", err)
+	}
+}
+
+func TestUpdateClassRequiresAdmin(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	student := createTestUser(t, store, "student")
+	class := createTestClass(t, s, owner, "Piano")
+	joinTestClass(t, s, class, owner, student)
+
+	err := s.UpdateClass(service_models.UpdateClassRequest{ClassID: class.ID, UserID: student.ID, Name: "Drums"})
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+
+	err = s.UpdateClass(service_models.UpdateClassRequest{ClassID: class.ID, UserID: owner.ID, Name: "Guitar", Description: "Acoustic"})
+	if err != nil {
+		t.Fatalf("UpdateClass returned %v", err)
+	}
+	updated, _ := store.Classes().FindByID(class.ID)
+	if updated.Name != "Guitar" || updated.Description != "Acoustic" {
+		t.Fatalf("unexpected class %+v", updated)
+	}
+}
+
+func TestDeleteClassRemovesMembers(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	student := createTestUser(t, store, "student")
+	class := createTestClass(t, s, owner, "Piano")
+	joinTestClass(t, s, class, owner, student)
+
+	if err := s.DeleteClass(service_models.DeleteClassRequest{ClassID: class.ID, UserID: owner.ID}); err != nil {
+		t.Fatalf("DeleteClass returned %v", err)
+	}
+
+	if _, err := store.Classes().FindByID(class.ID); !errors.Is(err, repositories.ErrNotFound) {
+		t.Fatalf("expected the class to be deleted, got %v", err)
+	}
+	if _, err := store.ClassMembers().Find(class.ID, student.ID); !errors.Is(err, repositories.ErrNotFound) {
+		t.Fatalf("expected the memberships to be deleted, got %v", err)
+	}
+}
+
+func TestJoinClass(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	student := createTestUser(t, store, "student")
+	class := createTestClass(t, s, owner, "Piano")
+
+	joinTestClass(t, s, class, owner, student)
+
+	member, err := store.ClassMembers().Find(class.ID, student.ID)
+	if err != nil || member.Role != "user" {
+		t.Fatalf("expected the student to be a user, got %+v, %v", member, err)
+	}
+}
+
+func TestJoinClassRejectsUnknownAndExpiredCodes(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	student := createTestUser(t, store, "student")
+	class := createTestClass(t, s, owner, "Piano")
+
+	_, err := s.JoinClass(service_models.JoinClassRequest{JoinCode: "NOPE", UserID: student.ID})
+	if !errors.Is(err, ErrClassNotFound) {
+		t.Fatalf("expected ErrClassNotFound, got %v", err)
+	}
+
+	yesterday := time.Now().Add(-24 * time.Hour)
+	expired := db_models.JoinCode{Code: "EXPIRED1", ClassID: class.ID, Role: "user", ExpirationDT: &yesterday}
+	store.JoinCodes().Create(&expired)
+	_, err = s.JoinClass(service_models.JoinClassRequest{JoinCode: "EXPIRED1", UserID: student.ID})
+	if !errors.Is(err, ErrClassNotFound) {
+		t.Fatalf("expected ErrClassNotFound, got %v", err)
+	}
+}
+
+func TestGenerateJoinCodeKeepsPreviousCodes(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	student := createTestUser(t, store, "student")
+	teacher := createTestUser(t, store, "coteacher")
+	class := createTestClass(t, s, owner, "Piano")
+
+	students, err := s.GenerateJoinCode(service_models.GenerateJoinCodeRequest{ClassID: class.ID, UserID: owner.ID, Name: "Students"})
+	if err != nil {
+		t.Fatalf("GenerateJoinCode returned %v", err)
+	}
+	teachers, err := s.GenerateJoinCode(service_models.GenerateJoinCodeRequest{ClassID: class.ID, UserID: owner.ID, Name: "Co-teachers", Role: "admin", NeverExpires: true})
+	if err != nil {
+		t.Fatalf("GenerateJoinCode returned %v", err)
+	}
+	if students.ExpirationDT == nil || time.Until(*students.ExpirationDT) > 24*time.Hour || teachers.ExpirationDT != nil {
+		t.Fatalf("unexpected expiry %v, %v", students.ExpirationDT, teachers.ExpirationDT)
+	}
+
+	// both codes work, each granting its own role
+	if _, err := s.JoinClass(service_models.JoinClassRequest{JoinCode: students.Code, UserID: student.ID}); err != nil {
+		t.Fatalf("JoinClass returned %v", err)
+	}
+	if _, err := s.JoinClass(service_models.JoinClassRequest{JoinCode: teachers.Code, UserID: teacher.ID}); err != nil {
+		t.Fatalf("JoinClass returned %v", err)
+	}
+	if member, _ := store.ClassMembers().Find(class.ID, teacher.ID); member == nil || member.Role != "admin" {
+		t.Fatalf("expected the co-teacher to be an admin, got %+v", member)
+	}
+	if _, err := s.JoinClass(service_models.JoinClassRequest{JoinCode: students.Code, UserID: student.ID}); !errors.Is(err, ErrAlreadyMember) {
+		t.Fatalf("expected ErrAlreadyMember, got %v", err)
+	}
+
+	// admins can list and revoke them one at a time
+	list, err := s.ListJoinCodes(service_models.ListJoinCodesRequest{ClassID: class.ID, UserID: owner.ID})
+	if err != nil || len(list.JoinCodes) != 2 || list.JoinCodes[0].Name != "Students" {
+		t.Fatalf("unexpected join codes %+v, %v", list.JoinCodes, err)
+	}
+	if _, err := s.ListJoinCodes(service_models.ListJoinCodesRequest{ClassID: class.ID, UserID: student.ID}); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+	if err := s.RevokeJoinCode(service_models.RevokeJoinCodeRequest{ClassID: class.ID, UserID: owner.ID, JoinCodeID: students.ID}); err != nil {
+		t.Fatalf("RevokeJoinCode returned %v", err)
+	}
+	if _, err := store.JoinCodes().FindByCode(students.Code); !errors.Is(err, repositories.ErrNotFound) {
+		t.Fatalf("expected the code to be revoked, got %v", err)
+	}
+	if _, err := store.JoinCodes().FindByCode(teachers.Code); err != nil {
+		t.Fatalf("expected the other code to survive, got %v", err)
+	}
+}
+
+func TestGenerateJoinCodeValidatesSettings(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	class := createTestClass(t, s, owner, "Piano")
+
+	for _, req := range []service_models.GenerateJoinCodeRequest{
+		{MaxUses: -1},
+		{ExpiresInHours: -1},
+		{ExpiresInHours: maxJoinCodeHours + 1},
+		{ExpiresInHours: 1, NeverExpires: true},
+		{Name: strings.Repeat("a", maxJoinCodeNameLen+1)},
+	} {
+		req.ClassID, req.UserID = class.ID, owner.ID
+		if _, err := s.GenerateJoinCode(req); !errors.Is(err, ErrInvalidJoinCode) {
+			t.Fatalf("expected ErrInvalidJoinCode for %+v, got %v", req, err)
+		}
+	}
+	if _, err := s.GenerateJoinCode(service_models.GenerateJoinCodeRequest{ClassID: class.ID, UserID: owner.ID, Role: "owner"}); !errors.Is(err, ErrInvalidRole) {
+		t.Fatalf("expected ErrInvalidRole, got %v", err)
+	}
+}
+
+func TestRevokeJoinCodeOfAnotherClass(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	mine := createTestClass(t, s, owner, "Piano")
+	other := createTestUser(t, store, "other")
+	theirs := createTestClass(t, s, other, "Violin")
+
+	code, err := s.GenerateJoinCode(service_models.GenerateJoinCodeRequest{ClassID: theirs.ID, UserID: other.ID})
+	if err != nil {
+		t.Fatalf("GenerateJoinCode returned %v", err)
+	}
+	err = s.RevokeJoinCode(service_models.RevokeJoinCodeRequest{ClassID: mine.ID, UserID: owner.ID, JoinCodeID: code.ID})
+	if !errors.Is(err, ErrJoinCodeNotFound) {
+		t.Fatalf("expected ErrJoinCodeNotFound, got %v", err)
+	}
+}
+
+func TestJoinClassUsesUpLimitedCodes(t *testing.T) {
+	db := newTestDB(t)
+	s := NewClassService(repositories.NewGormStore(db))
+	owner := createTestUser(t, s.Store, "teacher")
+	class := createTestClass(t, s, owner, "Piano")
+
+	code, err := s.GenerateJoinCode(service_models.GenerateJoinCodeRequest{ClassID: class.ID, UserID: owner.ID, MaxUses: 2})
+	if err != nil {
+		t.Fatalf("GenerateJoinCode returned %v", err)
+	}
+	for i, username := range []string{"first", "second", "third"} {
+		user := createTestUser(t, s.Store, username)
+		_, err := s.JoinClass(service_models.JoinClassRequest{JoinCode: code.Code, UserID: user.ID})
+		if i < 2 && err != nil {
+			t.Fatalf("JoinClass returned %v", err)
+		}
+		if i == 2 && !errors.Is(err, ErrClassNotFound) {
+			t.Fatalf("expected the code to be used up, got %v", err)
+		}
+	}
+
+	if n := countRows(t, db, &db_models.JoinCode{}, "id = ? AND remaining_uses = 0", code.ID); n != 1 {
+		t.Fatalf("expected no uses to be left, found %d", n)
+	}
+}
+
+func TestRemoveMemberRefusesOwner(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	coTeacher := createTestUser(t, store, "coteacher")
+	class := createTestClass(t, s, owner, "Piano")
+	joinTestClass(t, s, class, owner, coTeacher)
+	if err := s.UpdateMemberRole(service_models.UpdateMemberRoleRequest{ClassID: class.ID, UserID: owner.ID, MemberID: coTeacher.ID, Role: "admin"}); err != nil {
+		t.Fatalf("UpdateMemberRole returned %v", err)
+	}
+
+	err := s.RemoveMember(service_models.RemoveMemberRequest{ClassID: class.ID, UserID: coTeacher.ID, MemberID: owner.ID})
+	if !errors.Is(err, ErrOwnerMustTransfer) {
+		t.Fatalf("expected ErrOwnerMustTransfer, got %v", err)
+	}
+	if _, err := store.ClassMembers().Find(class.ID, owner.ID); err != nil {
+		t.Fatalf("expected the owner to stay a member, got %v", err)
+	}
+}
+
+func TestUpdateMemberRoleRefusesToDemoteOwner(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	coTeacher := createTestUser(t, store, "coteacher")
+	class := createTestClass(t, s, owner, "Piano")
+	joinTestClass(t, s, class, owner, coTeacher)
+	if err := s.UpdateMemberRole(service_models.UpdateMemberRoleRequest{ClassID: class.ID, UserID: owner.ID, MemberID: coTeacher.ID, Role: "admin"}); err != nil {
+		t.Fatalf("UpdateMemberRole returned %v", err)
+	}
+
+	err := s.UpdateMemberRole(service_models.UpdateMemberRoleRequest{ClassID: class.ID, UserID: coTeacher.ID, MemberID: owner.ID, Role: "user"})
+	if !errors.Is(err, ErrOwnerMustTransfer) {
+		t.Fatalf("expected ErrOwnerMustTransfer, got %v", err)
+	}
+	member, err := store.ClassMembers().Find(class.ID, owner.ID)
+	if err != nil || member.Role != "admin" {
+		t.Fatalf("expected the owner to stay an admin, got %+v, %v", member, err)
+	}
+}
+
+func TestListClassesPaginatesAndFilters(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	student := createTestUser(t, store, "student")
+	for _, name := range []string{"Cello", "Alto", "Bass"} {
+		createTestClass(t, s, owner, name)
+	}
+	other := createTestClass(t, s, student, "Drums")
+	joinTestClass(t, s, other, student, owner)
+
+	first, err := s.ListClasses(service_models.ListClassesRequest{UserID: owner.ID, SortBy: "name", Limit: 2})
+	if err != nil {
+		t.Fatalf("ListClasses returned %v", err)
+	}
+	if len(first.Classes) != 2 || first.Classes[0].Name != "Alto" || first.Classes[1].Name != "Bass" || first.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v", first)
+	}
+
+	second, err := s.ListClasses(service_models.ListClassesRequest{UserID: owner.ID, SortBy: "name", Limit: 2, Cursor: first.NextCursor})
+	if err != nil {
+		t.Fatalf("ListClasses returned %v", err)
+	}
+	if len(second.Classes) != 2 || second.Classes[0].Name != "Cello" || second.Classes[1].Name != "Drums" || second.NextCursor != "" {
+		t.Fatalf("unexpected second page %+v", second)
+	}
+	if second.Classes[1].Role != "user" || second.Classes[1].MemberCount != 2 || second.Classes[1].CreatedBy != "student" {
+		t.Fatalf("unexpected enrolled class %+v", second.Classes[1])
+	}
+
+	teaching, err := s.ListClasses(service_models.ListClassesRequest{UserID: owner.ID, Role: "admin"})
+	if err != nil {
+		t.Fatalf("ListClasses returned %v", err)
+	}
+	if len(teaching.Classes) != 3 {
+		t.Fatalf("expected 3 classes taught, got %d", len(teaching.Classes))
+	}
+
+	if _, err := s.ListClasses(service_models.ListClassesRequest{UserID: owner.ID, Cursor: "%%%"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestCreateClassRollsBackWhenMemberInsertFails(t *testing.T) {
+	db := newTestDB(t)
+	s := NewClassService(repositories.NewGormStore(db))
+	user := createTestUser(t, s.Store, "teacher")
+
+	failOn(t, db, "create", "ClassMember")
+	err := s.CreateClass(service_models.CreateClassRequest{Name: "Piano", UserID: user.ID})
+	if err == nil {
+		t.Fatal("expected CreateClass to fail")
+	}
+
+	if n := countRows(t, db, &db_models.Class{}, "1 = 1"); n != 0 {
+		t.Fatalf("expected no class to be left behind, found %d", n)
+	}
+}
+
+func TestDeleteClassRollsBackWhenMemberDeleteFails(t *testing.T) {
+	db := newTestDB(t)
+	s := NewClassService(repositories.NewGormStore(db))
+	user := createTestUser(t, s.Store, "teacher")
+	class := createTestClass(t, s, user, "Piano")
+
+	failOn(t, db, "delete", "ClassMember")
+	err := s.DeleteClass(service_models.DeleteClassRequest{ClassID: class.ID, UserID: user.ID})
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("expected the injected error, got %v", err)
+	}
+
+	if n := countRows(t, db, &db_models.Class{}, "id = ?", class.ID); n != 1 {
+		t.Fatalf("expected the class to survive, found %d", n)
+	}
+	if n := countRows(t, db, &db_models.ClassMember{}, "class_id = ?", class.ID); n != 1 {
+		t.Fatalf("expected the admin membership to survive, found %d", n)
+	}
+}
+
+func TestGenerateJoinCodeKeepsOldCodeWhenCreateFails(t *testing.T) {
+	db := newTestDB(t)
+	s := NewClassService(repositories.NewGormStore(db))
+	user := createTestUser(t, s.Store, "teacher")
+	class := createTestClass(t, s, user, "Piano")
+
+	first, err := s.GenerateJoinCode(service_models.GenerateJoinCodeRequest{ClassID: class.ID, UserID: user.ID})
+	if err != nil {
+		t.Fatalf("GenerateJoinCode returned %v", err)
+	}
+
+	failOn(t, db, "create", "JoinCode")
+	if _, err := s.GenerateJoinCode(service_models.GenerateJoinCodeRequest{ClassID: class.ID, UserID: user.ID}); !errors.Is(err, errInjected) {
+		t.Fatalf("expected the injected error, got %v", err)
+	}
+
+	if n := countRows(t, db, &db_models.JoinCode{}, "code = ?", first.Code); n != 1 {
+		t.Fatalf("expected the previous join code to survive, found %d", n)
+	}
+}
+
+func TestTransferOwnershipRollsBackWhenPromotionFails(t *testing.T) {
+	db := newTestDB(t)
+	s := NewClassService(repositories.NewGormStore(db))
+	owner := createTestUser(t, s.Store, "owner")
+	student := createTestUser(t, s.Store, "student")
+	class := createTestClass(t, s, owner, "Piano")
+	joinTestClass(t, s, class, owner, student)
+
+	failOn(t, db, "update", "ClassMember")
+	err := s.TransferOwnership(service_models.TransferOwnershipRequest{ClassID: class.ID, UserID: owner.ID, NewOwnerID: student.ID})
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("expected the injected error, got %v", err)
+	}
+
+	db.First(&class, class.ID)
+	if class.CreatorID != owner.ID {
+		t.Fatalf("expected the owner to be unchanged, got %d", class.CreatorID)
+	}
+}
+
+func TestRequireVerifiedEmailBlocksCreateAndJoin(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "owner")
+	class := createTestClass(t, s, owner, "Algebra")
+	code, _ := s.GenerateJoinCode(service_models.GenerateJoinCodeRequest{ClassID: class.ID, UserID: owner.ID})
+	student := createTestUser(t, store, "student")
+
+	s.RequireVerifiedEmail = true
+	err := s.CreateClass(service_models.CreateClassRequest{UserID: student.ID, Name: "Geometry"})
+	if !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("expected ErrEmailNotVerified, got %v", err)
+	}
+	_, err = s.JoinClass(service_models.JoinClassRequest{UserID: student.ID, JoinCode: code.Code})
+	if !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("expected ErrEmailNotVerified, got %v", err)
+	}
+
+	// verified users get through
+	student.EmailVerified = true
+	store.Users().Update(&student)
+	if _, err := s.JoinClass(service_models.JoinClassRequest{UserID: student.ID, JoinCode: code.Code}); err != nil {
+		t.Fatalf("JoinClass returned %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// position of the last row of a page, used to fetch the next one; Value holds the sort key when a list isn't ordered by ID alone
+type pageCursor struct {
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+func encodeCursor(c pageCursor) string {
+	bytes, _ := json.Marshal(c)
+	return base64.URLEncoding.EncodeToString(bytes)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	bytes, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(bytes, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
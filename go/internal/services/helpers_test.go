@@ -0,0 +1,147 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// error returned by statements failed on purpose
+var errInjected = errors.New("injected failure")
+
+// open a fresh in-memory database with every table migrated
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	// a single connection keeps the in-memory database alive and shared
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get database handle: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	err = db.AutoMigrate(
+		&db_models.User{},
+		&db_models.Class{},
+		&db_models.ClassMember{},
+		&db_models.JoinCode{},
+		&db_models.RefreshToken{},
+		&db_models.SecurityEvent{},
+		&db_models.PasswordResetToken{},
+		&db_models.EmailVerificationToken{},
+		&db_models.RecoveryCode{},
+		&db_models.Identity{},
+		&db_models.SignInThrottle{},
+		&db_models.PersonalAccessToken{},
+		&db_models.AdminAction{},
+		&db_models.AuditEvent{},
+		&db_models.JoinRequest{},
+		&db_models.Impersonation{},
+	)
+	if err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	return db
+}
+
+// make every create, update or delete against a table fail until the test ends
+func failOn(t *testing.T, db *gorm.DB, operation string, table string) {
+	t.Helper()
+
+	inject := func(tx *gorm.DB) {
+		if tx.Statement.Table == table {
+			tx.AddError(errInjected)
+		}
+	}
+
+	name := "test:fail_" + operation + "_" + table
+	var err error
+	switch operation {
+	case "create":
+		err = db.Callback().Create().Before("gorm:create").Register(name, inject)
+		t.Cleanup(func() { db.Callback().Create().Remove(name) })
+	case "update":
+		err = db.Callback().Update().Before("gorm:update").Register(name, inject)
+		t.Cleanup(func() { db.Callback().Update().Remove(name) })
+	case "delete":
+		err = db.Callback().Delete().Before("gorm:delete").Register(name, inject)
+		t.Cleanup(func() { db.Callback().Delete().Remove(name) })
+	default:
+		t.Fatalf("unknown operation %q", operation)
+	}
+	if err != nil {
+		t.Fatalf("failed to register callback: %v", err)
+	}
+}
+
+// insert a user directly
+func createTestUser(t *testing.T, store repositories.Store, username string) db_models.User {
+	t.Helper()
+
+	user := db_models.User{
+		Username:       username,
+		Email:          username + "@example.com",
+		HashedPassword: "hashed",
+	}
+	if err := store.Users().Create(&user); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
+
+// create a class owned by the user and return it
+func createTestClass(t *testing.T, s *ClassService, owner db_models.User, name string) db_models.Class {
+	t.Helper()
+
+	if err := s.CreateClass(service_models.CreateClassRequest{Name: name, UserID: owner.ID}); err != nil {
+		t.Fatalf("CreateClass returned %v", err)
+	}
+	res, err := s.ListClasses(service_models.ListClassesRequest{UserID: owner.ID, SortBy: "created_at"})
+	if err != nil || len(res.Classes) == 0 {
+		t.Fatalf("failed to find the new class: %v", err)
+	}
+	class, err := s.Store.Classes().FindByID(res.Classes[0].ClassID)
+	if err != nil {
+		t.Fatalf("failed to find the new class: %v", err)
+	}
+	return *class
+}
+
+// make the user join the class through a fresh join code
+func joinTestClass(t *testing.T, s *ClassService, class db_models.Class, admin db_models.User, user db_models.User) {
+	t.Helper()
+
+	code, err := s.GenerateJoinCode(service_models.GenerateJoinCodeRequest{ClassID: class.ID, UserID: admin.ID})
+	if err != nil {
+		t.Fatalf("GenerateJoinCode returned %v", err)
+	}
+	if _, err := s.JoinClass(service_models.JoinClassRequest{JoinCode: code.Code, UserID: user.ID}); err != nil {
+		t.Fatalf("JoinClass returned %v", err)
+	}
+}
+
+// count the rows of a model matching a condition
+func countRows(t *testing.T, db *gorm.DB, model interface{}, query string, args ...interface{}) int64 {
+	t.Helper()
+
+	var count int64
+	if err := db.Model(model).Where(query, args...).Count(&count).Error; err != nil {
+		t.Fatalf("failed to count rows: %v", err)
+	}
+	return count
+}
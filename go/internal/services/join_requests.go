@@ -0,0 +1,224 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+// define custom error messages
+var (
+	ErrJoinRequestNotFound   = errors.New("join request not found")
+	ErrJoinRequestPending    = errors.New("a request to join this class is already waiting for approval")
+	ErrJoinRequestNotPending = errors.New("the join request was already decided or cancelled")
+	ErrInvalidStatus         = errors.New("invalid status")
+)
+
+// list the join requests of a class with the given status, pending ones by default
+func (s *ClassService) ListJoinRequests(req service_models.ListJoinRequestsRequest) (service_models.ListJoinRequestsResponse, error) {
+	// input validation
+	if req.Status == "" {
+		req.Status = db_models.JoinRequestPending
+	}
+	switch req.Status {
+	case db_models.JoinRequestPending, db_models.JoinRequestApproved, db_models.JoinRequestRejected, db_models.JoinRequestCancelled:
+	default:
+		return service_models.ListJoinRequestsResponse{}, ErrInvalidStatus
+	}
+
+	// make sure the user is an admin of the class
+	if err := s.requireClassAdmin(s.Store, req.ClassID, req.UserID); err != nil {
+		return service_models.ListJoinRequestsResponse{}, err
+	}
+
+	joinRequests, err := s.Store.JoinRequests().ListByClass(req.ClassID, req.Status)
+	if err != nil {
+		return service_models.ListJoinRequestsResponse{}, err
+	}
+
+	// build the response
+	resp := service_models.ListJoinRequestsResponse{
+		JoinRequests: make([]service_models.JoinRequestSummary, 0, len(joinRequests)),
+	}
+	for _, joinRequest := range joinRequests {
+		summary := summarizeJoinRequest(joinRequest)
+		summary.Username = joinRequest.User.Username
+		resp.JoinRequests = append(resp.JoinRequests, summary)
+	}
+
+	return resp, nil
+}
+
+// approve a pending join request, adding the requester to the class with the role their code grants
+func (s *ClassService) ApproveJoinRequest(req service_models.DecideJoinRequestRequest) error {
+	alreadyMember := false
+	err := s.Store.Do(func(store repositories.Store) error {
+		joinRequest, err := s.findPendingJoinRequest(store, req)
+		if err != nil {
+			return err
+		}
+
+		// decide first, so only one of two concurrent approvals goes on to add the member
+		if err := s.decideJoinRequest(store, req, joinRequest, db_models.JoinRequestApproved, db_models.AuditActionApproveJoinRequest); err != nil {
+			return err
+		}
+
+		// the requester may have joined with another code in the meantime; the request is still decided, so it doesn't stay pending
+		if _, err := store.ClassMembers().Find(joinRequest.ClassID, joinRequest.UserID); err == nil {
+			alreadyMember = true
+			return nil
+		} else if !errors.Is(err, repositories.ErrNotFound) {
+			return err
+		}
+
+		// add the requester to the class
+		classMember := db_models.ClassMember{
+			ClassID: joinRequest.ClassID,
+			UserID:  joinRequest.UserID,
+			Role:    joinRequest.Role,
+		}
+		if err := store.ClassMembers().Create(&classMember); err != nil {
+			if errors.Is(err, repositories.ErrDuplicate) {
+				alreadyMember = true
+				return nil
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// report the membership only once the decision is committed
+	if alreadyMember {
+		return ErrAlreadyMember
+	}
+	return nil
+}
+
+// reject a pending join request; the use of the join code stays taken
+func (s *ClassService) RejectJoinRequest(req service_models.DecideJoinRequestRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		joinRequest, err := s.findPendingJoinRequest(store, req)
+		if err != nil {
+			return err
+		}
+
+		return s.decideJoinRequest(store, req, joinRequest, db_models.JoinRequestRejected, db_models.AuditActionRejectJoinRequest)
+	})
+}
+
+// list the user's own join requests, newest first, so they can follow their status
+func (s *ClassService) ListMyJoinRequests(req service_models.ListMyJoinRequestsRequest) (service_models.ListJoinRequestsResponse, error) {
+	joinRequests, err := s.Store.JoinRequests().ListByUser(req.UserID)
+	if err != nil {
+		return service_models.ListJoinRequestsResponse{}, err
+	}
+
+	// build the response
+	resp := service_models.ListJoinRequestsResponse{
+		JoinRequests: make([]service_models.JoinRequestSummary, 0, len(joinRequests)),
+	}
+	for _, joinRequest := range joinRequests {
+		summary := summarizeJoinRequest(joinRequest)
+		summary.ClassName = joinRequest.Class.Name
+		resp.JoinRequests = append(resp.JoinRequests, summary)
+	}
+
+	return resp, nil
+}
+
+// cancel one of the user's own pending join requests
+func (s *ClassService) CancelJoinRequest(req service_models.CancelJoinRequestRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		// find the join request, making sure it is the user's own
+		joinRequest, err := store.JoinRequests().FindByID(req.RequestID)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrJoinRequestNotFound
+			}
+			return err
+		}
+		if joinRequest.UserID != req.UserID {
+			return ErrJoinRequestNotFound
+		}
+		if joinRequest.Status != db_models.JoinRequestPending {
+			return ErrJoinRequestNotPending
+		}
+
+		// cancel the join request, unless an admin decided it in the meantime
+		joinRequest.Status = db_models.JoinRequestCancelled
+		cancelled, err := store.JoinRequests().UpdatePending(joinRequest)
+		if err != nil {
+			return err
+		}
+		if !cancelled {
+			return ErrJoinRequestNotPending
+		}
+
+		event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionCancelJoinRequest, TargetType: db_models.AuditTargetJoinRequest, TargetID: joinRequest.ID, ClassID: joinRequest.ClassID}
+		return recordAuditEvent(store, req.Audit, event, auditFields{"status": db_models.JoinRequestPending}, auditFields{"status": joinRequest.Status})
+	})
+}
+
+// helper function to find a pending join request of a class the user is an admin of
+func (s *ClassService) findPendingJoinRequest(store repositories.Store, req service_models.DecideJoinRequestRequest) (*db_models.JoinRequest, error) {
+	// make sure the user is an admin of the class
+	if err := s.requireClassAdmin(store, req.ClassID, req.UserID); err != nil {
+		return nil, err
+	}
+
+	// find the join request, making sure it belongs to the class
+	joinRequest, err := store.JoinRequests().FindByID(req.RequestID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrJoinRequestNotFound
+		}
+		return nil, err
+	}
+	if joinRequest.ClassID != req.ClassID {
+		return nil, ErrJoinRequestNotFound
+	}
+	if joinRequest.Status != db_models.JoinRequestPending {
+		return nil, ErrJoinRequestNotPending
+	}
+
+	return joinRequest, nil
+}
+
+// helper function to record an admin's decision on a join request
+func (s *ClassService) decideJoinRequest(store repositories.Store, req service_models.DecideJoinRequestRequest, joinRequest *db_models.JoinRequest, status string, action string) error {
+	now := time.Now()
+	joinRequest.Status = status
+	joinRequest.DecidedByID = &req.UserID
+	joinRequest.DecidedAt = &now
+	decided, err := store.JoinRequests().UpdatePending(joinRequest)
+	if err != nil {
+		return err
+	}
+	if !decided {
+		return ErrJoinRequestNotPending
+	}
+
+	event := db_models.AuditEvent{ActorID: req.UserID, Action: action, TargetType: db_models.AuditTargetJoinRequest, TargetID: joinRequest.ID, ClassID: joinRequest.ClassID}
+	before := auditFields{"status": db_models.JoinRequestPending}
+	after := auditFields{"status": status, "user_id": joinRequest.UserID, "role": joinRequest.Role}
+	return recordAuditEvent(store, req.Audit, event, before, after)
+}
+
+// helper function to describe a join request
+func summarizeJoinRequest(joinRequest db_models.JoinRequest) service_models.JoinRequestSummary {
+	return service_models.JoinRequestSummary{
+		ID:        joinRequest.ID,
+		ClassID:   joinRequest.ClassID,
+		UserID:    joinRequest.UserID,
+		Role:      joinRequest.Role,
+		Status:    joinRequest.Status,
+		CreatedAt: joinRequest.CreatedAt,
+		DecidedAt: joinRequest.DecidedAt,
+	}
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+// create a class that requires approval and ask to join it with a fresh code
+func requestToJoinTestClass(t *testing.T, s *ClassService, owner db_models.User, user db_models.User) (db_models.Class, uint) {
+	t.Helper()
+
+	class := createTestClass(t, s, owner, "Piano")
+	requireApproval := true
+	if err := s.UpdateClass(service_models.UpdateClassRequest{ClassID: class.ID, UserID: owner.ID, Name: class.Name, RequireApproval: &requireApproval}); err != nil {
+		t.Fatalf("UpdateClass returned %v", err)
+	}
+
+	code, err := s.GenerateJoinCode(service_models.GenerateJoinCodeRequest{ClassID: class.ID, UserID: owner.ID})
+	if err != nil {
+		t.Fatalf("GenerateJoinCode returned %v", err)
+	}
+	res, err := s.JoinClass(service_models.JoinClassRequest{JoinCode: code.Code, UserID: user.ID})
+	if err != nil {
+		t.Fatalf("JoinClass returned %v", err)
+	}
+	if !res.Pending || res.RequestID == 0 {
+		t.Fatalf("expected a pending join request, got %+v", res)
+	}
+	return class, res.RequestID
+}
+
+func TestJoinClassRequiringApprovalCreatesRequest(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	student := createTestUser(t, store, "student")
+
+	class, requestID := requestToJoinTestClass(t, s, owner, student)
+
+	// the student isn't a member until the request is approved
+	if _, err := store.ClassMembers().Find(class.ID, student.ID); !errors.Is(err, repositories.ErrNotFound) {
+		t.Fatalf("expected no membership yet, got %v", err)
+	}
+
+	// asking again while the request is pending is refused
+	code, err := s.GenerateJoinCode(service_models.GenerateJoinCodeRequest{ClassID: class.ID, UserID: owner.ID})
+	if err != nil {
+		t.Fatalf("GenerateJoinCode returned %v", err)
+	}
+	if _, err := s.JoinClass(service_models.JoinClassRequest{JoinCode: code.Code, UserID: student.ID}); !errors.Is(err, ErrJoinRequestPending) {
+		t.Fatalf("expected ErrJoinRequestPending, got %v", err)
+	}
+
+	// the admin sees the request with the requester's name
+	res, err := s.ListJoinRequests(service_models.ListJoinRequestsRequest{ClassID: class.ID, UserID: owner.ID})
+	if err != nil || len(res.JoinRequests) != 1 {
+		t.Fatalf("expected one pending request, got %+v, %v", res, err)
+	}
+	if res.JoinRequests[0].ID != requestID || res.JoinRequests[0].Username != "student" {
+		t.Fatalf("unexpected join request %+v", res.JoinRequests[0])
+	}
+
+	// the requester sees it with the class name
+	mine, err := s.ListMyJoinRequests(service_models.ListMyJoinRequestsRequest{UserID: student.ID})
+	if err != nil || len(mine.JoinRequests) != 1 || mine.JoinRequests[0].ClassName != "Piano" || mine.JoinRequests[0].Status != db_models.JoinRequestPending {
+		t.Fatalf("expected the student to see their pending request, got %+v, %v", mine, err)
+	}
+}
+
+func TestApproveJoinRequest(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	student := createTestUser(t, store, "student")
+	class, requestID := requestToJoinTestClass(t, s, owner, student)
+
+	// only admins of the class can decide
+	err := s.ApproveJoinRequest(service_models.DecideJoinRequestRequest{ClassID: class.ID, UserID: student.ID, RequestID: requestID})
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+
+	if err := s.ApproveJoinRequest(service_models.DecideJoinRequestRequest{ClassID: class.ID, UserID: owner.ID, RequestID: requestID}); err != nil {
+		t.Fatalf("ApproveJoinRequest returned %v", err)
+	}
+	member, err := store.ClassMembers().Find(class.ID, student.ID)
+	if err != nil || member.Role != "user" {
+		t.Fatalf("expected the student to be a user, got %+v, %v", member, err)
+	}
+
+	joinRequest, err := store.JoinRequests().FindByID(requestID)
+	if err != nil || joinRequest.Status != db_models.JoinRequestApproved || joinRequest.DecidedByID == nil || *joinRequest.DecidedByID != owner.ID {
+		t.Fatalf("expected the request to be approved by the owner, got %+v, %v", joinRequest, err)
+	}
+
+	// a decided request can't be decided again
+	err = s.RejectJoinRequest(service_models.DecideJoinRequestRequest{ClassID: class.ID, UserID: owner.ID, RequestID: requestID})
+	if !errors.Is(err, ErrJoinRequestNotPending) {
+		t.Fatalf("expected ErrJoinRequestNotPending, got %v", err)
+	}
+}
+
+func TestRejectJoinRequest(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	student := createTestUser(t, store, "student")
+	class, requestID := requestToJoinTestClass(t, s, owner, student)
+
+	// a request is only found through its own class
+	other := createTestClass(t, s, owner, "Drums")
+	err := s.RejectJoinRequest(service_models.DecideJoinRequestRequest{ClassID: other.ID, UserID: owner.ID, RequestID: requestID})
+	if !errors.Is(err, ErrJoinRequestNotFound) {
+		t.Fatalf("expected ErrJoinRequestNotFound, got %v", err)
+	}
+
+	if err := s.RejectJoinRequest(service_models.DecideJoinRequestRequest{ClassID: class.ID, UserID: owner.ID, RequestID: requestID}); err != nil {
+		t.Fatalf("RejectJoinRequest returned %v", err)
+	}
+	if _, err := store.ClassMembers().Find(class.ID, student.ID); !errors.Is(err, repositories.ErrNotFound) {
+		t.Fatalf("expected no membership, got %v", err)
+	}
+
+	res, err := s.ListJoinRequests(service_models.ListJoinRequestsRequest{ClassID: class.ID, UserID: owner.ID, Status: db_models.JoinRequestRejected})
+	if err != nil || len(res.JoinRequests) != 1 || res.JoinRequests[0].DecidedAt == nil {
+		t.Fatalf("expected one rejected request, got %+v, %v", res, err)
+	}
+}
+
+func TestCancelJoinRequest(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := NewClassService(store)
+	owner := createTestUser(t, store, "teacher")
+	student := createTestUser(t, store, "student")
+	_, requestID := requestToJoinTestClass(t, s, owner, student)
+
+	// only the requester can cancel
+	err := s.CancelJoinRequest(service_models.CancelJoinRequestRequest{UserID: owner.ID, RequestID: requestID})
+	if !errors.Is(err, ErrJoinRequestNotFound) {
+		t.Fatalf("expected ErrJoinRequestNotFound, got %v", err)
+	}
+
+	if err := s.CancelJoinRequest(service_models.CancelJoinRequestRequest{UserID: student.ID, RequestID: requestID}); err != nil {
+		t.Fatalf("CancelJoinRequest returned %v", err)
+	}
+	err = s.CancelJoinRequest(service_models.CancelJoinRequestRequest{UserID: student.ID, RequestID: requestID})
+	if !errors.Is(err, ErrJoinRequestNotPending) {
+		t.Fatalf("expected ErrJoinRequestNotPending, got %v", err)
+	}
+
+	mine, err := s.ListMyJoinRequests(service_models.ListMyJoinRequestsRequest{UserID: student.ID})
+	if err != nil || len(mine.JoinRequests) != 1 || mine.JoinRequests[0].Status != db_models.JoinRequestCancelled {
+		t.Fatalf("expected the request to be cancelled, got %+v, %v", mine, err)
+	}
+}
+
+func TestApproveJoinRequestAfterJoiningElsewhere(t *testing.T) {
+	db := newTestDB(t)
+	s := NewClassService(repositories.NewGormStore(db))
+	owner := createTestUser(t, s.Store, "teacher")
+	student := createTestUser(t, s.Store, "student")
+	class, requestID := requestToJoinTestClass(t, s, owner, student)
+
+	// the student became a member some other way in the meantime
+	if err := s.Store.ClassMembers().Create(&db_models.ClassMember{ClassID: class.ID, UserID: student.ID, Role: "user"}); err != nil {
+		t.Fatalf("failed to add member: %v", err)
+	}
+
+	err := s.ApproveJoinRequest(service_models.DecideJoinRequestRequest{ClassID: class.ID, UserID: owner.ID, RequestID: requestID})
+	if !errors.Is(err, ErrAlreadyMember) {
+		t.Fatalf("expected ErrAlreadyMember, got %v", err)
+	}
+	// the request is decided anyway, so it doesn't wait for an approval that can never succeed
+	if n := countRows(t, db, &db_models.JoinRequest{}, "id = ? AND status = ?", requestID, db_models.JoinRequestApproved); n != 1 {
+		t.Fatalf("expected the request to be approved, found %d", n)
+	}
+	if n := countRows(t, db, &db_models.ClassMember{}, "class_id = ? AND user_id = ?", class.ID, student.ID); n != 1 {
+		t.Fatalf("expected one membership, found %d", n)
+	}
+	err = s.ApproveJoinRequest(service_models.DecideJoinRequestRequest{ClassID: class.ID, UserID: owner.ID, RequestID: requestID})
+	if !errors.Is(err, ErrJoinRequestNotPending) {
+		t.Fatalf("expected ErrJoinRequestNotPending, got %v", err)
+	}
+}
+
+// a store whose join request lookups wait for each other, so both decisions read the request while it is still pending
+type joinRequestBarrierStore struct {
+	repositories.Store
+	barrier *sync.WaitGroup
+}
+
+func (s joinRequestBarrierStore) Do(fn func(store repositories.Store) error) error {
+	return fn(s)
+}
+
+func (s joinRequestBarrierStore) JoinRequests() repositories.JoinRequestRepository {
+	return joinRequestBarrierRepository{JoinRequestRepository: s.Store.JoinRequests(), barrier: s.barrier}
+}
+
+type joinRequestBarrierRepository struct {
+	repositories.JoinRequestRepository
+	barrier *sync.WaitGroup
+}
+
+func (r joinRequestBarrierRepository) FindByID(id uint) (*db_models.JoinRequest, error) {
+	joinRequest, err := r.JoinRequestRepository.FindByID(id)
+	r.barrier.Done()
+	r.barrier.Wait()
+	return joinRequest, err
+}
+
+func TestConcurrentDecisionsOnJoinRequest(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	owner := createTestUser(t, store, "teacher")
+	student := createTestUser(t, store, "student")
+	class, requestID := requestToJoinTestClass(t, NewClassService(store), owner, student)
+
+	barrier := &sync.WaitGroup{}
+	barrier.Add(2)
+	s := NewClassService(joinRequestBarrierStore{Store: store, barrier: barrier})
+
+	// one admin approves while the other rejects
+	req := service_models.DecideJoinRequestRequest{ClassID: class.ID, UserID: owner.ID, RequestID: requestID}
+	errs := make(chan error, 2)
+	go func() { errs <- s.ApproveJoinRequest(req) }()
+	go func() { errs <- s.RejectJoinRequest(req) }()
+
+	succeeded := 0
+	for i := 0; i < 2; i++ {
+		err := <-errs
+		if err == nil {
+			succeeded++
+		} else if !errors.Is(err, ErrJoinRequestNotPending) {
+			t.Fatalf("expected ErrJoinRequestNotPending, got %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("expected exactly one decision to stand, got %d", succeeded)
+	}
+
+	// the member exists exactly when the approval won
+	joinRequest, _ := store.JoinRequests().FindByID(requestID)
+	_, err := store.ClassMembers().Find(class.ID, student.ID)
+	if approved := joinRequest.Status == db_models.JoinRequestApproved; approved != (err == nil) {
+		t.Fatalf("request is %s but membership lookup returned %v", joinRequest.Status, err)
+	}
+}
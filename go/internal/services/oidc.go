@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/oidc"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+// define custom error messages
+var (
+	ErrUnknownProvider   = errors.New("Unknown sign in provider")
+	ErrInvalidOIDCState  = errors.New("Sign in expired or was started in another browser, please try again")
+	ErrOIDCLoginFailed   = errors.New("Sign in with the provider failed")
+	ErrOIDCEmailRequired = errors.New("The provider did not share a verified email address")
+	ErrIdentityConflict  = errors.New("An account already uses this email; sign in with your password and verify your email first")
+)
+
+// characters kept when building a username from a provider's profile
+var usernameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// start a login with an OIDC provider, returning where to send the user and the state to keep until the callback
+func (s *AuthService) StartOIDCLogin(req service_models.StartOIDCLoginRequest) (service_models.StartOIDCLoginResponse, error) {
+	provider, ok := s.OIDCProviders[req.Provider]
+	if !ok {
+		return service_models.StartOIDCLoginResponse{}, ErrUnknownProvider
+	}
+
+	// generate the state, nonce and PKCE code verifier for this attempt
+	state := auth.OIDCState{Provider: provider.Name}
+	for _, value := range []*string{&state.State, &state.Nonce, &state.CodeVerifier} {
+		var err error
+		if *value, err = oidc.GenerateRandomValue(); err != nil {
+			return service_models.StartOIDCLoginResponse{}, ErrTokenGeneration
+		}
+	}
+	stateToken, err := auth.GenerateOIDCStateToken(state)
+	if err != nil {
+		return service_models.StartOIDCLoginResponse{}, ErrTokenGeneration
+	}
+
+	authURL, err := provider.AuthCodeURL(context.Background(), state.State, state.Nonce, state.CodeVerifier)
+	if err != nil {
+		return service_models.StartOIDCLoginResponse{}, ErrOIDCLoginFailed
+	}
+
+	return service_models.StartOIDCLoginResponse{
+		AuthURL:    authURL,
+		StateToken: stateToken,
+	}, nil
+}
+
+// finish a login with an OIDC provider, signing in the linked user and creating or linking an account on first use
+func (s *AuthService) FinishOIDCLogin(req service_models.FinishOIDCLoginRequest) (service_models.SignInResponse, error) {
+	provider, ok := s.OIDCProviders[req.Provider]
+	if !ok {
+		return service_models.SignInResponse{}, ErrUnknownProvider
+	}
+
+	// the callback must belong to the login started in this browser
+	state, err := auth.ParseOIDCStateToken(req.StateToken)
+	if err != nil || state.Provider != provider.Name || state.State != req.State {
+		return service_models.SignInResponse{}, ErrInvalidOIDCState
+	}
+
+	// trade the code for the user's verified identity
+	claims, err := provider.Exchange(context.Background(), req.Code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		return service_models.SignInResponse{}, ErrOIDCLoginFailed
+	}
+
+	var user *db_models.User
+	err = s.Store.Do(func(store repositories.Store) error {
+		user, err = findOrLinkIdentity(store, provider.Name, claims, req.Audit)
+		return err
+	})
+	if err != nil {
+		return service_models.SignInResponse{}, err
+	}
+	if err := checkAccountStatus(user); err != nil {
+		return service_models.SignInResponse{}, err
+	}
+
+	// two-factor authentication still applies
+	if user.TOTPEnabled {
+		mfaToken, err := auth.GenerateMFAToken(user.ID)
+		if err != nil {
+			return service_models.SignInResponse{}, ErrTokenGeneration
+		}
+		return service_models.SignInResponse{MFARequired: true, MFAToken: mfaToken}, nil
+	}
+
+	var res service_models.SignInResponse
+	err = s.Store.Do(func(store repositories.Store) error {
+		res, err = startSession(store, user, req.UserAgent, req.IPAddress)
+		return err
+	})
+	if err != nil {
+		return service_models.SignInResponse{}, err
+	}
+
+	return res, nil
+}
+
+// helper function to find the user an identity belongs to, linking it to an existing account by verified email or creating one
+func findOrLinkIdentity(store repositories.Store, provider string, claims *oidc.Claims, audit service_models.AuditContext) (*db_models.User, error) {
+	// an identity that was linked before
+	identity, err := store.Identities().FindByProviderSubject(provider, claims.Subject)
+	if err == nil {
+		user, err := store.Users().FindByID(identity.UserID)
+		if err != nil {
+			return nil, ErrInternalServerError
+		}
+		return user, nil
+	}
+	if !errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrInternalServerError
+	}
+
+	// an unverified email could belong to anyone, so it can't be used to find or create an account
+	email := strings.TrimSpace(strings.ToLower(claims.Email))
+	if email == "" || !claims.EmailVerified {
+		return nil, ErrOIDCEmailRequired
+	}
+
+	user, err := store.Users().FindByEmail(email)
+	if err == nil {
+		// only link when both sides have proven they own the email, so nobody can pre-register someone else's address
+		if !user.EmailVerified {
+			return nil, ErrIdentityConflict
+		}
+	} else if errors.Is(err, repositories.ErrNotFound) {
+		if user, err = createOIDCUser(store, email, claims, audit); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, ErrInternalServerError
+	}
+
+	// link the identity
+	identity = &db_models.Identity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    email,
+	}
+	if err := store.Identities().Create(identity); err != nil {
+		return nil, ErrInternalServerError
+	}
+
+	event := db_models.AuditEvent{ActorID: user.ID, Action: db_models.AuditActionLinkIdentity, TargetType: db_models.AuditTargetUser, TargetID: user.ID}
+	if err := recordAuditEvent(store, audit, event, nil, auditFields{"provider": provider, "email": email}); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// helper function to create an account for a new user of an OIDC provider; it has no password until one is reset
+func createOIDCUser(store repositories.Store, email string, claims *oidc.Claims, audit service_models.AuditContext) (*db_models.User, error) {
+	// base the username on the provider's profile, adding a number until it is free
+	base := usernameUnsafe.ReplaceAllString(claims.PreferredUsername, "")
+	if base == "" {
+		base = usernameUnsafe.ReplaceAllString(strings.Split(email, "@")[0], "")
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	username := base
+	for i := 2; ; i++ {
+		_, err := store.Users().FindByUsername(username)
+		if errors.Is(err, repositories.ErrNotFound) {
+			break
+		}
+		if err != nil || i > 100 {
+			return nil, ErrInternalServerError
+		}
+		username = fmt.Sprintf("%s%d", base, i)
+	}
+
+	user := db_models.User{
+		Username:      username,
+		Email:         email,
+		EmailVerified: true,
+	}
+	if err := store.Users().Create(&user); err != nil {
+		return nil, ErrInternalServerError
+	}
+
+	event := db_models.AuditEvent{ActorID: user.ID, Action: db_models.AuditActionSignUp, TargetType: db_models.AuditTargetUser, TargetID: user.ID}
+	if err := recordAuditEvent(store, audit, event, nil, auditFields{"username": user.Username, "email": user.Email}); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/oidc/oidctest"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+// create an AuthService that signs in with a mock provider named "test"
+func newTestOIDCAuthService(t *testing.T, store repositories.Store) (*AuthService, *oidctest.Server) {
+	t.Helper()
+
+	server := oidctest.NewServer()
+	t.Cleanup(server.Close)
+	s := newTestAuthService(store)
+	s.OIDCProviders["test"] = server.Provider("test", "http://localhost:8080/auth/oidc/test/callback")
+	return s, server
+}
+
+// run a login through the mock provider as the given user
+func oidcLogin(t *testing.T, s *AuthService, server *oidctest.Server, user oidctest.User) (service_models.SignInResponse, error) {
+	t.Helper()
+
+	start, err := s.StartOIDCLogin(service_models.StartOIDCLoginRequest{Provider: "test"})
+	if err != nil {
+		t.Fatalf("StartOIDCLogin returned %v", err)
+	}
+	code, state, err := server.Authorize(start.AuthURL, user)
+	if err != nil {
+		t.Fatalf("Authorize returned %v", err)
+	}
+	return s.FinishOIDCLogin(service_models.FinishOIDCLoginRequest{Provider: "test", Code: code, State: state, StateToken: start.StateToken})
+}
+
+func TestOIDCLoginCreatesAndReusesAccount(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s, server := newTestOIDCAuthService(t, store)
+	signUpTestUser(t, s, "alice")
+	provider := oidctest.User{Subject: "sub-1", Email: "Alice@Elsewhere.com", EmailVerified: true, PreferredUsername: "alice"}
+
+	res, err := oidcLogin(t, s, server, provider)
+	if err != nil {
+		t.Fatalf("FinishOIDCLogin returned %v", err)
+	}
+	if res.AccessToken == "" || res.RefreshToken == "" {
+		t.Fatalf("expected tokens, got %+v", res)
+	}
+
+	// the new account gets a free username and a verified email
+	user, err := store.Users().FindByEmail("alice@elsewhere.com")
+	if err != nil {
+		t.Fatalf("expected an account to be created, got %v", err)
+	}
+	if user.Username != "alice2" || !user.EmailVerified {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	// signing in again uses the linked identity
+	if _, err := oidcLogin(t, s, server, provider); err != nil {
+		t.Fatalf("second FinishOIDCLogin returned %v", err)
+	}
+	identities, _ := store.Identities().ListByUser(user.ID)
+	if len(identities) != 1 || identities[0].Subject != "sub-1" {
+		t.Fatalf("expected one linked identity, got %+v", identities)
+	}
+}
+
+func TestOIDCLoginLinksByVerifiedEmail(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s, server := newTestOIDCAuthService(t, store)
+	signUpTestUser(t, s, "alice")
+	provider := oidctest.User{Subject: "sub-1", Email: "alice@example.com", EmailVerified: true}
+
+	// the local account hasn't proven it owns the email yet
+	if _, err := oidcLogin(t, s, server, provider); !errors.Is(err, ErrIdentityConflict) {
+		t.Fatalf("expected ErrIdentityConflict, got %v", err)
+	}
+
+	user, _ := store.Users().FindByUsername("alice")
+	user.EmailVerified = true
+	store.Users().Update(user)
+
+	if _, err := oidcLogin(t, s, server, provider); err != nil {
+		t.Fatalf("FinishOIDCLogin returned %v", err)
+	}
+	identities, _ := store.Identities().ListByUser(user.ID)
+	if len(identities) != 1 {
+		t.Fatalf("expected the identity to be linked to alice, got %+v", identities)
+	}
+
+	// an email the provider hasn't verified is never trusted
+	unverified := oidctest.User{Subject: "sub-2", Email: "alice@example.com"}
+	if _, err := oidcLogin(t, s, server, unverified); !errors.Is(err, ErrOIDCEmailRequired) {
+		t.Fatalf("expected ErrOIDCEmailRequired, got %v", err)
+	}
+}
+
+func TestOIDCLoginChecksState(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s, server := newTestOIDCAuthService(t, store)
+
+	if _, err := s.StartOIDCLogin(service_models.StartOIDCLoginRequest{Provider: "other"}); !errors.Is(err, ErrUnknownProvider) {
+		t.Fatalf("expected ErrUnknownProvider, got %v", err)
+	}
+
+	// a callback from a login started in another browser is refused
+	first, _ := s.StartOIDCLogin(service_models.StartOIDCLoginRequest{Provider: "test"})
+	second, _ := s.StartOIDCLogin(service_models.StartOIDCLoginRequest{Provider: "test"})
+	code, state, err := server.Authorize(first.AuthURL, oidctest.User{Subject: "sub-1", Email: "bob@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatalf("Authorize returned %v", err)
+	}
+	_, err = s.FinishOIDCLogin(service_models.FinishOIDCLoginRequest{Provider: "test", Code: code, State: state, StateToken: second.StateToken})
+	if !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("expected ErrInvalidOIDCState, got %v", err)
+	}
+}
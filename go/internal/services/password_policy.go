@@ -0,0 +1,37 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/hawkerd/privateinstruction/internal/auth"
+)
+
+// define custom error messages
+var (
+	ErrWeakPassword = errors.New("Password does not meet the requirements")
+)
+
+// returned when a new password breaks the password policy, listing every rule it broke
+type PasswordPolicyError struct {
+	Violations []auth.PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return ErrWeakPassword.Error()
+}
+
+func (e *PasswordPolicyError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+// check a new password against the policy
+func (s *AuthService) checkPasswordPolicy(password string, username string, email string) error {
+	violations, err := s.PasswordPolicy.Check(password, username, email)
+	if err != nil {
+		return ErrInternalServerError
+	}
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+func TestPasswordPolicyAppliesToNewPasswords(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+
+	err := s.SignUp(service_models.SignUpRequest{Username: "alice", Email: "alice@example.com", Password: "alice"})
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) || !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("expected a PasswordPolicyError, got %v", err)
+	}
+	if len(policyErr.Violations) != 3 {
+		t.Fatalf("expected too short, username and email violations, got %+v", policyErr.Violations)
+	}
+	if _, err := store.Users().FindByUsername("alice"); !errors.Is(err, repositories.ErrNotFound) {
+		t.Fatalf("expected no user to be created, got %v", err)
+	}
+
+	signUpTestUser(t, s, "alice")
+	user, _ := store.Users().FindByUsername("alice")
+	err = s.UpdatePassword(service_models.UpdatePasswordRequest{UserID: user.ID, OldPassword: "password", NewPassword: "short"})
+	if !errors.As(err, &policyErr) || policyErr.Violations[0].Code != auth.PasswordTooShort {
+		t.Fatalf("expected a too short violation, got %v", err)
+	}
+}
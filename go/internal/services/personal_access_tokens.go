@@ -0,0 +1,181 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+// define custom error messages
+var (
+	ErrInvalidTokenName       = errors.New("Token name must be between 1 and 100 characters")
+	ErrInvalidScope           = errors.New("Unknown or missing scope")
+	ErrInvalidTokenExpiration = errors.New("Token must expire within 1 to 365 days")
+	ErrTokenNotFound          = errors.New("token not found")
+)
+
+// personal access token limits
+const (
+	defaultPersonalAccessTokenDays = 30
+	maxPersonalAccessTokenDays     = 365
+	// last use is only written this often, so busy scripts don't write on every request
+	personalAccessTokenUseInterval = time.Minute
+)
+
+// mint a personal access token; the token is only returned here
+func (s *AuthService) CreatePersonalAccessToken(req service_models.CreatePersonalAccessTokenRequest) (service_models.CreatePersonalAccessTokenResponse, error) {
+	// input validation
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		return service_models.CreatePersonalAccessTokenResponse{}, ErrInvalidTokenName
+	}
+	if len(req.Scopes) == 0 {
+		return service_models.CreatePersonalAccessTokenResponse{}, ErrInvalidScope
+	}
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			return service_models.CreatePersonalAccessTokenResponse{}, ErrInvalidScope
+		}
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultPersonalAccessTokenDays
+	}
+	if req.ExpiresInDays < 1 || req.ExpiresInDays > maxPersonalAccessTokenDays {
+		return service_models.CreatePersonalAccessTokenResponse{}, ErrInvalidTokenExpiration
+	}
+
+	// generate the token
+	token, selector, verifier, err := auth.GenerateToken()
+	if err != nil {
+		return service_models.CreatePersonalAccessTokenResponse{}, ErrTokenGeneration
+	}
+
+	// store its hash
+	personalAccessToken := db_models.PersonalAccessToken{
+		UserID:      req.UserID,
+		Name:        req.Name,
+		Selector:    selector,
+		HashedToken: auth.HashVerifier(verifier),
+		Scopes:      strings.Join(req.Scopes, " "),
+		ExpiresAt:   time.Now().AddDate(0, 0, req.ExpiresInDays),
+	}
+	err = s.Store.Do(func(store repositories.Store) error {
+		if err := store.PersonalAccessTokens().Create(&personalAccessToken); err != nil {
+			return ErrInternalServerError
+		}
+
+		event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionCreatePersonalAccessToken, TargetType: db_models.AuditTargetPersonalAccessToken, TargetID: personalAccessToken.ID}
+		return recordAuditEvent(store, req.Audit, event, nil, auditFields{
+			"name":       personalAccessToken.Name,
+			"scopes":     personalAccessToken.Scopes,
+			"expires_at": personalAccessToken.ExpiresAt.UTC().Format(time.RFC3339),
+		})
+	})
+	if err != nil {
+		return service_models.CreatePersonalAccessTokenResponse{}, err
+	}
+
+	return service_models.CreatePersonalAccessTokenResponse{
+		PersonalAccessTokenSummary: summarizePersonalAccessToken(personalAccessToken),
+		Token:                      auth.PersonalAccessTokenPrefix + token,
+	}, nil
+}
+
+// list a user's personal access tokens, without the tokens themselves
+func (s *AuthService) ListPersonalAccessTokens(req service_models.ListPersonalAccessTokensRequest) (service_models.ListPersonalAccessTokensResponse, error) {
+	personalAccessTokens, err := s.Store.PersonalAccessTokens().ListByUser(req.UserID)
+	if err != nil {
+		return service_models.ListPersonalAccessTokensResponse{}, ErrInternalServerError
+	}
+
+	tokens := make([]service_models.PersonalAccessTokenSummary, 0, len(personalAccessTokens))
+	for _, personalAccessToken := range personalAccessTokens {
+		tokens = append(tokens, summarizePersonalAccessToken(personalAccessToken))
+	}
+
+	return service_models.ListPersonalAccessTokensResponse{Tokens: tokens}, nil
+}
+
+// revoke one of the user's personal access tokens
+func (s *AuthService) RevokePersonalAccessToken(req service_models.RevokePersonalAccessTokenRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		personalAccessTokens, err := store.PersonalAccessTokens().ListByUser(req.UserID)
+		if err != nil {
+			return ErrInternalServerError
+		}
+		for _, personalAccessToken := range personalAccessTokens {
+			if personalAccessToken.ID == req.TokenID {
+				if err := store.PersonalAccessTokens().Delete(personalAccessToken.ID); err != nil {
+					return ErrInternalServerError
+				}
+
+				event := db_models.AuditEvent{ActorID: req.UserID, Action: db_models.AuditActionRevokePersonalAccessToken, TargetType: db_models.AuditTargetPersonalAccessToken, TargetID: personalAccessToken.ID}
+				return recordAuditEvent(store, req.Audit, event, auditFields{"name": personalAccessToken.Name, "scopes": personalAccessToken.Scopes}, nil)
+			}
+		}
+		return ErrTokenNotFound
+	})
+}
+
+// check a personal access token presented with a request, recording its use
+func (s *AuthService) AuthenticatePersonalAccessToken(req service_models.AuthenticatePersonalAccessTokenRequest) (service_models.AuthenticatePersonalAccessTokenResponse, error) {
+	selector, verifier, err := auth.SplitToken(strings.TrimPrefix(req.Token, auth.PersonalAccessTokenPrefix))
+	if err != nil {
+		return service_models.AuthenticatePersonalAccessTokenResponse{}, ErrInvalidCredentials
+	}
+
+	// find the token and make sure it is still usable
+	personalAccessToken, err := s.Store.PersonalAccessTokens().FindBySelector(selector)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return service_models.AuthenticatePersonalAccessTokenResponse{}, ErrInvalidCredentials
+		}
+		return service_models.AuthenticatePersonalAccessTokenResponse{}, ErrInternalServerError
+	}
+	now := time.Now()
+	if !auth.CheckVerifier(personalAccessToken.HashedToken, verifier) || now.After(personalAccessToken.ExpiresAt) {
+		return service_models.AuthenticatePersonalAccessTokenResponse{}, ErrInvalidCredentials
+	}
+
+	user, err := s.Store.Users().FindByID(personalAccessToken.UserID)
+	if err != nil {
+		return service_models.AuthenticatePersonalAccessTokenResponse{}, ErrInvalidCredentials
+	}
+	if err := checkAccountStatus(user); err != nil {
+		return service_models.AuthenticatePersonalAccessTokenResponse{}, err
+	}
+
+	// record the use
+	if personalAccessToken.LastUsedAt == nil || now.Sub(*personalAccessToken.LastUsedAt) > personalAccessTokenUseInterval || personalAccessToken.LastUsedIP != req.IPAddress {
+		personalAccessToken.LastUsedAt = &now
+		personalAccessToken.LastUsedIP = req.IPAddress
+		if err := s.Store.PersonalAccessTokens().Update(personalAccessToken); err != nil {
+			return service_models.AuthenticatePersonalAccessTokenResponse{}, ErrInternalServerError
+		}
+	}
+
+	return service_models.AuthenticatePersonalAccessTokenResponse{
+		UserID:   user.ID,
+		Username: user.Username,
+		Scopes:   strings.Fields(personalAccessToken.Scopes),
+		TokenID:  personalAccessToken.ID,
+	}, nil
+}
+
+// helper function to describe a personal access token without its secret
+func summarizePersonalAccessToken(personalAccessToken db_models.PersonalAccessToken) service_models.PersonalAccessTokenSummary {
+	return service_models.PersonalAccessTokenSummary{
+		ID:         personalAccessToken.ID,
+		Name:       personalAccessToken.Name,
+		Scopes:     strings.Fields(personalAccessToken.Scopes),
+		CreatedAt:  personalAccessToken.CreatedAt,
+		ExpiresAt:  personalAccessToken.ExpiresAt,
+		LastUsedAt: personalAccessToken.LastUsedAt,
+		LastUsedIP: personalAccessToken.LastUsedIP,
+	}
+}
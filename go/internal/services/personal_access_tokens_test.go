@@ -0,0 +1,122 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+func TestPersonalAccessTokenLifecycle(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	alice := createTestUser(t, store, "alice")
+	bob := createTestUser(t, store, "bob")
+
+	created, err := s.CreatePersonalAccessToken(service_models.CreatePersonalAccessTokenRequest{
+		UserID: alice.ID,
+		Name:   "roster sync",
+		Scopes: []string{auth.ScopeClassesRead},
+	})
+	if err != nil {
+		t.Fatalf("CreatePersonalAccessToken returned %v", err)
+	}
+	if !strings.HasPrefix(created.Token, auth.PersonalAccessTokenPrefix) {
+		t.Fatalf("expected a prefixed token, got %q", created.Token)
+	}
+	if days := time.Until(created.ExpiresAt).Hours() / 24; days < 29 || days > 30 {
+		t.Fatalf("expected the default 30 day expiry, got %.1f days", days)
+	}
+
+	// only the hash is stored
+	stored, _ := store.PersonalAccessTokens().ListByUser(alice.ID)
+	if len(stored) != 1 || strings.Contains(created.Token, stored[0].HashedToken) {
+		t.Fatalf("unexpected stored tokens %+v", stored)
+	}
+
+	// the token authenticates as its owner and records the use
+	res, err := s.AuthenticatePersonalAccessToken(service_models.AuthenticatePersonalAccessTokenRequest{Token: created.Token, IPAddress: "203.0.113.7"})
+	if err != nil {
+		t.Fatalf("AuthenticatePersonalAccessToken returned %v", err)
+	}
+	if res.UserID != alice.ID || res.Username != "alice" || len(res.Scopes) != 1 || res.Scopes[0] != auth.ScopeClassesRead {
+		t.Fatalf("unexpected authentication %+v", res)
+	}
+	list, err := s.ListPersonalAccessTokens(service_models.ListPersonalAccessTokensRequest{UserID: alice.ID})
+	if err != nil || len(list.Tokens) != 1 || list.Tokens[0].LastUsedAt == nil || list.Tokens[0].LastUsedIP != "203.0.113.7" {
+		t.Fatalf("expected the use to be recorded, got %+v %v", list, err)
+	}
+
+	// a tampered token is refused
+	if _, err := s.AuthenticatePersonalAccessToken(service_models.AuthenticatePersonalAccessTokenRequest{Token: created.Token + "x"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	// only the owner can revoke it
+	if err := s.RevokePersonalAccessToken(service_models.RevokePersonalAccessTokenRequest{UserID: bob.ID, TokenID: created.ID}); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("expected ErrTokenNotFound, got %v", err)
+	}
+	if err := s.RevokePersonalAccessToken(service_models.RevokePersonalAccessTokenRequest{UserID: alice.ID, TokenID: created.ID}); err != nil {
+		t.Fatalf("RevokePersonalAccessToken returned %v", err)
+	}
+	if _, err := s.AuthenticatePersonalAccessToken(service_models.AuthenticatePersonalAccessTokenRequest{Token: created.Token}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected a revoked token to be refused, got %v", err)
+	}
+}
+
+func TestPersonalAccessTokenExpiry(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	alice := createTestUser(t, store, "alice")
+
+	created, err := s.CreatePersonalAccessToken(service_models.CreatePersonalAccessTokenRequest{
+		UserID:        alice.ID,
+		Name:          "ci",
+		Scopes:        []string{auth.ScopeUserRead},
+		ExpiresInDays: 1,
+	})
+	if err != nil {
+		t.Fatalf("CreatePersonalAccessToken returned %v", err)
+	}
+
+	// move the expiry into the past
+	tokens, _ := store.PersonalAccessTokens().ListByUser(alice.ID)
+	tokens[0].ExpiresAt = time.Now().Add(-time.Minute)
+	if err := store.PersonalAccessTokens().Update(&tokens[0]); err != nil {
+		t.Fatalf("failed to update token: %v", err)
+	}
+
+	if _, err := s.AuthenticatePersonalAccessToken(service_models.AuthenticatePersonalAccessTokenRequest{Token: created.Token}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected an expired token to be refused, got %v", err)
+	}
+}
+
+func TestCreatePersonalAccessTokenValidation(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	alice := createTestUser(t, store, "alice")
+
+	cases := []struct {
+		name string
+		req  service_models.CreatePersonalAccessTokenRequest
+		want error
+	}{
+		{"blank name", service_models.CreatePersonalAccessTokenRequest{Name: "  ", Scopes: []string{auth.ScopeUserRead}}, ErrInvalidTokenName},
+		{"no scopes", service_models.CreatePersonalAccessTokenRequest{Name: "ci"}, ErrInvalidScope},
+		{"unknown scope", service_models.CreatePersonalAccessTokenRequest{Name: "ci", Scopes: []string{"admin"}}, ErrInvalidScope},
+		{"too long", service_models.CreatePersonalAccessTokenRequest{Name: "ci", Scopes: []string{auth.ScopeUserRead}, ExpiresInDays: 366}, ErrInvalidTokenExpiration},
+		{"negative", service_models.CreatePersonalAccessTokenRequest{Name: "ci", Scopes: []string{auth.ScopeUserRead}, ExpiresInDays: -1}, ErrInvalidTokenExpiration},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.req.UserID = alice.ID
+			if _, err := s.CreatePersonalAccessToken(c.req); !errors.Is(err, c.want) {
+				t.Fatalf("expected %v, got %v", c.want, err)
+			}
+		})
+	}
+}
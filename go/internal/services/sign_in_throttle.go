@@ -0,0 +1,204 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/config"
+	"github.com/hawkerd/privateinstruction/internal/mail"
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+// define custom error messages
+var (
+	ErrTooManyAttempts = errors.New("Too many failed sign in attempts, please try again later")
+)
+
+// returned while sign in is throttled, saying when to try again
+type RetryAfterError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return ErrTooManyAttempts.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+// how failed sign ins are throttled for one kind of key
+type ThrottlePolicy struct {
+	// failures allowed before any delay
+	FreeAttempts int
+	// delay after the first failure past the free ones, doubling with every failure after that
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// failures after which the key is locked out for LockoutDuration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// failures older than this are forgotten
+	Window time.Duration
+}
+
+// default policies; an IP address can be shared by a whole school, so it gets more room than an account
+var (
+	DefaultAccountThrottle = ThrottlePolicy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  time.Hour,
+		Window:           24 * time.Hour,
+	}
+	DefaultIPThrottle = ThrottlePolicy{
+		FreeAttempts:     20,
+		BaseDelay:        time.Second,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 100,
+		LockoutDuration:  time.Hour,
+		Window:           time.Hour,
+	}
+)
+
+// how long a key has to wait after its latest failure
+func (p ThrottlePolicy) delay(failures int) time.Duration {
+	if failures >= p.LockoutThreshold {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+func accountThrottleKey(userID uint) string {
+	return "account:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+func ipThrottleKey(ipAddress string) string {
+	return "ip:" + ipAddress
+}
+
+// helper function to refuse an attempt while any of the keys is locked
+func checkSignInThrottle(store repositories.Store, keys ...string) error {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		throttle, err := store.SignInThrottles().FindByKey(key)
+		if errors.Is(err, repositories.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return ErrInternalServerError
+		}
+		if remaining := throttle.LockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	if wait > 0 {
+		return &RetryAfterError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// helper function to count a failed attempt against a key, returning whether it just got locked out
+func recordSignInFailure(store repositories.Store, key string, policy ThrottlePolicy) (bool, error) {
+	// lock the counter, so parallel guesses can't lose each other's failures
+	throttle, err := store.SignInThrottles().FindOrCreateForUpdate(key)
+	if err != nil {
+		return false, ErrInternalServerError
+	}
+	now := time.Now()
+
+	// start over once the earlier failures are old enough
+	if now.Sub(throttle.LastFailureAt) > policy.Window {
+		throttle.Failures = 0
+	}
+
+	throttle.Failures++
+	throttle.LastFailureAt = now
+	throttle.LockedUntil = now.Add(policy.delay(throttle.Failures))
+	if err := store.SignInThrottles().Update(throttle); err != nil {
+		return false, ErrInternalServerError
+	}
+
+	return throttle.Failures == policy.LockoutThreshold, nil
+}
+
+// helper function to forget the failures counted against a key
+func resetSignInThrottle(store repositories.Store, key string) error {
+	throttle, err := store.SignInThrottles().FindByKey(key)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return ErrInternalServerError
+	}
+	if throttle.Failures == 0 && throttle.LockedUntil.IsZero() {
+		return nil
+	}
+	throttle.Failures = 0
+	throttle.LockedUntil = time.Time{}
+	if err := store.SignInThrottles().Update(throttle); err != nil {
+		return ErrInternalServerError
+	}
+	return nil
+}
+
+// count a failed sign in against the account and the IP address, locking the account out after too many
+func (s *AuthService) recordFailedSignIn(user *db_models.User, ipAddress string, userAgent string) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		if ipAddress != "" {
+			if _, err := recordSignInFailure(store, ipThrottleKey(ipAddress), s.IPThrottle); err != nil {
+				return err
+			}
+		}
+		if user == nil {
+			return nil
+		}
+
+		locked, err := recordSignInFailure(store, accountThrottleKey(user.ID), s.AccountThrottle)
+		if err != nil || !locked {
+			return err
+		}
+
+		// let the owner know, and how to get back in
+		event := db_models.SecurityEvent{
+			UserID:    user.ID,
+			Type:      db_models.SecurityEventAccountLocked,
+			IPAddress: ipAddress,
+			UserAgent: userAgent,
+			Details:   fmt.Sprintf("locked for %s after %d failed sign ins", s.AccountThrottle.LockoutDuration, s.AccountThrottle.LockoutThreshold),
+		}
+		if err := store.SecurityEvents().Create(&event); err != nil {
+			return ErrInternalServerError
+		}
+		msg := mail.Message{
+			To:      user.Email,
+			Subject: "Your account has been locked",
+			Body: fmt.Sprintf("Hi %s,\n\nThere were too many failed attempts to sign in to your account, "+
+				"so it has been locked for the next %s.\n\n"+
+				"If this wasn't you, someone may be guessing your password. "+
+				"Resetting your password unlocks your account right away:\n\n%s",
+				user.Username, s.AccountThrottle.LockoutDuration, strings.TrimSuffix(config.GetAppURL(), "/")+"/forgot-password"),
+		}
+		// the lockout stands even if the email can't be sent
+		if err := s.Mailer.Send(msg); err != nil {
+			log.Printf("failed to send account locked email to user %d: %v", user.ID, err)
+		}
+		return nil
+	})
+}
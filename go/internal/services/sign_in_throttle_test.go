@@ -0,0 +1,138 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/mail"
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+// a policy small enough to walk through in a test
+var testThrottle = ThrottlePolicy{
+	FreeAttempts:     1,
+	BaseDelay:        time.Minute,
+	MaxDelay:         time.Hour,
+	LockoutThreshold: 3,
+	LockoutDuration:  24 * time.Hour,
+	Window:           24 * time.Hour,
+}
+
+// pretend the current delay on a key has passed
+func expireThrottle(t *testing.T, store repositories.Store, key string) {
+	t.Helper()
+
+	throttle, err := store.SignInThrottles().FindByKey(key)
+	if err != nil {
+		t.Fatalf("FindByKey returned %v", err)
+	}
+	throttle.LockedUntil = time.Now().Add(-time.Second)
+	store.SignInThrottles().Update(throttle)
+}
+
+func TestThrottlePolicyDelay(t *testing.T) {
+	policy := ThrottlePolicy{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: 5 * time.Second, LockoutThreshold: 10, LockoutDuration: time.Hour}
+	expected := map[int]time.Duration{1: 0, 2: 0, 3: time.Second, 4: 2 * time.Second, 5: 4 * time.Second, 6: 5 * time.Second, 9: 5 * time.Second, 10: time.Hour}
+	for failures, delay := range expected {
+		if got := policy.delay(failures); got != delay {
+			t.Errorf("delay(%d) = %s, expected %s", failures, got, delay)
+		}
+	}
+}
+
+func TestSignInLocksOutAccountUntilPasswordReset(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	var sent bytes.Buffer
+	s := NewAuthService(store, mail.NewLogMailer(&sent))
+	s.AccountThrottle = testThrottle
+	signUpTestUser(t, s, "alice")
+	user, _ := store.Users().FindByUsername("alice")
+	key := accountThrottleKey(user.ID)
+	sent.Reset()
+
+	wrong := service_models.SignInRequest{Username: "alice", Password: "wrong"}
+	right := service_models.SignInRequest{Username: "alice", Password: "password"}
+
+	// the free attempt doesn't slow anything down
+	if _, err := s.SignIn(wrong); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := s.SignIn(wrong); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	// the next attempt has to wait, even with the right password
+	_, err := s.SignIn(right)
+	var retryErr *RetryAfterError
+	if !errors.As(err, &retryErr) || retryErr.RetryAfter <= 0 || retryErr.RetryAfter > time.Minute {
+		t.Fatalf("expected to wait up to a minute, got %v", err)
+	}
+
+	// one more failure locks the account and tells the owner
+	expireThrottle(t, store, key)
+	s.SignIn(wrong)
+	if _, err := s.SignIn(right); !errors.As(err, &retryErr) || retryErr.RetryAfter <= time.Hour {
+		t.Fatalf("expected a lockout, got %v", err)
+	}
+	if !strings.Contains(sent.String(), "Your account has been locked") {
+		t.Fatalf("expected a lockout email, got %q", sent.String())
+	}
+	events, _ := store.SecurityEvents().ListByUser(user.ID)
+	if len(events) != 1 || events[0].Type != db_models.SecurityEventAccountLocked {
+		t.Fatalf("expected an account locked event, got %+v", events)
+	}
+
+	// resetting the password unlocks it
+	sent.Reset()
+	s.ForgotPassword(service_models.ForgotPasswordRequest{Email: "alice@example.com"})
+	if err := s.ResetPassword(service_models.ResetPasswordRequest{Token: tokenFromMail(t, &sent), NewPassword: "new-password"}); err != nil {
+		t.Fatalf("ResetPassword returned %v", err)
+	}
+	if _, err := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "new-password"}); err != nil {
+		t.Fatalf("expected the account to be unlocked, got %v", err)
+	}
+}
+
+func TestSuccessfulSignInResetsAccountFailures(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	s.AccountThrottle = testThrottle
+	signUpTestUser(t, s, "alice")
+
+	s.SignIn(service_models.SignInRequest{Username: "alice", Password: "wrong"})
+	if _, err := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"}); err != nil {
+		t.Fatalf("SignIn returned %v", err)
+	}
+
+	// the earlier failure no longer counts
+	if _, err := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "wrong"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"}); err != nil {
+		t.Fatalf("expected a single failure not to delay sign in, got %v", err)
+	}
+}
+
+func TestSignInThrottlesIPAddress(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	s.IPThrottle = testThrottle
+	signUpTestUser(t, s, "alice")
+
+	// guesses at accounts that don't exist still count
+	for _, username := range []string{"bob", "carol"} {
+		s.SignIn(service_models.SignInRequest{Username: username, Password: "password", IPAddress: "203.0.113.1"})
+	}
+
+	if _, err := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password", IPAddress: "203.0.113.1"}); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("expected ErrTooManyAttempts, got %v", err)
+	}
+	if _, err := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password", IPAddress: "203.0.113.2"}); err != nil {
+		t.Fatalf("expected another address to sign in, got %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+// define custom error messages
+var (
+	ErrTwoFactorEnabled    = errors.New("Two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled = errors.New("Two-factor authentication is not enabled")
+	ErrTwoFactorNotSetUp   = errors.New("Two-factor authentication has not been set up")
+	ErrInvalidMFACode      = errors.New("Invalid authentication code")
+)
+
+// number of recovery codes issued when two-factor authentication is enabled
+const recoveryCodeCount = 10
+
+// finish a sign in by checking the second factor
+func (s *AuthService) VerifyMFA(req service_models.VerifyMFARequest) (service_models.SignInResponse, error) {
+	// the challenge token proves the password was already checked
+	userID, err := auth.ParseMFAToken(req.MFAToken)
+	if err != nil {
+		return service_models.SignInResponse{}, ErrInvalidCredentials
+	}
+
+	user, err := s.Store.Users().FindByID(userID)
+	if err != nil || !user.TOTPEnabled {
+		return service_models.SignInResponse{}, ErrInvalidCredentials
+	}
+
+	// codes are throttled like passwords, since six digits don't take long to guess
+	if err := checkSignInThrottle(s.Store, accountThrottleKey(user.ID), ipThrottleKey(req.IPAddress)); err != nil {
+		return service_models.SignInResponse{}, err
+	}
+
+	var res service_models.SignInResponse
+	err = s.Store.Do(func(store repositories.Store) error {
+		// read the account again under a lock, so the session isn't started from a copy an admin has since changed
+		user, err := store.Users().FindByIDForUpdate(userID)
+		if err != nil || !user.TOTPEnabled {
+			return ErrInvalidCredentials
+		}
+
+		if err := checkSecondFactor(store, user, req.Code); err != nil {
+			return err
+		}
+		if err := resetSignInThrottle(store, accountThrottleKey(user.ID)); err != nil {
+			return err
+		}
+
+		res, err = startSession(store, user, req.UserAgent, req.IPAddress)
+		return err
+	})
+	if errors.Is(err, ErrInvalidMFACode) {
+		if err := s.recordFailedSignIn(user, req.IPAddress, req.UserAgent); err != nil {
+			return service_models.SignInResponse{}, err
+		}
+	}
+	if err != nil {
+		return service_models.SignInResponse{}, err
+	}
+
+	return res, nil
+}
+
+// generate a new TOTP secret for a user; it is only enforced once confirmed
+func (s *AuthService) SetupTwoFactor(req service_models.SetupTwoFactorRequest) (service_models.SetupTwoFactorResponse, error) {
+	user, err := s.Store.Users().FindByID(req.UserID)
+	if err != nil {
+		return service_models.SetupTwoFactorResponse{}, ErrUserNotFound
+	}
+	if user.TOTPEnabled {
+		return service_models.SetupTwoFactorResponse{}, ErrTwoFactorEnabled
+	}
+
+	// generate and store the secret
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return service_models.SetupTwoFactorResponse{}, ErrTokenGeneration
+	}
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+	if err := s.Store.Users().Update(user); err != nil {
+		return service_models.SetupTwoFactorResponse{}, ErrInternalServerError
+	}
+
+	return service_models.SetupTwoFactorResponse{
+		Secret: secret,
+		URI:    auth.TOTPURI(user.Email, secret),
+	}, nil
+}
+
+// enable two-factor authentication once the user proves their authenticator works, returning recovery codes
+func (s *AuthService) ConfirmTwoFactor(req service_models.ConfirmTwoFactorRequest) (service_models.ConfirmTwoFactorResponse, error) {
+	var codes []string
+	err := s.Store.Do(func(store repositories.Store) error {
+		user, err := store.Users().FindByID(req.UserID)
+		if err != nil {
+			return ErrUserNotFound
+		}
+		if user.TOTPEnabled {
+			return ErrTwoFactorEnabled
+		}
+		if user.TOTPSecret == "" {
+			return ErrTwoFactorNotSetUp
+		}
+
+		// check the code against the pending secret
+		step, ok := auth.ValidateTOTP(user.TOTPSecret, req.Code, time.Now())
+		if !ok {
+			return ErrInvalidMFACode
+		}
+
+		// enable two-factor authentication
+		user.TOTPEnabled = true
+		user.TOTPLastStep = step
+		if err := store.Users().Update(user); err != nil {
+			return ErrInternalServerError
+		}
+
+		// replace any previous recovery codes
+		if err := store.RecoveryCodes().DeleteByUser(user.ID); err != nil {
+			return ErrInternalServerError
+		}
+		for i := 0; i < recoveryCodeCount; i++ {
+			code, err := auth.GenerateRecoveryCode()
+			if err != nil {
+				return ErrTokenGeneration
+			}
+			recoveryCode := db_models.RecoveryCode{
+				UserID:     user.ID,
+				HashedCode: auth.HashVerifier(auth.NormalizeRecoveryCode(code)),
+			}
+			if err := store.RecoveryCodes().Create(&recoveryCode); err != nil {
+				return ErrInternalServerError
+			}
+			codes = append(codes, code)
+		}
+
+		event := db_models.AuditEvent{ActorID: user.ID, Action: db_models.AuditActionEnableTwoFactor, TargetType: db_models.AuditTargetUser, TargetID: user.ID}
+		return recordAuditEvent(store, req.Audit, event, auditFields{"two_factor_enabled": false}, auditFields{"two_factor_enabled": true})
+	})
+	if err != nil {
+		return service_models.ConfirmTwoFactorResponse{}, err
+	}
+
+	return service_models.ConfirmTwoFactorResponse{RecoveryCodes: codes}, nil
+}
+
+// turn off two-factor authentication, which takes a current code or a recovery code
+func (s *AuthService) DisableTwoFactor(req service_models.DisableTwoFactorRequest) error {
+	return s.Store.Do(func(store repositories.Store) error {
+		user, err := store.Users().FindByIDForUpdate(req.UserID)
+		if err != nil {
+			return ErrUserNotFound
+		}
+		if !user.TOTPEnabled {
+			return ErrTwoFactorNotEnabled
+		}
+
+		if err := checkSecondFactor(store, user, req.Code); err != nil {
+			return err
+		}
+
+		// clear the secret and recovery codes
+		user.TOTPEnabled = false
+		user.TOTPSecret = ""
+		user.TOTPLastStep = 0
+		if err := store.Users().Update(user); err != nil {
+			return ErrInternalServerError
+		}
+		if err := store.RecoveryCodes().DeleteByUser(user.ID); err != nil {
+			return ErrInternalServerError
+		}
+
+		event := db_models.AuditEvent{ActorID: user.ID, Action: db_models.AuditActionDisableTwoFactor, TargetType: db_models.AuditTargetUser, TargetID: user.ID}
+		return recordAuditEvent(store, req.Audit, event, auditFields{"two_factor_enabled": true}, auditFields{"two_factor_enabled": false})
+	})
+}
+
+// helper function to accept a TOTP code or an unused recovery code, consuming it
+func checkSecondFactor(store repositories.Store, user *db_models.User, code string) error {
+	// a TOTP code is only accepted once, so a code seen on the wire can't be replayed
+	if step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now()); ok {
+		advanced, err := store.Users().AdvanceTOTPStep(user.ID, step)
+		if err != nil {
+			return ErrInternalServerError
+		}
+		if !advanced {
+			return ErrInvalidMFACode
+		}
+		user.TOTPLastStep = step
+		return nil
+	}
+
+	// otherwise look for a matching recovery code
+	recoveryCodes, err := store.RecoveryCodes().ListByUser(user.ID)
+	if err != nil {
+		return ErrInternalServerError
+	}
+	normalized := auth.NormalizeRecoveryCode(code)
+	for _, recoveryCode := range recoveryCodes {
+		if recoveryCode.UsedAt != nil || !auth.CheckVerifier(recoveryCode.HashedCode, normalized) {
+			continue
+		}
+		used, err := store.RecoveryCodes().Use(recoveryCode.ID, time.Now())
+		if err != nil {
+			return ErrInternalServerError
+		}
+		if !used {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	return ErrInvalidMFACode
+}
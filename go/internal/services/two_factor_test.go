@@ -0,0 +1,199 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hawkerd/privateinstruction/internal/auth"
+	"github.com/hawkerd/privateinstruction/internal/models/db_models"
+	"github.com/hawkerd/privateinstruction/internal/models/service_models"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
+)
+
+// sign up a user and enable two-factor authentication, returning the secret and recovery codes
+func enrollTwoFactor(t *testing.T, s *AuthService, username string) (uint, string, []string) {
+	t.Helper()
+
+	signUpTestUser(t, s, username)
+	user, err := s.Store.Users().FindByUsername(username)
+	if err != nil {
+		t.Fatalf("FindByUsername returned %v", err)
+	}
+
+	setup, err := s.SetupTwoFactor(service_models.SetupTwoFactorRequest{UserID: user.ID})
+	if err != nil {
+		t.Fatalf("SetupTwoFactor returned %v", err)
+	}
+	code, _ := auth.TOTPCode(setup.Secret, time.Now())
+	confirm, err := s.ConfirmTwoFactor(service_models.ConfirmTwoFactorRequest{UserID: user.ID, Code: code})
+	if err != nil {
+		t.Fatalf("ConfirmTwoFactor returned %v", err)
+	}
+	return user.ID, setup.Secret, confirm.RecoveryCodes
+}
+
+func TestTwoFactorEnrollment(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	signUpTestUser(t, s, "alice")
+	user, _ := store.Users().FindByUsername("alice")
+
+	if _, err := s.ConfirmTwoFactor(service_models.ConfirmTwoFactorRequest{UserID: user.ID, Code: "123456"}); !errors.Is(err, ErrTwoFactorNotSetUp) {
+		t.Fatalf("expected ErrTwoFactorNotSetUp, got %v", err)
+	}
+
+	setup, err := s.SetupTwoFactor(service_models.SetupTwoFactorRequest{UserID: user.ID})
+	if err != nil {
+		t.Fatalf("SetupTwoFactor returned %v", err)
+	}
+	if setup.Secret == "" || setup.URI == "" {
+		t.Fatalf("unexpected setup response %+v", setup)
+	}
+
+	// signing in doesn't need a second factor until the secret is confirmed
+	res, err := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	if err != nil || res.MFARequired {
+		t.Fatalf("expected a plain sign in, got %+v %v", res, err)
+	}
+
+	if _, err := s.ConfirmTwoFactor(service_models.ConfirmTwoFactorRequest{UserID: user.ID, Code: "000000"}); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("expected ErrInvalidMFACode, got %v", err)
+	}
+	code, _ := auth.TOTPCode(setup.Secret, time.Now())
+	confirm, err := s.ConfirmTwoFactor(service_models.ConfirmTwoFactorRequest{UserID: user.ID, Code: code})
+	if err != nil {
+		t.Fatalf("ConfirmTwoFactor returned %v", err)
+	}
+	if len(confirm.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %d", recoveryCodeCount, len(confirm.RecoveryCodes))
+	}
+	if _, err := s.SetupTwoFactor(service_models.SetupTwoFactorRequest{UserID: user.ID}); !errors.Is(err, ErrTwoFactorEnabled) {
+		t.Fatalf("expected ErrTwoFactorEnabled, got %v", err)
+	}
+}
+
+func TestSignInWithTwoFactor(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	_, secret, _ := enrollTwoFactor(t, s, "alice")
+
+	// the password alone only earns a challenge
+	res, err := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("SignIn returned %v", err)
+	}
+	if !res.MFARequired || res.MFAToken == "" || res.AccessToken != "" || res.RefreshToken != "" {
+		t.Fatalf("expected only an MFA challenge, got %+v", res)
+	}
+
+	// the code from the enrollment step was already used, so wait for the next one
+	code, _ := auth.TOTPCode(secret, time.Now().Add(30*time.Second))
+	if _, err := s.VerifyMFA(service_models.VerifyMFARequest{MFAToken: res.MFAToken, Code: "000000"}); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("expected ErrInvalidMFACode, got %v", err)
+	}
+	session, err := s.VerifyMFA(service_models.VerifyMFARequest{MFAToken: res.MFAToken, Code: code})
+	if err != nil {
+		t.Fatalf("VerifyMFA returned %v", err)
+	}
+	if session.AccessToken == "" || session.RefreshToken == "" {
+		t.Fatalf("expected tokens, got %+v", session)
+	}
+
+	// the same code can't be replayed
+	if _, err := s.VerifyMFA(service_models.VerifyMFARequest{MFAToken: res.MFAToken, Code: code}); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("expected a replayed code to be rejected, got %v", err)
+	}
+
+	if _, err := s.VerifyMFA(service_models.VerifyMFARequest{MFAToken: session.AccessToken, Code: code}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected an access token to be rejected as an MFA token, got %v", err)
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	s := newTestAuthService(store)
+	userID, _, codes := enrollTwoFactor(t, s, "alice")
+
+	res, _ := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	if _, err := s.VerifyMFA(service_models.VerifyMFARequest{MFAToken: res.MFAToken, Code: codes[0]}); err != nil {
+		t.Fatalf("VerifyMFA with a recovery code returned %v", err)
+	}
+	if _, err := s.VerifyMFA(service_models.VerifyMFARequest{MFAToken: res.MFAToken, Code: codes[0]}); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("expected a used recovery code to be rejected, got %v", err)
+	}
+
+	// a recovery code also turns two-factor authentication off
+	if err := s.DisableTwoFactor(service_models.DisableTwoFactorRequest{UserID: userID, Code: codes[1]}); err != nil {
+		t.Fatalf("DisableTwoFactor returned %v", err)
+	}
+	res, err := s.SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	if err != nil || res.MFARequired {
+		t.Fatalf("expected a plain sign in, got %+v %v", res, err)
+	}
+	if remaining, _ := store.RecoveryCodes().ListByUser(userID); len(remaining) != 0 {
+		t.Fatalf("expected the recovery codes to be deleted, got %d", len(remaining))
+	}
+}
+
+// a store whose locked user lookups wait for each other, so both sign ins read the account before either accepts the code
+type mfaBarrierStore struct {
+	repositories.Store
+	barrier *sync.WaitGroup
+}
+
+func (s mfaBarrierStore) Do(fn func(store repositories.Store) error) error {
+	return fn(s)
+}
+
+func (s mfaBarrierStore) Users() repositories.UserRepository {
+	return mfaBarrierRepository{UserRepository: s.Store.Users(), barrier: s.barrier}
+}
+
+type mfaBarrierRepository struct {
+	repositories.UserRepository
+	barrier *sync.WaitGroup
+}
+
+func (r mfaBarrierRepository) FindByIDForUpdate(id uint) (*db_models.User, error) {
+	user, err := r.UserRepository.FindByIDForUpdate(id)
+	r.barrier.Done()
+	r.barrier.Wait()
+	return user, err
+}
+
+func TestConcurrentVerifyMFAAcceptsACodeOnce(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	_, secret, _ := enrollTwoFactor(t, newTestAuthService(store), "alice")
+	res, err := newTestAuthService(store).SignIn(service_models.SignInRequest{Username: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("SignIn returned %v", err)
+	}
+	code, _ := auth.TOTPCode(secret, time.Now().Add(30*time.Second))
+
+	barrier := &sync.WaitGroup{}
+	barrier.Add(2)
+	s := newTestAuthService(mfaBarrierStore{Store: store, barrier: barrier})
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := s.VerifyMFA(service_models.VerifyMFARequest{MFAToken: res.MFAToken, Code: code})
+			errs <- err
+		}()
+	}
+
+	succeeded := 0
+	for i := 0; i < 2; i++ {
+		err := <-errs
+		if err == nil {
+			succeeded++
+		} else if !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("expected ErrInvalidMFACode, got %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("expected the code to be accepted once, got %d", succeeded)
+	}
+}
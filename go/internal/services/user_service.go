@@ -2,10 +2,12 @@ package services
 
 import (
 	"errors"
+	"strings"
 
+	"github.com/hawkerd/privateinstruction/internal/mail"
 	"github.com/hawkerd/privateinstruction/internal/models/db_models"
 	"github.com/hawkerd/privateinstruction/internal/models/service_models"
-	"gorm.io/gorm"
+	"github.com/hawkerd/privateinstruction/internal/repositories"
 )
 
 // define custom error messages
@@ -14,21 +16,23 @@ var (
 )
 
 type UserService struct {
-	DB *gorm.DB
+	Store  repositories.Store
+	Mailer mail.Mailer
 }
 
-// create and return a new AuthService instance
-func NewUserService(db *gorm.DB) *UserService {
+// create and return a new UserService instance
+func NewUserService(store repositories.Store, mailer mail.Mailer) *UserService {
 	return &UserService{
-		DB: db,
+		Store:  store,
+		Mailer: mailer,
 	}
 }
 
 // read a user by ID
 func (s *UserService) ReadUser(req service_models.ReadUserRequest) (*service_models.ReadUserResponse, error) {
-	var user db_models.User
-	if err := s.DB.First(&user, req.UserID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	user, err := s.Store.Users().FindByID(req.UserID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
 			return nil, ErrUserNotFound
 		}
 		return nil, err
@@ -36,8 +40,12 @@ func (s *UserService) ReadUser(req service_models.ReadUserRequest) (*service_mod
 
 	// build the response
 	response := service_models.ReadUserResponse{
-		Username: user.Username,
-		Email:    user.Email,
+		Username:         user.Username,
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerified,
+		PendingEmail:     user.PendingEmail,
+		TwoFactorEnabled: user.TOTPEnabled,
+		Role:             user.Role,
 	}
 
 	return &response, nil
@@ -45,38 +53,78 @@ func (s *UserService) ReadUser(req service_models.ReadUserRequest) (*service_mod
 
 // delete a user by ID
 func (s *UserService) DeleteUser(req service_models.DeleteUserRequest) error {
-	// find user
-	var user db_models.User
-	if err := s.DB.First(&user, req.UserID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return ErrUserNotFound
+	return s.Store.Do(func(store repositories.Store) error {
+		// find user
+		user, err := store.Users().FindByID(req.UserID)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrUserNotFound
+			}
+			return err
 		}
-		return err
-	}
 
-	if err := s.DB.Delete(&user).Error; err != nil {
-		return err
-	}
+		// sign out every session
+		if err := store.RefreshTokens().DeleteByUser(user.ID); err != nil {
+			return err
+		}
+
+		// revoke personal access tokens
+		if err := store.PersonalAccessTokens().DeleteByUser(user.ID); err != nil {
+			return err
+		}
+
+		// unlink external identities so they can sign up again
+		if err := store.Identities().DeleteByUser(user.ID); err != nil {
+			return err
+		}
 
-	return nil
+		if err := store.Users().Delete(user.ID); err != nil {
+			return err
+		}
+
+		event := db_models.AuditEvent{ActorID: user.ID, Action: db_models.AuditActionDeleteUser, TargetType: db_models.AuditTargetUser, TargetID: user.ID}
+		return recordAuditEvent(store, req.Audit, event, auditFields{"username": user.Username, "email": user.Email}, nil)
+	})
 }
 
 // update a user by ID
 func (s *UserService) UpdateUser(req service_models.UpdateUserRequest) error {
-	var user db_models.User
-	if err := s.DB.First(&user, req.UserID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return ErrUserNotFound
+	return s.Store.Do(func(store repositories.Store) error {
+		user, err := store.Users().FindByID(req.UserID)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return ErrUserNotFound
+			}
+			return err
 		}
-		return err
-	}
 
-	user.Username = req.Username
-	user.Email = req.Email
+		before := auditFields{"username": user.Username, "pending_email": user.PendingEmail}
+		user.Username = req.Username
 
-	if err := s.DB.Save(&user).Error; err != nil {
-		return err
-	}
+		// a new email only replaces the current one once it is verified
+		email := strings.TrimSpace(strings.ToLower(req.Email))
+		if email == user.Email {
+			user.PendingEmail = ""
+		} else if email != "" && email != user.PendingEmail {
+			existing, err := store.Users().FindByEmail(email)
+			if err == nil && existing.ID != user.ID {
+				return ErrUserExists
+			}
+			if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+				return err
+			}
+
+			user.PendingEmail = email
+			if err := sendVerificationEmail(store, s.Mailer, user, email); err != nil {
+				return err
+			}
+		}
+
+		if err := store.Users().Update(user); err != nil {
+			return err
+		}
 
-	return nil
+		event := db_models.AuditEvent{ActorID: user.ID, Action: db_models.AuditActionUpdateUser, TargetType: db_models.AuditTargetUser, TargetID: user.ID}
+		return recordAuditEvent(store, req.Audit, event, before, auditFields{"username": user.Username, "pending_email": user.PendingEmail})
+	})
 }
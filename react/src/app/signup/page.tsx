@@ -77,7 +77,12 @@ export default function SignUp() {
         setResponseText('Sign up successful. Logging in...');
         setSuccess(true);
       } else {
-        const errorText = await signUpRes.text();
+        // password policy errors list every rule the password broke
+        let errorText = await signUpRes.text();
+        if (signUpRes.headers.get('Content-Type')?.includes('application/json')) {
+          const data = JSON.parse(errorText);
+          errorText = data.violations?.map((v: { message: string }) => v.message).join('. ') || data.error;
+        }
         setResponseText(errorText);
         setError(true);
         setLoading(false);
@@ -65,6 +65,11 @@ export default function Header() {
                 <Box sx={{ display: 'flex', alignItems: 'center', gap: 2 }}>
                     {isAuthenticated ? (
                         <>
+                            {user?.impersonated_by && (
+                                <Typography variant="body2" sx={{ color: 'error.main', fontWeight: 'bold' }}>
+                                    Impersonated by {user.impersonated_by.username} until {user.impersonated_by.expires_at}
+                                </Typography>
+                            )}
                             {user && <Typography variant="h6" sx={{ color: 'black' }}>{user.username}</Typography>}
                             <IconButton
                                 sx={{ color: 'black' }}
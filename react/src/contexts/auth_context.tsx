@@ -1,5 +1,5 @@
 'use client'
-import React, { createContext, useContext, useEffect, useState } from 'react';
+import React, { createContext, useContext, useEffect, useRef, useState } from 'react';
 import config from '@/config';
 import { useMemo } from 'react';
 
@@ -47,12 +47,33 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({ children
 
     // logout function to clear the token
     const logout = () => {
+        // revoke the session server-side; the local token is cleared regardless
+        const storedToken = localStorage.getItem('authToken');
+        if (storedToken) {
+            fetch(`${config.servicePath}/auth/logout`, {
+                method: 'POST',
+                credentials: 'include',
+                headers: { 'Authorization': `Bearer ${storedToken}` },
+            }).catch(() => {});
+        }
         setToken(null);
         localStorage.removeItem('authToken');
     };
 
+    // the refresh in flight, shared so concurrent requests don't present the same refresh token twice
+    const refreshInFlight = useRef<Promise<string | null> | null>(null);
+
     // function to refresh the JWT
-    const refreshToken = async (oldToken: string) => {
+    const refreshToken = (oldToken: string) => {
+        if (!refreshInFlight.current) {
+            refreshInFlight.current = doRefreshToken(oldToken).finally(() => {
+                refreshInFlight.current = null;
+            });
+        }
+        return refreshInFlight.current;
+    };
+
+    const doRefreshToken = async (oldToken: string) => {
         const response = await fetch(`${config.servicePath}/auth/refresh`, {
             method: 'POST',
             credentials: 'include',
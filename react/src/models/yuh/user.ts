@@ -1,4 +1,10 @@
 export interface User {
   username: string;
   email: string;
+  // set while an admin is impersonating the user
+  impersonated_by?: {
+    id: number;
+    username: string;
+    expires_at: string;
+  };
 }
\ No newline at end of file